@@ -0,0 +1,483 @@
+package main
+
+import (
+	"[app name]/conf"
+	"[app name]/feed"
+	"[app name]/lib"
+	"[app name]/publish"
+	"[app name]/route"
+	"[app name]/sql"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	dateFormat  = "2006-01-02"
+	exitOk      = 0
+	exitFailed  = 1
+	exitUsage   = 2
+	exitPending = 3
+	exportPage  = 500 // Articles to a page when exporting.
+)
+
+type command struct {
+	usage string
+	run   func(args []string) int
+}
+
+var commands map[string]command
+
+// What the commands use of the database, tests swap them.
+var (
+	openDB      = sql.OpenDB
+	exportStore = sql.ArticleStore(sql.MysqlArticles{})
+)
+
+func init() {
+	commands = map[string]command{
+		"serve":    {"serve [-port n] [-migrate]                     run the api (the default)", serveCommand},
+		"migrate":  {"migrate up|down|status|baseline [flags]        manage the schema", migrateCommand},
+		"ingest":   {"ingest [-topic t] <dir>                        load feed and article files from a folder", ingestCommand},
+		"accounts": {"accounts create|list|revoke [flags]            manage api accounts", accountsCommand},
+		"sources":  {"sources add|list|remove [flags]                manage the feeds polled for articles", sourcesCommand},
+		"export":   {"export [-from d] [-to d] [-cat c] [-o file]    write articles out as json lines", exportCommand},
+		"config":   {"config print                                   show the settings, secrets redacted", configCommand},
+	}
+}
+
+/****************************************************************************
+ *                                                    _
+ *                                                   | |
+ *       ___ ___  _ __ ___  _ __ ___   __ _ _ __   __| |___
+ *      / __/ _ \| '_ ` _ \| '_ ` _ \ / _` | '_ \ / _` / __|
+ *     | (_| (_) | | | | | | | | | | | (_| | | | | (_| \__ \
+ *      \___\___/|_| |_| |_|_| |_| |_|\__,_|_| |_|\__,_|___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * The command tree. With no command the binary serves, as it always has, and
+ * each command has its own flags. Exit codes: 0 done, 1 failed, 2 bad usage,
+ * 3 migrate status found migrations still to apply.
+ * ----------------------------------------------------------------------- */
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		return serveCommand(nil)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage(os.Stderr)
+		return exitUsage
+	}
+	return cmd.run(args[1:])
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage:", filepath.Base(os.Args[0]), "[-c app.conf] <command> [flags]")
+	for _, name := range []string{"serve", "migrate", "ingest", "accounts", "sources", "export", "config"} {
+		fmt.Fprintln(w, "  "+commands[name].usage)
+	}
+}
+
+// A flag set that reports its own errors and leaves the exit to the caller.
+func newFlags(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ContinueOnError)
+}
+
+// Opens the database for a command, most of them need nothing else.
+func openForCommand() bool {
+	if err := openDB(); err != nil {
+		fmt.Fprintln(os.Stderr, "Database:", err)
+		return false
+	}
+	return true
+}
+
+// Writes a value as indented json to stdout.
+func printJson(value interface{}) int {
+	out, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	fmt.Println(string(out))
+	return exitOk
+}
+
+func serveCommand(args []string) int {
+	flags := newFlags("serve")
+	port := flags.String("port", conf.PORT, "port to listen on")
+	migrate := flags.Bool("migrate", conf.MIGRATE, "apply new migrations before serving")
+	if flags.Parse(args) != nil {
+		return exitUsage
+	}
+	conf.PORT, conf.MIGRATE = *port, *migrate
+
+	lib.Info("Initialize Database")
+	sql.InitDB()
+	go lib.TrapExit(sql.CloseDB) // Flush account usage to the database on the way out.
+	go feed.KeepIngesting(conf.HEARTBEAT)
+	go feed.KeepPolling(conf.HEARTBEAT)
+	lib.Info("Initilize Posting to Channels")
+	go publish.KeepPublishing(conf.HEARTBEAT)
+	go publish.KeepDelivering(conf.HEARTBEAT)
+
+	route.Init()
+	return exitOk
+}
+
+/************************************************************
+ *                _                 _
+ *               (_)               | |
+ *      _ __ ___  _  __ _ _ __ __ _| |_ ___
+ *     | '_ ` _ \| |/ _` | '__/ _` | __/ _ \
+ *     | | | | | | | (_| | | | (_| | ||  __/
+ *     |_| |_| |_|_|\__, |_|  \__,_|\__\___|
+ *                   __/ |
+ *                  |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * migrate up [-dry-run], migrate down [-steps n] [-dry-run],
+ * migrate status, migrate baseline -version n.
+ * ------------------------------------------------------- */
+func migrateCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, commands["migrate"].usage)
+		return exitUsage
+	}
+	flags := newFlags("migrate " + args[0])
+	dryRun := flags.Bool("dry-run", false, "show the statements without running them")
+	steps := flags.Int("steps", 1, "migrations to undo, newest first")
+	version := flags.Int("version", -1, "baseline: the version the schema is already at")
+	if flags.Parse(args[1:]) != nil {
+		return exitUsage
+	}
+	if !openForCommand() {
+		return exitFailed
+	}
+	var ran []sql.Migration
+	var err error
+	switch args[0] {
+	case "up":
+		ran, err = sql.MigrateUp(*dryRun)
+	case "down":
+		ran, err = sql.MigrateDown(*steps, *dryRun)
+	case "status":
+		return migrateStatus()
+	case "baseline":
+		if *version < 0 {
+			fmt.Fprintln(os.Stderr, "baseline needs -version")
+			return exitUsage
+		}
+		err = sql.MigrateBaseline(*version)
+	default:
+		fmt.Fprintln(os.Stderr, commands["migrate"].usage)
+		return exitUsage
+	}
+	for _, m := range ran {
+		fmt.Printf("%04d_%s\n", m.Version, m.Name)
+		if *dryRun {
+			for _, statement := range m.Statements(args[0] == "down") {
+				fmt.Println(statement + ";")
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Migrate:", err)
+		return exitFailed
+	}
+	return exitOk
+}
+
+func migrateStatus() int {
+	states, err := sql.MigrationStatus()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Migrate:", err)
+		return exitFailed
+	}
+	code := exitOk
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, state := range states {
+		applied := "pending"
+		if state.Applied == nil {
+			code = exitPending
+		} else {
+			applied = state.Applied.Format(time.RFC3339)
+		}
+		if state.Changed {
+			applied += " (changed since)"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", state.Version, state.Name, applied)
+	}
+	lib.CheckErr(w.Flush())
+	return code
+}
+
+/*********************************************************************************
+ *      _                       _
+ *     (_)                     | |
+ *      _ _ __   __ _  ___  ___| |_
+ *     | | '_ \ / _` |/ _ \/ __| __|
+ *     | | | | | (_| |  __/\__ \ |_
+ *     |_|_| |_|\__, |\___||___/\__|
+ *               __/ |
+ *              |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Loads the feed and article files in a folder, RSS, Atom, JSON Feed or the
+ * app's own json, moving each to archive once read, or to failed if it would not.
+ * ---------------------------------------------------------------------------- */
+func ingestCommand(args []string) int {
+	flags := newFlags("ingest")
+	topic := flags.String("topic", feed.Topic, "topic the articles are filed under")
+	if flags.Parse(args) != nil || flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, commands["ingest"].usage)
+		return exitUsage
+	}
+	if !openForCommand() {
+		return exitFailed
+	}
+	feed.Topic = *topic
+	result, err := feed.IngestDir(flags.Arg(0), nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Ingest:", err)
+		return exitFailed
+	}
+	fmt.Printf("%d files, %d articles added, %d already held, %d failed\n", result.Files, result.Added, result.Seen, result.Failed)
+	if result.Failed > 0 {
+		return exitFailed
+	}
+	return exitOk
+}
+
+/*****************************************************************************
+ *                                      _
+ *                                     | |
+ *       __ _  ___ ___ ___  _   _ _ __ | |_ ___
+ *      / _` |/ __/ __/ _ \| | | | '_ \| __/ __|
+ *     | (_| | (_| (_| (_) | |_| | | | | |_\__ \
+ *      \__,_|\___\___\___/ \__,_|_| |_|\__|___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * accounts create -email e [-plan p], accounts list [-search s] [-plan p]
+ * [-limit n] [-offset n], accounts revoke -urn n. The key of a new account is
+ * in what create prints, and is not shown again.
+ * ------------------------------------------------------------------------ */
+func accountsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, commands["accounts"].usage)
+		return exitUsage
+	}
+	flags := newFlags("accounts " + args[0])
+	email := flags.String("email", "", "create: the account email")
+	plan := flags.String("plan", "", "create: plan, FREE if not given. list: only this plan")
+	search := flags.String("search", "", "list: part of the email")
+	limit := flags.Int("limit", 100, "list: accounts to show")
+	offset := flags.Int("offset", 0, "list: accounts to skip")
+	urn := flags.Int64("urn", 0, "revoke: the account urn")
+	if flags.Parse(args[1:]) != nil {
+		return exitUsage
+	}
+	if !openForCommand() {
+		return exitFailed
+	}
+	defer sql.CloseDB()
+	switch args[0] {
+	case "create":
+		if !strings.Contains(*email, "@") {
+			fmt.Fprintln(os.Stderr, "create needs a valid -email")
+			return exitUsage
+		}
+		if len(*plan) == 0 {
+			*plan = "FREE"
+		}
+		account, err := sql.CreateAccount(context.Background(), *email, strings.ToUpper(*plan))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Create:", err)
+			return exitFailed
+		}
+		return printJson(account)
+	case "list":
+		aList, err := sql.ListAccounts(context.Background(), *search, strings.ToUpper(*plan), *limit, *offset)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "List:", err)
+			return exitFailed
+		}
+		return printJson(aList)
+	case "revoke":
+		if *urn <= 0 {
+			fmt.Fprintln(os.Stderr, "revoke needs -urn")
+			return exitUsage
+		}
+		if err := sql.RevokeAccount(context.Background(), *urn); err != nil {
+			fmt.Fprintln(os.Stderr, "Revoke:", err)
+			return exitFailed
+		}
+		fmt.Println("Revoked", *urn)
+		return exitOk
+	}
+	fmt.Fprintln(os.Stderr, commands["accounts"].usage)
+	return exitUsage
+}
+
+/*********************************************************************
+ *
+ *
+ *      ___  ___  _   _ _ __ ___ ___  ___
+ *     / __|/ _ \| | | | '__/ __/ _ \/ __|
+ *     \__ \ (_) | |_| | | | (_|  __/\__ \
+ *     |___/\___/ \__,_|_|  \___\___||___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * sources add -url u [-topic t], sources list, sources remove -uid n.
+ * Removed sources are kept, stopped, with the articles they brought.
+ * ---------------------------------------------------------------- */
+func sourcesCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, commands["sources"].usage)
+		return exitUsage
+	}
+	flags := newFlags("sources " + args[0])
+	url := flags.String("url", "", "add: the feed url")
+	topic := flags.String("topic", feed.Topic, "add: topic the articles are filed under")
+	uid := flags.Int64("uid", 0, "remove: the source uid")
+	if flags.Parse(args[1:]) != nil {
+		return exitUsage
+	}
+	if !openForCommand() {
+		return exitFailed
+	}
+	defer sql.CloseDB()
+	switch args[0] {
+	case "add":
+		if !strings.HasPrefix(*url, "http://") && !strings.HasPrefix(*url, "https://") {
+			fmt.Fprintln(os.Stderr, "add needs an http or https -url")
+			return exitUsage
+		}
+		source, err := sql.AddSource(context.Background(), *url, *topic)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Add:", err)
+			return exitFailed
+		}
+		return printJson(source)
+	case "list":
+		sList, err := sql.ListSources(context.Background())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "List:", err)
+			return exitFailed
+		}
+		return printJson(sList)
+	case "remove":
+		if *uid <= 0 {
+			fmt.Fprintln(os.Stderr, "remove needs -uid")
+			return exitUsage
+		}
+		if err := sql.RemoveSource(context.Background(), *uid); err != nil {
+			fmt.Fprintln(os.Stderr, "Remove:", err)
+			return exitFailed
+		}
+		fmt.Println("Removed", *uid)
+		return exitOk
+	}
+	fmt.Fprintln(os.Stderr, commands["sources"].usage)
+	return exitUsage
+}
+
+/****************************************************
+ *                                 _
+ *                                | |
+ *       _____  ___ __   ___  _ __| |_
+ *      / _ \ \/ / '_ \ / _ \| '__| __|
+ *     |  __/>  <| |_) | (_) | |  | |_
+ *      \___/_/\_\ .__/ \___/|_|   \__|
+ *               | |
+ *               |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Writes the articles out, oldest first, one json
+ * object to a line, a page at a time from the store
+ * keyed on the uid the last page ended at, so a page
+ * deep in costs the same as the first. They are only
+ * counted the once, on the first page.
+ * ----------------------------------------------- */
+func exportCommand(args []string) int {
+	flags := newFlags("export")
+	from := flags.String("from", "", "first day, "+dateFormat)
+	to := flags.String("to", "", "last day, "+dateFormat)
+	category := flags.String("cat", "", "only this category")
+	output := flags.String("o", "", "file to write, stdout if not given")
+	if flags.Parse(args) != nil {
+		return exitUsage
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, commands["export"].usage)
+		return exitUsage
+	}
+	query := sql.NewsQuery{Sort: "uid_asc", Limit: exportPage}
+	var err error
+	if len(*from) > 0 {
+		if query.From, err = time.Parse(dateFormat, *from); err != nil {
+			fmt.Fprintln(os.Stderr, "-from:", err)
+			return exitUsage
+		}
+	}
+	if len(*to) > 0 {
+		if query.To, err = time.Parse(dateFormat, *to); err != nil {
+			fmt.Fprintln(os.Stderr, "-to:", err)
+			return exitUsage
+		}
+		query.To = query.To.AddDate(0, 0, 1) // The whole of the last day.
+	}
+	if len(*category) > 0 {
+		query.Categories = []string{*category}
+	}
+	if !openForCommand() {
+		return exitFailed
+	}
+	out := io.Writer(os.Stdout)
+	if len(*output) > 0 {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Export:", err)
+			return exitFailed
+		}
+		defer lib.DeferClose(file)
+		out = file
+	}
+	encoder := json.NewEncoder(out)
+	count := 0
+	var total int64
+	for {
+		aList, counted, err := exportStore.List(context.Background(), query)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Export:", err)
+			return exitFailed
+		}
+		if !query.Uncounted {
+			total, query.Uncounted = counted, true
+		}
+		for _, a := range aList {
+			if err = encoder.Encode(a); err != nil {
+				fmt.Fprintln(os.Stderr, "Export:", err)
+				return exitFailed
+			}
+		}
+		count += len(aList)
+		if len(aList) < query.Limit {
+			break
+		}
+		query.AfterUid = aList[len(aList)-1].Uid
+	}
+	fmt.Fprintln(os.Stderr, count, "of", total, "articles exported")
+	return exitOk
+}
+
+func configCommand(args []string) int {
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, commands["config"].usage)
+		return exitUsage
+	}
+	conf.PrintConfig(os.Stdout)
+	return exitOk
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"[app name]/sql"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Keeps the queries the export pages through.
+type pagingStore struct {
+	sql.ArticleStore
+	queries []sql.NewsQuery
+}
+
+func (s *pagingStore) List(ctx context.Context, query sql.NewsQuery) ([]sql.Article, int64, error) {
+	s.queries = append(s.queries, query)
+	return s.ArticleStore.List(ctx, query)
+}
+
+// Exports from articles in memory, without a database, until the test ends.
+func useExportStore(t *testing.T, aList ...sql.Article) *pagingStore {
+	t.Helper()
+	store := &pagingStore{ArticleStore: sql.NewMemoryArticles(aList...)}
+	oldOpen, oldStore := openDB, exportStore
+	openDB, exportStore = func() error { return nil }, store
+	t.Cleanup(func() { openDB, exportStore = oldOpen, oldStore })
+	return store
+}
+
+func numberedArticles(count int) (aList []sql.Article) {
+	for i := 1; i <= count; i++ {
+		cat := "general"
+		if i%2 == 0 {
+			cat = "science"
+		}
+		aList = append(aList, sql.Article{Title: "Article " + strconv.Itoa(i), Topic: "general", Cat: cat})
+	}
+	return
+}
+
+// The uids of the articles written one json object to a line.
+func exportedUids(t *testing.T, out string) (uids []int64) {
+	t.Helper()
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var a sql.Article
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		uids = append(uids, a.Uid)
+	}
+	return
+}
+
+// What the function writes to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = write
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(read)
+		out <- string(b)
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	fn()
+	_ = write.Close()
+	return <-out
+}
+
+func TestExportUsage(t *testing.T) {
+	store := useExportStore(t)
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"unknown flag", []string{"-since", "2024-01-01"}},
+		{"bad from", []string{"-from", "01/05/2024"}},
+		{"bad to", []string{"-to", "2024-13-01"}},
+		{"flag without a value", []string{"-cat"}},
+		{"left over argument", []string{"-cat", "science", "extra"}},
+	}
+	for _, test := range tests {
+		if code := runCommand(append([]string{"export"}, test.args...)); code != exitUsage {
+			t.Errorf("%s: exit %d, want %d", test.name, code, exitUsage)
+		}
+	}
+	if len(store.queries) > 0 {
+		t.Errorf("the store was queried with bad arguments: %+v", store.queries)
+	}
+	if code := runCommand([]string{"exports"}); code != exitUsage {
+		t.Errorf("unknown command: exit %d, want %d", code, exitUsage)
+	}
+}
+
+func TestExportPagesByUid(t *testing.T) {
+	count := 2*exportPage + 3
+	store := useExportStore(t, numberedArticles(count)...)
+	name := filepath.Join(t.TempDir(), "export.jsonl")
+	if code := runCommand([]string{"export", "-o", name}); code != exitOk {
+		t.Fatalf("exit %d", code)
+	}
+	out, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids := exportedUids(t, string(out))
+	if len(uids) != count {
+		t.Fatalf("exported %d articles, want %d", len(uids), count)
+	}
+	for i, uid := range uids {
+		if uid != int64(i+1) {
+			t.Fatalf("article %d has uid %d, want them in uid order", i, uid)
+		}
+	}
+	if len(store.queries) != 3 {
+		t.Fatalf("%d pages, want 3", len(store.queries))
+	}
+	for i, query := range store.queries {
+		after := int64(i * exportPage)
+		if query.Offset != 0 || query.AfterUid != after || query.Sort != "uid_asc" || query.Limit != exportPage {
+			t.Errorf("page %d queried as %+v, want after uid %d", i, query, after)
+		}
+		if query.Uncounted != (i > 0) {
+			t.Errorf("page %d counted: %v, want only the first", i, !query.Uncounted)
+		}
+	}
+}
+
+func TestExportFilters(t *testing.T) {
+	today := time.Now().Format(dateFormat)
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"all", nil, 10},
+		{"category", []string{"-cat", "science"}, 5},
+		{"from today", []string{"-from", today}, 10},
+		{"to today", []string{"-to", today}, 10}, // The whole of the last day.
+		{"before", []string{"-to", "2000-01-01"}, 0},
+		{"after", []string{"-from", "2999-01-01"}, 0},
+	}
+	for _, test := range tests {
+		useExportStore(t, numberedArticles(10)...)
+		var code int
+		out := captureStdout(t, func() { code = runCommand(append([]string{"export"}, test.args...)) })
+		if code != exitOk {
+			t.Errorf("%s: exit %d", test.name, code)
+			continue
+		}
+		if uids := exportedUids(t, out); len(uids) != test.want {
+			t.Errorf("%s: exported %v, want %d articles", test.name, uids, test.want)
+		}
+	}
+}
+
+func TestExportFailures(t *testing.T) {
+	useExportStore(t)
+	openDB = func() error { return errors.New("no database") }
+	if code := runCommand([]string{"export"}); code != exitFailed {
+		t.Errorf("without a database: exit %d, want %d", code, exitFailed)
+	}
+	useExportStore(t)
+	if code := runCommand([]string{"export", "-o", filepath.Join(t.TempDir(), "missing", "export.jsonl")}); code != exitFailed {
+		t.Errorf("unable to write the file: exit %d, want %d", code, exitFailed)
+	}
+}
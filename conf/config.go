@@ -3,11 +3,14 @@ package conf
 import (
 	"[app name]/lib"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -19,14 +22,23 @@ var (
 	VERSION           string
 	AppName           string
 	LOGDIR            string
+	LOGOUTPUT         string
+	LOGFORMAT         string
+	LOGMAXSIZE        int
+	LOGMAXAGE         int
+	LOGKEEP           int
+	LOGCOMPRESS       bool
 	PORT              string
 	HEARTBEAT         int
+	STREAMHEARTBEAT   int
 	RSSFILES          string
 	ATOMFILES         string
 	JSONFILES         string
 	CHATID            string
 	BOTID             string
 	POSTDELAY         int
+	WEBHOOKRETRIES    int
+	TELEGRAMAPI       string
 	MYSQL_USER        string
 	MYSQL_PASS        string
 	MYSQL_DB          string
@@ -34,12 +46,34 @@ var (
 	MYSQL_HOST        string
 	POSTAGE           int
 	DISCORDTOKEN      string
+	DISCORDAPI        string
+	DISCORDAPPID      string
+	DISCORDPUBLICKEY  string
 	NEWSDETAIL        bool
 	NEWSLIMIT         int
 	MONITORAPI        string
 	MYDNS             string
 	THROTTLE          int
+	APIRATE           int
+	APIBURST          int
 	UPDATE_NEWSDETAIL bool
+	USAGEFLUSH        int
+	ACCOUNTREFRESH    int
+	ADMINKEY          string
+	KEYGRACE          int
+	OTPWINDOW         int
+	ADMINOTPKEY       string
+	ADMINSESSION      int
+	METRICSTOKEN      string
+	PUBLICURL         string
+	MAILHOST          string
+	MAILPORT          int
+	MAILFROM          string
+	MAILPASS          string
+	SIGNUPSECRET      string
+	SIGNUPEXPIRE      int
+	SIGNUPPERHOUR     int
+	MIGRATE           bool
 )
 
 /***********************************
@@ -61,9 +95,15 @@ type Reply struct {
 
 var RestartSequece bool
 
-func init() { // Autoloaded on run
+func init() { // Autoloaded on run, the defaults and environment only.
 	RestartSequece = true
 	flag.StringVar(&ConfigFilePath, "c", "app.conf", "config file path")
+	LoadConfig()
+}
+
+// Parses the flags, loads the config file and watches it for edits. Called once from main,
+// the tests leave it out and run on the defaults.
+func Init() {
 	flag.Parse()
 	lib.CheckErr(godotenv.Load(ConfigFilePath))
 
@@ -88,18 +128,27 @@ func LoadConfig() {
 		}
 	}()
 
-	DEBUG = getEnv("DEBUG", "ERROR DEBUG INFO")
+	DEBUG = getEnv("DEBUG", "CRIT ERROR WARN DEBUG INFO")
 	VERSION = getEnv("VERSION", "0.2.1")
 	AppName = getEnv("APPNAME", filepath.Base(os.Args[0]))
 	LOGDIR = getEnv("LOGDIR", ".")
+	LOGOUTPUT = getEnv("LOGOUTPUT", "syslog")       // syslog, file or stdout, a comma list for more than one.
+	LOGFORMAT = getEnv("LOGFORMAT", "text")         // text, json or logfmt.
+	LOGMAXSIZE = getEnvAsInt("LOGMAXSIZE", 100)     // MB the file in LOGDIR grows to before it is rotated.
+	LOGMAXAGE = getEnvAsInt("LOGMAXAGE", 24)        // Hours the file is written to before it is rotated.
+	LOGKEEP = getEnvAsInt("LOGKEEP", 14)            // Days the rotated files are kept.
+	LOGCOMPRESS = getEnvAsBool("LOGCOMPRESS", true) // Gzip the rotated files.
 	PORT = getEnv("PORT", "7451")
 	HEARTBEAT = getEnvAsInt("HEARTBEAT", 60)
+	STREAMHEARTBEAT = getEnvAsInt("STREAMHEARTBEAT", 15) // Seconds between the keep alive comments on an open event stream.
 	RSSFILES = getEnv("RSSFILES", "./rss")
 	ATOMFILES = getEnv("ATOMFILES", "./atom")
 	JSONFILES = getEnv("JSONFILES", "./json")
 	CHATID = getEnv("CHATID", "77612747")
 	BOTID = getEnv("BOTID", "1204200932:AAFR-Rr_kSzqSR4XnpcslTtVc0ddSRL1z_U")
 	POSTDELAY = getEnvAsInt("POSTDELAY", 5)
+	WEBHOOKRETRIES = getEnvAsInt("WEBHOOKRETRIES", 8)               // Tries at a delivery, backing off, before it goes to the dead letters.
+	TELEGRAMAPI = getEnv("TELEGRAMAPI", "https://api.telegram.org") // Point at a fake Bot API to try the poster out.
 	MYSQL_USER = getEnv("MYSQL_USER", "news")
 	MYSQL_PASS = getEnv("MYSQL_PASS", "NewsMe101")
 	MYSQL_DB = getEnv("MYSQL_DB", "news")
@@ -107,14 +156,46 @@ func LoadConfig() {
 	MYSQL_HOST = getEnv("MYSQL_HOST", "localhost")
 	POSTAGE = getEnvAsInt("POSTAGE", 362)
 	DISCORDTOKEN = getEnv("DISCORDTOKEN", "NzczODIzNTE2NjA0MTA0NzA0.X6O1Tw.pMjvmtozxsv2K7FAj69tHVSTdoc")
+	DISCORDAPI = getEnv("DISCORDAPI", "https://discord.com/api/v10") // Point at a fake api to try the poster out.
+	DISCORDAPPID = getEnv("DISCORDAPPID", "")                        // The bot application, for the /news command. None, no command.
+	DISCORDPUBLICKEY = getEnv("DISCORDPUBLICKEY", "")                // Checks the /news command calls really come from Discord.
 	NEWSDETAIL = getEnvAsBool("NEWSDETAIL", true)
 	NEWSLIMIT = getEnvAsInt("NEWSLIMIT", 100)
 	MONITORAPI = "http://" + getEnv("MONITORAPI", "domains.aenxchange.com:7440")
 	MYDNS = getEnv("MYDNS", "news.aensmart.com")
 	THROTTLE = getEnvAsInt("THROTTLE", 10)
+	APIRATE = getEnvAsInt("APIRATE", 2)   // Api calls a second across every caller.
+	APIBURST = getEnvAsInt("APIBURST", 5) // Calls over the rate let through at once.
 	UPDATE_NEWSDETAIL = getEnvAsBool("UPDATE_NEWSDETAIL", false)
+	USAGEFLUSH = getEnvAsInt("USAGEFLUSH", 30)
+	ACCOUNTREFRESH = getEnvAsInt("ACCOUNTREFRESH", 60)
+	ADMINKEY = getEnv("ADMINKEY", "")              // No admin key, no admin api.
+	KEYGRACE = getEnvAsInt("KEYGRACE", 24)         // Hours the old keys still work after a rotate.
+	OTPWINDOW = getEnvAsInt("OTPWINDOW", 1)        // Steps of 30 seconds either side of now a code is good for.
+	ADMINOTPKEY = getEnv("ADMINOTPKEY", "")        // TOTP secret for the admin, base32. No secret, no admin api.
+	ADMINSESSION = getEnvAsInt("ADMINSESSION", 15) // Minutes an admin session lasts after a good code.
+	METRICSTOKEN = getEnv("METRICSTOKEN", "")      // Bearer token /metrics wants. None, the metrics are open.
+
+	PUBLICURL = getEnv("PUBLICURL", "https://"+MYDNS) // Where the links in emails point.
+	MAILHOST = getEnv("MAILHOST", "localhost")
+	MAILPORT = getEnvAsInt("MAILPORT", 587)
+	MAILFROM = getEnv("MAILFROM", "news@"+MYDNS)
+	MAILPASS = getEnv("MAILPASS", "")
+	SIGNUPSECRET = getEnv("SIGNUPSECRET", "")       // Signs the verification links. No secret, no signups.
+	SIGNUPEXPIRE = getEnvAsInt("SIGNUPEXPIRE", 24)  // Hours a verification link is good for.
+	SIGNUPPERHOUR = getEnvAsInt("SIGNUPPERHOUR", 5) // Signups an address can ask for in an hour.
+	MIGRATE = getEnvAsBool("MIGRATE", false)        // Apply any new db/sql migrations at startup.
 
 	lib.LogInit(DEBUG, AppName) //Global debug levels.
+	lib.LogSetup(lib.LogOptions{
+		Outputs:  LOGOUTPUT,
+		Format:   LOGFORMAT,
+		Dir:      LOGDIR,
+		MaxSize:  int64(LOGMAXSIZE) << 20,
+		MaxAge:   time.Duration(LOGMAXAGE) * time.Hour,
+		Keep:     time.Duration(LOGKEEP) * 24 * time.Hour,
+		Compress: LOGCOMPRESS,
+	})
 	lib.Info("Logfile:", AppName)
 }
 
@@ -128,8 +209,10 @@ func getEnv(key string, defaultVal string) string {
 	}()
 
 	if value, exists := os.LookupEnv(key); exists {
+		remember(key, value)
 		return value
 	}
+	remember(key, defaultVal)
 	return defaultVal
 }
 
@@ -143,9 +226,10 @@ func getEnvAsBool(name string, defaultVal bool) bool {
 	}()
 	valStr := getEnv(name, "")
 	if val, err := strconv.ParseBool(valStr); err == nil {
+		remember(name, val)
 		return val
 	}
-
+	remember(name, defaultVal)
 	return defaultVal
 }
 
@@ -159,8 +243,10 @@ func getEnvAsInt(name string, defaultVal int) int {
 	}()
 	valueStr := getEnv(name, "")
 	if value, err := strconv.Atoi(valueStr); err == nil {
+		remember(name, value)
 		return value
 	}
+	remember(name, defaultVal)
 	return defaultVal
 }
 
@@ -222,3 +308,53 @@ func nextHeartBeat() (nextHeartBeat int) {
 	}
 	return
 }
+
+/*******************************************************************************
+ *      _____      _       _    _____             __ _
+ *     |  __ \    (_)     | |  / ____|           / _(_)
+ *     | |__) | __ _ _ __ | |_| |     ___  _ __ | |_ _  __ _
+ *     |  ___/ '__| | '_ \| __| |    / _ \| '_ \|  _| |/ _` |
+ *     | |   | |  | | | | | |_| |___| (_) | | | | | | | (_| |
+ *     |_|   |_|  |_|_| |_|\__|\_____\___/|_| |_|_| |_|\__, |
+ *                                                      __/ |
+ *                                                     |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Writes the settings as they were loaded, one per line in .env form, so the
+ * output can be diffed or fed back in. Passwords, keys, secrets and tokens only
+ * show whether they are set.
+ * -------------------------------------------------------------------------- */
+func PrintConfig(w io.Writer) {
+	settingsLock.Lock()
+	defer settingsLock.Unlock()
+	for _, key := range settingKeys {
+		value := fmt.Sprint(settings[key])
+		if secretSetting(key) && len(value) > 0 {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(w, "%s=%s\n", key, value)
+	}
+}
+
+var (
+	settingsLock sync.Mutex
+	settings     = make(map[string]interface{})
+	settingKeys  []string // In the order LoadConfig reads them.
+)
+
+func remember(key string, value interface{}) {
+	settingsLock.Lock()
+	defer settingsLock.Unlock()
+	if _, seen := settings[key]; !seen {
+		settingKeys = append(settingKeys, key)
+	}
+	settings[key] = value
+}
+
+func secretSetting(key string) bool {
+	for _, suffix := range []string{"PASS", "KEY", "SECRET", "TOKEN"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return key == "BOTID"
+}
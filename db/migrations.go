@@ -0,0 +1,9 @@
+package db
+
+import "embed"
+
+// The numbered scripts, built into the binary for the migration runner in sql.
+// Each sql/NNNN_name.sql can have a sql/down/NNNN_name.sql that undoes it.
+//
+//go:embed sql/*.sql sql/down/*.sql
+var Migrations embed.FS
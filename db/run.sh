@@ -14,7 +14,7 @@ export SQL_FOLDER=$PWD/sql
 export DBHOST=localhost
 export SCRIPTDIR=$PWD/script
 
-for entry in "ls -v $SCRIPTDIR"; do
+for entry in $(ls -v $SCRIPTDIR); do
     echo "Processing : $SCRIPTDIR/$entry "
     $SCRIPTDIR/$entry
 done
@@ -0,0 +1,3 @@
+#!/bin/bash
+
+mysql -u$MYSQL_USER -p$MYSQL_PASS < $SQL_FOLDER/0004_otp.sql 2>&1 | grep -v password >> deploy.log
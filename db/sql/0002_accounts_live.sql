@@ -0,0 +1,4 @@
+USE news;
+
+ALTER TABLE `news`.`accounts`
+    ADD COLUMN `live` TINYINT NOT NULL DEFAULT 1 AFTER `end`;
@@ -0,0 +1,24 @@
+USE news;
+
+CREATE TABLE IF NOT EXISTS `news`.`apikeys` (
+    `uid`         INT AUTO_INCREMENT PRIMARY KEY,
+    `urn`         INT NOT NULL,
+    `keyhash`     CHAR(64) NOT NULL UNIQUE,
+    `hint`        VARCHAR(8) NOT NULL DEFAULT '',
+    `live`        TINYINT NOT NULL DEFAULT 1,
+    `expires`     TIMESTAMP NULL DEFAULT NULL,
+    `timestamp`   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (`urn`),
+    FOREIGN KEY (`urn`) REFERENCES `accounts` (`urn`)
+) ENGINE=InnoDB DEFAULT CHARSET=UTF8MB4;
+
+-- Keys are made in the app now, the email derived ones are no longer issued.
+DROP TRIGGER IF EXISTS trigger_name;
+
+-- Existing keys keep working for 30 days, only their SHA-256 is kept from here on.
+-- They can be worked out from the account's email, so the holders have to rotate
+-- to a new key, at /api/V1/account/rotate or /admin/accounts/:urn/rotate, before then.
+-- A hash is 64 characters, so running this twice leaves the keys alone.
+INSERT INTO apikeys (urn, keyhash, hint, expires)
+    SELECT urn, SHA2(apikey, 256), RIGHT(apikey, 4), NOW() + INTERVAL 30 DAY FROM accounts WHERE CHAR_LENGTH(apikey) < 64;
+UPDATE accounts SET apikey = SHA2(apikey, 256) WHERE CHAR_LENGTH(apikey) < 64;
@@ -0,0 +1,16 @@
+USE news;
+
+-- The otpkey column is the TOTP secret, it only counts once a code from it is confirmed.
+ALTER TABLE `news`.`accounts`
+    ADD COLUMN `otplive` TINYINT NOT NULL DEFAULT 0 AFTER `otpkey`,
+    ADD COLUMN `otpstep` BIGINT NOT NULL DEFAULT 0 AFTER `otplive`;
+
+CREATE TABLE IF NOT EXISTS `news`.`recoverycodes` (
+    `uid`         INT AUTO_INCREMENT PRIMARY KEY,
+    `urn`         INT NOT NULL,
+    `codehash`    CHAR(64) NOT NULL,
+    `used`        TINYINT NOT NULL DEFAULT 0,
+    `timestamp`   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (`urn`, `codehash`),
+    FOREIGN KEY (`urn`) REFERENCES `accounts` (`urn`)
+) ENGINE=InnoDB DEFAULT CHARSET=UTF8MB4;
@@ -0,0 +1,5 @@
+USE news;
+
+-- Self service signups wait here, not live, until the emailed link is followed.
+ALTER TABLE `news`.`accounts`
+    ADD COLUMN `pending` TINYINT NOT NULL DEFAULT 0 AFTER `live`;
@@ -0,0 +1,17 @@
+USE news;
+
+-- Remote feeds to poll, with what the last fetch left for the next conditional GET.
+CREATE TABLE IF NOT EXISTS `news`.`sources` (
+    `uid`         INT AUTO_INCREMENT PRIMARY KEY,
+    `url`         VARCHAR(512) NOT NULL UNIQUE,
+    `topic`       VARCHAR(45) NOT NULL DEFAULT 'general',
+    `live`        TINYINT NOT NULL DEFAULT 1,
+    `etag`        VARCHAR(256) NOT NULL DEFAULT '',
+    `modified`    VARCHAR(64) NOT NULL DEFAULT '',
+    `failures`    INT NOT NULL DEFAULT 0,
+    `lasterror`   VARCHAR(512) NOT NULL DEFAULT '',
+    `lastfetch`   TIMESTAMP NULL DEFAULT NULL,
+    `next`        TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    `timestamp`   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (`live`, `next`)
+) ENGINE=InnoDB DEFAULT CHARSET=UTF8MB4;
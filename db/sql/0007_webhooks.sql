@@ -0,0 +1,27 @@
+USE news;
+
+-- Partner endpoints new articles are pushed to. Each one's cursor is its control record.
+CREATE TABLE IF NOT EXISTS `news`.`webhooks` (
+    `uid`         INT AUTO_INCREMENT PRIMARY KEY,
+    `url`         VARCHAR(512) NOT NULL,
+    `secret`      VARCHAR(128) NOT NULL,
+    `topic`       VARCHAR(45) NOT NULL DEFAULT 'general',
+    `cats`        VARCHAR(256) NOT NULL DEFAULT '',
+    `keywords`    VARCHAR(256) NOT NULL DEFAULT '',
+    `live`        TINYINT NOT NULL DEFAULT 1,
+    `failures`    INT NOT NULL DEFAULT 0,
+    `next`        TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    `timestamp`   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (`live`, `next`)
+) ENGINE=InnoDB DEFAULT CHARSET=UTF8MB4;
+
+-- Deliveries that failed every retry, kept for the admin to look into.
+CREATE TABLE IF NOT EXISTS `news`.`deadletters` (
+    `uid`         INT AUTO_INCREMENT PRIMARY KEY,
+    `webhook`     INT NOT NULL,
+    `article`     INT NOT NULL,
+    `attempts`    INT NOT NULL,
+    `lasterror`   VARCHAR(512) NOT NULL DEFAULT '',
+    `timestamp`   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (`webhook`)
+) ENGINE=InnoDB DEFAULT CHARSET=UTF8MB4;
@@ -0,0 +1,7 @@
+USE news;
+
+-- Per target settings for the publishers. A NULL detail follows NEWSDETAIL, keywords
+-- are any of, matched against the title, empty for every article.
+ALTER TABLE `news`.`control`
+    ADD COLUMN `detail`   TINYINT NULL DEFAULT NULL AFTER `platform`,
+    ADD COLUMN `keywords` VARCHAR(256) NOT NULL DEFAULT '' AFTER `detail`;
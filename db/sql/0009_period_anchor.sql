@@ -0,0 +1,8 @@
+USE news;
+
+-- The day of the month each period ends on, kept so a period cut short by a short
+-- month goes back to the full day after it. New accounts take the day they start.
+ALTER TABLE `news`.`accounts`
+    ADD COLUMN `anchor` TINYINT NOT NULL DEFAULT (DAYOFMONTH(CURDATE())) AFTER `end`;
+
+UPDATE `news`.`accounts` SET `anchor` = DAYOFMONTH(`end`);
@@ -0,0 +1,8 @@
+USE news;
+
+-- Back to an empty schema, everything in it goes.
+DROP TRIGGER IF EXISTS trigger_name;
+DROP TABLE IF EXISTS `news`.`accounts`;
+DROP TABLE IF EXISTS `news`.`control`;
+DROP TABLE IF EXISTS `news`.`genrated`;
+DROP TABLE IF EXISTS `news`.`articles`;
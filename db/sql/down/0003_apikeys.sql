@@ -0,0 +1,15 @@
+USE news;
+
+-- The stored hashes cannot be turned back into keys, accounts keep them and need
+-- new keys made by hand. New accounts get the email derived keys again.
+DROP TABLE IF EXISTS `news`.`apikeys`;
+
+DELIMITER //
+
+CREATE TRIGGER trigger_name BEFORE INSERT ON accounts
+FOR EACH ROW
+BEGIN
+  SET NEW.apikey = LEFT(MD5(CONCAT('salt', NEW.email)), 24);
+END//
+
+DELIMITER ;
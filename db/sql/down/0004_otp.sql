@@ -0,0 +1,7 @@
+USE news;
+
+DROP TABLE IF EXISTS `news`.`recoverycodes`;
+
+ALTER TABLE `news`.`accounts`
+    DROP COLUMN `otpstep`,
+    DROP COLUMN `otplive`;
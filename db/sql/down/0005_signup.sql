@@ -0,0 +1,4 @@
+USE news;
+
+ALTER TABLE `news`.`accounts`
+    DROP COLUMN `pending`;
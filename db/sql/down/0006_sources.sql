@@ -0,0 +1,3 @@
+USE news;
+
+DROP TABLE IF EXISTS `news`.`sources`;
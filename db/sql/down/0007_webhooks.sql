@@ -0,0 +1,5 @@
+USE news;
+
+DELETE FROM `news`.`control` WHERE platform = 'webhook';
+DROP TABLE IF EXISTS `news`.`deadletters`;
+DROP TABLE IF EXISTS `news`.`webhooks`;
@@ -0,0 +1,5 @@
+USE news;
+
+ALTER TABLE `news`.`control`
+    DROP COLUMN `keywords`,
+    DROP COLUMN `detail`;
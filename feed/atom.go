@@ -0,0 +1,143 @@
+package feed
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type atomFeed struct {
+	Title   string       `xml:"http://www.w3.org/2005/Atom title"`
+	Authors []atomPerson `xml:"http://www.w3.org/2005/Atom author"`
+	Entries []atomEntry  `xml:"http://www.w3.org/2005/Atom entry"`
+}
+
+type atomEntry struct {
+	Title      atomText       `xml:"http://www.w3.org/2005/Atom title"`
+	Summary    atomText       `xml:"http://www.w3.org/2005/Atom summary"`
+	Content    atomText       `xml:"http://www.w3.org/2005/Atom content"`
+	Links      []atomLink     `xml:"http://www.w3.org/2005/Atom link"`
+	Authors    []atomPerson   `xml:"http://www.w3.org/2005/Atom author"`
+	Categories []atomCategory `xml:"http://www.w3.org/2005/Atom category"`
+	Published  string         `xml:"http://www.w3.org/2005/Atom published"`
+	Updated    string         `xml:"http://www.w3.org/2005/Atom updated"`
+	Media      []rssMedia     `xml:"http://search.yahoo.com/mrss/ content"`
+	Thumbnail  []rssMedia     `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	Group      []rssMedia     `xml:"http://search.yahoo.com/mrss/ group>content"`
+}
+
+// Text constructs come as text, html or xhtml, the inner xml covers all three.
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",innerxml"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type atomPerson struct {
+	Name  string `xml:"http://www.w3.org/2005/Atom name"`
+	Email string `xml:"http://www.w3.org/2005/Atom email"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+/******************************************************************************
+ *      _____                            _
+ *     |  __ \                      /\  | |
+ *     | |__) |_ _ _ __ ___  ___   /  \ | |_ ___  _ __ ___
+ *     |  ___/ _` | '__/ __|/ _ \ / /\ \| __/ _ \| '_ ` _ \
+ *     | |  | (_| | |  \__ \  __// ____ \ || (_) | | | | | |
+ *     |_|   \__,_|_|  |___/\___/_/    \_\__\___/|_| |_| |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Atom 1.0 entries. The summary is the description when there is one, else the
+ * content, published is the date when there is one, else updated. Every author
+ * is kept, the entry's own or else the feed's.
+ * ------------------------------------------------------------------------- */
+func ParseAtom(r io.Reader) (items []Item, err error) {
+	var doc atomFeed
+	if err = newDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("atom: %v", err)
+	}
+	for _, entry := range doc.Entries {
+		people := entry.Authors
+		if len(people) == 0 {
+			people = doc.Authors
+		}
+		author, email := atomAuthors(people)
+		var categories []string
+		for _, c := range entry.Categories {
+			categories = append(categories, firstOf(c.Label, c.Term))
+		}
+		content := entry.Content.text()
+		items = append(items, Item{
+			Title:     plainText(entry.Title.text()),
+			Content:   plainText(firstOf(entry.Summary.text(), content)),
+			Author:    firstOf(author, doc.Title),
+			Email:     email,
+			Category:  trimAll(categories),
+			Link:      entry.link(),
+			Image:     entry.image(content + entry.Summary.text()),
+			Published: parseDate(firstOf(entry.Published, entry.Updated)),
+		})
+	}
+	return
+}
+
+// The text as html, escaped text and html both come through the decoder escaped.
+func (t atomText) text() string {
+	body := strings.TrimSpace(t.Body)
+	if strings.HasPrefix(body, "<![CDATA[") && strings.HasSuffix(body, "]]>") {
+		return body[len("<![CDATA[") : len(body)-len("]]>")]
+	}
+	if t.Type == "xhtml" {
+		return body
+	}
+	return unescapeXML(body)
+}
+
+// The alternate link, which is the one with no rel at all as often as not.
+func (entry atomEntry) link() string {
+	for _, l := range entry.Links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+func (entry atomEntry) image(body string) string {
+	for _, l := range entry.Links {
+		if l.Rel == "enclosure" && strings.HasPrefix(l.Type, "image/") {
+			return l.Href
+		}
+	}
+	return rssImage(rssItem{Media: entry.Media, Thumbnail: entry.Thumbnail, Group: entry.Group}, body)
+}
+
+// All the names, and the first email given.
+func atomAuthors(people []atomPerson) (author string, email string) {
+	var names []string
+	for _, p := range people {
+		if name := strings.TrimSpace(p.Name); len(name) > 0 {
+			names = append(names, name)
+		}
+		if len(email) == 0 {
+			email = strings.TrimSpace(p.Email)
+		}
+	}
+	return strings.Join(names, ", "), email
+}
+
+// Inner xml keeps the text escaped as it was in the file.
+func unescapeXML(body string) string {
+	return xmlEscapes.Replace(body)
+}
+
+var xmlEscapes = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&#39;", "'", "&amp;", "&")
@@ -0,0 +1,308 @@
+package feed
+
+import (
+	"[app name]/conf"
+	"[app name]/lib"
+	"[app name]/sql"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// One entry from any of the feed formats, before it becomes an article.
+type Item struct {
+	Title     string
+	Content   string
+	Author    string
+	Email     string
+	Category  []string
+	Link      string
+	Image     string
+	Published time.Time
+}
+
+// Reads one feed document into its items.
+type Parser func(r io.Reader) ([]Item, error)
+
+// What an ingest run did, for the logs and the ingest command.
+type Result struct {
+	Files  int
+	Added  int
+	Seen   int // Titles already held.
+	Failed int
+}
+
+var (
+	Store sql.ArticleStore = sql.MysqlArticles{} // Swap for sql.NewMemoryArticles() to run without a database.
+	Topic                  = "general"           // The topic feed files are filed under.
+
+	ingested = lib.NewCounter("news_articles_ingested_total", "Feed items taken in, by topic and result: added, seen or failed.", "topic", "result")
+)
+
+const (
+	archiveDir = "archive"
+	failedDir  = "failed"
+)
+
+// The article an item becomes, the image goes in the detail as InsertArticle puts it.
+// The feed's date goes there too, created is when the store took it in, as the
+// publishers follow created and an older date would put the item behind them.
+func (item Item) Article(topic string) sql.Article {
+	detail := sql.ArticleDetail{"img": item.Image}
+	if !item.Published.IsZero() {
+		detail["published"] = item.Published.UTC().Format(time.RFC3339)
+	}
+	return sql.Article{
+		Topic:   topic,
+		Title:   strings.TrimSpace(item.Title),
+		Content: item.Content,
+		Author:  item.Author,
+		Email:   item.Email,
+		Cat:     strings.Join(item.Category, ","),
+		Link:    item.Link,
+		Detail:  detail,
+	}
+}
+
+// Adds the items to the store under the topic, a title the store already holds counts as seen.
+func Insert(items []Item, topic string) (result Result) {
+	for _, item := range items {
+		a := item.Article(topic)
+		if len(a.Title) == 0 {
+			result.Failed++
+			ingested.Inc(topic, "failed")
+			continue
+		}
+		_, err := Store.Insert(context.Background(), a)
+		switch {
+		case err == nil:
+			result.Added++
+			ingested.Inc(topic, "added")
+		case strings.Contains(err.Error(), "Duplicate"):
+			result.Seen++
+			ingested.Inc(topic, "seen")
+		default:
+			lib.Warn("Feed insert:", a.Title, err)
+			result.Failed++
+			ingested.Inc(topic, "failed")
+		}
+	}
+	return
+}
+
+/*****************************************************************************
+ *      _____                       _   _____  _
+ *     |_   _|                     | | |  __ \(_)
+ *       | |  _ __   __ _  ___  ___| |_| |  | |_ _ __
+ *       | | | '_ \ / _` |/ _ \/ __| __| |  | | | '__|
+ *      _| |_| | | | (_| |  __/\__ \ |_| |__| | | |
+ *     |_____|_| |_|\__, |\___||___/\__|_____/|_|_|
+ *                   __/ |
+ *                  |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Parses every feed file in the folder into the article store. Titles already
+ * held count as seen, not as failures. Files that parse go to archive, files
+ * that do not go to failed, so nothing is read twice either way.
+ * ------------------------------------------------------------------------ */
+func IngestDir(dir string, parse Parser) (result Result, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name())
+		result.Files++
+		items, parseErr := parseFile(file, parse)
+		if parseErr != nil {
+			lib.Warn("Feed file did not parse:", file, parseErr)
+			result.Failed++
+			lib.CheckErr(moveTo(file, failedDir))
+			continue
+		}
+		done := Insert(items, Topic)
+		result.Added += done.Added
+		result.Seen += done.Seen
+		result.Failed += done.Failed
+		lib.CheckErr(moveTo(file, archiveDir))
+	}
+	if result.Files > 0 {
+		lib.Info("Feed folder ingested:", dir, fmt.Sprintf("%+v", result))
+	}
+	return
+}
+
+// Reads a file with the parser, or with whichever one suits it if there is none.
+func parseFile(file string, parse Parser) ([]Item, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if parse == nil {
+		if parse = Sniff(content); parse == nil {
+			return nil, fmt.Errorf("not a feed format we know")
+		}
+	}
+	return parse(bytes.NewReader(content))
+}
+
+// The parser for a document, by a look at how it starts. Nil if it is none of them.
+func Sniff(content []byte) Parser {
+	head := string(content)
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	trimmed := strings.TrimSpace(head)
+	switch {
+	case strings.Contains(head, "<rss"):
+		return ParseRSS
+	case strings.Contains(head, "<feed") && strings.Contains(head, "http://www.w3.org/2005/Atom"):
+		return ParseAtom
+	case strings.HasPrefix(trimmed, "{") && strings.Contains(head, "jsonfeed.org/version"):
+		return ParseJSONFeed
+	case strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{"):
+		return ParseArticles
+	}
+	return nil
+}
+
+// The app's own article json, one article or a list of them, as the ingest command
+// has always taken.
+func ParseArticles(r io.Reader) (items []Item, err error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	var aList []sql.HomeMadeArticle
+	if strings.HasPrefix(strings.TrimSpace(string(content)), "[") {
+		err = json.Unmarshal(content, &aList)
+	} else {
+		var a sql.HomeMadeArticle
+		err = json.Unmarshal(content, &a)
+		aList = append(aList, a)
+	}
+	for _, a := range aList {
+		items = append(items, Item{
+			Title:    a.Title,
+			Content:  a.Content,
+			Author:   a.Author,
+			Email:    a.Email,
+			Category: trimAll(strings.Split(a.Cat, ",")),
+			Link:     a.Link,
+			Image:    a.Image,
+		})
+	}
+	return
+}
+
+// Moves a file into a folder next to it, with the time added if the name is taken.
+func moveTo(file string, folder string) error {
+	dir := filepath.Join(filepath.Dir(file), folder)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	target := filepath.Join(dir, filepath.Base(file))
+	if lib.Exists(target) {
+		target += "." + time.Now().Format("20060102150405")
+	}
+	return os.Rename(file, target)
+}
+
+/****************************************************************************
+ *      _  __              _____                       _   _
+ *     | |/ /             |_   _|                     | | (_)
+ *     | ' / ___  ___ _ __  | |  _ __   __ _  ___  ___| |_ _ _ __   __ _
+ *     |  < / _ \/ _ \ '_ \ | | | '_ \ / _` |/ _ \/ __| __| | '_ \ / _` |
+ *     | . \  __/  __/ |_) || |_| | | | (_| |  __/\__ \ |_| | | | | (_| |
+ *     |_|\_\___|\___| .__/_____|_| |_|\__, |\___||___/\__|_|_| |_|\__, |
+ *                   | |                __/ |                       __/ |
+ *                   |_|               |___/                       |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Watches the feed folders from the config on a jittered heart beat, for the
+ * life of the app.
+ * ----------------------------------------------------------------------- */
+func KeepIngesting(heartBeat int) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Error("Feed ingest loop:", r)
+		}
+	}()
+	for {
+		for dir, parse := range folders() {
+			if !lib.Exists(dir) {
+				continue
+			}
+			_, err := IngestDir(dir, parse)
+			lib.CheckErr(err)
+		}
+		time.Sleep(time.Duration(lib.NextHeartBeat(heartBeat)) * time.Second)
+	}
+}
+
+// The folders from the config and how to read what is in them.
+func folders() map[string]Parser {
+	return map[string]Parser{
+		conf.RSSFILES:  ParseRSS,
+		conf.ATOMFILES: ParseAtom,
+		conf.JSONFILES: ParseJSONFeed,
+	}
+}
+
+var (
+	tagPattern   = regexp.MustCompile(`<[^>]*>`)
+	spacePattern = regexp.MustCompile(`\s+`)
+)
+
+// Feed bodies are html, the articles hold plain text.
+func plainText(body string) string {
+	body = tagPattern.ReplaceAllString(body, " ")
+	return strings.TrimSpace(spacePattern.ReplaceAllString(html.UnescapeString(body), " "))
+}
+
+// The first of the values that is not empty.
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// The dates feeds use, RFC 822 in its many forms and RFC 3339.
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC822Z,
+	time.RFC822,
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// The time of a feed date, the zero time if it is none of the known forms.
+func parseDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,217 @@
+package feed
+
+import (
+	"[app name]/sql"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The samples in testdata are cut down from real feeds, each in the shape its kind
+// of publisher sends.
+var sampleFeeds = []struct {
+	file  string
+	parse Parser
+	items []Item
+}{
+	{"bbc.xml", ParseRSS, []Item{
+		{
+			Title:     "Storm brings travel disruption across the north",
+			Content:   "Trains are cancelled & roads closed as winds reach 90mph.",
+			Author:    "BBC News",
+			Link:      "https://www.bbc.com/news/articles/c0storm1?at_medium=RSS&at_campaign=rss",
+			Image:     "https://ichef.bbci.co.uk/ace/standard/240/storm.jpg",
+			Published: time.Date(2025, 10, 18, 5, 47, 12, 0, time.UTC),
+		},
+		{
+			Title:     "Markets steady ahead of rate decision",
+			Content:   "Investors wait on the central bank's announcement.",
+			Author:    "BBC News",
+			Link:      "https://www.bbc.com/news/articles/c0markets2?at_medium=RSS&at_campaign=rss",
+			Image:     "https://ichef.bbci.co.uk/ace/standard/240/markets.jpg",
+			Published: time.Date(2025, 10, 17, 22, 3, 0, 0, time.UTC),
+		},
+	}},
+	{"wordpress.xml", ParseRSS, []Item{
+		{
+			Title:     "Why we moved to Go\u2019s new iterators",
+			Content:   "Range over func landed in 1.23 \u2014 here is how it went.",
+			Author:    "Sam Rivera",
+			Category:  []string{"Programming", "Go"},
+			Link:      "https://technotes.example/2025/10/16/iterators/",
+			Image:     "https://technotes.example/wp-content/uploads/2025/10/range.png",
+			Published: time.Date(2025, 10, 16, 9, 14, 51, 0, time.UTC),
+		},
+		{
+			Title:     "Release notes & changelog",
+			Content:   "Everything that changed in October.",
+			Author:    "Jo Smith",
+			Email:     "editor@technotes.example",
+			Link:      "https://technotes.example/2025/10/01/release/",
+			Published: time.Date(2025, 10, 1, 18, 0, 0, 0, time.UTC),
+		},
+	}},
+	{"latin1.xml", ParseRSS, []Item{
+		{
+			Title:     "Caf\u00e9 cr\u00e8me et d\u00e9bats",
+			Content:   "Le d\u00e9bat continue \u00e9tonnamment.",
+			Author:    "R\u00e9daction",
+			Email:     "redaction@journal.example",
+			Link:      "https://journal.example/cafe",
+			Image:     "https://journal.example/cafe.jpg",
+			Published: time.Date(2025, 10, 14, 6, 30, 0, 0, time.UTC),
+		},
+	}},
+	{"atom.xml", ParseAtom, []Item{
+		{
+			Title:     "v2.4.0 released",
+			Content:   "Faster builds and a new cache.",
+			Author:    "Release Bot",
+			Email:     "bot@releases.example",
+			Category:  []string{"release"},
+			Link:      "https://releases.example/v2.4.0",
+			Published: time.Date(2025, 10, 15, 11, 58, 0, 0, time.UTC),
+		},
+	}},
+	{"jsonfeed.json", ParseJSONFeed, []Item{
+		{
+			Title:     "Monday brief",
+			Content:   "Five things to know today.",
+			Author:    "Brief Desk",
+			Category:  []string{"daily", "brief"},
+			Link:      "https://brief.example/2025/10/13/brief",
+			Image:     "https://brief.example/img/monday.png",
+			Published: time.Date(2025, 10, 13, 6, 0, 0, 0, time.UTC),
+		},
+	}},
+}
+
+func TestParseSampleFeeds(t *testing.T) {
+	for _, sample := range sampleFeeds {
+		content, err := os.ReadFile(filepath.Join("testdata", sample.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parse := Sniff(content); reflect.ValueOf(parse).Pointer() != reflect.ValueOf(sample.parse).Pointer() {
+			t.Errorf("%s: sniffed as the wrong format", sample.file)
+		}
+		items, err := parseFile(filepath.Join("testdata", sample.file), nil)
+		if err != nil {
+			t.Errorf("%s: %v", sample.file, err)
+			continue
+		}
+		if len(items) != len(sample.items) {
+			t.Errorf("%s: %d items, want %d", sample.file, len(items), len(sample.items))
+			continue
+		}
+		for i, item := range items {
+			want := sample.items[i]
+			if !item.Published.Equal(want.Published) {
+				t.Errorf("%s item %d: published %s, want %s", sample.file, i, item.Published, want.Published)
+			}
+			item.Published, want.Published = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(item, want) {
+				t.Errorf("%s item %d:\n got %+v\nwant %+v", sample.file, i, item, want)
+			}
+		}
+	}
+}
+
+func TestParseBadFeeds(t *testing.T) {
+	for name, content := range map[string]string{
+		"not a feed": "hello there",
+		"cut short":  `<rss version="2.0"><channel><item><title>Half`,
+		"charset":    `<?xml version="1.0" encoding="EBCDIC"?><rss version="2.0"><channel></channel></rss>`,
+	} {
+		file := filepath.Join(t.TempDir(), "feed.xml")
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if items, err := parseFile(file, nil); err == nil {
+			t.Errorf("%s: parsed into %+v", name, items)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	for value, want := range map[string]time.Time{
+		"Sat, 18 Oct 2025 05:47:12 GMT":   time.Date(2025, 10, 18, 5, 47, 12, 0, time.UTC),
+		"Thu, 16 Oct 2025 09:14:51 +0000": time.Date(2025, 10, 16, 9, 14, 51, 0, time.UTC),
+		"Tue, 7 Oct 2025 08:30:00 +0200":  time.Date(2025, 10, 7, 6, 30, 0, 0, time.UTC),
+		"7 Oct 2025 08:30:00 +0200":       time.Date(2025, 10, 7, 6, 30, 0, 0, time.UTC),
+		"2025-10-15T11:58:00Z":            time.Date(2025, 10, 15, 11, 58, 0, 0, time.UTC),
+		"2025-10-15T11:58:00.250+01:00":   time.Date(2025, 10, 15, 10, 58, 0, 250000000, time.UTC),
+		" 2025-10-15 ":                    time.Date(2025, 10, 15, 0, 0, 0, 0, time.UTC),
+		"yesterday":                       {},
+	} {
+		if got := parseDate(value); !got.Equal(want) {
+			t.Errorf("%q: %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestItemArticle(t *testing.T) {
+	item := sampleFeeds[1].items[0]
+	a := item.Article("tech")
+	if a.Topic != "tech" || a.Cat != "Programming,Go" || a.Detail["img"] != item.Image {
+		t.Errorf("article %+v", a)
+	}
+	if a.Detail["published"] != "2025-10-16T09:14:51Z" {
+		t.Errorf("published %v, want the feed's date in the detail", a.Detail["published"])
+	}
+	if !a.Created.IsZero() {
+		t.Errorf("created %s, want it left for the store to set", a.Created)
+	}
+	if _, ok := (Item{Title: "Undated"}).Article("tech").Detail["published"]; ok {
+		t.Error("an undated item has a published date")
+	}
+}
+
+func TestIngestDir(t *testing.T) {
+	old := Store
+	t.Cleanup(func() { Store = old })
+	store := sql.NewMemoryArticles(sql.Article{Topic: Topic, Title: "Markets steady ahead of rate decision"})
+	Store = store
+
+	dir := t.TempDir()
+	for _, file := range []string{"bbc.xml", "wordpress.xml"} {
+		content, err := os.ReadFile(filepath.Join("testdata", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(dir, file), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.xml"), []byte("<rss><channel><item>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	result, err := IngestDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Result{Files: 3, Added: 3, Seen: 1, Failed: 1}); result != want {
+		t.Errorf("result %+v, want %+v", result, want)
+	}
+	for _, moved := range []string{"archive/bbc.xml", "archive/wordpress.xml", "failed/broken.xml"} {
+		if _, err := os.Stat(filepath.Join(dir, moved)); err != nil {
+			t.Errorf("%s: %v", moved, err)
+		}
+	}
+
+	// Newest first by uid, each created when it came in however old the feed says it is.
+	aList, err := store.Last(context.Background(), 10, nil, Topic)
+	if err != nil || len(aList) != 4 {
+		t.Fatalf("store holds %d articles, %v", len(aList), err)
+	}
+	for _, a := range aList[:3] {
+		if a.Created.Before(before) {
+			t.Errorf("%q created %s, before it was ingested", a.Title, a.Created)
+		}
+	}
+}
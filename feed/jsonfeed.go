@@ -0,0 +1,133 @@
+package feed
+
+import (
+	"[app name]/lib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type jsonFeed struct {
+	Version string         `json:"version"`
+	Title   string         `json:"title"`
+	Authors []jsonAuthor   `json:"authors"`
+	Author  *jsonAuthor    `json:"author"` // 1.0
+	Items   []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	Id            string           `json:"id"`
+	Url           string           `json:"url"`
+	ExternalUrl   string           `json:"external_url"`
+	Title         string           `json:"title"`
+	ContentHtml   string           `json:"content_html"`
+	ContentText   string           `json:"content_text"`
+	Summary       string           `json:"summary"`
+	Image         string           `json:"image"`
+	BannerImage   string           `json:"banner_image"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Authors       []jsonAuthor     `json:"authors"`
+	Author        *jsonAuthor      `json:"author"` // 1.0
+	Tags          []string         `json:"tags"`
+	Attachments   []jsonAttachment `json:"attachments"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+	Url  string `json:"url"`
+}
+
+type jsonAttachment struct {
+	Url      string `json:"url"`
+	MimeType string `json:"mime_type"`
+}
+
+/*******************************************************************************
+ *      _____                         _  _____  ____  _   _ ______            _
+ *     |  __ \                       | |/ ____|/ __ \| \ | |  ____|          | |
+ *     | |__) |_ _ _ __ ___  ___     | | (___ | |  | |  \| | |__ ___  ___  __| |
+ *     |  ___/ _` | '__/ __|/ _ \_   | |\___ \| |  | | . ` |  __/ _ \/ _ \/ _` |
+ *     | |  | (_| | |  \__ \  __/ |__| |____) | |__| | |\  | | |  __/  __/ (_| |
+ *     |_|   \__,_|_|  |___/\___|\____/|_____/ \____/|_| \_|_|  \___|\___|\__,_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * JSON Feed 1.1, and 1.0 with its single author. Items may have no title, they
+ * are given the start of their text. An image attachment, or failing that the
+ * item image, is the picture.
+ * -------------------------------------------------------------------------- */
+func ParseJSONFeed(r io.Reader) (items []Item, err error) {
+	var doc jsonFeed
+	if err = json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("json feed: %v", err)
+	}
+	if !strings.Contains(doc.Version, "jsonfeed.org") {
+		return nil, fmt.Errorf("json feed: unknown version %q", doc.Version)
+	}
+	feedAuthors := jsonAuthors(doc.Authors, doc.Author)
+	for _, ji := range doc.Items {
+		people := jsonAuthors(ji.Authors, ji.Author)
+		if len(people) == 0 {
+			people = feedAuthors
+		}
+		author, email := jsonAuthorNames(people)
+		body := firstOf(ji.ContentHtml, ji.ContentText)
+		description := plainText(firstOf(ji.Summary, body))
+		items = append(items, Item{
+			Title:     firstOf(plainText(ji.Title), shortTitle(description)),
+			Content:   description,
+			Author:    firstOf(author, doc.Title),
+			Email:     email,
+			Category:  trimAll(ji.Tags),
+			Link:      firstOf(ji.Url, ji.ExternalUrl, permalink(ji.Id)),
+			Image:     ji.image(),
+			Published: parseDate(firstOf(ji.DatePublished, ji.DateModified)),
+		})
+	}
+	return
+}
+
+func (ji jsonFeedItem) image() string {
+	for _, a := range ji.Attachments {
+		if strings.HasPrefix(a.MimeType, "image/") {
+			return a.Url
+		}
+	}
+	return firstOf(ji.Image, ji.BannerImage, lib.GetImagePath(ji.ContentHtml))
+}
+
+// 1.1 has a list of authors, 1.0 had the one.
+func jsonAuthors(authors []jsonAuthor, author *jsonAuthor) []jsonAuthor {
+	if len(authors) == 0 && author != nil {
+		return []jsonAuthor{*author}
+	}
+	return authors
+}
+
+// All the names, and the first mailto url as the email.
+func jsonAuthorNames(people []jsonAuthor) (author string, email string) {
+	var names []string
+	for _, p := range people {
+		if name := strings.TrimSpace(p.Name); len(name) > 0 {
+			names = append(names, name)
+		}
+		if len(email) == 0 && strings.HasPrefix(p.Url, "mailto:") {
+			email = strings.TrimPrefix(p.Url, "mailto:")
+		}
+	}
+	return strings.Join(names, ", "), email
+}
+
+// The first words of the text, for items that come without a title.
+func shortTitle(text string) string {
+	const size = 80
+	runes := []rune(text)
+	if len(runes) <= size {
+		return text
+	}
+	cut := strings.LastIndex(string(runes[:size]), " ")
+	if cut < len(string(runes[:size/2])) {
+		cut = len(string(runes[:size]))
+	}
+	return strings.TrimSpace(text[:cut]) + "..."
+}
@@ -0,0 +1,178 @@
+package feed
+
+import (
+	"[app name]/conf"
+	"[app name]/lib"
+	"[app name]/sql"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxFeedBytes = 10 << 20 // A feed bigger than this is not one we want.
+	maxBackoff   = 24 * time.Hour
+	fetchTimeout = 30 * time.Second
+	feedAccept   = "application/rss+xml, application/atom+xml, application/feed+json, application/xml;q=0.9, application/json;q=0.9, */*;q=0.8"
+)
+
+var (
+	feedClient    = &http.Client{Timeout: fetchTimeout}
+	sourceFetched = sql.SourceFetched // Swap the two to poll without a database.
+	sourceFailed  = sql.SourceFailed
+)
+
+/*****************************************************************************
+ *      _  __               _____      _ _ _
+ *     | |/ /              |  __ \    | | (_)
+ *     | ' / ___  ___ _ __ | |__) |__ | | |_ _ __   __ _
+ *     |  < / _ \/ _ \ '_ \|  ___/ _ \| | | | '_ \ / _` |
+ *     | . \  __/  __/ |_) | |  | (_) | | | | | | | (_| |
+ *     |_|\_\___|\___| .__/|_|   \___/|_|_|_|_| |_|\__, |
+ *                   | |                            __/ |
+ *                   |_|                           |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Fetches the sources that are due on a jittered heart beat, for the life of
+ * the app. Each source carries its own next time, so a failing feed backs off
+ * without holding up the others, and a round that panics is only that round.
+ * ------------------------------------------------------------------------ */
+func KeepPolling(heartBeat int) {
+	for {
+		pollDue(heartBeat)
+		time.Sleep(time.Duration(lib.NextHeartBeat(heartBeat)) * time.Second)
+	}
+}
+
+// One round over the sources that are due.
+func pollDue(heartBeat int) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Error("Feed poll round:", r)
+		}
+	}()
+	sources, err := sql.DueSources(context.Background(), time.Now())
+	lib.CheckErr(err)
+	for _, source := range sources {
+		_, err = PollSource(source, heartBeat)
+		if err != nil {
+			lib.Warn("Feed source failed:", source.Uid, source.Url, err)
+		}
+	}
+}
+
+/********************************************************************************
+ *      _____      _ _  _____
+ *     |  __ \    | | |/ ____|
+ *     | |__) |__ | | | (___   ___  _   _ _ __ ___ ___
+ *     |  ___/ _ \| | |\___ \ / _ \| | | | '__/ __/ _ \
+ *     | |  | (_) | | |____) | (_) | |_| | | | (_|  __/
+ *     |_|   \___/|_|_|_____/ \___/ \__,_|_|  \___\___|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * One conditional fetch of a source. A 304 only moves the source on to its
+ * next time, a new body goes through the parser that suits it and into the
+ * articles. Any failure is recorded on the source with the backoff that follows.
+ * --------------------------------------------------------------------------- */
+func PollSource(source sql.Source, heartBeat int) (result Result, err error) {
+	etag, modified, retryAfter, body, err := fetch(source)
+	if err == nil && body != nil {
+		result, err = parseBody(body, source.Topic)
+	}
+	if err != nil {
+		failures := source.Failures + 1
+		next := time.Now().Add(backoff(heartBeat, failures))
+		if retry := time.Now().Add(retryAfter); retry.After(next) {
+			next = retry
+		}
+		lib.CheckErr(sourceFailed(context.Background(), source.Uid, failures, err.Error(), next))
+		return
+	}
+	next := time.Now().Add(time.Duration(lib.NextHeartBeat(heartBeat)) * time.Second)
+	lib.CheckErr(sourceFetched(context.Background(), source.Uid, firstOf(etag, source.Etag), firstOf(modified, source.Modified), next))
+	if result.Added > 0 {
+		lib.Info("Feed source polled:", source.Url, fmt.Sprintf("%+v", result))
+	}
+	return
+}
+
+// Gets the feed, the body is nil when it has not changed since the last fetch.
+func fetch(source sql.Source) (etag string, modified string, retryAfter time.Duration, body []byte, err error) {
+	req, err := http.NewRequest(http.MethodGet, source.Url, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", conf.AppName)
+	req.Header.Set("Accept", feedAccept)
+	if len(source.Etag) > 0 {
+		req.Header.Set("If-None-Match", source.Etag)
+	}
+	if len(source.Modified) > 0 {
+		req.Header.Set("If-Modified-Since", source.Modified)
+	}
+	resp, err := feedClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer lib.DeferClose(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		err = fmt.Errorf("%s", resp.Status)
+		return
+	default:
+		err = fmt.Errorf("%s", resp.Status)
+		return
+	}
+	body, err = io.ReadAll(io.LimitReader(resp.Body, maxFeedBytes+1))
+	if err == nil && len(body) > maxFeedBytes {
+		err = fmt.Errorf("feed is over %d bytes", maxFeedBytes)
+	}
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+	return resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), 0, body, nil
+}
+
+func parseBody(body []byte, topic string) (result Result, err error) {
+	parse := Sniff(body)
+	if parse == nil {
+		return result, fmt.Errorf("not a feed format we know")
+	}
+	items, err := parse(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	return Insert(items, topic), nil
+}
+
+// The wait after the failures in a row, doubling from the heart beat up to a day, jittered
+// the way the heart beat is so a batch of dead feeds do not all come round together.
+func backoff(heartBeat int, failures int) time.Duration {
+	wait := time.Duration(heartBeat) * time.Second
+	for i := 0; i < failures && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	wait = time.Duration(lib.NextHeartBeat(int(wait/time.Second))) * time.Second
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+// Retry-After in seconds or as a date, zero if it is neither.
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,188 @@
+package feed
+
+import (
+	"[app name]/sql"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const heartBeat = 60
+
+// What PollSource wrote back to the source.
+type sourceUpdate struct {
+	uid      int64
+	etag     string
+	modified string
+	failures int
+	reason   string
+	next     time.Time
+}
+
+// The source updates caught and the articles in memory, put back when the test ends.
+func usePollStandIn(t *testing.T) (updates func() []sourceUpdate) {
+	t.Helper()
+	oldFetched, oldFailed, oldStore := sourceFetched, sourceFailed, Store
+	t.Cleanup(func() { sourceFetched, sourceFailed, Store = oldFetched, oldFailed, oldStore })
+	Store = sql.NewMemoryArticles()
+	var lock sync.Mutex
+	var caught []sourceUpdate
+	sourceFetched = func(ctx context.Context, uid int64, etag string, modified string, next time.Time) error {
+		lock.Lock()
+		defer lock.Unlock()
+		caught = append(caught, sourceUpdate{uid: uid, etag: etag, modified: modified, next: next})
+		return nil
+	}
+	sourceFailed = func(ctx context.Context, uid int64, failures int, reason string, next time.Time) error {
+		lock.Lock()
+		defer lock.Unlock()
+		caught = append(caught, sourceUpdate{uid: uid, failures: failures, reason: reason, next: next})
+		return nil
+	}
+	return func() []sourceUpdate {
+		lock.Lock()
+		defer lock.Unlock()
+		taken := caught
+		caught = nil
+		return taken
+	}
+}
+
+func within(t *testing.T, what string, got time.Time, from time.Time, to time.Time) {
+	t.Helper()
+	if got.Before(from) || got.After(to) {
+		t.Errorf("%s %s, want between %s and %s", what, got.Format(time.RFC3339), from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+}
+
+func TestPollSourceConditional(t *testing.T) {
+	updates := usePollStandIn(t)
+	feed, err := os.ReadFile(filepath.Join("testdata", "bbc.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const etag, modified = `"bbc-1"`, "Sat, 18 Oct 2025 06:12:45 GMT"
+	var notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == modified {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified)
+		w.Header().Set("Content-Type", "application/rss+xml")
+		_, _ = w.Write(feed)
+	}))
+	defer server.Close()
+
+	source := sql.Source{Uid: 1, Url: server.URL, Topic: "general"}
+	start := time.Now()
+	result, err := PollSource(source, heartBeat)
+	if err != nil || result.Added != 2 {
+		t.Fatalf("first poll: %+v, %v", result, err)
+	}
+	got := updates()
+	if len(got) != 1 || got[0].etag != etag || got[0].modified != modified || got[0].failures != 0 {
+		t.Fatalf("first poll updated %+v", got)
+	}
+	within(t, "next poll", got[0].next, start.Add(heartBeat/2*time.Second), time.Now().Add(2*heartBeat*time.Second))
+
+	source.Etag, source.Modified = got[0].etag, got[0].modified
+	result, err = PollSource(source, heartBeat)
+	if err != nil || result != (Result{}) {
+		t.Fatalf("second poll: %+v, %v", result, err)
+	}
+	if notModified != 1 {
+		t.Errorf("%d not modified replies, want 1", notModified)
+	}
+	got = updates()
+	if len(got) != 1 || got[0].etag != etag || got[0].modified != modified {
+		t.Errorf("a 304 lost the validators: %+v", got)
+	}
+}
+
+func TestPollSourceRetryAfter(t *testing.T) {
+	updates := usePollStandIn(t)
+	for _, retryAfter := range []string{"3600", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		start := time.Now()
+		_, err := PollSource(sql.Source{Uid: 2, Url: server.URL, Failures: 1}, heartBeat)
+		server.Close()
+		if err == nil || !strings.Contains(err.Error(), "429") {
+			t.Errorf("Retry-After %s: error %v, want the 429", retryAfter, err)
+		}
+		got := updates()
+		if len(got) != 1 || got[0].failures != 2 || !strings.Contains(got[0].reason, "429") {
+			t.Fatalf("Retry-After %s: updated %+v", retryAfter, got)
+		}
+		// The backoff for 2 failures is at most 8 heart beats, the hour is longer.
+		within(t, "retry", got[0].next, start.Add(time.Hour-2*time.Second), time.Now().Add(time.Hour))
+	}
+}
+
+func TestPollSourceFailures(t *testing.T) {
+	updates := usePollStandIn(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			http.Error(w, "gone", http.StatusGone)
+		case "/html":
+			_, _ = w.Write([]byte("<html><body>Not a feed</body></html>"))
+		}
+	}))
+	defer server.Close()
+	for path, reason := range map[string]string{"/gone": "410", "/html": "not a feed"} {
+		start := time.Now()
+		if _, err := PollSource(sql.Source{Uid: 3, Url: server.URL + path, Failures: 3}, heartBeat); err == nil {
+			t.Errorf("%s: no error", path)
+		}
+		got := updates()
+		if len(got) != 1 || got[0].failures != 4 || !strings.Contains(got[0].reason, reason) {
+			t.Fatalf("%s: updated %+v", path, got)
+		}
+		wait := heartBeat * time.Second << 4
+		within(t, path+" next", got[0].next, start.Add(wait/2), time.Now().Add(2*wait))
+	}
+}
+
+func TestBackoffGrows(t *testing.T) {
+	for failures := 0; failures <= 16; failures++ {
+		wait := heartBeat * time.Second << failures
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+		for i := 0; i < 20; i++ {
+			got := backoff(heartBeat, failures)
+			if got > maxBackoff {
+				t.Fatalf("%d failures: %s, over the most of %s", failures, got, maxBackoff)
+			}
+			if got < wait/2 || (got >= 2*wait && wait < maxBackoff) {
+				t.Fatalf("%d failures: %s, want around %s", failures, got, wait)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("120"); got != 2*time.Minute {
+		t.Errorf("seconds: %s", got)
+	}
+	if got := parseRetryAfter(time.Now().Add(10 * time.Minute).UTC().Format(http.TimeFormat)); got < 9*time.Minute || got > 10*time.Minute {
+		t.Errorf("date: %s", got)
+	}
+	for _, value := range []string{"", "-5", "0", "soon"} {
+		if got := parseRetryAfter(value); got != 0 {
+			t.Errorf("%q: %s, want 0", value, got)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package feed
+
+import (
+	"[app name]/lib"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title string    `xml:"title"`
+	Items []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string         `xml:"title"`
+	Description string         `xml:"description"`
+	Encoded     string         `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Link        string         `xml:"link"`
+	Guid        string         `xml:"guid"`
+	Author      string         `xml:"author"`
+	Creator     string         `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Category    []string       `xml:"category"`
+	PubDate     string         `xml:"pubDate"`
+	Date        string         `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Enclosure   []rssEnclosure `xml:"enclosure"`
+	Media       []rssMedia     `xml:"http://search.yahoo.com/mrss/ content"`
+	Thumbnail   []rssMedia     `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	Group       []rssMedia     `xml:"http://search.yahoo.com/mrss/ group>content"`
+}
+
+type rssEnclosure struct {
+	Url  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssMedia struct {
+	Url    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Medium string `xml:"medium,attr"`
+}
+
+/*******************************************************************************
+ *      _____                    _____   _____ _____
+ *     |  __ \                  |  __ \ / ____/ ____|
+ *     | |__) |_ _ _ __ ___  ___| |__) | (___| (___
+ *     |  ___/ _` | '__/ __|/ _ \  _  / \___ \\___ \
+ *     | |  | (_| | |  \__ \  __/ | \ \ ____) |___) |
+ *     |_|   \__,_|_|  |___/\___|_|  \_\_____/_____/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * RSS 2.0, with the extensions most feeds lean on: dc:creator for authors,
+ * content:encoded for the body and media:content or media:thumbnail for images.
+ * -------------------------------------------------------------------------- */
+func ParseRSS(r io.Reader) (items []Item, err error) {
+	var doc rssDocument
+	if err = newDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("rss: %v", err)
+	}
+	for _, ri := range doc.Channel.Items {
+		author, email := splitAuthor(firstOf(ri.Author, ri.Creator))
+		body := firstOf(ri.Description, ri.Encoded)
+		items = append(items, Item{
+			Title:     plainText(ri.Title),
+			Content:   plainText(body),
+			Author:    firstOf(author, doc.Channel.Title),
+			Email:     email,
+			Category:  trimAll(ri.Category),
+			Link:      firstOf(ri.Link, permalink(ri.Guid)),
+			Image:     rssImage(ri, body+ri.Encoded),
+			Published: parseDate(firstOf(ri.PubDate, ri.Date)),
+		})
+	}
+	return
+}
+
+// The first image the item carries, else the first one in its html.
+func rssImage(ri rssItem, body string) string {
+	for _, e := range ri.Enclosure {
+		if strings.HasPrefix(e.Type, "image/") {
+			return e.Url
+		}
+	}
+	media := append(append(ri.Media, ri.Group...), ri.Thumbnail...)
+	for _, m := range media {
+		if m.Medium == "image" || strings.HasPrefix(m.Type, "image/") || len(m.Type)+len(m.Medium) == 0 {
+			return m.Url
+		}
+	}
+	return lib.GetImagePath(body)
+}
+
+// RSS authors are an email with the name in brackets, "jo@news.com (Jo Smith)",
+// though plenty of feeds just put the name.
+func splitAuthor(author string) (name string, email string) {
+	if !strings.Contains(author, "@") {
+		return author, ""
+	}
+	if open := strings.Index(author, "("); open > 0 && strings.HasSuffix(author, ")") {
+		return strings.TrimSpace(author[open+1 : len(author)-1]), strings.TrimSpace(author[:open])
+	}
+	if address, err := mail.ParseAddress(author); err == nil {
+		return address.Name, address.Address
+	}
+	return "", author
+}
+
+// A guid is only a link when it looks like one.
+func permalink(guid string) string {
+	if strings.HasPrefix(guid, "http://") || strings.HasPrefix(guid, "https://") {
+		return guid
+	}
+	return ""
+}
+
+func trimAll(values []string) (trimmed []string) {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			trimmed = append(trimmed, value)
+		}
+	}
+	return
+}
+
+// Real feeds carry html entities and loose markup, and not all of them are utf-8.
+func newDecoder(r io.Reader) *xml.Decoder {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = charsetReader
+	return decoder
+}
+
+// Latin-1 and its Windows cousin cover most feeds that are not utf-8. The Windows
+// extras in 0x80 to 0x9f are taken as Latin-1, close enough for headlines.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "iso8859-1", "latin1", "latin-1", "windows-1252", "cp1252":
+		content, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		var out strings.Builder
+		for _, b := range content {
+			var buf [utf8.UTFMax]byte
+			out.Write(buf[:utf8.EncodeRune(buf[:], rune(b))])
+		}
+		return strings.NewReader(out.String()), nil
+	}
+	return nil, fmt.Errorf("unsupported charset: %s", charset)
+}
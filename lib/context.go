@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+)
+
+type requestIdKey struct{}
+
+/********************************************************************************
+ *     __          ___ _   _     _____                            _   _____    _
+ *     \ \        / (_) | | |   |  __ \                          | | |_   _|  | |
+ *      \ \  /\  / / _| |_| |__ | |__) |___  __ _ _   _  ___  ___| |_  | |  __| |
+ *       \ \/  \/ / | | __| '_ \|  _  // _ \/ _` | | | |/ _ \/ __| __| | | / _` |
+ *        \  /\  /  | | |_| | | | | \ \  __/ (_| | |_| |  __/\__ \ |_ _| || (_| |
+ *         \/  \/   |_|\__|_| |_|_|  \_\___|\__, |\__,_|\___||___/\__|_____\__,_|
+ *                                             | |
+ *                                             |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Carries a request's id in its context, from the http layer down into the
+ * store calls, so every line logged for the request can be found by it.
+ * --------------------------------------------------------------------------- */
+func WithRequestId(ctx context.Context, id string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// The request id in the context, empty if there is none.
+func RequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// A new random request id, 32 hex characters.
+func NewRequestId() string {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// The logger for the context, with its request id on every line if it has one.
+func Log(ctx context.Context) Logger {
+	if id := RequestId(ctx); len(id) > 0 {
+		return With("request_id", id)
+	}
+	return Logger{}
+}
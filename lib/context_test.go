@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// Keeps the entries written while it is added.
+type entrySink struct {
+	lock    sync.Mutex
+	entries []LogEntry
+}
+
+func (s *entrySink) Write(e LogEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+func (s *entrySink) Close() error { return nil }
+
+// Captures every level of entry until the test ends.
+func captureEntries(t *testing.T) *entrySink {
+	t.Helper()
+	debug := DebugLevel
+	sink := &entrySink{}
+	t.Cleanup(func() {
+		RemoveLogSink(sink)
+		DebugLevel = debug
+	})
+	DebugLevel = "DEBUG INFO WARN ERROR CRIT"
+	AddLogSink(sink)
+	return sink
+}
+
+func TestRequestIdContext(t *testing.T) {
+	ctx := WithRequestId(context.Background(), "req-1")
+	if id := RequestId(ctx); id != "req-1" {
+		t.Errorf("id %q, want req-1", id)
+	}
+	if id := RequestId(context.Background()); id != "" {
+		t.Errorf("id %q from a context without one", id)
+	}
+	var none context.Context
+	if id := RequestId(WithRequestId(none, "req-2")); id != "req-2" {
+		t.Errorf("id %q on a nil context, want req-2", id)
+	}
+	hex := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	first, second := NewRequestId(), NewRequestId()
+	if !hex.MatchString(first) || first == second {
+		t.Errorf("new ids %q and %q, want two different 32 hex characters", first, second)
+	}
+}
+
+func TestLogCarriesRequestId(t *testing.T) {
+	sink := captureEntries(t)
+	Log(WithRequestId(context.Background(), "req-1")).Info("with an id")
+	Log(context.Background()).Info("without one")
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if len(sink.entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(sink.entries))
+	}
+	if fields := sink.entries[0].Fields; len(fields) != 2 || fields[0] != "request_id" || fields[1] != "req-1" {
+		t.Errorf("fields %v, want request_id req-1", fields)
+	}
+	if fields := sink.entries[1].Fields; len(fields) != 0 {
+		t.Errorf("fields %v on a context without an id", fields)
+	}
+}
+
+func TestRemoveLogSink(t *testing.T) {
+	sink := captureEntries(t)
+	Info("kept")
+	RemoveLogSink(sink)
+	Info("not kept")
+	if len(sink.entries) != 1 || sink.entries[0].Msg != "kept" {
+		t.Errorf("entries %+v, want only the one before it was removed", sink.entries)
+	}
+}
@@ -6,14 +6,16 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"log/syslog"
 	"math"
 	"math/rand"
 	"net"
 	"os"
+	"os/signal"
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/goyeh/gomail-v2"
@@ -24,7 +26,6 @@ const (
 )
 
 var (
-	logOut     *syslog.Writer
 	DebugLevel string
 )
 
@@ -49,11 +50,11 @@ func randomRange(min int, max int) (randomRange int) {
  *     | | | | | | |_
  *     |_|_| |_|_|\__|  * * */
 func LogInit(level string, name string) {
-	var err error
 	DebugLevel = level
-	logOut, err = syslog.New(syslog.LOG_INFO, name)
-	log.SetOutput(logOut)
-	log.Println("Log Init:", logOut, err)
+	logName = name
+	LogSetup(LogOptions{Outputs: "syslog", Format: LogText})
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{}) // The log package goes to the same sinks.
 }
 
 /****   _             _    _      _
@@ -87,24 +88,7 @@ func logCore(level string, msg ...interface{}) {
 			fmt.Print("Error detected logging:", r)
 		}
 	}()
-	var err error
-	if strings.Contains(DebugLevel, level) {
-		switch level {
-		case "INFO":
-			err = logOut.Info(fmt.Sprint(msg...))
-		case "DEBUG":
-			err = logOut.Debug(fmt.Sprint(msg...))
-		case "ERROR":
-			err = logOut.Err(fmt.Sprint(msg...))
-		case "CRIT":
-			err = logOut.Crit(fmt.Sprint(msg...))
-		}
-		if strings.Contains(DebugLevel, "STDOUT") {
-			fmt.Println(msg...)
-		} else if err != nil {
-			panic(err)
-		}
-	}
+	logEntry(level, nil, msg...)
 }
 
 // -------------------------------------------------
@@ -245,18 +229,26 @@ type Services struct {
  * This function will temp store the value in a map and then remove it, it will
  * return true or false if the item is in the map, Now sets delay on second response
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
-var throttle = make(map[string]bool)
+var (
+	throttle     = make(map[string]bool)
+	throttleLock sync.Mutex // Handlers and the clearing goroutines all touch the map.
+)
 
 func ThrottleAllow(ip string, timeout int) (retVal bool) {
-	if throttle[ip] {
+	throttleLock.Lock()
+	seen := throttle[ip]
+	throttle[ip] = true
+	throttleLock.Unlock()
+	if seen {
 		Warn("-=Throttle=-To frequent calls from:", ip)
 		time.Sleep(time.Duration(timeout) * time.Second) //Random next cycle.
 		retVal = true                                    // false will result is receiging to frequent message
 	} else {
-		throttle[ip] = true
 		go func() {
 			time.Sleep(time.Duration(timeout) * time.Nanosecond) //Random next cycle.
+			throttleLock.Lock()
 			delete(throttle, ip)
+			throttleLock.Unlock()
 		}()
 		retVal = true
 	}
@@ -288,6 +280,28 @@ func StayAlive(fn func()) {
 	Debug("Stay Alive Ended")
 }
 
+/****************************************************************************
+ *      _______              ______      _ _
+ *     |__   __|            |  ____|    (_) |
+ *        | |_ __ __ _ _ __ | |__  __  ___| |_
+ *        | | '__/ _` | '_ \|  __| \ \/ / | __|
+ *        | | | | (_| | |_) | |____ >  <| | |_
+ *        |_|_|  \__,_| .__/|______/_/\_\_|\__|
+ *                    | |
+ *                    |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Waits for an interrupt or terminate signal, runs the clean up function and
+ * exits. Start it in its own go routine.
+ * ----------------------------------------------------------------------- */
+func TrapExit(fn func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	Info("Shutting down on signal:", sig)
+	fn()
+	os.Exit(0)
+}
+
 // ---___--_--_------------___-------_------_-----------
 //
 //	| __|(_)| | ___  ___ | __|__ __(_) ___| |_  ___
@@ -380,11 +394,12 @@ type Mail struct {
 	FILES   []string
 }
 
-func SendMail(mail Mail) {
+func SendMail(mail Mail) (err error) {
 	defer func() {
 		r := recover()
 		if r != nil {
 			Error("Sending Email problem:", r)
+			err = fmt.Errorf("sending email: %v", r)
 		}
 	}()
 	addresses := strings.Split(mail.TO, ";")
@@ -399,9 +414,9 @@ func SendMail(mail Mail) {
 	logCore("DEBUG", "Sending email")
 	del := gomail.NewDialer(mail.HOST, mail.PORT, mail.FROM, mail.PASS) // Settings for SMTP server
 	del.TLSConfig = &tls.Config{InsecureSkipVerify: true}
-	logCore("DEBUG", "Sent email:", mess)
-	res := del.DialAndSend(mess)
-	logCore("DEBUG", "Result:", res)
+	err = del.DialAndSend(mess)
+	logCore("DEBUG", "Sent email:", mail.SUBJECT, "Result:", err) // Not the body, it can carry links and keys.
+	return
 }
 
 /*****************************************************************
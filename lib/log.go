@@ -0,0 +1,464 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LogText   = "text"
+	LogJSON   = "json"
+	LogLogfmt = "logfmt"
+)
+
+var (
+	logLock  sync.Mutex
+	logSinks []LogSink
+	logName  string
+)
+
+// One log line before it is formatted, Fields are key, value pairs.
+type LogEntry struct {
+	Time   time.Time
+	Level  string
+	Msg    string
+	Fields []interface{}
+}
+
+/******************************************************************************
+ *      _                  _____ _       _
+ *     | |                / ____(_)     | |
+ *     | |     ___   __ _| (___  _ _ __ | | __
+ *     | |    / _ \ / _` |\___ \| | '_ \| |/ /
+ *     | |___| (_) | (_| |____) | | | | |   <
+ *     |______\___/ \__, |_____/|_|_| |_|_|\_\
+ *                   __/ |
+ *                  |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Somewhere the log lines go. The sinks here are syslog, stdout and a rotating
+ * file under LOGDIR, any other can be added with AddLogSink.
+ * ------------------------------------------------------------------------- */
+type LogSink interface {
+	Write(e LogEntry) error
+	Close() error
+}
+
+// How LogSetup builds the sinks, from the LOG settings in the config.
+type LogOptions struct {
+	Outputs  string        // syslog, file and stdout, a comma list for more than one.
+	Format   string        // text, json or logfmt.
+	Dir      string        // Where the file goes.
+	MaxSize  int64         // Bytes a file grows to before it is rotated.
+	MaxAge   time.Duration // How long a file is written to before it is rotated.
+	Keep     time.Duration // How long the rotated files are kept.
+	Compress bool          // Gzip the rotated files.
+}
+
+/*****************************************************************************
+ *      _                  _____      _
+ *     | |                / ____|    | |
+ *     | |     ___   __ _| (___   ___| |_ _   _ _ __
+ *     | |    / _ \ / _` |\___ \ / _ \ __| | | | '_ \
+ *     | |___| (_) | (_| |____) |  __/ |_| |_| | |_) |
+ *     |______\___/ \__, |_____/ \___|\__|\__,_| .__/
+ *                   __/ |                     | |
+ *                  |___/                      |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Swaps the sinks for those the options ask for, closing the old ones, so a
+ * config reload takes effect. STDOUT in DebugLevel adds stdout as it did. A
+ * sink that cannot be opened is left out with a note on stderr, and with none
+ * at all the lines go to stdout.
+ * ------------------------------------------------------------------------ */
+func LogSetup(opts LogOptions) {
+	var sinks []LogSink
+	outputs := strings.ToLower(opts.Outputs)
+	if strings.Contains(DebugLevel, "STDOUT") && !strings.Contains(outputs, "stdout") {
+		outputs += ",stdout" // As it always has.
+	}
+	for _, output := range strings.Split(outputs, ",") {
+		switch strings.TrimSpace(output) {
+		case "syslog":
+			sink, err := NewSyslogSink(logName, opts.Format)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Unable to log to syslog:", err)
+				continue
+			}
+			sinks = append(sinks, sink)
+		case "file":
+			file := &RotatingFile{Dir: opts.Dir, Name: logName, MaxSize: opts.MaxSize, MaxAge: opts.MaxAge, Keep: opts.Keep, Compress: opts.Compress}
+			sinks = append(sinks, &WriterSink{Writer: file, Format: opts.Format})
+		case "stdout":
+			sinks = append(sinks, &WriterSink{Writer: os.Stdout, Format: opts.Format})
+		case "":
+		default:
+			fmt.Fprintln(os.Stderr, "Unknown log output:", output)
+		}
+	}
+	if len(sinks) == 0 {
+		sinks = append(sinks, &WriterSink{Writer: os.Stdout, Format: opts.Format})
+	}
+	logLock.Lock()
+	old := logSinks
+	logSinks = sinks
+	logLock.Unlock()
+	for _, sink := range old {
+		_ = sink.Close()
+	}
+}
+
+// Adds a sink alongside those already set up.
+func AddLogSink(sink LogSink) {
+	logLock.Lock()
+	defer logLock.Unlock()
+	logSinks = append(logSinks, sink)
+}
+
+// Takes out a sink AddLogSink put in, without closing it. It has to be the same
+// value, a pointer say, for it to be found.
+func RemoveLogSink(sink LogSink) {
+	logLock.Lock()
+	defer logLock.Unlock()
+	kept := make([]LogSink, 0, len(logSinks))
+	for _, s := range logSinks {
+		if s != sink {
+			kept = append(kept, s)
+		}
+	}
+	logSinks = kept
+}
+
+/*******************************************************************
+ *      _
+ *     | |
+ *     | |     ___   __ _  __ _  ___ _ __
+ *     | |    / _ \ / _` |/ _` |/ _ \ '__|
+ *     | |___| (_) | (_| | (_| |  __/ |
+ *     |______\___/ \__, |\__, |\___|_|
+ *                   __/ | __/ |
+ *                  |___/ |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Logs with key/value fields on every line, lib.With("source", uid)
+ * .Info("Fetched") say. The plain lib.Info and the rest have none.
+ * -------------------------------------------------------------- */
+type Logger struct {
+	fields []interface{}
+}
+
+// A logger with the key, value pairs on every line.
+func With(keyvals ...interface{}) Logger {
+	return Logger{}.With(keyvals...)
+}
+
+// The logger with more key, value pairs added.
+func (l Logger) With(keyvals ...interface{}) Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	return Logger{fields: append(append(fields, l.fields...), keyvals...)}
+}
+
+func (l Logger) Debug(msg ...interface{}) { logEntry("DEBUG", l.fields, msg...) }
+func (l Logger) Info(msg ...interface{})  { logEntry("INFO", l.fields, msg...) }
+func (l Logger) Warn(msg ...interface{})  { logEntry("WARN", l.fields, msg...) }
+func (l Logger) Error(msg ...interface{}) { logEntry("ERROR", l.fields, msg...) }
+func (l Logger) Crit(msg ...interface{})  { logEntry("CRIT", l.fields, msg...) }
+
+// Hands the line to every sink if DebugLevel lets its level through.
+func logEntry(level string, fields []interface{}, msg ...interface{}) {
+	if !strings.Contains(DebugLevel, level) {
+		return
+	}
+	writeEntry(LogEntry{Time: time.Now(), Level: level, Msg: strings.TrimSuffix(fmt.Sprintln(msg...), "\n"), Fields: fields})
+}
+
+func writeEntry(e LogEntry) {
+	logLock.Lock()
+	defer logLock.Unlock()
+	for _, sink := range logSinks {
+		if err := sink.Write(e); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to log:", err, e.Msg)
+		}
+	}
+}
+
+/******************************************************************************
+ *      ______                         _   _
+ *     |  ____|                       | | | |
+ *     | |__ ___  _ __ _ __ ___   __ _| |_| |     ___   __ _
+ *     |  __/ _ \| '__| '_ ` _ \ / _` | __| |    / _ \ / _` |
+ *     | | | (_) | |  | | | | | | (_| | |_| |___| (_) | (_| |
+ *     |_|  \___/|_|  |_| |_| |_|\__,_|\__|______\___/ \__, |
+ *                                                      __/ |
+ *                                                     |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * The line as text, JSON or logfmt, with its newline. Text leaves the time and
+ * level off when the sink adds its own, as syslog does.
+ * ------------------------------------------------------------------------- */
+func FormatLog(format string, e LogEntry, stamped bool) []byte {
+	var b bytes.Buffer
+	switch format {
+	case LogJSON:
+		line := map[string]interface{}{}
+		forFields(e.Fields, func(key string, value interface{}) { line[key] = value })
+		line["time"], line["level"], line["app"], line["msg"] = e.Time.Format(time.RFC3339Nano), e.Level, logName, e.Msg
+		if err := json.NewEncoder(&b).Encode(line); err != nil {
+			b.Reset()
+			fmt.Fprintf(&b, "{\"time\":%q,\"level\":%q,\"msg\":%q}\n", e.Time.Format(time.RFC3339Nano), e.Level, e.Msg)
+		}
+		return b.Bytes()
+	case LogLogfmt:
+		fmt.Fprintf(&b, "time=%s level=%s app=%s msg=%s", e.Time.Format(time.RFC3339Nano), e.Level, logfmtValue(logName), logfmtValue(e.Msg))
+	default:
+		if !stamped {
+			fmt.Fprintf(&b, "%s %-5s ", e.Time.Format(timeFormat), e.Level)
+		}
+		b.WriteString(e.Msg)
+	}
+	forFields(e.Fields, func(key string, value interface{}) {
+		fmt.Fprintf(&b, " %s=%s", logfmtValue(key), logfmtValue(fmt.Sprint(value)))
+	})
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// Walks the key, value pairs, an odd one out goes under the key "extra".
+func forFields(fields []interface{}, fn func(key string, value interface{})) {
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 == len(fields) {
+			fn("extra", fieldValue(fields[i]))
+			return
+		}
+		fn(fmt.Sprint(fields[i]), fieldValue(fields[i+1]))
+	}
+}
+
+// Errors and Stringers as their text, so JSON does not turn them into {}.
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+// Quotes a logfmt value if it has a space, a quote or an = in it, or is empty.
+func logfmtValue(value string) string {
+	if len(value) == 0 || strings.ContainsAny(value, " =\"\t\r\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// Takes the lines of the log package, at INFO whatever DebugLevel is, as they always went to syslog.
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	writeEntry(LogEntry{Time: time.Now(), Level: "INFO", Msg: strings.TrimSuffix(string(p), "\n")})
+	return len(p), nil
+}
+
+/* ------------------------------------- */
+
+// Formatted lines to any writer, stdout or a RotatingFile.
+type WriterSink struct {
+	Writer io.Writer
+	Format string
+}
+
+func (s *WriterSink) Write(e LogEntry) error {
+	_, err := s.Writer.Write(FormatLog(s.Format, e, false))
+	return err
+}
+
+func (s *WriterSink) Close() error {
+	if c, ok := s.Writer.(io.Closer); ok && s.Writer != os.Stdout {
+		return c.Close()
+	}
+	return nil
+}
+
+// The local syslog, each level at its own priority.
+type SyslogSink struct {
+	writer *syslog.Writer
+	format string
+}
+
+func NewSyslogSink(name string, format string) (*SyslogSink, error) {
+	writer, err := syslog.New(syslog.LOG_INFO, name)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{writer: writer, format: format}, nil
+}
+
+func (s *SyslogSink) Write(e LogEntry) error {
+	line := strings.TrimSuffix(string(FormatLog(s.format, e, true)), "\n")
+	switch e.Level {
+	case "DEBUG":
+		return s.writer.Debug(line)
+	case "WARN":
+		return s.writer.Warning(line)
+	case "ERROR":
+		return s.writer.Err(line)
+	case "CRIT":
+		return s.writer.Crit(line)
+	}
+	return s.writer.Info(line)
+}
+
+func (s *SyslogSink) Close() error {
+	return s.writer.Close()
+}
+
+/*******************************************************************************
+ *      _____       _        _   _             ______ _ _
+ *     |  __ \     | |      | | (_)           |  ____(_) |
+ *     | |__) |___ | |_ __ _| |_ _ _ __   __ _| |__   _| | ___
+ *     |  _  // _ \| __/ _` | __| | '_ \ / _` |  __| | | |/ _ \
+ *     | | \ \ (_) | || (_| | |_| | | | | (_| | |    | | |  __/
+ *     |_|  \_\___/ \__\__,_|\__|_|_| |_|\__, |_|    |_|_|\___|
+ *                                        __/ |
+ *                                       |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * A log file under Dir that moves aside, with the time in its name, once it
+ * passes MaxSize or has been written to for MaxAge, either left at zero for
+ * no limit. The old ones are gzipped if Compress is set and removed after Keep.
+ * -------------------------------------------------------------------------- */
+type RotatingFile struct {
+	Dir      string
+	Name     string
+	MaxSize  int64
+	MaxAge   time.Duration
+	Keep     time.Duration
+	Compress bool
+
+	lock   sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func (r *RotatingFile) Write(p []byte) (n int, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.file == nil {
+		if err = r.open(); err != nil {
+			return
+		}
+	}
+	if (r.MaxSize > 0 && r.size+int64(len(p)) > r.MaxSize && r.size > 0) || (r.MaxAge > 0 && time.Since(r.opened) > r.MaxAge) {
+		if err = r.rotate(); err != nil {
+			return
+		}
+	}
+	n, err = r.file.Write(p)
+	r.size += int64(n)
+	return
+}
+
+func (r *RotatingFile) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RotatingFile) path() string {
+	return filepath.Join(r.Dir, r.Name+".log")
+}
+
+func (r *RotatingFile) open() error {
+	if err := os.MkdirAll(r.Dir, 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(r.path(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	// A file carried on from before counts its age from when it was last
+	// written, so restarting does not keep putting off MaxAge.
+	r.file, r.size, r.opened = file, info.Size(), time.Now()
+	if info.Size() > 0 {
+		r.opened = info.ModTime()
+	}
+	return nil
+}
+
+// Moves the file aside and starts a new one, the old ones are seen to in the background.
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+	stamp := time.Now().Format("20060102-150405.000")
+	rotated := filepath.Join(r.Dir, r.Name+"-"+stamp+".log")
+	for i := 1; Exists(rotated) || Exists(rotated+".gz"); i++ { // Two rotations in the one millisecond.
+		rotated = filepath.Join(r.Dir, r.Name+"-"+stamp+"-"+strconv.Itoa(i)+".log")
+	}
+	if err := os.Rename(r.path(), rotated); err != nil {
+		return err
+	}
+	go r.tidy(rotated)
+	return r.open()
+}
+
+// Gzips the newly rotated file and removes the rotated files older than Keep.
+func (r *RotatingFile) tidy(rotated string) {
+	if r.Compress {
+		if err := gzipFile(rotated); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to compress log:", rotated, err)
+		}
+	}
+	if r.Keep <= 0 {
+		return
+	}
+	old, _ := filepath.Glob(filepath.Join(r.Dir, r.Name+"-*.log*"))
+	for _, name := range old {
+		if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > r.Keep {
+			_ = os.Remove(name)
+		}
+	}
+}
+
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.Create(name + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err == nil {
+		err = zw.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,281 @@
+package lib
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The name the log lines and files go under for the test, put back when it ends.
+func useLogName(t *testing.T, name string) {
+	t.Helper()
+	before := logName
+	logName = name
+	t.Cleanup(func() { logName = before })
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func writeAll(t *testing.T, w io.Writer, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// The rotated files in dir, oldest first as the time is in the name.
+func rotatedFiles(t *testing.T, dir string, name string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, name+"-*.log*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Waits for the tidying done in the background after a rotation.
+func eventually(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Gave up waiting for", what)
+		}
+	}
+}
+
+func TestFormatLog(t *testing.T) {
+	useLogName(t, "news")
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		format  string
+		stamped bool
+		entry   LogEntry
+		want    string
+	}{
+		{"text", LogText, false, LogEntry{Time: at, Level: "INFO", Msg: "Fetched", Fields: []interface{}{"source", 7}},
+			"2024-05-01 12:00:00 INFO  Fetched source=7\n"},
+		{"text stamped", LogText, true, LogEntry{Time: at, Level: "WARN", Msg: "Slow feed"},
+			"Slow feed\n"},
+		{"text quoted", LogText, false, LogEntry{Time: at, Level: "ERROR", Msg: "Failed", Fields: []interface{}{"err", errors.New("no route"), "url", ""}},
+			"2024-05-01 12:00:00 ERROR Failed err=\"no route\" url=\"\"\n"},
+		{"json", LogJSON, false, LogEntry{Time: at, Level: "INFO", Msg: "Fetched 3", Fields: []interface{}{"source", 7, "err", errors.New("none"), "request_id", "req-1"}},
+			`{"app":"news","err":"none","level":"INFO","msg":"Fetched 3","request_id":"req-1","source":7,"time":"2024-05-01T12:00:00Z"}` + "\n"},
+		{"json fields do not overwrite", LogJSON, true, LogEntry{Time: at, Level: "DEBUG", Msg: "m", Fields: []interface{}{"msg", "x", "level", "y"}},
+			`{"app":"news","level":"DEBUG","msg":"m","time":"2024-05-01T12:00:00Z"}` + "\n"},
+		{"json odd field", LogJSON, false, LogEntry{Time: at, Level: "INFO", Msg: "m", Fields: []interface{}{"lonely"}},
+			`{"app":"news","extra":"lonely","level":"INFO","msg":"m","time":"2024-05-01T12:00:00Z"}` + "\n"},
+		{"json unencodable", LogJSON, false, LogEntry{Time: at, Level: "INFO", Msg: "m", Fields: []interface{}{"fn", func() {}}},
+			`{"time":"2024-05-01T12:00:00Z","level":"INFO","msg":"m"}` + "\n"},
+		{"logfmt", LogLogfmt, false, LogEntry{Time: at, Level: "INFO", Msg: "Fetched", Fields: []interface{}{"source", 7, "request_id", "req-1"}},
+			"time=2024-05-01T12:00:00Z level=INFO app=news msg=Fetched source=7 request_id=req-1\n"},
+		{"logfmt quoted", LogLogfmt, true, LogEntry{Time: at, Level: "WARN", Msg: "a=b \"c\"", Fields: []interface{}{"key with space", "line\nbreak", "odd"}},
+			"time=2024-05-01T12:00:00Z level=WARN app=news msg=\"a=b \\\"c\\\"\" \"key with space\"=\"line\\nbreak\" extra=odd\n"},
+	}
+	for _, test := range tests {
+		if got := string(FormatLog(test.format, test.entry, test.stamped)); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRotateOnSize(t *testing.T) {
+	dir := t.TempDir()
+	file := &RotatingFile{Dir: dir, Name: "test", MaxSize: 10}
+	defer file.Close()
+	writeAll(t, file, "0123456789ABCDEF\n") // Bigger than MaxSize, but the file is empty.
+	if rotated := rotatedFiles(t, dir, "test"); len(rotated) != 0 {
+		t.Fatalf("rotated %v before the file had anything in it", rotated)
+	}
+	writeAll(t, file, "second\n", "third\n")
+	rotated := rotatedFiles(t, dir, "test")
+	if len(rotated) != 2 {
+		t.Fatalf("rotated %v, want two", rotated)
+	}
+	// Two rotations in the one millisecond are told apart by a number, so it is
+	// what they hold that says which came first.
+	got := []string{readFile(t, rotated[0]), readFile(t, rotated[1])}
+	sort.Strings(got)
+	if got[0] != "0123456789ABCDEF\n" || got[1] != "second\n" {
+		t.Errorf("rotated files have %q", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "test.log")); got != "third\n" {
+		t.Errorf("log file has %q, want the third line", got)
+	}
+}
+
+func TestRotateOnAge(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "test.log")
+	if err := os.WriteFile(name, []byte("before the restart\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	written := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(name, written, written); err != nil {
+		t.Fatal(err)
+	}
+	// Reopened after a restart the file is already two hours old, past MaxAge.
+	file := &RotatingFile{Dir: dir, Name: "test", MaxAge: time.Hour}
+	defer file.Close()
+	writeAll(t, file, "after\n")
+	rotated := rotatedFiles(t, dir, "test")
+	if len(rotated) != 1 {
+		t.Fatalf("rotated %v, want the file from before the restart", rotated)
+	}
+	if got := readFile(t, rotated[0]); got != "before the restart\n" {
+		t.Errorf("rotated file has %q", got)
+	}
+	writeAll(t, file, "again\n") // The new file is not old yet.
+	if got := readFile(t, name); got != "after\nagain\n" {
+		t.Errorf("log file has %q", got)
+	}
+	if rotated := rotatedFiles(t, dir, "test"); len(rotated) != 1 {
+		t.Errorf("rotated %v, a new file should not be", rotated)
+	}
+}
+
+func TestRotateCompresses(t *testing.T) {
+	dir := t.TempDir()
+	file := &RotatingFile{Dir: dir, Name: "test", MaxSize: 10, Compress: true}
+	defer file.Close()
+	writeAll(t, file, "first line\n", "second line\n")
+	var rotated []string
+	eventually(t, "the rotated file to be gzipped", func() bool {
+		rotated = rotatedFiles(t, dir, "test")
+		return len(rotated) == 1 && filepath.Ext(rotated[0]) == ".gz"
+	})
+	in, err := os.Open(rotated[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(zr); err != nil || string(b) != "first line\n" {
+		t.Errorf("gzipped file has %q, %v", b, err)
+	}
+	if got := readFile(t, filepath.Join(dir, "test.log")); got != "second line\n" {
+		t.Errorf("log file has %q", got)
+	}
+}
+
+func TestRotateKeep(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "test-20200101-000000.000.log.gz")
+	recent := filepath.Join(dir, "test-20200102-000000.000.log")
+	other := filepath.Join(dir, "other-20200101-000000.000.log")
+	for name, age := range map[string]time.Duration{old: 48 * time.Hour, recent: time.Hour, other: 48 * time.Hour} {
+		if err := os.WriteFile(name, []byte("old\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, time.Now().Add(-age), time.Now().Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := &RotatingFile{Dir: dir, Name: "test", MaxSize: 10, Keep: 24 * time.Hour}
+	defer file.Close()
+	writeAll(t, file, "first line\n", "second line\n")
+	eventually(t, "the file past Keep to be removed", func() bool { return !Exists(old) })
+	rotated := rotatedFiles(t, dir, "test")
+	if len(rotated) != 2 || rotated[0] != recent {
+		t.Errorf("left %v, want %s and the file just rotated", rotated, filepath.Base(recent))
+	}
+	if !Exists(other) {
+		t.Error("removed the rotated file of another log")
+	}
+}
+
+func TestLogSetupReload(t *testing.T) {
+	useLogName(t, "test")
+	logLock.Lock()
+	sinks := logSinks
+	logLock.Unlock()
+	t.Cleanup(func() {
+		logLock.Lock()
+		setup := logSinks
+		logSinks = sinks
+		logLock.Unlock()
+		for _, sink := range setup {
+			_ = sink.Close()
+		}
+	})
+	debug := DebugLevel
+	t.Cleanup(func() { DebugLevel = debug })
+	DebugLevel = "INFO"
+
+	dir := t.TempDir()
+	opts := LogOptions{Outputs: "file", Format: LogLogfmt, Dir: dir}
+	fileOf := func() *RotatingFile {
+		t.Helper()
+		logLock.Lock()
+		defer logLock.Unlock()
+		if len(logSinks) != 1 {
+			t.Fatalf("%d sinks set up, want just the file", len(logSinks))
+		}
+		return logSinks[0].(*WriterSink).Writer.(*RotatingFile)
+	}
+	LogSetup(opts)
+	Info("before the reload")
+	first := fileOf()
+	LogSetup(opts) // As a config reload does.
+	second := fileOf()
+	if first == second {
+		t.Fatal("the reload kept the old sink")
+	}
+	if first.file != nil {
+		t.Error("the reload left the old file open")
+	}
+	Info("after the reload")
+	got := readFile(t, filepath.Join(dir, "test.log"))
+	for _, msg := range []string{"msg=\"before the reload\"", "msg=\"after the reload\""} {
+		if !strings.Contains(got, msg) {
+			t.Errorf("log file has\n%s\nwant a line with %s", got, msg)
+		}
+	}
+
+	// A reload to the same sinks again and again does not pile up open files.
+	for i := 0; i < 50; i++ {
+		LogSetup(opts)
+		Info("reload", i)
+	}
+	if second.file != nil {
+		t.Error("left the file of the second setup open")
+	}
+	if open := openCount(t, filepath.Join(dir, "test.log")); open != 1 {
+		t.Errorf("%d open files after the reloads, want 1", open)
+	}
+}
+
+// How many files this process has open at name, from /proc where there is one.
+func openCount(t *testing.T, name string) int {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("No /proc to count open files with:", err)
+	}
+	count := 0
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); err == nil && target == name {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,262 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+// The Prometheus client defaults, in seconds, right for most request and query times.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	metricsLock     sync.Mutex
+	metricFamilies  = make(map[string]*metricFamily)
+	metricCollected []func()
+)
+
+// One set of label values of a metric, the counts are per bucket, not yet added up.
+type metricSeries struct {
+	labels []string
+	value  float64
+	counts []uint64
+	sum    float64
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	lock   sync.Mutex
+	series map[string]*metricSeries
+}
+
+// A count that only goes up, one for each set of label values.
+type Counter struct{ family *metricFamily }
+
+// A value that goes up and down, set as it changes or just before a scrape.
+type Gauge struct{ family *metricFamily }
+
+// Observations counted into buckets, with their sum, for the quantiles and averages.
+type Histogram struct{ family *metricFamily }
+
+/******************************************************************************
+ *      _   _                _____                  _
+ *     | \ | |              / ____|                | |
+ *     |  \| | _____      _| |     ___  _   _ _ __ | |_ ___ _ __
+ *     | . ` |/ _ \ \ /\ / / |    / _ \| | | | '_ \| __/ _ \ '__|
+ *     | |\  |  __/\ V  V /| |___| (_) | |_| | | | | ||  __/ |
+ *     |_| \_|\___| \_/\_/  \_____\___/ \__,_|_| |_|\__\___|_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Registers a counter under the name, with the labels its values are told
+ * apart by. The name is registered once, a second one is a mistake and panics.
+ * ------------------------------------------------------------------------- */
+func NewCounter(name string, help string, labels ...string) Counter {
+	return Counter{registerMetric(name, help, metricCounter, labels, nil)}
+}
+
+func NewGauge(name string, help string, labels ...string) Gauge {
+	return Gauge{registerMetric(name, help, metricGauge, labels, nil)}
+}
+
+// Buckets are the upper bounds in order, nil for DefaultBuckets. +Inf is added when written.
+func NewHistogram(name string, help string, buckets []float64, labels ...string) Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return Histogram{registerMetric(name, help, metricHistogram, labels, buckets)}
+}
+
+func registerMetric(name string, help string, kind string, labels []string, buckets []float64) *metricFamily {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	if _, taken := metricFamilies[name]; taken {
+		panic("Metric registered twice: " + name)
+	}
+	family := &metricFamily{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*metricSeries)}
+	metricFamilies[name] = family
+	if len(labels) == 0 {
+		family.update(nil, func(s *metricSeries) {}) // Written as zero from the start.
+	}
+	return family
+}
+
+func (c Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Negative amounts are ignored, a counter never goes down.
+func (c Counter) Add(amount float64, labels ...string) {
+	if amount < 0 {
+		return
+	}
+	c.family.update(labels, func(s *metricSeries) { s.value += amount })
+}
+
+// For a count kept somewhere else, like the connection pool's, copied in before a scrape.
+func (c Counter) Set(value float64, labels ...string) {
+	c.family.update(labels, func(s *metricSeries) { s.value = value })
+}
+
+func (g Gauge) Set(value float64, labels ...string) {
+	g.family.update(labels, func(s *metricSeries) { s.value = value })
+}
+
+func (g Gauge) Add(amount float64, labels ...string) {
+	g.family.update(labels, func(s *metricSeries) { s.value += amount })
+}
+
+func (h Histogram) Observe(value float64, labels ...string) {
+	h.family.update(labels, func(s *metricSeries) {
+		i := sort.SearchFloat64s(h.family.buckets, value) // The first bound the value is under or on.
+		s.counts[i]++
+		s.sum += value
+	})
+}
+
+// Observes the seconds since start, as deferred at the top of what is being timed.
+func (h Histogram) Since(start time.Time, labels ...string) {
+	h.Observe(time.Since(start).Seconds(), labels...)
+}
+
+func (f *metricFamily) update(labels []string, change func(s *metricSeries)) {
+	if len(labels) != len(f.labels) {
+		panic("Metric " + f.name + " wants " + strconv.Itoa(len(f.labels)) + " labels, got " + strconv.Itoa(len(labels)))
+	}
+	key := strings.Join(labels, "\xff")
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labels: append([]string(nil), labels...)}
+		if f.kind == metricHistogram {
+			s.counts = make([]uint64, len(f.buckets)+1) // The last is +Inf.
+		}
+		f.series[key] = s
+	}
+	change(s)
+}
+
+// Runs before every WriteMetrics, to set the gauges that are read rather than kept.
+func OnMetrics(collect func()) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	metricCollected = append(metricCollected, collect)
+}
+
+/***************************************************************************
+ *     __          __   _ _       __  __      _        _
+ *     \ \        / /  (_) |     |  \/  |    | |      (_)
+ *      \ \  /\  / / __ _| |_ ___| \  / | ___| |_ _ __ _  ___ ___
+ *       \ \/  \/ / '__| | __/ _ \ |\/| |/ _ \ __| '__| |/ __/ __|
+ *        \  /\  /| |  | | ||  __/ |  | |  __/ |_| |  | | (__\__ \
+ *         \/  \/ |_|  |_|\__\___|_|  |_|\___|\__|_|  |_|\___|___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Writes every metric in the Prometheus text format, version 0.0.4, the
+ * families in name order and their series in label order, so the same
+ * metrics always read the same. Nothing but a writer is needed to check it.
+ * ---------------------------------------------------------------------- */
+func WriteMetrics(w io.Writer) error {
+	metricsLock.Lock()
+	collectors := append([]func(){}, metricCollected...)
+	families := make([]*metricFamily, 0, len(metricFamilies))
+	for _, family := range metricFamilies {
+		families = append(families, family)
+	}
+	metricsLock.Unlock()
+	for _, collect := range collectors {
+		collect()
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var buf bytes.Buffer
+	for _, family := range families {
+		family.write(&buf)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (f *metricFamily) write(buf *bytes.Buffer) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	buf.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+	buf.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != metricHistogram {
+			writeSample(buf, f.name, f.labels, s.labels, "", "", s.value)
+			continue
+		}
+		var count uint64
+		for i, bound := range f.buckets {
+			count += s.counts[i]
+			writeSample(buf, f.name+"_bucket", f.labels, s.labels, "le", formatMetric(bound), float64(count))
+		}
+		count += s.counts[len(f.buckets)]
+		writeSample(buf, f.name+"_bucket", f.labels, s.labels, "le", "+Inf", float64(count))
+		writeSample(buf, f.name+"_sum", f.labels, s.labels, "", "", s.sum)
+		writeSample(buf, f.name+"_count", f.labels, s.labels, "", "", float64(count))
+	}
+}
+
+// One line, name{label="value",...} value. The extra label is the le of a bucket.
+func writeSample(buf *bytes.Buffer, name string, names []string, values []string, extraName string, extraValue string, value float64) {
+	buf.WriteString(name)
+	if len(names) > 0 || len(extraName) > 0 {
+		buf.WriteByte('{')
+		for i, label := range names {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(label + `="` + escapeLabel(values[i]) + `"`)
+		}
+		if len(extraName) > 0 {
+			if len(names) > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(extraName + `="` + extraValue + `"`)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteString(" " + formatMetric(value) + "\n")
+}
+
+func formatMetric(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// An empty registry for the test, the one before put back when it ends.
+func useRegistry(t *testing.T) {
+	t.Helper()
+	metricsLock.Lock()
+	families, collected := metricFamilies, metricCollected
+	metricFamilies, metricCollected = make(map[string]*metricFamily), nil
+	metricsLock.Unlock()
+	t.Cleanup(func() {
+		metricsLock.Lock()
+		defer metricsLock.Unlock()
+		metricFamilies, metricCollected = families, collected
+	})
+}
+
+func checkMetrics(t *testing.T, want string) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("metrics written as\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteCounters(t *testing.T) {
+	useRegistry(t)
+	requests := NewCounter("test_requests_total", "Requests answered, by method.", "method")
+	started := NewCounter("test_started_total", "Times started.")
+	requests.Inc("POST")
+	requests.Inc("GET")
+	requests.Add(2.5, "GET")
+	requests.Add(-1, "GET") // A counter never goes down.
+	checkMetrics(t, `# HELP test_requests_total Requests answered, by method.
+# TYPE test_requests_total counter
+test_requests_total{method="GET"} 3.5
+test_requests_total{method="POST"} 1
+# HELP test_started_total Times started.
+# TYPE test_started_total counter
+test_started_total 0
+`)
+	started.Inc()
+	started.Set(42)
+	checkMetrics(t, `# HELP test_requests_total Requests answered, by method.
+# TYPE test_requests_total counter
+test_requests_total{method="GET"} 3.5
+test_requests_total{method="POST"} 1
+# HELP test_started_total Times started.
+# TYPE test_started_total counter
+test_started_total 42
+`)
+}
+
+func TestWriteGauges(t *testing.T) {
+	useRegistry(t)
+	accounts := NewGauge("test_plan_accounts", "Live accounts, by plan and state.", "plan", "state")
+	open := NewGauge("test_open", "Open now.")
+	accounts.Set(3, "PRO", "live")
+	accounts.Set(10, "FREE", "live")
+	accounts.Add(-4, "FREE", "live")
+	accounts.Set(1e9, "BASIC", "paused")
+	OnMetrics(func() { open.Set(7) }) // Read at the scrape.
+	checkMetrics(t, `# HELP test_open Open now.
+# TYPE test_open gauge
+test_open 7
+# HELP test_plan_accounts Live accounts, by plan and state.
+# TYPE test_plan_accounts gauge
+test_plan_accounts{plan="BASIC",state="paused"} 1e+09
+test_plan_accounts{plan="FREE",state="live"} 6
+test_plan_accounts{plan="PRO",state="live"} 3
+`)
+}
+
+func TestWriteHistogram(t *testing.T) {
+	useRegistry(t)
+	duration := NewHistogram("test_duration_seconds", "Time taken, by route.", []float64{.1, .5, 1}, "route")
+	NewHistogram("test_plain_seconds", "Time taken.", []float64{1}) // Written before anything is observed.
+	for _, seconds := range []float64{.05, .1, .3, .7, 2} {
+		duration.Observe(seconds, "/api/V1")
+	}
+	duration.Observe(.2, "/metrics")
+	checkMetrics(t, `# HELP test_duration_seconds Time taken, by route.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/api/V1",le="0.1"} 2
+test_duration_seconds_bucket{route="/api/V1",le="0.5"} 3
+test_duration_seconds_bucket{route="/api/V1",le="1"} 4
+test_duration_seconds_bucket{route="/api/V1",le="+Inf"} 5
+test_duration_seconds_sum{route="/api/V1"} 3.15
+test_duration_seconds_count{route="/api/V1"} 5
+test_duration_seconds_bucket{route="/metrics",le="0.1"} 0
+test_duration_seconds_bucket{route="/metrics",le="0.5"} 1
+test_duration_seconds_bucket{route="/metrics",le="1"} 1
+test_duration_seconds_bucket{route="/metrics",le="+Inf"} 1
+test_duration_seconds_sum{route="/metrics"} 0.2
+test_duration_seconds_count{route="/metrics"} 1
+# HELP test_plain_seconds Time taken.
+# TYPE test_plain_seconds histogram
+test_plain_seconds_bucket{le="1"} 0
+test_plain_seconds_bucket{le="+Inf"} 0
+test_plain_seconds_sum 0
+test_plain_seconds_count 0
+`)
+}
+
+func TestWriteEscapes(t *testing.T) {
+	useRegistry(t)
+	failures := NewCounter("test_errors_total", "Errors, by message.\nA \\ in the help is doubled.", "message")
+	failures.Inc(`said "no"`)
+	failures.Inc(`C:\path`)
+	failures.Inc("two\nlines")
+	checkMetrics(t, `# HELP test_errors_total Errors, by message.\nA \\ in the help is doubled.
+# TYPE test_errors_total counter
+test_errors_total{message="C:\\path"} 1
+test_errors_total{message="said \"no\""} 1
+test_errors_total{message="two\nlines"} 1
+`)
+}
+
+func TestMetricMistakesPanic(t *testing.T) {
+	useRegistry(t)
+	counter := NewCounter("test_twice_total", "Registered twice.", "label")
+	for name, mistake := range map[string]func(){
+		"registered twice": func() { NewGauge("test_twice_total", "Again.") },
+		"too few labels":   func() { counter.Inc() },
+		"too many labels":  func() { counter.Inc("a", "b") },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(r.(string), "test_twice_total") {
+					t.Errorf("%s: recovered %v, want a panic naming the metric", name, r)
+				}
+			}()
+			mistake()
+		}()
+	}
+}
@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// The RFC 6238 appendix B secret, "12345678901234567890" in base32.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// The SHA1 vectors of RFC 6238 appendix B, the last six of the eight digits.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTotpCodeRfcVectors(t *testing.T) {
+	for _, v := range rfcVectors {
+		code, err := TotpCode(rfcSecret, time.Unix(v.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != v.code {
+			t.Errorf("at %d: code %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestTotpCodeSecretForms(t *testing.T) {
+	at := time.Unix(59, 0)
+	for _, secret := range []string{
+		"gezdgnbvgy3tqojqgezdgnbvgy3tqojq",
+		"GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ",
+		rfcSecret + "====",
+	} {
+		if code, err := TotpCode(secret, at); err != nil || code != "287082" {
+			t.Errorf("secret %q: code %s, %v", secret, code, err)
+		}
+	}
+	if _, err := TotpCode("not base32!", at); err == nil {
+		t.Error("a bad secret gave a code")
+	}
+}
+
+func TestTotpMatchWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := TotpCode(rfcSecret, now)
+	for _, c := range []struct {
+		name   string
+		at     time.Time
+		window int
+		ok     bool
+	}{
+		{"same step", now, 0, true},
+		{"a step later, no window", now.Add(TotpPeriod * time.Second), 0, false},
+		{"a step later, window 1", now.Add(TotpPeriod * time.Second), 1, true},
+		{"a step earlier, window 1", now.Add(-TotpPeriod * time.Second), 1, true},
+		{"two steps later, window 1", now.Add(2 * TotpPeriod * time.Second), 1, false},
+		{"two steps later, window 2", now.Add(2 * TotpPeriod * time.Second), 2, true},
+	} {
+		step, ok := TotpMatch(rfcSecret, code, c.at, c.window)
+		if ok != c.ok {
+			t.Errorf("%s: matched %v, want %v", c.name, ok, c.ok)
+		}
+		if ok && step != TotpStep(now) {
+			t.Errorf("%s: matched step %d, want %d", c.name, step, TotpStep(now))
+		}
+	}
+	for _, code := range []string{"", "28708", "2870822", "abcdef"} {
+		if _, ok := TotpMatch(rfcSecret, code, now, 1); ok {
+			t.Errorf("code %q matched", code)
+		}
+	}
+}
+
+func TestTotpURI(t *testing.T) {
+	uri := TotpURI("All News", "a@example.com", rfcSecret)
+	want := "otpauth://totp/All%20News:a@example.com?algorithm=SHA1&digits=6&issuer=All+News&period=30&secret=" + rfcSecret
+	if uri != want {
+		t.Errorf("uri %s, want %s", uri, want)
+	}
+}
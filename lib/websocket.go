@@ -0,0 +1,173 @@
+package lib
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	WsContinuation = 0x0
+	WsText         = 0x1
+	WsBinary       = 0x2
+	WsClose        = 0x8
+	WsPing         = 0x9
+	WsPong         = 0xA
+
+	WsCloseNormal   = 1000
+	WsCloseProtocol = 1002
+	WsCloseTooBig   = 1009
+
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var ErrWsTooBig = errors.New("websocket message too big")
+
+/***************************************************************************
+ *     __          __  _     _____            _        _
+ *     \ \        / / | |   / ____|          | |      | |
+ *      \ \  /\  / /__| |__| (___   ___   ___| | _____| |_
+ *       \ \/  \/ / _ \ '_ \\___ \ / _ \ / __| |/ / _ \ __|
+ *        \  /\  /  __/ |_) |___) | (_) | (__|   <  __/ |_
+ *         \/  \/ \___|_.__/_____/ \___/ \___|_|\_\___|\__|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * The server end of an RFC 6455 connection, once the http side has been
+ * hijacked. Messages are read whole, the fragments put back together, with
+ * the control frames handed back as they come. Reading and writing may each
+ * run on their own goroutine, but only one of each at a time.
+ * ---------------------------------------------------------------------- */
+type WebSocket struct {
+	Conn       net.Conn
+	MaxMessage int           // Largest message read, ErrWsTooBig past it.
+	Timeout    time.Duration // For each write.
+
+	reader   *bufio.Reader
+	partial  []byte
+	partOp   int
+	writeBuf []byte
+}
+
+func NewWebSocket(conn net.Conn) *WebSocket {
+	return &WebSocket{Conn: conn, MaxMessage: 64 << 10, Timeout: 10 * time.Second, reader: bufio.NewReader(conn)}
+}
+
+// The Sec-WebSocket-Accept for the Sec-WebSocket-Key of an upgrade request.
+func WebSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// The next whole message, or control frame, from the client.
+func (ws *WebSocket) ReadMessage() (opcode int, payload []byte, err error) {
+	for {
+		var fin bool
+		fin, opcode, payload, err = ws.readFrame()
+		if err != nil {
+			return
+		}
+		switch {
+		case opcode >= WsClose: // Control frames are never fragmented, and may come between fragments.
+			if !fin || len(payload) > 125 {
+				return 0, nil, fmt.Errorf("websocket control frame %d malformed", opcode)
+			}
+			return
+		case opcode == WsContinuation:
+			if ws.partOp == 0 {
+				return 0, nil, errors.New("websocket continuation with nothing to continue")
+			}
+		case ws.partOp != 0:
+			return 0, nil, errors.New("websocket message started inside another")
+		default:
+			ws.partOp = opcode
+		}
+		if len(ws.partial)+len(payload) > ws.MaxMessage {
+			return 0, nil, ErrWsTooBig
+		}
+		ws.partial = append(ws.partial, payload...)
+		if fin {
+			opcode, payload = ws.partOp, ws.partial
+			ws.partOp, ws.partial = 0, nil
+			return
+		}
+	}
+}
+
+func (ws *WebSocket) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(ws.reader, head[:]); err != nil {
+		return
+	}
+	fin, opcode = head[0]&0x80 != 0, int(head[0]&0x0F)
+	if head[0]&0x70 != 0 {
+		return fin, opcode, nil, errors.New("websocket reserved bits set")
+	}
+	if head[1]&0x80 == 0 {
+		return fin, opcode, nil, errors.New("websocket frame from the client not masked")
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > uint64(ws.MaxMessage) {
+		return fin, opcode, nil, ErrWsTooBig
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(ws.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// Sends one unfragmented message or control frame.
+func (ws *WebSocket) WriteMessage(opcode int, payload []byte) error {
+	frame := append(ws.writeBuf[:0], 0x80|byte(opcode))
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, 126, byte(length>>8), byte(length))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	frame = append(frame, payload...)
+	ws.writeBuf = frame
+	if ws.Timeout > 0 {
+		_ = ws.Conn.SetWriteDeadline(time.Now().Add(ws.Timeout))
+	}
+	_, err := ws.Conn.Write(frame)
+	return err
+}
+
+// Sends the close frame with its status code and reason. The caller closes the connection.
+func (ws *WebSocket) WriteClose(code int, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return ws.WriteMessage(WsClose, append(payload, reason...))
+}
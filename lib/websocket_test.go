@@ -0,0 +1,173 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A connection read from what it was given and written to a buffer.
+type bufferConn struct {
+	net.Conn
+	in  *bytes.Reader
+	out bytes.Buffer
+}
+
+func (c *bufferConn) Read(p []byte) (int, error)       { return c.in.Read(p) }
+func (c *bufferConn) Write(p []byte) (int, error)      { return c.out.Write(p) }
+func (c *bufferConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *bufferConn) SetWriteDeadline(time.Time) error { return nil }
+
+// A socket reading the frames, one after another.
+func socketReading(frames ...[]byte) (*WebSocket, *bufferConn) {
+	conn := &bufferConn{in: bytes.NewReader(bytes.Join(frames, nil))}
+	return NewWebSocket(conn), conn
+}
+
+// A frame as a client sends it, masked.
+func clientFrame(fin bool, opcode int, payload []byte) []byte {
+	head := byte(opcode)
+	if fin {
+		head |= 0x80
+	}
+	frame := []byte{head}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, 0x80|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, 0x80|126, byte(length>>8), byte(length))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func TestWebSocketAccept(t *testing.T) {
+	// The example of RFC 6455 section 1.3.
+	if got := WebSocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("accept %q", got)
+	}
+}
+
+func TestWriteMessageLengths(t *testing.T) {
+	tests := []struct {
+		length int
+		head   []byte
+	}{
+		{0, []byte{0x81, 0}},
+		{125, []byte{0x81, 125}},
+		{126, []byte{0x81, 126, 0, 126}},
+		{65535, []byte{0x81, 126, 0xFF, 0xFF}},
+		{65536, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+		{70000, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0x11, 0x70}},
+	}
+	for _, test := range tests {
+		ws, conn := socketReading()
+		payload := bytes.Repeat([]byte("a"), test.length)
+		if err := ws.WriteMessage(WsText, payload); err != nil {
+			t.Fatal(err)
+		}
+		frame := conn.out.Bytes()
+		if !bytes.HasPrefix(frame, test.head) || !bytes.Equal(frame[len(test.head):], payload) {
+			t.Errorf("%d bytes: frame starts % x, want % x then the payload unmasked", test.length, frame[:min(len(frame), 10)], test.head)
+		}
+	}
+}
+
+func TestReadMessageMasked(t *testing.T) {
+	for _, length := range []int{0, 5, 125, 126, 300, 65535, 65536, 70000} {
+		payload := bytes.Repeat([]byte("news "), length/5+1)[:length]
+		ws, _ := socketReading(clientFrame(true, WsText, payload))
+		ws.MaxMessage = 1 << 20
+		opcode, got, err := ws.ReadMessage()
+		if err != nil || opcode != WsText || !bytes.Equal(got, payload) {
+			t.Errorf("%d bytes: read opcode %d, %d bytes, %v", length, opcode, len(got), err)
+		}
+	}
+}
+
+func TestReadMessageFragments(t *testing.T) {
+	ws, _ := socketReading(
+		clientFrame(false, WsText, []byte("Hel")),
+		clientFrame(true, WsPing, []byte("are you there")), // Control frames may come between fragments.
+		clientFrame(false, WsContinuation, []byte("lo, ")),
+		clientFrame(true, WsContinuation, []byte("world")),
+		clientFrame(true, WsBinary, []byte{1, 2, 3}),
+		clientFrame(true, WsClose, []byte{0x03, 0xE8}),
+	)
+	want := []struct {
+		opcode  int
+		payload string
+	}{
+		{WsPing, "are you there"},
+		{WsText, "Hello, world"},
+		{WsBinary, "\x01\x02\x03"},
+		{WsClose, "\x03\xE8"},
+	}
+	for _, w := range want {
+		opcode, payload, err := ws.ReadMessage()
+		if err != nil || opcode != w.opcode || string(payload) != w.payload {
+			t.Errorf("read %d %q, %v, want %d %q", opcode, payload, err, w.opcode, w.payload)
+		}
+	}
+	if _, _, err := ws.ReadMessage(); err != io.EOF {
+		t.Errorf("read past the end: %v", err)
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	unmasked := clientFrame(true, WsText, []byte("hi"))
+	unmasked[1] &^= 0x80
+	reserved := clientFrame(true, WsText, []byte("hi"))
+	reserved[0] |= 0x40
+	tests := []struct {
+		name   string
+		frames [][]byte
+		want   string
+	}{
+		{"not masked", [][]byte{unmasked}, "not masked"},
+		{"reserved bits", [][]byte{reserved}, "reserved bits"},
+		{"continuation alone", [][]byte{clientFrame(true, WsContinuation, []byte("lo"))}, "nothing to continue"},
+		{"message inside another", [][]byte{clientFrame(false, WsText, []byte("Hel")), clientFrame(true, WsText, []byte("lo"))}, "inside another"},
+		{"fragmented ping", [][]byte{clientFrame(false, WsPing, nil)}, "malformed"},
+		{"long ping", [][]byte{clientFrame(true, WsPing, make([]byte, 126))}, "malformed"},
+		{"frame too big", [][]byte{clientFrame(true, WsBinary, make([]byte, 201))}, ErrWsTooBig.Error()},
+		{"fragments too big", [][]byte{clientFrame(false, WsText, make([]byte, 110)), clientFrame(true, WsContinuation, make([]byte, 110))}, ErrWsTooBig.Error()},
+		{"cut short", [][]byte{clientFrame(true, WsText, []byte("hello"))[:5]}, "EOF"},
+	}
+	for _, test := range tests {
+		ws, _ := socketReading(test.frames...)
+		ws.MaxMessage = 200
+		if _, _, err := ws.ReadMessage(); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestWriteClose(t *testing.T) {
+	ws, conn := socketReading()
+	if err := ws.WriteClose(WsCloseNormal, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conn.out.Bytes(), []byte{0x88, 5, 0x03, 0xE8, 'b', 'y', 'e'}; !bytes.Equal(got, want) {
+		t.Errorf("close frame % x, want % x", got, want)
+	}
+	conn.out.Reset()
+	if err := ws.WriteClose(WsCloseTooBig, strings.Repeat("x", 200)); err != nil {
+		t.Fatal(err)
+	}
+	// Held to the 125 bytes of a control frame.
+	if got := conn.out.Bytes(); len(got) != 127 || got[1] != 125 || binary.BigEndian.Uint16(got[2:4]) != WsCloseTooBig {
+		t.Errorf("close frame of %d bytes starting % x", len(got), got[:4])
+	}
+}
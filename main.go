@@ -3,8 +3,8 @@ package main
 import ( // after go mod init [app name] add the app name to these modules
 	"[app name]/conf"
 	"[app name]/lib"
-	"[app name]/route"
-	"[app name]/sql"
+	"flag"
+	"os"
 )
 
 /*
@@ -19,15 +19,17 @@ import ( // after go mod init [app name] add the app name to these modules
  *     |_| |_| |_|\__,_|_|_| |_|
  * ---------------------------------- */
 func main() {
-	defer func() { //This is a normal trap to prevent app crashing out. no point in main, but higher functions works well.
-		r := recover()
-		if r != nil {
-			lib.Error("Application Failure:", r)
-		}
+	code := exitFailed
+	func() {
+		defer func() { //This is a normal trap to prevent app crashing out. no point in main, but higher functions works well.
+			r := recover()
+			if r != nil {
+				lib.Error("Application Failure:", r)
+			}
+		}()
+		conf.Init()
+		lib.Debug("Config:", conf.ConfigFilePath, "Version:", conf.VERSION)
+		code = runCommand(flag.Args()) // conf has parsed -c, what is left is the command.
 	}()
-	lib.Info("Initialize Database")
-	sql.InitDB()
-	lib.Info("Initilize Posting to Channels")
-
-	route.Init()
+	os.Exit(code)
 }
@@ -0,0 +1,337 @@
+package publish
+
+import (
+	"[app name]/conf"
+	"[app name]/lib"
+	"[app name]/sql"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DiscordPlatform = "discord"
+
+// Where the /news command starts and stops a channel, swapped out by the tests.
+var setTargetLive = sql.SetTargetLive
+
+/******************************************************************************
+ *      _____  _                       _
+ *     |  __ \(_)                     | |
+ *     | |  | |_ ___  ___ ___  _ __ __| |
+ *     | |  | | / __|/ __/ _ \| '__/ _` |
+ *     | |__| | \__ \ (_| (_) | | | (_| |
+ *     |_____/|_|___/\___\___/|_|  \__,_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * A bot on the Discord api. Discord limits each route on its own and tells us
+ * how much is left in the headers of every reply, so the bot keeps what it was
+ * last told per route and waits out an empty bucket rather than earn a 429.
+ * ------------------------------------------------------------------------- */
+type Discord struct {
+	Api    string
+	Token  string
+	Client *http.Client
+
+	lock        sync.Mutex
+	buckets     map[string]discordBucket
+	globalUntil time.Time // Set by a global 429, every route waits for it.
+}
+
+type discordBucket struct {
+	remaining int
+	reset     time.Time
+}
+
+// The embed an article is posted as.
+type DiscordEmbed struct {
+	Title       string            `json:"title"`
+	Url         string            `json:"url,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Timestamp   string            `json:"timestamp,omitempty"`
+	Thumbnail   *DiscordEmbedUrl  `json:"thumbnail,omitempty"`
+	Footer      *DiscordEmbedText `json:"footer,omitempty"`
+}
+
+type DiscordEmbedUrl struct {
+	Url string `json:"url"`
+}
+
+type DiscordEmbedText struct {
+	Text string `json:"text"`
+}
+
+// The bot from the config.
+func NewDiscord() *Discord {
+	return &Discord{
+		Api:     strings.TrimSuffix(conf.DISCORDAPI, "/"),
+		Token:   conf.DISCORDTOKEN,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+		buckets: make(map[string]discordBucket),
+	}
+}
+
+// The platform its targets are under in the control table.
+func (bot *Discord) Name() string {
+	return DiscordPlatform
+}
+
+// The article as an embed.
+func (bot *Discord) Format(a sql.Article) Message {
+	return ArticleEmbed(a)
+}
+
+// The embed for an article, cut to the lengths Discord takes.
+func ArticleEmbed(a sql.Article) (embed DiscordEmbed) {
+	embed = DiscordEmbed{
+		Title:     cut(a.Title, 256),
+		Url:       a.Link,
+		Timestamp: a.Created.Format(time.RFC3339),
+	}
+	if len(a.Content) > 0 {
+		embed.Description = cut(a.Content, 2048)
+	}
+	if img, _ := a.Detail["img"].(string); len(img) > 0 {
+		embed.Thumbnail = &DiscordEmbedUrl{Url: img}
+	}
+	if len(a.Cat) > 0 {
+		embed.Footer = &DiscordEmbedText{Text: cut(a.Cat, 2048)}
+	}
+	return
+}
+
+/******************************************************************************
+ *       _____                _
+ *      / ____|              | |
+ *     | (___   ___ _ __   __| |
+ *      \___ \ / _ \ '_ \ / _` |
+ *      ____) |  __/ | | | (_| |
+ *     |_____/ \___|_| |_|\__,_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Posts an embed to a channel. A 429 is waited out, for the route or for every
+ * route if Discord says it is global, and the post goes again.
+ * ------------------------------------------------------------------------- */
+func (bot *Discord) Send(channelId string, msg Message) (err error) {
+	embed, ok := msg.(DiscordEmbed)
+	if !ok {
+		return fmt.Errorf("discord cannot send a %T", msg)
+	}
+	payload := map[string]interface{}{"embeds": []DiscordEmbed{embed}}
+	for attempt := 1; attempt <= maxSendAttempts; attempt++ {
+		var retry bool
+		retry, err = bot.call(http.MethodPost, "/channels/"+channelId+"/messages", payload, nil)
+		if !retry {
+			return
+		}
+	}
+	return
+}
+
+/*************************************************************************************************
+ *      _____            _     _             _____                                          _
+ *     |  __ \          (_)   | |           / ____|                                        | |
+ *     | |__) |___  __ _ _ ___| |_ ___ _ __| |     ___  _ __ ___  _ __ ___   __ _ _ __   __| |___
+ *     |  _  // _ \/ _` | / __| __/ _ \ '__| |    / _ \| '_ ` _ \| '_ ` _ \ / _` | '_ \ / _` / __|
+ *     | | \ \  __/ (_| | \__ \ ||  __/ |  | |___| (_) | | | | | | | | | | | (_| | | | | (_| \__ \
+ *     |_|  \_\___|\__, |_|___/\__\___|_|   \_____\___/|_| |_| |_|_| |_| |_|\__,_|_| |_|\__,_|___/
+ *                  __/ |
+ *                 |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Puts the /news command on the application, with subscribe and unsubscribe
+ * under it. Only members who can manage the channel see it. Registering again
+ * just replaces it.
+ * -------------------------------------------------------------------------------------------- */
+func (bot *Discord) RegisterCommands(appId string) (err error) {
+	commands := []map[string]interface{}{{
+		"name":                       "news",
+		"description":                "News articles in this channel",
+		"default_member_permissions": "16", // Manage channels.
+		"dm_permission":              false,
+		"options": []map[string]interface{}{
+			{"type": 1, "name": "subscribe", "description": "Post new articles in this channel"},
+			{"type": 1, "name": "unsubscribe", "description": "Stop posting articles in this channel"},
+		},
+	}}
+	for attempt := 1; attempt <= maxSendAttempts; attempt++ {
+		var retry bool
+		if retry, err = bot.call(http.MethodPut, "/applications/"+appId+"/commands", commands, nil); !retry {
+			break
+		}
+	}
+	if err == nil {
+		lib.Info("Discord /news command registered for:", appId)
+	}
+	return
+}
+
+// One api call, after any wait its route is under. Retry is true for a 429, once it has been noted.
+func (bot *Discord) call(method string, path string, payload interface{}, out interface{}) (retry bool, err error) {
+	route := method + " " + path
+	bot.wait(route)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(method, bot.Api+path, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bot "+bot.Token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "DiscordBot ("+conf.PUBLICURL+", "+conf.VERSION+")")
+	resp, err := bot.Client.Do(req)
+	if err != nil {
+		return
+	}
+	defer lib.DeferClose(resp.Body)
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return
+	}
+	bot.track(route, resp.Header)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		var limited struct {
+			RetryAfter float64 `json:"retry_after"`
+			Global     bool    `json:"global"`
+		}
+		_ = json.Unmarshal(content, &limited)
+		wait := time.Duration(limited.RetryAfter * float64(time.Second))
+		lib.Warn("Discord rate limited, waiting:", wait, route, "global:", limited.Global)
+		bot.limit(route, wait, limited.Global || resp.Header.Get("X-RateLimit-Global") == "true")
+		return true, fmt.Errorf("discord %s: %s", resp.Status, strings.TrimSpace(string(content)))
+	case resp.StatusCode >= 300:
+		return false, fmt.Errorf("discord %s: %s", resp.Status, strings.TrimSpace(string(content)))
+	case out != nil:
+		err = json.Unmarshal(content, out)
+	}
+	return
+}
+
+// Sleeps until the route, and the api as a whole, have calls left.
+func (bot *Discord) wait(route string) {
+	bot.lock.Lock()
+	until := bot.globalUntil
+	if b, ok := bot.buckets[route]; ok && b.remaining <= 0 && b.reset.After(until) {
+		until = b.reset
+	}
+	bot.lock.Unlock()
+	if wait := time.Until(until); wait > 0 {
+		lib.Debug("Discord waiting for the rate limit:", wait, route)
+		time.Sleep(wait)
+	}
+}
+
+// Keeps what the headers say is left on the route and when it fills again.
+func (bot *Discord) track(route string, header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	resetAfter, err := strconv.ParseFloat(header.Get("X-RateLimit-Reset-After"), 64)
+	if err != nil {
+		return
+	}
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	bot.buckets[route] = discordBucket{remaining: remaining, reset: time.Now().Add(time.Duration(resetAfter * float64(time.Second)))}
+}
+
+func (bot *Discord) limit(route string, wait time.Duration, global bool) {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	until := time.Now().Add(wait)
+	if global {
+		bot.globalUntil = until
+	} else {
+		bot.buckets[route] = discordBucket{remaining: 0, reset: until}
+	}
+}
+
+/*********************************************************************************************
+ *      _____  _                       _ _____       _                      _   _
+ *     |  __ \(_)                     | |_   _|     | |                    | | (_)
+ *     | |  | |_ ___  ___ ___  _ __ __| | | |  _ __ | |_ ___ _ __ __ _  ___| |_ _  ___  _ __
+ *     | |  | | / __|/ __/ _ \| '__/ _` | | | | '_ \| __/ _ \ '__/ _` |/ __| __| |/ _ \| '_ \
+ *     | |__| | \__ \ (_| (_) | | | (_| |_| |_| | | | ||  __/ | | (_| | (__| |_| | (_) | | | |
+ *     |_____/|_|___/\___\___/|_|  \__,_|_____|_| |_|\__\___|_|  \__,_|\___|\__|_|\___/|_| |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Answers a call from Discord to the interactions endpoint: the ping Discord
+ * sends to check the endpoint, and the /news command, which starts or stops
+ * posting in the channel it was used in. The reply is only seen by whoever
+ * used it.
+ * ---------------------------------------------------------------------------------------- */
+func DiscordInteraction(ctx context.Context, body []byte) (reply []byte, err error) {
+	var interaction struct {
+		Type      int    `json:"type"`
+		ChannelId string `json:"channel_id"`
+		Data      struct {
+			Name    string `json:"name"`
+			Options []struct {
+				Name string `json:"name"`
+			} `json:"options"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(body, &interaction); err != nil {
+		return
+	}
+	switch interaction.Type {
+	case 1: // Ping.
+		return json.Marshal(map[string]int{"type": 1})
+	case 2: // A command.
+		if interaction.Data.Name != "news" || len(interaction.Data.Options) == 0 || len(interaction.ChannelId) == 0 {
+			return nil, fmt.Errorf("unknown command: %s", interaction.Data.Name)
+		}
+	default:
+		return nil, fmt.Errorf("unknown interaction type: %d", interaction.Type)
+	}
+	var content string
+	switch interaction.Data.Options[0].Name {
+	case "subscribe":
+		content = "New articles will be posted in this channel."
+		if !setTargetLive(ctx, interaction.ChannelId, DiscordPlatform, true) {
+			content = "Unable to subscribe this channel, try again later."
+		}
+	case "unsubscribe":
+		content = "Articles will no longer be posted in this channel."
+		if !setTargetLive(ctx, interaction.ChannelId, DiscordPlatform, false) {
+			content = "Unable to unsubscribe this channel, try again later."
+		}
+	default:
+		return nil, fmt.Errorf("unknown /news option: %s", interaction.Data.Options[0].Name)
+	}
+	lib.Log(ctx).Info("Discord /news", interaction.Data.Options[0].Name, "in:", interaction.ChannelId)
+	return json.Marshal(map[string]interface{}{
+		"type": 4, // A message in reply.
+		"data": map[string]interface{}{"content": content, "flags": 64},
+	})
+}
+
+// Checks the Ed25519 signature Discord puts on each interaction, over the timestamp and body.
+func VerifyDiscord(publicKey string, signature string, timestamp string, body []byte) bool {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(key, append([]byte(timestamp), body...), sig)
+}
+
+// Cuts a string to at most max runes, with an ellipsis if anything went.
+func cut(value string, max int) string {
+	runes := []rune(strings.TrimSpace(value))
+	if len(runes) <= max {
+		return string(runes)
+	}
+	return string(runes[:max-1]) + "…"
+}
@@ -0,0 +1,217 @@
+package publish
+
+import (
+	"[app name]/sql"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A stand in for the Discord api, answering each call with the next reply, the last over and over.
+type fakeDiscordApi struct {
+	lock     sync.Mutex
+	replies  []discordReply
+	requests []*http.Request
+	times    []time.Time
+}
+
+type discordReply struct {
+	status int
+	header map[string]string
+	body   string
+}
+
+var discordOk = discordReply{status: http.StatusOK, body: `{"id":"1"}`}
+
+func (api *fakeDiscordApi) serve(t *testing.T) *Discord {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.lock.Lock()
+		defer api.lock.Unlock()
+		api.requests = append(api.requests, r)
+		api.times = append(api.times, time.Now())
+		reply := api.replies[0]
+		if len(api.replies) > 1 {
+			api.replies = api.replies[1:]
+		}
+		for key, value := range reply.header {
+			w.Header().Set(key, value)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(reply.status)
+		_, _ = w.Write([]byte(reply.body))
+	}))
+	t.Cleanup(server.Close)
+	return &Discord{Api: server.URL, Token: "test-token", Client: server.Client(), buckets: make(map[string]discordBucket)}
+}
+
+func TestDiscordSend(t *testing.T) {
+	api := &fakeDiscordApi{replies: []discordReply{discordOk}}
+	bot := api.serve(t)
+	if err := bot.Send("42", bot.Format(sql.Article{Title: "Markets rally", Link: "https://news.example/1"})); err != nil {
+		t.Fatal(err)
+	}
+	r := api.requests[0]
+	if r.Method != http.MethodPost || r.URL.Path != "/channels/42/messages" || r.Header.Get("Authorization") != "Bot test-token" {
+		t.Errorf("sent %s %s with %q", r.Method, r.URL.Path, r.Header.Get("Authorization"))
+	}
+	if err := bot.Send("42", "text"); err == nil {
+		t.Error("sent a message that is not an embed")
+	}
+}
+
+func TestDiscordBucketWaits(t *testing.T) {
+	api := &fakeDiscordApi{replies: []discordReply{
+		{status: http.StatusOK, body: `{}`, header: map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset-After": "0.5"}},
+		discordOk,
+	}}
+	bot := api.serve(t)
+	msg := bot.Format(sql.Article{Title: "Markets rally"})
+	for i := 0; i < 2; i++ {
+		if err := bot.Send("42", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if gap := api.times[1].Sub(api.times[0]); gap < 450*time.Millisecond {
+		t.Errorf("second post came %s after the bucket ran out, before it filled", gap)
+	}
+	start := time.Now() // Another route has its own bucket.
+	if err := bot.Send("43", msg); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited > 250*time.Millisecond {
+		t.Errorf("another channel waited %s on the first one's bucket", waited)
+	}
+}
+
+func TestDiscordGlobalLimit(t *testing.T) {
+	api := &fakeDiscordApi{replies: []discordReply{
+		{status: http.StatusTooManyRequests, body: `{"message":"You are being rate limited.","retry_after":0.5,"global":true}`},
+		discordOk,
+	}}
+	bot := api.serve(t)
+	if err := bot.Send("42", bot.Format(sql.Article{Title: "Markets rally"})); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.requests) != 2 {
+		t.Fatalf("%d requests, want the 429 and the retry", len(api.requests))
+	}
+	if gap := api.times[1].Sub(api.times[0]); gap < 450*time.Millisecond {
+		t.Errorf("retried %s after the 429, before retry_after", gap)
+	}
+	bot.lock.Lock()
+	global := bot.globalUntil
+	_, bucketed := bot.buckets["POST /channels/42/messages"]
+	bot.lock.Unlock()
+	if global.IsZero() || bucketed {
+		t.Errorf("a global 429 was kept as the route's: global until %v, route bucket %v", global, bucketed)
+	}
+}
+
+func TestDiscordRateLimitedGivesUp(t *testing.T) {
+	api := &fakeDiscordApi{replies: []discordReply{{status: http.StatusTooManyRequests, body: `{"retry_after":0.01,"global":false}`}}}
+	bot := api.serve(t)
+	err := bot.Send("42", bot.Format(sql.Article{Title: "Markets rally"}))
+	if err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("error %v, want the 429", err)
+	}
+	if len(api.requests) != maxSendAttempts {
+		t.Errorf("%d requests, want %d", len(api.requests), maxSendAttempts)
+	}
+}
+
+// A key pair and a signer for the interactions, as Discord would sign them.
+func discordKey(t *testing.T) (string, func(timestamp string, body string) string) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(public), func(timestamp string, body string) string {
+		return hex.EncodeToString(ed25519.Sign(private, []byte(timestamp+body)))
+	}
+}
+
+func TestVerifyDiscord(t *testing.T) {
+	key, sign := discordKey(t)
+	otherKey, _ := discordKey(t)
+	body, timestamp := `{"type":1}`, "1700000000"
+	signature := sign(timestamp, body)
+	cases := []struct {
+		name      string
+		key       string
+		signature string
+		timestamp string
+		body      string
+		want      bool
+	}{
+		{"signed", key, signature, timestamp, body, true},
+		{"body changed", key, signature, timestamp, `{"type":2}`, false},
+		{"timestamp changed", key, signature, "1700000001", body, false},
+		{"another key", otherKey, signature, timestamp, body, false},
+		{"signature not hex", key, "zz" + signature[2:], timestamp, body, false},
+		{"signature short", key, signature[:10], timestamp, body, false},
+		{"key not hex", "not a key", signature, timestamp, body, false},
+		{"no key", "", signature, timestamp, body, false},
+	}
+	for _, c := range cases {
+		if got := VerifyDiscord(c.key, c.signature, c.timestamp, []byte(c.body)); got != c.want {
+			t.Errorf("%s: %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDiscordInteraction(t *testing.T) {
+	old := setTargetLive
+	t.Cleanup(func() { setTargetLive = old })
+	live := make(map[string]bool)
+	setTargetLive = func(ctx context.Context, target string, platform string, on bool) bool {
+		live[target+" "+platform] = on
+		return true
+	}
+
+	reply, err := DiscordInteraction(context.Background(), []byte(`{"type":1}`))
+	if err != nil || string(reply) != `{"type":1}` {
+		t.Errorf("ping: %s %v, want a pong", reply, err)
+	}
+	for _, option := range []string{"subscribe", "unsubscribe"} {
+		reply, err = DiscordInteraction(context.Background(), []byte(`{"type":2,"channel_id":"42","data":{"name":"news","options":[{"name":"`+option+`"}]}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var message struct {
+			Type int `json:"type"`
+			Data struct {
+				Content string `json:"content"`
+				Flags   int    `json:"flags"`
+			} `json:"data"`
+		}
+		if err = json.Unmarshal(reply, &message); err != nil || message.Type != 4 || message.Data.Flags != 64 || len(message.Data.Content) == 0 {
+			t.Errorf("%s: %s, want a message only the user sees", option, reply)
+		}
+		if on, ok := live["42 "+DiscordPlatform]; !ok || on != (option == "subscribe") {
+			t.Errorf("%s: the channel was left live %v", option, on)
+		}
+	}
+
+	for _, body := range []string{
+		`not json`,
+		`{"type":3}`,
+		`{"type":2,"channel_id":"42","data":{"name":"weather","options":[{"name":"subscribe"}]}}`,
+		`{"type":2,"channel_id":"42","data":{"name":"news","options":[]}}`,
+		`{"type":2,"data":{"name":"news","options":[{"name":"subscribe"}]}}`,
+		`{"type":2,"channel_id":"42","data":{"name":"news","options":[{"name":"delete"}]}}`,
+	} {
+		if reply, err := DiscordInteraction(context.Background(), []byte(body)); err == nil {
+			t.Errorf("%s: answered %s", body, reply)
+		}
+	}
+}
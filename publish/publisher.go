@@ -0,0 +1,165 @@
+package publish
+
+import (
+	"[app name]/conf"
+	"[app name]/lib"
+	"[app name]/sql"
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	Topic = "general" // The topic the targets are posted from.
+
+	publishers     []Publisher
+	publishersLock sync.Mutex
+
+	// The control table as PublishRound uses it, swapped out by the tests.
+	listTargets    = sql.GetListOfTargets
+	getTarget      = sql.GetTarget
+	pendingArticle = sql.PendingArticle
+	advanceControl = sql.AdvanceControl
+
+	sends = lib.NewCounter("news_publisher_sends_total", "Articles sent out, by publisher and result: sent, failed or, for a webhook, dead.", "publisher", "result")
+)
+
+// What a publisher makes of an article, a string for a chat, an embed for Discord.
+type Message interface{}
+
+/***************************************************************************
+ *      _____       _     _ _     _
+ *     |  __ \     | |   | (_)   | |
+ *     | |__) |   _| |__ | |_ ___| |__   ___ _ __
+ *     |  ___/ | | | '_ \| | / __| '_ \ / _ \ '__|
+ *     | |   | |_| | |_) | | \__ \ | | |  __/ |
+ *     |_|    \__,_|_.__/|_|_|___/_| |_|\___|_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Somewhere articles are posted. Name is the platform its targets are under
+ * in the control table, Format lays an article out the way the platform
+ * shows it and Send posts that to one target. With detail off for the
+ * target Format is handed the article without its content.
+ * ---------------------------------------------------------------------- */
+type Publisher interface {
+	Name() string
+	Format(a sql.Article) Message
+	Send(target string, msg Message) error
+}
+
+// Adds a publisher for KeepPublishing to drive.
+func Register(p Publisher) {
+	publishersLock.Lock()
+	defer publishersLock.Unlock()
+	publishers = append(publishers, p)
+	lib.Info("Publisher registered:", p.Name())
+}
+
+// The publishers registered so far.
+func Publishers() []Publisher {
+	publishersLock.Lock()
+	defer publishersLock.Unlock()
+	return append([]Publisher(nil), publishers...)
+}
+
+// Registers the bots the config has tokens for.
+func registerConfigured() {
+	if len(conf.BOTID) > 0 {
+		sql.CheckControl(context.Background(), conf.CHATID, telegramPlatform) // The home channel is always a target.
+		Register(NewTelegram())
+	} else {
+		lib.Info("No BOTID, not posting to Telegram")
+	}
+	if len(conf.DISCORDTOKEN) > 0 {
+		bot := NewDiscord()
+		if len(conf.DISCORDAPPID) > 0 {
+			lib.CheckErr(bot.RegisterCommands(conf.DISCORDAPPID))
+		}
+		Register(bot)
+	} else {
+		lib.Info("No DISCORDTOKEN, not posting to Discord")
+	}
+}
+
+/***************************************************************************
+ *      _  __               _____       _     _ _     _     _
+ *     | |/ /              |  __ \     | |   | (_)   | |   (_)
+ *     | ' / ___  ___ _ __ | |__) |   _| |__ | |_ ___| |__  _ _ __   __ _
+ *     |  < / _ \/ _ \ '_ \|  ___/ | | | '_ \| | / __| '_ \| | '_ \ / _` |
+ *     | . \  __/  __/ |_) | |   | |_| | |_) | | \__ \ | | | | | | | (_| |
+ *     |_|\_\___|\___| .__/|_|    \__,_|_.__/|_|_|___/_| |_|_|_| |_|\__, |
+ *                   | |                                             __/ |
+ *                   |_|                                            |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Registers the configured bots and drives every publisher, each on its
+ * own so one waiting on a rate limit does not hold up the others. While any
+ * of a publisher's targets is behind its rounds keep coming, once they are
+ * all caught up it waits a jittered heart beat.
+ * ---------------------------------------------------------------------- */
+func KeepPublishing(heartBeat int) {
+	registerConfigured()
+	var wg sync.WaitGroup
+	for _, p := range Publishers() {
+		wg.Add(1)
+		go func(p Publisher) {
+			defer wg.Done()
+			keepPublishing(p, heartBeat)
+		}(p)
+	}
+	wg.Wait()
+}
+
+func keepPublishing(p Publisher, heartBeat int) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Error("Publishing loop:", p.Name(), r)
+		}
+	}()
+	for {
+		if PublishRound(p) == 0 {
+			time.Sleep(time.Duration(lib.NextHeartBeat(heartBeat)) * time.Second)
+		}
+	}
+}
+
+/***************************************************************************
+ *      _____       _     _ _     _     _____                       _
+ *     |  __ \     | |   | (_)   | |   |  __ \                     | |
+ *     | |__) |   _| |__ | |_ ___| |__ | |__) |___  _   _ _ __   __| |
+ *     |  ___/ | | | '_ \| | / __| '_ \|  _  // _ \| | | | '_ \ / _` |
+ *     | |   | |_| | |_) | | \__ \ | | | | \ \ (_) | |_| | | | | (_| |
+ *     |_|    \__,_|_.__/|_|_|___/_| |_|_|  \_\___/ \__,_|_| |_|\__,_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Posts the next article to each live target of the publisher that has one,
+ * POSTDELAY seconds apart, following the target's own detail and keywords.
+ * The control only moves once the article is sent, a failed one is tried
+ * again next round. Returns how many were sent.
+ * ---------------------------------------------------------------------- */
+func PublishRound(p Publisher) (sent int) {
+	platform := p.Name()
+	for _, target := range listTargets(platform) {
+		settings, err := getTarget(context.Background(), target)
+		if err != nil {
+			settings = sql.Target{Target: target, Platform: platform}
+		}
+		a, found := pendingArticle(context.Background(), target, Topic, settings.Keywords)
+		if !found {
+			continue
+		}
+		article := a
+		if !settings.ShowDetail() {
+			article.Content = ""
+		}
+		if err := p.Send(target, p.Format(article)); err != nil {
+			lib.Error("Publishing to:", platform, target, err, a.Title)
+			sends.Inc(platform, "failed")
+		} else {
+			lib.Debug("Published to:", platform, target, a.Uid)
+			advanceControl(context.Background(), target, a)
+			sends.Inc(platform, "sent")
+			sent++
+		}
+		time.Sleep(time.Duration(conf.POSTDELAY) * time.Second)
+	}
+	return
+}
@@ -0,0 +1,89 @@
+package publish
+
+import (
+	"[app name]/conf"
+	"[app name]/sql"
+	"context"
+	"errors"
+	"testing"
+)
+
+// A publisher that fails the sends it is told to, and keeps the rest.
+type fakePublisher struct {
+	fail bool
+	sent []sql.Article
+}
+
+func (p *fakePublisher) Name() string                 { return "fake" }
+func (p *fakePublisher) Format(a sql.Article) Message { return a }
+func (p *fakePublisher) Send(target string, msg Message) error {
+	if p.fail {
+		return errors.New("channel unreachable")
+	}
+	p.sent = append(p.sent, msg.(sql.Article))
+	return nil
+}
+
+// One target over a queue of articles, its cursor the uid of the last one it was given.
+type fakeControl struct {
+	articles []sql.Article
+	keywords string
+	cursor   int64
+	asked    []string
+}
+
+func (c *fakeControl) use(t *testing.T) {
+	oldList, oldGet, oldPending, oldAdvance, delay := listTargets, getTarget, pendingArticle, advanceControl, conf.POSTDELAY
+	t.Cleanup(func() {
+		listTargets, getTarget, pendingArticle, advanceControl, conf.POSTDELAY = oldList, oldGet, oldPending, oldAdvance, delay
+	})
+	conf.POSTDELAY = 0
+	detail := true
+	listTargets = func(platform string) []string { return []string{"chat-1"} }
+	getTarget = func(ctx context.Context, target string) (sql.Target, error) {
+		return sql.Target{Target: target, Platform: "fake", Live: true, Detail: &detail, Keywords: c.keywords}, nil
+	}
+	pendingArticle = func(ctx context.Context, target string, topic string, keywords string) (sql.Article, bool) {
+		c.asked = append(c.asked, keywords)
+		for _, a := range c.articles {
+			if a.Uid > c.cursor {
+				return a, true
+			}
+		}
+		return sql.Article{}, false
+	}
+	advanceControl = func(ctx context.Context, target string, a sql.Article) bool {
+		c.cursor = a.Uid
+		return true
+	}
+}
+
+func TestPublishRoundKeepsFailedArticle(t *testing.T) {
+	control := &fakeControl{articles: []sql.Article{{Uid: 1, Title: "First"}, {Uid: 2, Title: "Second"}}}
+	control.use(t)
+	p := &fakePublisher{fail: true}
+	if sent := PublishRound(p); sent != 0 || control.cursor != 0 {
+		t.Fatalf("failed send: %d sent, cursor at %d, want it left before the article", sent, control.cursor)
+	}
+	p.fail = false
+	for round, want := range []int64{1, 2} {
+		if sent := PublishRound(p); sent != 1 || control.cursor != want {
+			t.Errorf("round %d: %d sent, cursor at %d, want %d", round, sent, control.cursor, want)
+		}
+	}
+	if len(p.sent) != 2 || p.sent[0].Uid != 1 || p.sent[1].Uid != 2 {
+		t.Errorf("sent %+v, want the first article again then the second", p.sent)
+	}
+	if sent := PublishRound(p); sent != 0 {
+		t.Errorf("%d sent with nothing left", sent)
+	}
+}
+
+func TestPublishRoundPassesKeywords(t *testing.T) {
+	control := &fakeControl{keywords: "AI"}
+	control.use(t)
+	PublishRound(&fakePublisher{})
+	if len(control.asked) != 1 || control.asked[0] != "AI" {
+		t.Errorf("asked for the pending article with keywords %q, want the target's", control.asked)
+	}
+}
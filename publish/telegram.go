@@ -0,0 +1,145 @@
+package publish
+
+import (
+	"[app name]/conf"
+	"[app name]/lib"
+	"[app name]/sql"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	telegramPlatform = "telegram"
+	maxSendAttempts  = 3
+)
+
+/*****************************************************************************
+ *      _______   _
+ *     |__   __| | |
+ *        | | ___| | ___  __ _ _ __ __ _ _ __ ___
+ *        | |/ _ \ |/ _ \/ _` | '__/ _` | '_ ` _ \
+ *        | |  __/ |  __/ (_| | | | (_| | | | | | |
+ *        |_|\___|_|\___|\__, |_|  \__,_|_| |_| |_|
+ *                        __/ |
+ *                       |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * A bot on the Telegram Bot API. Api is the base url, the real one unless the
+ * config points it at a fake server, and Token is the BOTID from the config.
+ * ------------------------------------------------------------------------ */
+type Telegram struct {
+	Api    string
+	Token  string
+	Client *http.Client
+}
+
+// The reply the Bot API gives to every call.
+type telegramReply struct {
+	Ok          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// The bot from the config.
+func NewTelegram() *Telegram {
+	return &Telegram{
+		Api:    strings.TrimSuffix(conf.TELEGRAMAPI, "/"),
+		Token:  conf.BOTID,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// The platform its targets are under in the control table.
+func (bot *Telegram) Name() string {
+	return telegramPlatform
+}
+
+// The article as Markdown, the title in bold, then the content if it has any, then the link.
+func (bot *Telegram) Format(a sql.Article) Message {
+	if len(a.Content) > 0 {
+		return fmt.Sprintf("*%[1]s*\n _%[2]s_ [%[3]s]", a.Title, a.Content, a.Link)
+	}
+	return fmt.Sprintf("*%[1]s*\n [%[2]s]", a.Title, a.Link)
+}
+
+/*******************************************************************************
+ *       _____                _
+ *      / ____|              | |
+ *     | (___   ___ _ __   __| |
+ *      \___ \ / _ \ '_ \ / _` |
+ *      ____) |  __/ | | | (_| |
+ *     |_____/ \___|_| |_|\__,_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Sends one message to a chat. A 429 waits out the retry_after the api asks for
+ * and goes again, and markdown the api cannot parse, a stray _ in a title say,
+ * goes again as plain text.
+ * -------------------------------------------------------------------------- */
+func (bot *Telegram) Send(chatId string, msg Message) (err error) {
+	text, ok := msg.(string)
+	if !ok {
+		return fmt.Errorf("telegram cannot send a %T", msg)
+	}
+	parseMode := "Markdown"
+	for attempt := 1; attempt <= maxSendAttempts; attempt++ {
+		var reply telegramReply
+		reply, err = bot.sendMessage(chatId, text, parseMode)
+		switch {
+		case err != nil:
+			return
+		case reply.Ok:
+			return nil
+		case reply.ErrorCode == http.StatusTooManyRequests:
+			wait := time.Duration(reply.Parameters.RetryAfter) * time.Second
+			if wait <= 0 {
+				wait = time.Duration(conf.POSTDELAY) * time.Second
+			}
+			lib.Warn("Telegram rate limited, waiting:", wait, chatId)
+			time.Sleep(wait)
+		case reply.ErrorCode == http.StatusBadRequest && len(parseMode) > 0 && strings.Contains(reply.Description, "parse entities"):
+			parseMode = ""
+		default:
+			return fmt.Errorf("telegram %d: %s", reply.ErrorCode, reply.Description)
+		}
+		err = fmt.Errorf("telegram %d: %s", reply.ErrorCode, reply.Description)
+	}
+	return
+}
+
+func (bot *Telegram) sendMessage(chatId string, text string, parseMode string) (reply telegramReply, err error) {
+	payload := map[string]interface{}{"chat_id": chatId, "text": text}
+	if len(parseMode) > 0 {
+		payload["parse_mode"] = parseMode
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	resp, err := bot.Client.Post(bot.Api+"/bot"+bot.Token+"/sendMessage", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return reply, fmt.Errorf("telegram: %v", redact(err.Error(), bot.Token))
+	}
+	defer lib.DeferClose(resp.Body)
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(content, &reply); err != nil {
+		return reply, fmt.Errorf("telegram %s: %v", resp.Status, err)
+	}
+	return
+}
+
+// The bot token is in the url, so it would be in any error about the url.
+func redact(message string, token string) string {
+	if len(token) == 0 {
+		return message
+	}
+	return strings.ReplaceAll(message, token, "<BOTID>")
+}
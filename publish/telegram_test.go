@@ -0,0 +1,149 @@
+package publish
+
+import (
+	"[app name]/conf"
+	"[app name]/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testBotToken = "123456:test-token"
+
+// A stand in for the Bot API, answering each sendMessage with the next reply, the last over and over.
+type fakeBotApi struct {
+	lock     sync.Mutex
+	replies  []string
+	requests []map[string]interface{}
+}
+
+func (api *fakeBotApi) serve(t *testing.T) *Telegram {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bot"+testBotToken+"/sendMessage" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		api.lock.Lock()
+		defer api.lock.Unlock()
+		api.requests = append(api.requests, payload)
+		reply := api.replies[0]
+		if len(api.replies) > 1 {
+			api.replies = api.replies[1:]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(reply))
+	}))
+	t.Cleanup(server.Close)
+	return &Telegram{Api: server.URL, Token: testBotToken, Client: server.Client()}
+}
+
+const telegramOk = `{"ok":true,"result":{"message_id":1}}`
+
+func TestTelegramSend(t *testing.T) {
+	api := &fakeBotApi{replies: []string{telegramOk}}
+	bot := api.serve(t)
+	msg := bot.Format(sql.Article{Title: "Markets rally", Content: "Stocks rose", Link: "https://news.example/1"})
+	if err := bot.Send("-1001", msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(api.requests))
+	}
+	want := map[string]interface{}{"chat_id": "-1001", "text": "*Markets rally*\n _Stocks rose_ [https://news.example/1]", "parse_mode": "Markdown"}
+	for key, value := range want {
+		if api.requests[0][key] != value {
+			t.Errorf("%s: %v, want %v", key, api.requests[0][key], value)
+		}
+	}
+	if err := bot.Send("-1001", 42); err == nil {
+		t.Error("sent a message that is not text")
+	}
+}
+
+func TestTelegramRateLimited(t *testing.T) {
+	api := &fakeBotApi{replies: []string{
+		`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`,
+		telegramOk,
+	}}
+	bot := api.serve(t)
+	start := time.Now()
+	if err := bot.Send("-1001", "*hello*"); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.requests) != 2 {
+		t.Errorf("%d requests, want the 429 and the retry", len(api.requests))
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("retried after %s, sooner than the retry_after asked for", waited)
+	}
+}
+
+func TestTelegramRateLimitedGivesUp(t *testing.T) {
+	delay := conf.POSTDELAY
+	t.Cleanup(func() { conf.POSTDELAY = delay })
+	conf.POSTDELAY = 0 // No retry_after, the post delay is waited instead.
+	api := &fakeBotApi{replies: []string{`{"ok":false,"error_code":429,"description":"Too Many Requests"}`}}
+	bot := api.serve(t)
+	err := bot.Send("-1001", "*hello*")
+	if err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("error %v, want the 429", err)
+	}
+	if len(api.requests) != maxSendAttempts {
+		t.Errorf("%d requests, want %d", len(api.requests), maxSendAttempts)
+	}
+}
+
+func TestTelegramMarkdownFallback(t *testing.T) {
+	api := &fakeBotApi{replies: []string{
+		`{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities: Can't find end of the entity starting at byte offset 9"}`,
+		telegramOk,
+	}}
+	bot := api.serve(t)
+	if err := bot.Send("-1001", "*snake_case title*"); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(api.requests))
+	}
+	if api.requests[0]["parse_mode"] != "Markdown" {
+		t.Errorf("first go: parse_mode %v, want Markdown", api.requests[0]["parse_mode"])
+	}
+	if _, ok := api.requests[1]["parse_mode"]; ok || api.requests[1]["text"] != "*snake_case title*" {
+		t.Errorf("second go: %v, want the same text with no parse_mode", api.requests[1])
+	}
+}
+
+func TestTelegramRefused(t *testing.T) {
+	api := &fakeBotApi{replies: []string{`{"ok":false,"error_code":403,"description":"Forbidden: bot was kicked from the group chat"}`}}
+	bot := api.serve(t)
+	err := bot.Send("-1001", "*hello*")
+	if err == nil || !strings.Contains(err.Error(), "kicked") {
+		t.Errorf("error %v, want the api's description", err)
+	}
+	if len(api.requests) != 1 {
+		t.Errorf("%d requests, want 1, a refusal is not retried", len(api.requests))
+	}
+}
+
+func TestTelegramErrorHidesToken(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	bot := &Telegram{Api: server.URL, Token: testBotToken, Client: &http.Client{Timeout: time.Second}}
+	err := bot.Send("-1001", "*hello*")
+	if err == nil {
+		t.Fatal("sent to a closed server")
+	}
+	if strings.Contains(err.Error(), testBotToken) {
+		t.Errorf("the token is in the error: %v", err)
+	}
+}
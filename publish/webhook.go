@@ -0,0 +1,171 @@
+package publish
+
+import (
+	"[app name]/conf"
+	"[app name]/lib"
+	"[app name]/sql"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	webhookBatch      = 20 // Deliveries to one webhook before the others get a turn.
+	webhookFirstRetry = 30 * time.Second
+	webhookMaxRetry   = time.Hour
+)
+
+var (
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+	// The webhook records as DispatchWebhook keeps them, swapped out by the tests.
+	setWebhookFailures = sql.SetWebhookFailures
+	addDeadLetter      = sql.AddDeadLetter
+)
+
+// What a webhook is sent, one article at a time.
+type WebhookEvent struct {
+	Event   string      `json:"event"`
+	Webhook int64       `json:"webhook"`
+	Article sql.Article `json:"article"`
+}
+
+/****************************************************************************
+ *      _  __               _____       _ _                _
+ *     | |/ /              |  __ \     | (_)              (_)
+ *     | ' / ___  ___ _ __ | |  | | ___| |___   _____ _ __ _ _ __   __ _
+ *     |  < / _ \/ _ \ '_ \| |  | |/ _ \ | \ \ / / _ \ '__| | '_ \ / _` |
+ *     | . \  __/  __/ |_) | |__| |  __/ | |\ V /  __/ |  | | | | | (_| |
+ *     |_|\_\___|\___| .__/|_____/ \___|_|_| \_/ \___|_|  |_|_| |_|\__, |
+ *                   | |                                            __/ |
+ *                   |_|                                           |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Pushes new articles to the webhooks, each from where its control record
+ * says it is up to. A webhook that fails waits longer each time, and after
+ * WEBHOOKRETRIES tries the article goes to the dead letters and it moves on.
+ * ----------------------------------------------------------------------- */
+func KeepDelivering(heartBeat int) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Error("Webhook dispatch loop:", r)
+		}
+	}()
+	for {
+		hooks, err := sql.DueWebhooks(context.Background(), time.Now())
+		lib.CheckErr(err)
+		delivered := 0
+		for _, hook := range hooks {
+			delivered += DispatchWebhook(hook)
+		}
+		if delivered == 0 {
+			time.Sleep(time.Duration(lib.NextHeartBeat(heartBeat)) * time.Second)
+		}
+	}
+}
+
+// Delivers the articles waiting for one webhook, up to a batch, stopping at a failure.
+func DispatchWebhook(hook sql.Webhook) (delivered int) {
+	target := hook.Target()
+	for i := 0; i < webhookBatch; i++ {
+		a, found := pendingArticle(context.Background(), target, hook.Topic, "")
+		if !found {
+			return
+		}
+		if !hook.Matches(a) {
+			advanceControl(context.Background(), target, a)
+			continue
+		}
+		err := DeliverWebhook(hook, a)
+		if err == nil {
+			sends.Inc("webhook", "sent")
+			advanceControl(context.Background(), target, a)
+			if hook.Failures > 0 {
+				setWebhookFailures(context.Background(), hook.Uid, 0, time.Now())
+				hook.Failures = 0
+			}
+			delivered++
+			continue
+		}
+		hook.Failures++
+		sends.Inc("webhook", "failed")
+		lib.Warn("Webhook delivery failed:", hook.Uid, a.Uid, "try:", hook.Failures, err)
+		if hook.Failures < conf.WEBHOOKRETRIES {
+			setWebhookFailures(context.Background(), hook.Uid, hook.Failures, time.Now().Add(webhookBackoff(hook.Failures)))
+			return
+		}
+		addDeadLetter(context.Background(), hook.Uid, a.Uid, hook.Failures, err.Error())
+		sends.Inc("webhook", "dead")
+		advanceControl(context.Background(), target, a)
+		setWebhookFailures(context.Background(), hook.Uid, 0, time.Now())
+		hook.Failures = 0
+	}
+	return
+}
+
+/********************************************************************************
+ *      _____       _ _             __          __  _     _                 _
+ *     |  __ \     | (_)            \ \        / / | |   | |               | |
+ *     | |  | | ___| |___   _____ _ _\ \  /\  / /__| |__ | |__   ___   ___ | | __
+ *     | |  | |/ _ \ | \ \ / / _ \ '__\ \/  \/ / _ \ '_ \| '_ \ / _ \ / _ \| |/ /
+ *     | |__| |  __/ | |\ V /  __/ |   \  /\  /  __/ |_) | | | | (_) | (_) |   <
+ *     |_____/ \___|_|_| \_/ \___|_|    \/  \/ \___|_.__/|_| |_|\___/ \___/|_|\_\
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * POSTs one article to the webhook. X-Webhook-Signature is sha256= and the
+ * HMAC-SHA256, keyed with the webhook secret, of the X-Webhook-Timestamp, a dot
+ * and the body, so the partner can check it is us and that it is recent.
+ * --------------------------------------------------------------------------- */
+func DeliverWebhook(hook sql.Webhook, a sql.Article) error {
+	body, err := json.Marshal(WebhookEvent{Event: "article", Webhook: hook.Uid, Article: a})
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", conf.AppName+"-webhook")
+	req.Header.Set("X-Webhook-Id", strconv.FormatInt(hook.Uid, 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+WebhookSignature(hook.Secret, timestamp, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer lib.DeferClose(resp.Body)
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // So the connection can be used again.
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook replied %s", resp.Status)
+	}
+	return nil
+}
+
+// The hex HMAC-SHA256 of the timestamp, a dot and the body.
+func WebhookSignature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Doubles from thirty seconds with each failure, up to an hour.
+func webhookBackoff(failures int) time.Duration {
+	wait := webhookFirstRetry
+	for i := 1; i < failures && wait < webhookMaxRetry; i++ {
+		wait *= 2
+	}
+	if wait > webhookMaxRetry {
+		wait = webhookMaxRetry
+	}
+	return wait
+}
@@ -0,0 +1,255 @@
+package publish
+
+import (
+	"[app name]/conf"
+	"[app name]/sql"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A partner endpoint that keeps what it is sent and replies with the status it is told to.
+type webhookPartner struct {
+	lock     sync.Mutex
+	status   int
+	received []*http.Request
+	bodies   [][]byte
+}
+
+func (p *webhookPartner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.received = append(p.received, r)
+	p.bodies = append(p.bodies, body)
+	w.WriteHeader(p.status)
+}
+
+func (p *webhookPartner) setStatus(status int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.status = status
+}
+
+// The uids of the articles it was sent, in order.
+func (p *webhookPartner) articles(t *testing.T) (uids []int64) {
+	t.Helper()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, body := range p.bodies {
+		var event WebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatalf("body %s: %v", body, err)
+		}
+		uids = append(uids, event.Article.Uid)
+	}
+	return
+}
+
+func usePartner(t *testing.T) (*webhookPartner, sql.Webhook) {
+	t.Helper()
+	partner := &webhookPartner{status: http.StatusOK}
+	server := httptest.NewServer(partner)
+	t.Cleanup(server.Close)
+	return partner, sql.Webhook{Uid: 7, Url: server.URL + "/hook", Secret: "whsec_test", Topic: "general", Live: true}
+}
+
+// One webhook's records, its cursor the uid of the last article it was moved past.
+type webhookRecords struct {
+	articles []sql.Article
+	cursor   int64
+	failures []int           // Each count set, in order.
+	waits    []time.Duration // How far off each next try was put.
+	dead     []sql.DeadLetter
+}
+
+func (w *webhookRecords) use(t *testing.T) {
+	oldPending, oldAdvance, oldFailures, oldDead, retries := pendingArticle, advanceControl, setWebhookFailures, addDeadLetter, conf.WEBHOOKRETRIES
+	t.Cleanup(func() {
+		pendingArticle, advanceControl, setWebhookFailures, addDeadLetter, conf.WEBHOOKRETRIES = oldPending, oldAdvance, oldFailures, oldDead, retries
+	})
+	conf.WEBHOOKRETRIES = 3
+	pendingArticle = func(ctx context.Context, target string, topic string, keywords string) (sql.Article, bool) {
+		if target != "webhook:7" {
+			t.Errorf("asked for the articles of %s", target)
+		}
+		for _, a := range w.articles {
+			if a.Uid > w.cursor {
+				return a, true
+			}
+		}
+		return sql.Article{}, false
+	}
+	advanceControl = func(ctx context.Context, target string, a sql.Article) bool {
+		w.cursor = a.Uid
+		return true
+	}
+	setWebhookFailures = func(ctx context.Context, uid int64, failures int, next time.Time) bool {
+		w.failures = append(w.failures, failures)
+		w.waits = append(w.waits, time.Until(next).Round(time.Second))
+		return true
+	}
+	addDeadLetter = func(ctx context.Context, webhook int64, article int64, attempts int, reason string) bool {
+		w.dead = append(w.dead, sql.DeadLetter{Webhook: webhook, Article: article, Attempts: attempts, LastError: reason})
+		return true
+	}
+}
+
+func TestDeliverWebhookSigned(t *testing.T) {
+	partner, hook := usePartner(t)
+	a := sql.Article{Uid: 42, Title: "Markets rally", Topic: "general"}
+	before := time.Now().Unix()
+	if err := DeliverWebhook(hook, a); err != nil {
+		t.Fatal(err)
+	}
+	if len(partner.received) != 1 {
+		t.Fatalf("%d requests, want 1", len(partner.received))
+	}
+	r, body := partner.received[0], partner.bodies[0]
+	if r.Method != http.MethodPost || r.URL.Path != "/hook" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Webhook-Id") != "7" {
+		t.Errorf("sent %s %s with %v", r.Method, r.URL.Path, r.Header)
+	}
+	timestamp := r.Header.Get("X-Webhook-Timestamp")
+	if sent, err := strconv.ParseInt(timestamp, 10, 64); err != nil || sent < before || sent > time.Now().Unix() {
+		t.Errorf("timestamp %q", timestamp)
+	}
+	// Checked the way a partner would, without WebhookSignature.
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := r.Header.Get("X-Webhook-Signature"); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("signature %q, want %q", got, want)
+	}
+	if WebhookSignature("other secret", timestamp, body) == strings.TrimPrefix(want, "sha256=") {
+		t.Error("the signature does not depend on the secret")
+	}
+	if WebhookSignature("whsec_test", timestamp+"1", body) == strings.TrimPrefix(want, "sha256=") {
+		t.Error("the signature does not depend on the timestamp")
+	}
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil || event.Event != "article" || event.Webhook != 7 || event.Article.Uid != 42 || event.Article.Title != "Markets rally" {
+		t.Errorf("sent %s, %v", body, err)
+	}
+
+	for _, status := range []int{http.StatusInternalServerError, http.StatusNotFound, http.StatusMovedPermanently} {
+		partner.setStatus(status)
+		if err := DeliverWebhook(hook, a); err == nil || !strings.Contains(err.Error(), strconv.Itoa(status)) {
+			t.Errorf("status %d: error %v", status, err)
+		}
+	}
+	partner.setStatus(http.StatusNoContent)
+	if err := DeliverWebhook(hook, a); err != nil {
+		t.Errorf("status 204: %v", err)
+	}
+}
+
+func TestDispatchWebhookRetries(t *testing.T) {
+	partner, hook := usePartner(t)
+	records := &webhookRecords{articles: []sql.Article{{Uid: 1, Topic: "general"}, {Uid: 2, Topic: "general"}}}
+	records.use(t)
+	partner.setStatus(http.StatusBadGateway)
+
+	// Each failure leaves the cursor where it is and waits twice as long.
+	for try, wait := range []time.Duration{30 * time.Second, time.Minute} {
+		if delivered := DispatchWebhook(hook); delivered != 0 || records.cursor != 0 {
+			t.Fatalf("try %d: %d delivered, cursor at %d", try+1, delivered, records.cursor)
+		}
+		if last := len(records.failures) - 1; records.failures[last] != try+1 || records.waits[last] != wait {
+			t.Errorf("try %d: failures set %v, waits %v, want %d in %s", try+1, records.failures, records.waits, try+1, wait)
+		}
+		hook.Failures = try + 1 // As DueWebhooks reads it back.
+	}
+	if len(records.dead) > 0 {
+		t.Fatalf("dead lettered %+v before the last try", records.dead)
+	}
+
+	// The last try parks the article, moves past it and starts again on the next.
+	records.failures, records.waits = nil, nil
+	if delivered := DispatchWebhook(hook); delivered != 0 {
+		t.Errorf("%d delivered", delivered)
+	}
+	if len(records.dead) != 1 || records.dead[0].Webhook != 7 || records.dead[0].Article != 1 || records.dead[0].Attempts != 3 || !strings.Contains(records.dead[0].LastError, "502") {
+		t.Errorf("dead letters %+v, want article 1 after 3 tries", records.dead)
+	}
+	if records.cursor != 1 {
+		t.Errorf("cursor at %d, want it past the dead letter", records.cursor)
+	}
+	if want := []int{0, 1}; len(records.failures) != 2 || records.failures[0] != want[0] || records.failures[1] != want[1] || records.waits[1] != 30*time.Second {
+		t.Errorf("failures set %v, waits %v, want cleared then 1 for the next article", records.failures, records.waits)
+	}
+	if got := partner.articles(t); len(got) != 4 || got[3] != 2 {
+		t.Errorf("sent %v, want article 1 three times then 2", got)
+	}
+
+	// It comes back, the count is cleared and the rest go.
+	hook.Failures = 1
+	records.failures = nil
+	partner.setStatus(http.StatusOK)
+	records.articles = append(records.articles, sql.Article{Uid: 3, Topic: "general"})
+	if delivered := DispatchWebhook(hook); delivered != 2 || records.cursor != 3 {
+		t.Errorf("%d delivered, cursor at %d, want 2 and 3", delivered, records.cursor)
+	}
+	if len(records.failures) != 1 || records.failures[0] != 0 {
+		t.Errorf("failures set %v, want cleared once", records.failures)
+	}
+	if len(records.dead) != 1 {
+		t.Errorf("dead letters %+v, want just the one", records.dead)
+	}
+}
+
+func TestDispatchWebhookFilters(t *testing.T) {
+	partner, hook := usePartner(t)
+	hook.Cats = "science"
+	records := &webhookRecords{articles: []sql.Article{
+		{Uid: 1, Topic: "general", Cat: "business"},
+		{Uid: 2, Topic: "general", Cat: "science"},
+		{Uid: 3, Topic: "general", Cat: "sport"},
+	}}
+	records.use(t)
+	if delivered := DispatchWebhook(hook); delivered != 1 || records.cursor != 3 {
+		t.Errorf("%d delivered, cursor at %d, want 1 and past them all", delivered, records.cursor)
+	}
+	if got := partner.articles(t); len(got) != 1 || got[0] != 2 {
+		t.Errorf("sent %v, want only the science article", got)
+	}
+}
+
+func TestDispatchWebhookBatch(t *testing.T) {
+	partner, hook := usePartner(t)
+	records := &webhookRecords{}
+	for uid := int64(1); uid <= webhookBatch+5; uid++ {
+		records.articles = append(records.articles, sql.Article{Uid: uid, Topic: "general"})
+	}
+	records.use(t)
+	if delivered := DispatchWebhook(hook); delivered != webhookBatch || records.cursor != webhookBatch {
+		t.Errorf("%d delivered, cursor at %d, want a batch of %d", delivered, records.cursor, webhookBatch)
+	}
+	if delivered := DispatchWebhook(hook); delivered != 5 || len(partner.articles(t)) != webhookBatch+5 {
+		t.Errorf("%d delivered the second time, want the 5 left", delivered)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		7:  32 * time.Minute,
+		8:  time.Hour,
+		50: time.Hour,
+	} {
+		if got := webhookBackoff(failures); got != want {
+			t.Errorf("%d failures: wait %s, want %s", failures, got, want)
+		}
+	}
+}
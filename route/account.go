@@ -0,0 +1,137 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/sql"
+	dbsql "database/sql"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+/********************************************************************************
+ *                                      _   _____             _
+ *                                     | | |  __ \           | |
+ *       __ _  ___ ___ ___  _   _ _ __ | |_| |__) |___  _   _| |_ ___  ___
+ *      / _` |/ __/ __/ _ \| | | | '_ \| __|  _  // _ \| | | | __/ _ \/ __|
+ *     | (_| | (_| (_| (_) | |_| | | | | |_| | \ \ (_) | |_| | ||  __/\__ \
+ *      \__,_|\___\___\___/ \__,_|_| |_|\__|_|  \_\___/ \__,_|\__\___||___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Self service for the account holder, called with the account's own access key.
+ * Enrolling a second factor is open to the key alone until one is live, the rest
+ * want a current TOTP code, or a recovery code, in an X-OTP header or otp value.
+ * --------------------------------------------------------------------------- */
+func accountRoutes(router *fasthttprouter.Router) {
+	router.GET("/api/V1/account", account(accountGet, true))
+	router.POST("/api/V1/account/otp", account(accountEnrol, false))
+	router.POST("/api/V1/account/otp/confirm", account(accountConfirm, false))
+	router.POST("/api/V1/account/recovery", account(accountRecovery, true))
+	router.POST("/api/V1/account/rotate", account(accountRotate, true))
+}
+
+type accountHandler func(ctx *fasthttp.RequestCtx, urn int64)
+
+// Wraps a self service handler with the access key, and the otp check if wanted.
+func account(handler accountHandler, otp bool) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		defer func() {
+			r := recover()
+			if r != nil {
+				requestLog(ctx).Error("Account request failed:", r, string(ctx.Path()))
+				ctx.Error("Account request failed", fasthttp.StatusInternalServerError)
+			}
+		}()
+		if !limiterAllow(ctx) {
+			ctx.Error("Too many requests", fasthttp.StatusTooManyRequests)
+			return
+		}
+		if urn, ok := accountAuth(ctx, otp); ok {
+			handler(ctx, urn)
+		}
+	}
+}
+
+/***************************************************************************
+ *                                      _                 _   _
+ *                                     | |     /\        | | | |
+ *       __ _  ___ ___ ___  _   _ _ __ | |_   /  \  _   _| |_| |__
+ *      / _` |/ __/ __/ _ \| | | | '_ \| __| / /\ \| | | | __| '_ \
+ *     | (_| | (_| (_| (_) | |_| | | | | |_ / ____ \ |_| | |_| | | |
+ *      \__,_|\___\___\___/ \__,_|_| |_|\__/_/    \_\__,_|\__|_| |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * The account behind the access key, with its second factor checked as well
+ * when otp is set. Replies with the refusal itself when it is not ok.
+ * ---------------------------------------------------------------------- */
+func accountAuth(ctx *fasthttp.RequestCtx, otp bool) (urn int64, ok bool) {
+	accessKey := formValue(ctx, "access_key")
+	account, found := Accounts.Get(accessKey)
+	if !found {
+		ctx.Error("Access key is invalid", fasthttp.StatusUnauthorized)
+		return
+	}
+	if !otp {
+		return account.Urn, true
+	}
+	code := string(ctx.Request.Header.Peek("X-OTP"))
+	if len(code) == 0 {
+		code = formValue(ctx, "otp")
+	}
+	switch err := sql.CheckOtp(requestContext(ctx), account.Urn, code); err {
+	case nil:
+		return account.Urn, true
+	case sql.ErrOtpNotEnrolled:
+		ctx.Error("Enrol a second factor at /api/V1/account/otp first", fasthttp.StatusForbidden)
+	default:
+		requestLog(ctx).Warn("Account otp refused:", account.Urn, ctx.RemoteIP())
+		ctx.Error("Second factor code is invalid", fasthttp.StatusUnauthorized)
+	}
+	return
+}
+
+func accountGet(ctx *fasthttp.RequestCtx, urn int64) {
+	record, err := sql.GetAccount(requestContext(ctx), urn)
+	accountReply(ctx, err, record)
+}
+
+// A new secret, or a replacement for a live one, which then needs a code from the old.
+func accountEnrol(ctx *fasthttp.RequestCtx, urn int64) {
+	if sql.OtpEnrolled(requestContext(ctx), urn) {
+		if _, ok := accountAuth(ctx, true); !ok {
+			return
+		}
+	}
+	secret, uri, err := sql.EnrolOtp(requestContext(ctx), urn)
+	accountReply(ctx, err, map[string]string{"secret": secret, "uri": uri})
+}
+
+func accountConfirm(ctx *fasthttp.RequestCtx, urn int64) {
+	codes, err := sql.ConfirmOtp(requestContext(ctx), urn, formValue(ctx, "otp"))
+	accountReply(ctx, err, map[string][]string{"recovery_codes": codes})
+}
+
+func accountRecovery(ctx *fasthttp.RequestCtx, urn int64) {
+	codes, err := sql.NewRecoveryCodes(requestContext(ctx), urn)
+	accountReply(ctx, err, map[string][]string{"recovery_codes": codes})
+}
+
+func accountRotate(ctx *fasthttp.RequestCtx, urn int64) {
+	apikey, err := sql.RotateAccountKey(requestContext(ctx), urn, time.Duration(conf.KEYGRACE)*time.Hour)
+	accountReply(ctx, err, map[string]string{"apikey": apikey})
+}
+
+func accountReply(ctx *fasthttp.RequestCtx, err error, value interface{}) {
+	switch err {
+	case nil:
+		jsonResponse(ctx, fasthttp.StatusOK, value)
+	case dbsql.ErrNoRows:
+		ctx.Error("No such account", fasthttp.StatusNotFound)
+	case sql.ErrOtpNotEnrolled:
+		ctx.Error(err.Error(), fasthttp.StatusConflict)
+	case sql.ErrOtpInvalid:
+		ctx.Error(err.Error(), fasthttp.StatusUnauthorized)
+	default:
+		requestLog(ctx).Error("Account request:", err)
+		ctx.Error("Account request failed", fasthttp.StatusInternalServerError)
+	}
+}
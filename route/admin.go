@@ -0,0 +1,352 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/lib"
+	"all-news/sql"
+	"crypto/rand"
+	"crypto/subtle"
+	dbsql "database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+/*********************************************************************************
+ *                _           _       _____             _
+ *               | |         (_)     |  __ \           | |
+ *       __ _  __| |_ __ ___  _ _ __ | |__) |___  _   _| |_ ___  ___
+ *      / _` |/ _` | '_ ` _ \| | '_ \|  _  // _ \| | | | __/ _ \/ __|
+ *     | (_| | (_| | | | | | | | | | | | \ \ (_) | |_| | ||  __/\__ \
+ *      \__,_|\__,_|_| |_| |_|_|_| |_|_|  \_\___/ \__,_|\__\___||___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Account management for the admin, every call carries the admin key from the
+ * config in an X-Admin-Key header and a current code for the admin TOTP secret
+ * in X-OTP. With either of them not configured these all refuse. A code is only
+ * good once, so a good one gets an X-Admin-Session token back that stands in
+ * for the code, alongside the key, for ADMINSESSION minutes.
+ * ---------------------------------------------------------------------------- */
+func adminRoutes(router *fasthttprouter.Router) {
+	router.POST("/admin/accounts", admin(adminCreate))
+	router.GET("/admin/accounts", admin(adminList))
+	router.GET("/admin/accounts/:urn", admin(adminGet))
+	router.PUT("/admin/accounts/:urn/plan", admin(adminPlan))
+	router.PUT("/admin/accounts/:urn/allocation", admin(adminAllocation))
+	router.PUT("/admin/accounts/:urn/end", admin(adminEnd))
+	router.POST("/admin/accounts/:urn/rotate", admin(adminRotate))
+	router.GET("/admin/accounts/:urn/keys", admin(adminKeys))
+	router.DELETE("/admin/accounts/:urn/keys/:uid", admin(adminRevokeKey))
+	router.DELETE("/admin/accounts/:urn/otp", admin(adminResetOtp))
+	router.DELETE("/admin/accounts/:urn", admin(adminRevoke))
+}
+
+// Wraps an admin handler with the admin key check.
+func admin(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		defer func() {
+			r := recover()
+			if r != nil {
+				requestLog(ctx).Error("Admin request failed:", r, string(ctx.Path()))
+				ctx.Error("Admin request failed", fasthttp.StatusInternalServerError)
+			}
+		}()
+		key := ctx.Request.Header.Peek("X-Admin-Key")
+		if len(conf.ADMINKEY) == 0 || subtle.ConstantTimeCompare(key, []byte(conf.ADMINKEY)) != 1 {
+			requestLog(ctx).Warn("Admin key refused from:", ctx.RemoteIP())
+			ctx.Error("Forbidden", fasthttp.StatusForbidden)
+			return
+		}
+		if session := string(ctx.Request.Header.Peek("X-Admin-Session")); len(session) > 0 {
+			if !adminSessionAt(session, time.Now()) {
+				requestLog(ctx).Warn("Admin session refused from:", ctx.RemoteIP())
+				ctx.Error("Forbidden", fasthttp.StatusForbidden)
+				return
+			}
+		} else if adminOtp(string(ctx.Request.Header.Peek("X-OTP"))) {
+			ctx.Response.Header.Set("X-Admin-Session", newAdminSession(time.Now()))
+		} else {
+			requestLog(ctx).Warn("Admin otp refused from:", ctx.RemoteIP())
+			ctx.Error("Forbidden", fasthttp.StatusForbidden)
+			return
+		}
+		handler(ctx)
+	}
+}
+
+var (
+	adminOtpLock     sync.Mutex
+	adminOtpStep     int64                // The last step used, a code is only good once.
+	adminSessions    map[string]time.Time // When each session token runs out.
+	adminSessionLock sync.Mutex
+)
+
+// Checks the admin code, the admin has no account row so the last step is kept here.
+func adminOtp(code string) bool {
+	return adminOtpAt(code, time.Now())
+}
+
+func adminOtpAt(code string, t time.Time) bool {
+	if len(conf.ADMINOTPKEY) == 0 {
+		return false
+	}
+	adminOtpLock.Lock()
+	defer adminOtpLock.Unlock()
+	step, ok := lib.TotpMatch(conf.ADMINOTPKEY, code, t, conf.OTPWINDOW)
+	if !ok || step <= adminOtpStep {
+		return false
+	}
+	adminOtpStep = step
+	return true
+}
+
+// A token good for ADMINSESSION minutes from t, the ones run out are dropped as it goes.
+func newAdminSession(t time.Time) string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(b)
+	adminSessionLock.Lock()
+	defer adminSessionLock.Unlock()
+	if adminSessions == nil {
+		adminSessions = make(map[string]time.Time)
+	}
+	for old, expires := range adminSessions {
+		if !t.Before(expires) {
+			delete(adminSessions, old)
+		}
+	}
+	adminSessions[token] = t.Add(time.Duration(conf.ADMINSESSION) * time.Minute)
+	return token
+}
+
+// Whether the token is one handed out that has not run out by t.
+func adminSessionAt(token string, t time.Time) bool {
+	if len(conf.ADMINOTPKEY) == 0 {
+		return false
+	}
+	adminSessionLock.Lock()
+	defer adminSessionLock.Unlock()
+	expires, ok := adminSessions[token]
+	return ok && t.Before(expires)
+}
+
+func adminCreate(ctx *fasthttp.RequestCtx) {
+	email := strings.TrimSpace(formValue(ctx, "email"))
+	plan := strings.ToUpper(formValue(ctx, "plan"))
+	if len(plan) == 0 {
+		plan = "FREE"
+	}
+	if !strings.Contains(email, "@") {
+		ctx.Error("A valid email is required", fasthttp.StatusBadRequest)
+		return
+	}
+	account, err := sql.CreateAccount(requestContext(ctx), email, plan)
+	if err != nil {
+		adminError(ctx, err)
+		return
+	}
+	jsonResponse(ctx, fasthttp.StatusCreated, account)
+}
+
+func adminList(ctx *fasthttp.RequestCtx) {
+	offset := 0
+	if value := formValue(ctx, "offset"); len(value) > 0 {
+		var err error
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			ctx.Error("offset must be a whole number, zero or more", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultLimit
+	if value := formValue(ctx, "limit"); len(value) > 0 {
+		var err error
+		if limit, err = setLimit(value); err != nil {
+			ctx.Error("limit must be a whole number", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	aList, err := sql.ListAccounts(requestContext(ctx), formValue(ctx, "search"), strings.ToUpper(formValue(ctx, "plan")), limit, offset)
+	if err != nil {
+		adminError(ctx, err)
+		return
+	}
+	if aList == nil {
+		aList = []sql.AccountRecord{}
+	}
+	jsonResponse(ctx, fasthttp.StatusOK, aList)
+}
+
+func adminGet(ctx *fasthttp.RequestCtx) {
+	if urn, ok := urnParam(ctx); ok {
+		account, err := sql.GetAccount(requestContext(ctx), urn)
+		adminReply(ctx, urn, err, account)
+	}
+}
+
+func adminPlan(ctx *fasthttp.RequestCtx) {
+	if urn, ok := urnParam(ctx); ok {
+		adminReply(ctx, urn, sql.SetAccountPlan(requestContext(ctx), urn, strings.ToUpper(formValue(ctx, "plan"))), nil)
+	}
+}
+
+func adminAllocation(ctx *fasthttp.RequestCtx) {
+	if urn, ok := urnParam(ctx); ok {
+		allocated, err := strconv.ParseInt(formValue(ctx, "allocated"), 10, 64)
+		if err != nil || allocated < 0 {
+			ctx.Error("allocated must be a whole number", fasthttp.StatusBadRequest)
+			return
+		}
+		adminReply(ctx, urn, sql.SetAccountAllocation(requestContext(ctx), urn, allocated), nil)
+	}
+}
+
+// Takes either an end date, or a number of days to add to the current end.
+func adminEnd(ctx *fasthttp.RequestCtx) {
+	if urn, ok := urnParam(ctx); ok {
+		account, err := sql.GetAccount(requestContext(ctx), urn)
+		if err != nil {
+			adminReply(ctx, urn, err, nil)
+			return
+		}
+		end := account.End
+		if value := formValue(ctx, "end"); len(value) > 0 {
+			end, err = time.Parse(dateFormat, value)
+		} else {
+			var days int
+			days, err = strconv.Atoi(formValue(ctx, "days"))
+			end = end.AddDate(0, 0, days)
+		}
+		if err != nil {
+			ctx.Error("end must be a date ("+dateFormat+") or days a whole number", fasthttp.StatusBadRequest)
+			return
+		}
+		adminReply(ctx, urn, sql.SetAccountEnd(requestContext(ctx), urn, end), nil)
+	}
+}
+
+func adminRotate(ctx *fasthttp.RequestCtx) {
+	if urn, ok := urnParam(ctx); ok {
+		grace := conf.KEYGRACE
+		if value := formValue(ctx, "grace"); len(value) > 0 {
+			var err error
+			if grace, err = strconv.Atoi(value); err != nil || grace < 0 {
+				ctx.Error("grace must be a whole number of hours", fasthttp.StatusBadRequest)
+				return
+			}
+		}
+		apikey, err := sql.RotateAccountKey(requestContext(ctx), urn, time.Duration(grace)*time.Hour)
+		adminReply(ctx, urn, err, map[string]string{"apikey": apikey})
+	}
+}
+
+func adminKeys(ctx *fasthttp.RequestCtx) {
+	if urn, ok := urnParam(ctx); ok {
+		kList, err := sql.AccountKeys(requestContext(ctx), urn)
+		adminReply(ctx, urn, err, kList)
+	}
+}
+
+func adminRevokeKey(ctx *fasthttp.RequestCtx) {
+	if urn, ok := urnParam(ctx); ok {
+		uid, err := strconv.ParseInt(ctx.UserValue("uid").(string), 10, 64)
+		if err != nil {
+			ctx.Error("Key uid must be a number", fasthttp.StatusBadRequest)
+			return
+		}
+		err = sql.RevokeAccountKey(requestContext(ctx), urn, uid)
+		if err == dbsql.ErrNoRows {
+			ctx.Error("No such live key on the account", fasthttp.StatusNotFound)
+			return
+		}
+		adminReply(ctx, urn, err, nil)
+	}
+}
+
+func adminRevoke(ctx *fasthttp.RequestCtx) {
+	if urn, ok := urnParam(ctx); ok {
+		adminReply(ctx, urn, sql.RevokeAccount(requestContext(ctx), urn), nil)
+	}
+}
+
+func adminResetOtp(ctx *fasthttp.RequestCtx) {
+	if urn, ok := urnParam(ctx); ok {
+		adminReply(ctx, urn, sql.ResetOtp(requestContext(ctx), urn), nil)
+	}
+}
+
+/*******************************************************************************
+ *                _           _       _____            _
+ *               | |         (_)     |  __ \          | |
+ *       __ _  __| |_ __ ___  _ _ __ | |__) |___ _ __ | |_   _
+ *      / _` |/ _` | '_ ` _ \| | '_ \|  _  // _ \ '_ \| | | | |
+ *     | (_| | (_| | | | | | | | | | | | \ \  __/ |_) | | |_| |
+ *      \__,_|\__,_|_| |_| |_|_|_| |_|_|  \_\___| .__/|_|\__, |
+ *                                              | |       __/ |
+ *                                              |_|      |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Replies to an admin call on one account, with the account as it now stands if
+ * there is nothing else to send back.
+ * -------------------------------------------------------------------------- */
+func adminReply(ctx *fasthttp.RequestCtx, urn int64, err error, value interface{}) {
+	if err != nil {
+		adminError(ctx, err)
+		return
+	}
+	if value == nil {
+		value, err = sql.GetAccount(requestContext(ctx), urn)
+		if err != nil {
+			adminError(ctx, err)
+			return
+		}
+	}
+	jsonResponse(ctx, fasthttp.StatusOK, value)
+}
+
+func adminError(ctx *fasthttp.RequestCtx, err error) {
+	switch {
+	case err == dbsql.ErrNoRows:
+		ctx.Error("No such account", fasthttp.StatusNotFound)
+	case strings.Contains(err.Error(), "Duplicate"):
+		ctx.Error("An account with that email already exists", fasthttp.StatusConflict)
+	case strings.HasPrefix(err.Error(), "unknown plan"):
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+	default:
+		requestLog(ctx).Error("Admin request:", err)
+		ctx.Error("Admin request failed", fasthttp.StatusInternalServerError)
+	}
+}
+
+// The :urn in the path, replies with a 400 if it is not a number.
+func urnParam(ctx *fasthttp.RequestCtx) (urn int64, ok bool) {
+	urn, err := strconv.ParseInt(ctx.UserValue("urn").(string), 10, 64)
+	if err != nil {
+		ctx.Error("Invalid account urn", fasthttp.StatusBadRequest)
+		return
+	}
+	return urn, true
+}
+
+// A value from the posted form, or failing that the query string.
+func formValue(ctx *fasthttp.RequestCtx, key string) string {
+	if value := ctx.PostArgs().Peek(key); len(value) > 0 {
+		return string(value)
+	}
+	return string(ctx.QueryArgs().Peek(key))
+}
+
+func jsonResponse(ctx *fasthttp.RequestCtx, code int, value interface{}) {
+	jsonBytes, err := json.Marshal(value)
+	if lib.CheckErr(err) {
+		ctx.Error("Unable to encode reply", fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json; charset=utf-8")
+	response(ctx, conf.Reply{Code: code, Msg: string(jsonBytes)})
+}
@@ -0,0 +1,132 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/lib"
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Sets up the admin key and TOTP secret with no codes used and no sessions, put back when the test ends.
+func useAdmin(t *testing.T) {
+	t.Helper()
+	key, otpKey, window, session := conf.ADMINKEY, conf.ADMINOTPKEY, conf.OTPWINDOW, conf.ADMINSESSION
+	step, sessions := adminOtpStep, adminSessions
+	t.Cleanup(func() {
+		conf.ADMINKEY, conf.ADMINOTPKEY, conf.OTPWINDOW, conf.ADMINSESSION = key, otpKey, window, session
+		adminOtpStep, adminSessions = step, sessions
+	})
+	conf.ADMINKEY = "admin-key"
+	conf.ADMINOTPKEY = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	conf.OTPWINDOW = 1
+	conf.ADMINSESSION = 15
+	adminOtpStep, adminSessions = 0, nil
+}
+
+// Runs one request through the admin check with the headers given, and gives the session replied with.
+func callAdmin(handler fasthttp.RequestHandler, headers map[string]string) (status int, session string) {
+	var req fasthttp.Request
+	req.SetRequestURI("/admin/accounts")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, nil, nil)
+	admin(handler)(&ctx)
+	return ctx.Response.StatusCode(), string(ctx.Response.Header.Peek("X-Admin-Session"))
+}
+
+func TestAdminOtpReplay(t *testing.T) {
+	useAdmin(t)
+
+	now := time.Unix(1111111111, 0)
+	code, _ := lib.TotpCode(conf.ADMINOTPKEY, now)
+	if !adminOtpAt(code, now) {
+		t.Fatal("a fresh code was refused")
+	}
+	if adminOtpAt(code, now) {
+		t.Error("the same code was let in twice")
+	}
+	if adminOtpAt(code, now.Add(lib.TotpPeriod*time.Second)) {
+		t.Error("the same code was let in again a step later")
+	}
+	earlier, _ := lib.TotpCode(conf.ADMINOTPKEY, now.Add(-lib.TotpPeriod*time.Second))
+	if adminOtpAt(earlier, now) {
+		t.Error("a code from before the one used was let in")
+	}
+	next := now.Add(lib.TotpPeriod * time.Second)
+	nextCode, _ := lib.TotpCode(conf.ADMINOTPKEY, next)
+	if !adminOtpAt(nextCode, next) {
+		t.Error("the next step's code was refused")
+	}
+
+	conf.ADMINOTPKEY = ""
+	if adminOtpAt(nextCode, next) {
+		t.Error("a code was let in with no admin secret")
+	}
+}
+
+func TestAdminSession(t *testing.T) {
+	useAdmin(t)
+	calls := 0
+	handler := func(ctx *fasthttp.RequestCtx) { calls++ }
+	code, _ := lib.TotpCode(conf.ADMINOTPKEY, time.Now())
+
+	status, session := callAdmin(handler, map[string]string{"X-Admin-Key": "admin-key", "X-OTP": code})
+	if status != fasthttp.StatusOK || len(session) != 64 {
+		t.Fatalf("a good code: status %d, session %q", status, session)
+	}
+	if status, _ := callAdmin(handler, map[string]string{"X-Admin-Key": "admin-key", "X-OTP": code}); status != fasthttp.StatusForbidden {
+		t.Errorf("the code again: status %d, want it refused", status)
+	}
+	for i := 0; i < 3; i++ {
+		status, again := callAdmin(handler, map[string]string{"X-Admin-Key": "admin-key", "X-Admin-Session": session})
+		if status != fasthttp.StatusOK || len(again) > 0 {
+			t.Errorf("with the session: status %d, session %q", status, again)
+		}
+	}
+	if calls != 4 {
+		t.Errorf("the handler ran %d times, want 4", calls)
+	}
+
+	refused := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"session without the key", map[string]string{"X-Admin-Session": session}},
+		{"session with the wrong key", map[string]string{"X-Admin-Key": "guess", "X-Admin-Session": session}},
+		{"made up session", map[string]string{"X-Admin-Key": "admin-key", "X-Admin-Session": strings.Repeat("0", 64)}},
+		{"bad session with a good code", map[string]string{"X-Admin-Key": "admin-key", "X-Admin-Session": "stale", "X-OTP": code}},
+		{"nothing but the key", map[string]string{"X-Admin-Key": "admin-key"}},
+	}
+	for _, test := range refused {
+		if status, issued := callAdmin(handler, test.headers); status != fasthttp.StatusForbidden || len(issued) > 0 {
+			t.Errorf("%s: status %d, session %q, want it refused", test.name, status, issued)
+		}
+	}
+	if calls != 4 {
+		t.Errorf("the handler ran %d times for refused requests", calls-4)
+	}
+
+	now := time.Now()
+	if !adminSessionAt(session, now.Add(14*time.Minute)) {
+		t.Error("the session ran out early")
+	}
+	if adminSessionAt(session, now.Add(15*time.Minute)) {
+		t.Error("the session lasted past ADMINSESSION")
+	}
+	newAdminSession(now.Add(15 * time.Minute))
+	if _, kept := adminSessions[session]; kept {
+		t.Error("the session that ran out was kept")
+	}
+
+	fresh := newAdminSession(now)
+	conf.ADMINOTPKEY = ""
+	if adminSessionAt(fresh, now) {
+		t.Error("a session was let in with no admin secret")
+	}
+}
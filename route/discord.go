@@ -0,0 +1,53 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/publish"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+/******************************************************************************
+ *          _ _                       _ _____             _
+ *         | (_)                     | |  __ \           | |
+ *       __| |_ ___  ___ ___  _ __ __| | |__) |___  _   _| |_ ___  ___
+ *      / _` | / __|/ __/ _ \| '__/ _` |  _  // _ \| | | | __/ _ \/ __|
+ *     | (_| | \__ \ (_| (_) | | | (_| | | \ \ (_) | |_| | ||  __/\__ \
+ *      \__,_|_|___/\___\___/|_|  \__,_|_|  \_\___/ \__,_|\__\___||___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * The interactions endpoint to give Discord for the /news command. Every call
+ * is signed with the application key, anything not signed by it is refused, as
+ * Discord checks when the endpoint is set.
+ * ------------------------------------------------------------------------- */
+func discordRoutes(router *fasthttprouter.Router) {
+	router.POST("/discord/interactions", discordInteraction)
+}
+
+func discordInteraction(ctx *fasthttp.RequestCtx) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			requestLog(ctx).Error("Discord interaction failed:", r)
+			ctx.Error("Discord interaction failed", fasthttp.StatusInternalServerError)
+		}
+	}()
+	if len(conf.DISCORDPUBLICKEY) == 0 {
+		ctx.Error("Not Found", fasthttp.StatusNotFound)
+		return
+	}
+	signature := string(ctx.Request.Header.Peek("X-Signature-Ed25519"))
+	timestamp := string(ctx.Request.Header.Peek("X-Signature-Timestamp"))
+	if !publish.VerifyDiscord(conf.DISCORDPUBLICKEY, signature, timestamp, ctx.PostBody()) {
+		ctx.Error("Invalid request signature", fasthttp.StatusUnauthorized)
+		return
+	}
+	reply, err := publish.DiscordInteraction(requestContext(ctx), ctx.PostBody())
+	if err != nil {
+		requestLog(ctx).Warn("Discord interaction:", err)
+		ctx.Error("Unknown interaction", fasthttp.StatusBadRequest)
+		return
+	}
+	ctx.SetContentType("application/json")
+	response(ctx, conf.Reply{Code: fasthttp.StatusOK, Msg: string(reply)})
+}
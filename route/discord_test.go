@@ -0,0 +1,58 @@
+package route
+
+import (
+	"all-news/conf"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func signedInteraction(private ed25519.PrivateKey, timestamp string, body string) *fasthttp.RequestCtx {
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI("/discord/interactions")
+	ctx.Request.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(private, []byte(timestamp+body))))
+	ctx.Request.Header.Set("X-Signature-Timestamp", timestamp)
+	ctx.Request.SetBodyString(body)
+	return &ctx
+}
+
+func TestDiscordInteractionSigned(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	key := conf.DISCORDPUBLICKEY
+	t.Cleanup(func() { conf.DISCORDPUBLICKEY = key })
+
+	conf.DISCORDPUBLICKEY = ""
+	ctx := signedInteraction(private, "1700000000", `{"type":1}`)
+	if discordInteraction(ctx); ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+		t.Errorf("no key set: %d, want 404", ctx.Response.StatusCode())
+	}
+
+	conf.DISCORDPUBLICKEY = hex.EncodeToString(public)
+	ctx = signedInteraction(private, "1700000000", `{"type":1}`)
+	discordInteraction(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK || string(ctx.Response.Body()) != `{"type":1}` {
+		t.Errorf("signed ping: %d %s, want a pong", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+
+	ctx = signedInteraction(otherPrivate, "1700000000", `{"type":1}`)
+	if discordInteraction(ctx); ctx.Response.StatusCode() != fasthttp.StatusUnauthorized {
+		t.Errorf("signed by another key: %d, want 401", ctx.Response.StatusCode())
+	}
+	ctx = signedInteraction(private, "1700000000", `{"type":1}`)
+	ctx.Request.SetBodyString(`{"type":2}`)
+	if discordInteraction(ctx); ctx.Response.StatusCode() != fasthttp.StatusUnauthorized {
+		t.Errorf("body changed after signing: %d, want 401", ctx.Response.StatusCode())
+	}
+	ctx = signedInteraction(private, "1700000000", `{"type":7}`)
+	if discordInteraction(ctx); ctx.Response.StatusCode() != fasthttp.StatusBadRequest {
+		t.Errorf("unknown interaction: %d, want 400", ctx.Response.StatusCode())
+	}
+}
@@ -0,0 +1,400 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/lib"
+	"all-news/sql"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	rssType      = "application/rss+xml; charset=utf-8"
+	atomType     = "application/atom+xml; charset=utf-8"
+	jsonFeedType = "application/feed+json; charset=utf-8"
+)
+
+/***************************************************************************
+ *       __              _ _____             _
+ *      / _|            | |  __ \           | |
+ *     | |_ ___  ___  __| | |__) |___  _   _| |_ ___  ___
+ *     |  _/ _ \/ _ \/ _` |  _  // _ \| | | | __/ _ \/ __|
+ *     | ||  __/  __/ (_| | | \ \ (_) | |_| | ||  __/\__ \
+ *     |_| \___|\___|\__,_|_|  \_\___/ \__,_|\__\___||___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * The latest articles as RSS 2.0, Atom and JSON Feed, open to any reader.
+ * topic, cat and keywords narrow them down as they do on the api, and limit
+ * sets how many. Each carries an ETag and Last-Modified, so a reader that
+ * already has the latest gets a 304 and nothing more.
+ * ---------------------------------------------------------------------- */
+func feedRoutes(router *fasthttprouter.Router) {
+	router.GET("/feed/rss", feedHandler(rssType, renderRSS))
+	router.GET("/feed/atom", feedHandler(atomType, renderAtom))
+	router.GET("/feed/json", feedHandler(jsonFeedType, renderJSONFeed))
+}
+
+// Writes a list of articles out as one of the feed formats.
+type feedRenderer func(self string, aList []sql.Article, updated time.Time) ([]byte, error)
+
+func feedHandler(contentType string, render feedRenderer) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		defer func() {
+			r := recover()
+			if r != nil {
+				requestLog(ctx).Error("Feed request failed:", r, string(ctx.RequestURI()))
+				ctx.Error("Feed request failed", fasthttp.StatusInternalServerError)
+			}
+		}()
+		if !limiterAllow(ctx) {
+			ctx.Error("Too many requests", fasthttp.StatusTooManyRequests)
+			return
+		}
+		query := sql.NewsQuery{
+			Topic:      string(ctx.QueryArgs().Peek("topic")),
+			Keywords:   splitList(string(ctx.QueryArgs().Peek("keywords"))),
+			Categories: splitList(string(ctx.QueryArgs().Peek("cat"))),
+			Sort:       "published_desc",
+			Limit:      defaultLimit,
+		}
+		if limit := string(ctx.QueryArgs().Peek("limit")); len(limit) > 0 {
+			value, err := setLimit(limit)
+			if err != nil {
+				ctx.Error("Invalid limit: "+limit, fasthttp.StatusBadRequest)
+				return
+			}
+			query.Limit = value
+		}
+		aList, _, err := Articles.List(requestContext(ctx), query)
+		if err != nil {
+			requestLog(ctx).Error("Feed query:", err)
+			ctx.Error("Feed request failed", fasthttp.StatusInternalServerError)
+			return
+		}
+		updated := feedUpdated(aList)
+		body, err := render(conf.PUBLICURL+string(ctx.RequestURI()), aList, updated)
+		if lib.CheckErr(err) {
+			ctx.Error("Unable to render the feed", fasthttp.StatusInternalServerError)
+			return
+		}
+		sum := sha256.Sum256(body)
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		notModified := !ctx.IfModifiedSince(updated)
+		if ifNoneMatch := string(ctx.Request.Header.Peek("If-None-Match")); len(ifNoneMatch) > 0 {
+			notModified = etagMatch(ifNoneMatch, etag)
+		}
+		if notModified {
+			ctx.NotModified() // This clears the headers, the 304 carries them as well.
+		}
+		ctx.Response.Header.Set("ETag", etag)
+		ctx.Response.Header.Set("Last-Modified", string(fasthttp.AppendHTTPDate(nil, updated)))
+		ctx.Response.Header.Set("Cache-Control", "public, max-age=300")
+		if notModified {
+			return
+		}
+		ctx.SetContentType(contentType)
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetBody(body)
+	}
+}
+
+// The newest article time, to the second as http dates are, the epoch for no articles.
+func feedUpdated(aList []sql.Article) (updated time.Time) {
+	updated = time.Unix(0, 0)
+	for _, a := range aList {
+		if a.Created.After(updated) {
+			updated = a.Created
+		}
+	}
+	return updated.UTC().Truncate(time.Second)
+}
+
+// If-None-Match holds one or more tags, weak ones match too as the body is all we compare.
+func etagMatch(ifNoneMatch string, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// RSS 2.0 as it goes out, atom:link for the self link as feed validators ask.
+type rssOut struct {
+	XMLName xml.Name      `xml:"rss"`
+	Version string        `xml:"version,attr"`
+	Atom    string        `xml:"xmlns:atom,attr"`
+	Dc      string        `xml:"xmlns:dc,attr"`
+	Channel rssOutChannel `xml:"channel"`
+}
+
+type rssOutChannel struct {
+	Title         string       `xml:"title"`
+	Link          string       `xml:"link"`
+	Description   string       `xml:"description"`
+	Self          rssOutLink   `xml:"atom:link"`
+	LastBuildDate string       `xml:"lastBuildDate"`
+	Items         []rssOutItem `xml:"item"`
+}
+
+type rssOutLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssOutItem struct {
+	Title       string           `xml:"title"`
+	Link        string           `xml:"link,omitempty"`
+	Description string           `xml:"description"`
+	Author      string           `xml:"author,omitempty"`
+	Creator     string           `xml:"dc:creator,omitempty"`
+	Category    []string         `xml:"category"`
+	Guid        rssOutGuid       `xml:"guid"`
+	PubDate     string           `xml:"pubDate"`
+	Enclosure   *rssOutEnclosure `xml:"enclosure"`
+}
+
+type rssOutGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssOutEnclosure struct {
+	Url    string `xml:"url,attr"`
+	Length int    `xml:"length,attr"` // Not known without fetching it, 0 as the spec allows.
+	Type   string `xml:"type,attr"`
+}
+
+func renderRSS(self string, aList []sql.Article, updated time.Time) ([]byte, error) {
+	doc := rssOut{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Dc:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssOutChannel{
+			Title:         conf.AppName,
+			Link:          conf.PUBLICURL,
+			Description:   "The latest articles from " + conf.AppName,
+			Self:          rssOutLink{Href: self, Rel: "self", Type: "application/rss+xml"},
+			LastBuildDate: updated.Format(time.RFC1123Z),
+		},
+	}
+	for _, a := range aList {
+		item := rssOutItem{
+			Title:       a.Title,
+			Link:        a.Link,
+			Description: a.Content,
+			Category:    splitList(a.Cat),
+			Guid:        rssOutGuid{Value: articleId(a)},
+			PubDate:     a.Created.Format(time.RFC1123Z),
+		}
+		// RSS wants an email in author, the name alone goes in dc:creator.
+		if len(a.Email) > 0 && len(a.Author) > 0 {
+			item.Author = a.Email + " (" + a.Author + ")"
+		} else if len(a.Email) > 0 {
+			item.Author = a.Email
+		} else {
+			item.Creator = a.Author
+		}
+		if img := articleImage(a); len(img) > 0 {
+			item.Enclosure = &rssOutEnclosure{Url: img, Type: imageType(img)}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	return marshalXML(doc)
+}
+
+// Atom as it goes out.
+type atomOut struct {
+	XMLName xml.Name       `xml:"feed"`
+	Xmlns   string         `xml:"xmlns,attr"`
+	Title   string         `xml:"title"`
+	Id      string         `xml:"id"`
+	Updated string         `xml:"updated"`
+	Links   []atomOutLink  `xml:"link"`
+	Author  atomOutAuthor  `xml:"author"`
+	Entries []atomOutEntry `xml:"entry"`
+}
+
+type atomOutLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomOutAuthor struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+}
+
+type atomOutCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomOutText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomOutEntry struct {
+	Title      string            `xml:"title"`
+	Id         string            `xml:"id"`
+	Updated    string            `xml:"updated"`
+	Published  string            `xml:"published"`
+	Author     atomOutAuthor     `xml:"author"`
+	Links      []atomOutLink     `xml:"link"`
+	Categories []atomOutCategory `xml:"category"`
+	Summary    atomOutText       `xml:"summary"`
+}
+
+func renderAtom(self string, aList []sql.Article, updated time.Time) ([]byte, error) {
+	doc := atomOut{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		Title:   conf.AppName,
+		Id:      self,
+		Updated: updated.Format(time.RFC3339),
+		Links: []atomOutLink{
+			{Href: self, Rel: "self", Type: "application/atom+xml"},
+			{Href: conf.PUBLICURL, Rel: "alternate"},
+		},
+		Author: atomOutAuthor{Name: conf.AppName},
+	}
+	for _, a := range aList {
+		entry := atomOutEntry{
+			Title:     a.Title,
+			Id:        articleId(a),
+			Updated:   a.Created.Format(time.RFC3339),
+			Published: a.Created.Format(time.RFC3339),
+			Author:    atomOutAuthor{Name: firstNonEmpty(a.Author, conf.AppName), Email: a.Email},
+			Summary:   atomOutText{Type: "text", Value: a.Content},
+		}
+		if len(a.Link) > 0 {
+			entry.Links = append(entry.Links, atomOutLink{Href: a.Link, Rel: "alternate"})
+		}
+		if img := articleImage(a); len(img) > 0 {
+			entry.Links = append(entry.Links, atomOutLink{Href: img, Rel: "enclosure", Type: imageType(img)})
+		}
+		for _, cat := range splitList(a.Cat) {
+			entry.Categories = append(entry.Categories, atomOutCategory{Term: cat})
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+	return marshalXML(doc)
+}
+
+// JSON Feed 1.1 as it goes out.
+type jsonFeedOut struct {
+	Version     string            `json:"version"`
+	Title       string            `json:"title"`
+	HomePageUrl string            `json:"home_page_url,omitempty"`
+	FeedUrl     string            `json:"feed_url"`
+	Items       []jsonFeedOutItem `json:"items"`
+}
+
+type jsonFeedOutAuthor struct {
+	Name string `json:"name,omitempty"`
+	Url  string `json:"url,omitempty"`
+}
+
+type jsonFeedOutAttachment struct {
+	Url      string `json:"url"`
+	MimeType string `json:"mime_type"`
+}
+
+type jsonFeedOutItem struct {
+	Id            string                  `json:"id"`
+	Url           string                  `json:"url,omitempty"`
+	Title         string                  `json:"title"`
+	ContentText   string                  `json:"content_text"`
+	Image         string                  `json:"image,omitempty"`
+	DatePublished string                  `json:"date_published"`
+	Authors       []jsonFeedOutAuthor     `json:"authors,omitempty"`
+	Tags          []string                `json:"tags,omitempty"`
+	Attachments   []jsonFeedOutAttachment `json:"attachments,omitempty"`
+}
+
+func renderJSONFeed(self string, aList []sql.Article, updated time.Time) ([]byte, error) {
+	doc := jsonFeedOut{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       conf.AppName,
+		HomePageUrl: conf.PUBLICURL,
+		FeedUrl:     self,
+		Items:       []jsonFeedOutItem{},
+	}
+	for _, a := range aList {
+		item := jsonFeedOutItem{
+			Id:            articleId(a),
+			Url:           a.Link,
+			Title:         a.Title,
+			ContentText:   a.Content,
+			DatePublished: a.Created.Format(time.RFC3339),
+			Tags:          splitList(a.Cat),
+		}
+		if len(a.Author) > 0 || len(a.Email) > 0 {
+			author := jsonFeedOutAuthor{Name: a.Author}
+			if len(a.Email) > 0 {
+				author.Url = "mailto:" + a.Email
+			}
+			item.Authors = []jsonFeedOutAuthor{author}
+		}
+		if img := articleImage(a); len(img) > 0 {
+			item.Image = img
+			item.Attachments = []jsonFeedOutAttachment{{Url: img, MimeType: imageType(img)}}
+		}
+		doc.Items = append(doc.Items, item)
+	}
+	return json.Marshal(doc)
+}
+
+func marshalXML(doc interface{}) ([]byte, error) {
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// A tag uri for the article, it stays the same wherever the feed is served from.
+func articleId(a sql.Article) string {
+	host := "localhost"
+	if u, err := url.Parse(conf.PUBLICURL); err == nil && len(u.Hostname()) > 0 {
+		host = u.Hostname()
+	}
+	return fmt.Sprintf("tag:%s,%s:article/%d", host, a.Created.UTC().Format(dateFormat), a.Uid)
+}
+
+// The image InsertArticle keeps in the detail, if there is one.
+func articleImage(a sql.Article) string {
+	img, _ := a.Detail["img"].(string)
+	return strings.TrimSpace(img)
+}
+
+// The type of an image by its extension, jpeg when that says nothing.
+func imageType(link string) string {
+	if u, err := url.Parse(link); err == nil {
+		if imageType := mime.TypeByExtension(path.Ext(u.Path)); strings.HasPrefix(imageType, "image/") {
+			return imageType
+		}
+	}
+	return "image/jpeg"
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if len(strings.TrimSpace(value)) > 0 {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,252 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/sql"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The feeds served from memory stores, with the rate open so the test is not held up.
+func useFeeds(t *testing.T) (*fasthttp.Client, *sql.MemoryArticles) {
+	t.Helper()
+	store := useMemoryStores(t)
+	rate, burst, newsLimit := conf.APIRATE, conf.APIBURST, conf.NEWSLIMIT
+	t.Cleanup(func() { conf.APIRATE, conf.APIBURST, conf.NEWSLIMIT = rate, burst, newsLimit })
+	conf.APIRATE, conf.APIBURST, conf.NEWSLIMIT = 1000, 1000, 100
+	return serve(t), store
+}
+
+type feedReply struct {
+	status       int
+	contentType  string
+	etag         string
+	lastModified string
+	body         []byte
+}
+
+func getFeed(t *testing.T, client *fasthttp.Client, uri string, headers map[string]string) feedReply {
+	t.Helper()
+	req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://news.test" + uri)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if err := client.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+	return feedReply{
+		status:       resp.StatusCode(),
+		contentType:  string(resp.Header.ContentType()),
+		etag:         string(resp.Header.Peek("ETag")),
+		lastModified: string(resp.Header.Peek("Last-Modified")),
+		body:         append([]byte(nil), resp.Body()...),
+	}
+}
+
+// The titles in a feed, read back in whichever format it is.
+func feedTitles(t *testing.T, path string, body []byte) (titles []string) {
+	t.Helper()
+	switch {
+	case strings.HasPrefix(path, "/feed/rss"):
+		var doc struct {
+			Version string   `xml:"version,attr"`
+			Titles  []string `xml:"channel>item>title"`
+		}
+		if err := xml.Unmarshal(body, &doc); err != nil || doc.Version != "2.0" {
+			t.Fatalf("not RSS 2.0, %v: %s", err, body)
+		}
+		return doc.Titles
+	case strings.HasPrefix(path, "/feed/atom"):
+		var doc struct {
+			XMLName xml.Name
+			Titles  []string `xml:"entry>title"`
+		}
+		if err := xml.Unmarshal(body, &doc); err != nil || doc.XMLName.Space != "http://www.w3.org/2005/Atom" || doc.XMLName.Local != "feed" {
+			t.Fatalf("not Atom, %v: %s", err, body)
+		}
+		return doc.Titles
+	default:
+		var doc struct {
+			Version string `json:"version"`
+			Items   []struct {
+				Title string `json:"title"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(body, &doc); err != nil || doc.Version != "https://jsonfeed.org/version/1.1" {
+			t.Fatalf("not JSON Feed, %v: %s", err, body)
+		}
+		titles = []string{}
+		for _, item := range doc.Items {
+			titles = append(titles, item.Title)
+		}
+		return titles
+	}
+}
+
+var feedPaths = map[string]string{
+	"/feed/rss":  rssType,
+	"/feed/atom": atomType,
+	"/feed/json": jsonFeedType,
+}
+
+func TestFeedFormats(t *testing.T) {
+	client, _ := useFeeds(t)
+	for path, contentType := range feedPaths {
+		reply := getFeed(t, client, path, nil)
+		if reply.status != fasthttp.StatusOK || reply.contentType != contentType {
+			t.Errorf("%s: status %d, type %q, want %q", path, reply.status, reply.contentType, contentType)
+			continue
+		}
+		if titles := feedTitles(t, path, reply.body); len(titles) != len(testArticles) {
+			t.Errorf("%s: titles %q, want all %d articles", path, titles, len(testArticles))
+		}
+	}
+}
+
+func TestFeedParameters(t *testing.T) {
+	client, _ := useFeeds(t)
+	conf.NEWSLIMIT = 3
+	tests := []struct {
+		query  string
+		status int
+		want   []string // The titles, in any order.
+		count  int      // Or only how many of them, when want is nil.
+	}{
+		{query: "topic=sport", status: fasthttp.StatusOK, want: []string{"Cup final"}},
+		{query: "topic=general&cat=business", status: fasthttp.StatusOK, want: []string{"Markets rally"}},
+		{query: "topic=weather", status: fasthttp.StatusOK, want: []string{}},
+		{query: "keywords=rates", status: fasthttp.StatusOK, want: []string{"Rates hold"}},
+		{query: "limit=2", status: fasthttp.StatusOK, count: 2},
+		{query: "topic=general&limit=1", status: fasthttp.StatusOK, count: 1},
+		{query: "limit=0", status: fasthttp.StatusOK, count: 1},
+		{query: "limit=50", status: fasthttp.StatusOK, count: 3}, // Held to NEWSLIMIT.
+		{query: "limit=many", status: fasthttp.StatusBadRequest},
+		{query: "limit=2.5", status: fasthttp.StatusBadRequest},
+	}
+	for path := range feedPaths {
+		for _, test := range tests {
+			reply := getFeed(t, client, path+"?"+test.query, nil)
+			if reply.status != test.status {
+				t.Errorf("%s?%s: status %d, want %d", path, test.query, reply.status, test.status)
+				continue
+			}
+			if test.status != fasthttp.StatusOK {
+				continue
+			}
+			titles := feedTitles(t, path, reply.body)
+			if test.want == nil {
+				if len(titles) != test.count {
+					t.Errorf("%s?%s: %d titles, want %d", path, test.query, len(titles), test.count)
+				}
+			} else if !sameTitles(titles, test.want) {
+				t.Errorf("%s?%s: titles %q, want %q", path, test.query, titles, test.want)
+			}
+		}
+	}
+}
+
+func sameTitles(got []string, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	seen := make(map[string]int)
+	for _, title := range got {
+		seen[title]++
+	}
+	for _, title := range want {
+		if seen[title] == 0 {
+			return false
+		}
+		seen[title]--
+	}
+	return true
+}
+
+func TestFeedNotModified(t *testing.T) {
+	client, store := useFeeds(t)
+	for path := range feedPaths {
+		first := getFeed(t, client, path, nil)
+		if len(first.etag) < 3 || !strings.HasPrefix(first.etag, `"`) || !strings.HasSuffix(first.etag, `"`) {
+			t.Fatalf("%s: etag %q", path, first.etag)
+		}
+		modified, err := time.Parse(time.RFC1123, first.lastModified)
+		if err != nil || time.Since(modified) > time.Minute || time.Until(modified) > 0 {
+			t.Fatalf("%s: last modified %q, %v, want the time of the newest article", path, first.lastModified, err)
+		}
+		if again := getFeed(t, client, path, nil); again.etag != first.etag {
+			t.Errorf("%s: etag %q then %q for the same articles", path, first.etag, again.etag)
+		}
+		earlier := modified.Add(-time.Second).Format(time.RFC1123)
+		tests := []struct {
+			name    string
+			headers map[string]string
+			status  int
+		}{
+			{"etag", map[string]string{"If-None-Match": first.etag}, fasthttp.StatusNotModified},
+			{"weak etag", map[string]string{"If-None-Match": "W/" + first.etag}, fasthttp.StatusNotModified},
+			{"etag in a list", map[string]string{"If-None-Match": `"other", ` + first.etag}, fasthttp.StatusNotModified},
+			{"any etag", map[string]string{"If-None-Match": "*"}, fasthttp.StatusNotModified},
+			{"other etag", map[string]string{"If-None-Match": `"other"`}, fasthttp.StatusOK},
+			{"modified since", map[string]string{"If-Modified-Since": first.lastModified}, fasthttp.StatusNotModified},
+			{"modified since later", map[string]string{"If-Modified-Since": modified.Add(time.Hour).Format(time.RFC1123)}, fasthttp.StatusNotModified},
+			{"modified since earlier", map[string]string{"If-Modified-Since": earlier}, fasthttp.StatusOK},
+			// If-None-Match is the one that counts when both are sent.
+			{"other etag, not modified", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": first.lastModified}, fasthttp.StatusOK},
+			{"etag, modified", map[string]string{"If-None-Match": first.etag, "If-Modified-Since": earlier}, fasthttp.StatusNotModified},
+		}
+		for _, test := range tests {
+			reply := getFeed(t, client, path, test.headers)
+			if reply.status != test.status {
+				t.Errorf("%s %s: status %d, want %d", path, test.name, reply.status, test.status)
+			}
+			if reply.status == fasthttp.StatusNotModified && (len(reply.body) > 0 || reply.etag != first.etag) {
+				t.Errorf("%s %s: 304 with body %q, etag %q", path, test.name, reply.body, reply.etag)
+			}
+		}
+		if reply := getFeed(t, client, path+"?limit=1", map[string]string{"If-None-Match": first.etag}); reply.status != fasthttp.StatusOK {
+			t.Errorf("%s: other parameters took the etag, status %d", path, reply.status)
+		}
+	}
+
+	// A new article, a new etag, and the old one no longer holds.
+	before := make(map[string]string)
+	for path := range feedPaths {
+		before[path] = getFeed(t, client, path, nil).etag
+	}
+	if _, err := store.Insert(context.Background(), sql.Article{Topic: "general", Title: "Breaking", Content: "Just in", Cat: "news"}); err != nil {
+		t.Fatal(err)
+	}
+	for path := range feedPaths {
+		reply := getFeed(t, client, path, map[string]string{"If-None-Match": before[path]})
+		if reply.status != fasthttp.StatusOK || reply.etag == before[path] {
+			t.Errorf("%s: status %d, etag %q after a new article", path, reply.status, reply.etag)
+		}
+	}
+}
+
+func TestFeedEmpty(t *testing.T) {
+	client, _ := useFeeds(t)
+	Articles = sql.NewMemoryArticles()
+	for path := range feedPaths {
+		reply := getFeed(t, client, path, nil)
+		if reply.status != fasthttp.StatusOK {
+			t.Errorf("%s: status %d", path, reply.status)
+			continue
+		}
+		if titles := feedTitles(t, path, reply.body); len(titles) != 0 {
+			t.Errorf("%s: titles %q with no articles", path, titles)
+		}
+		if modified, err := time.Parse(time.RFC1123, reply.lastModified); err != nil || !modified.Equal(time.Unix(0, 0)) {
+			t.Errorf("%s: last modified %q, want the epoch", path, reply.lastModified)
+		}
+	}
+}
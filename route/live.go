@@ -0,0 +1,318 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/lib"
+	"all-news/sql"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+const liveBuffer = 64 // Articles a socket can fall behind by, past it they are missed and counted.
+
+// What a dashboard sends down the socket.
+type liveCommand struct {
+	Action string   `json:"action"` // subscribe, unsubscribe, pause, resume or backfill.
+	Topics []string `json:"topics"`
+	Uid    int64    `json:"uid"` // Backfill the articles after this one.
+	Limit  int      `json:"limit"`
+}
+
+// What the socket sends back, one type to a message.
+type liveMessage struct {
+	Type    string       `json:"type"` // article, ack, dropped or error.
+	Action  string       `json:"action,omitempty"`
+	Topics  []string     `json:"topics,omitempty"`
+	Paused  bool         `json:"paused,omitempty"`
+	Article *sql.Article `json:"article,omitempty"`
+	Count   int          `json:"count,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// One dashboard's socket and what it has asked for.
+type liveSession struct {
+	ws        *lib.WebSocket
+	accessKey string
+	plan      sql.Plan
+	remote    string
+	rctx      context.Context // The request the socket came from, for the store and the log.
+	filter    sql.NewsQuery   // The categories and keywords it connected with.
+	topics    map[string]bool
+	paused    bool
+}
+
+type liveFrame struct {
+	opcode  int
+	payload []byte
+}
+
+/**************************************************************************
+ *      _ _           _____             _
+ *     | (_)         |  __ \           | |
+ *     | |___   _____| |__) |___  _   _| |_ ___  ___
+ *     | | \ \ / / _ \  _  // _ \| | | | __/ _ \/ __|
+ *     | | |\ V /  __/ | \ \ (_) | |_| | ||  __/\__ \
+ *     |_|_| \_/ \___|_|  \_\___/ \__,_|\__\___||___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * A WebSocket for the dashboards, new articles as they are inserted, with
+ * the topics, pausing and backfill under the dashboard's control. It holds
+ * one of the plan's streams, the same as an event stream.
+ * --------------------------------------------------------------------- */
+func liveRoutes(router *fasthttprouter.Router) {
+	router.GET("/api/V1/live", liveHandler)
+}
+
+/***************************************************************************
+ *      _ _           _    _                 _ _
+ *     | (_)         | |  | |               | | |
+ *     | |___   _____| |__| | __ _ _ __   __| | | ___ _ __
+ *     | | \ \ / / _ \  __  |/ _` | '_ \ / _` | |/ _ \ '__|
+ *     | | |\ V /  __/ |  | | (_| | | | | (_| | |  __/ |
+ *     |_|_| \_/ \___|_|  |_|\__,_|_| |_|\__,_|_|\___|_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Upgrades the request once the access key is good, as for the news api,
+ * and the plan has a stream to spare. ?topics= are those subscribed to from
+ * the start, ?categories= and ?keywords= filter every article for the life
+ * of the socket.
+ * ---------------------------------------------------------------------- */
+func liveHandler(ctx *fasthttp.RequestCtx) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			requestLog(ctx).Warn("liveHandler problem:", r)
+		}
+	}()
+	if !limiterAllow(ctx) {
+		ctx.Error("Too many requests", fasthttp.StatusTooManyRequests)
+		return
+	}
+	key := string(ctx.Request.Header.Peek("Sec-WebSocket-Key"))
+	if !bytes.EqualFold(ctx.Request.Header.Peek("Upgrade"), []byte("websocket")) || len(key) == 0 {
+		ctx.Error("WebSocket upgrade required", fasthttp.StatusUpgradeRequired)
+		return
+	}
+	if string(ctx.Request.Header.Peek("Sec-WebSocket-Version")) != "13" {
+		ctx.Error("Unsupported WebSocket version", fasthttp.StatusBadRequest)
+		ctx.Response.Header.Set("Sec-WebSocket-Version", "13") // After the error, which clears the headers.
+		return
+	}
+	accessKey := string(ctx.QueryArgs().Peek("access_key"))
+	plan, ok := authorise(ctx, accessKey)
+	if !ok {
+		return
+	}
+	if !streamAcquire(accessKey, plan) {
+		ctx.Error("Too many streams for the "+plan.Name+" plan", fasthttp.StatusTooManyRequests)
+		return
+	}
+	session := &liveSession{
+		accessKey: accessKey,
+		plan:      plan,
+		remote:    ctx.RemoteIP().String(),
+		rctx:      requestContext(ctx),
+		filter: sql.NewsQuery{
+			Keywords:   splitList(string(ctx.QueryArgs().Peek("keywords"))),
+			Categories: splitList(string(ctx.QueryArgs().Peek("categories"))),
+		},
+		topics: make(map[string]bool),
+	}
+	for _, topic := range splitList(string(ctx.QueryArgs().Peek("topics"))) {
+		session.topics[topic] = true
+	}
+	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+	ctx.Response.Header.Set("Upgrade", "websocket")
+	ctx.Response.Header.Set("Connection", "Upgrade")
+	ctx.Response.Header.Set("Sec-WebSocket-Accept", lib.WebSocketAccept(key))
+	ctx.Hijack(func(conn net.Conn) {
+		defer streamRelease(accessKey)
+		session.ws = lib.NewWebSocket(conn)
+		session.run()
+	})
+}
+
+/****************************************************************************
+ *
+ *
+ *      _ __ _   _ _ __
+ *     | '__| | | | '_ \
+ *     | |  | |_| | | | |
+ *     |_|   \__,_|_| |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Sends the articles the dashboard is subscribed to while it is not paused,
+ * and answers what it sends. A ping goes every STREAMHEARTBEAT seconds and a
+ * dashboard not heard from in three is closed. Articles are missed rather
+ * than let a slow dashboard hold anything up, and it is told how many.
+ * ----------------------------------------------------------------------- */
+func (s *liveSession) run() {
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Log(s.rctx).Error("Live socket:", r, s.remote)
+		}
+		lib.DeferClose(s.ws.Conn)
+	}()
+	lib.Log(s.rctx).Info("Live socket opened:", s.remote, s.plan.Name)
+	sub := sql.SubscribeArticlesDropping(liveBuffer)
+	defer sub.Close()
+	heartBeat := time.Duration(conf.STREAMHEARTBEAT) * time.Second
+	frames := make(chan liveFrame)
+	readErr := make(chan error, 1)
+	quit := make(chan struct{})
+	reading := make(chan struct{})
+	defer func() {
+		// The server takes the conn back once run returns, so the reader has to be off it
+		// first. Closing the hijacked conn does nothing, the one under it ends the read.
+		close(quit)
+		if hijacked, ok := s.ws.Conn.(interface{ UnsafeConn() net.Conn }); ok {
+			lib.DeferClose(hijacked.UnsafeConn())
+		}
+		<-reading
+	}()
+	go func() {
+		defer close(reading)
+		for {
+			_ = s.ws.Conn.SetReadDeadline(time.Now().Add(3 * heartBeat))
+			opcode, payload, err := s.ws.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case frames <- liveFrame{opcode: opcode, payload: payload}:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	ticker := time.NewTicker(heartBeat)
+	defer ticker.Stop()
+	s.send(liveMessage{Type: "ack", Action: "open", Topics: s.topicList()})
+	for {
+		select {
+		case a, open := <-sub.C:
+			if !open {
+				return
+			}
+			if missed := sub.Dropped(); missed > 0 {
+				s.send(liveMessage{Type: "dropped", Count: missed})
+			}
+			if s.paused || !s.topics[a.Topic] || !s.filter.Matches(a) {
+				continue
+			}
+			if s.send(liveMessage{Type: "article", Article: &a}) != nil {
+				return
+			}
+		case frame := <-frames:
+			switch frame.opcode {
+			case lib.WsText:
+				if s.command(frame.payload) != nil {
+					return
+				}
+			case lib.WsPing:
+				if s.ws.WriteMessage(lib.WsPong, frame.payload) != nil {
+					return
+				}
+			case lib.WsClose:
+				_ = s.ws.WriteClose(lib.WsCloseNormal, "")
+				lib.Log(s.rctx).Info("Live socket closed:", s.remote)
+				return
+			case lib.WsBinary:
+				s.send(liveMessage{Type: "error", Error: "Commands are JSON text"})
+			}
+		case err := <-readErr:
+			if err == lib.ErrWsTooBig {
+				_ = s.ws.WriteClose(lib.WsCloseTooBig, err.Error())
+			}
+			lib.Log(s.rctx).Info("Live socket gone:", s.remote, err)
+			return
+		case <-ticker.C:
+			if s.ws.WriteMessage(lib.WsPing, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+// Carries out one command, an error only if the socket is gone.
+func (s *liveSession) command(payload []byte) error {
+	var cmd liveCommand
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return s.send(liveMessage{Type: "error", Error: "Invalid command: " + err.Error()})
+	}
+	switch cmd.Action {
+	case "subscribe":
+		for _, topic := range cmd.Topics {
+			s.topics[topic] = true
+		}
+	case "unsubscribe":
+		for _, topic := range cmd.Topics {
+			delete(s.topics, topic)
+		}
+	case "pause":
+		s.paused = true
+	case "resume":
+		s.paused = false
+	case "backfill":
+		return s.backfill(cmd)
+	default:
+		return s.send(liveMessage{Type: "error", Action: cmd.Action, Error: "Unknown action"})
+	}
+	return s.send(liveMessage{Type: "ack", Action: cmd.Action, Topics: s.topicList(), Paused: s.paused})
+}
+
+// Sends the articles after the uid on the subscribed topics, in the order they came in,
+// up to the plan's max limit. Each backfill counts as an api call.
+func (s *liveSession) backfill(cmd liveCommand) error {
+	if !planAllow(s.accessKey, s.plan) {
+		return s.send(liveMessage{Type: "error", Action: cmd.Action, Error: "Too many requests for the " + s.plan.Name + " plan"})
+	}
+	Accounts.Use(s.accessKey, 1)
+	planRequests.Inc(s.plan.Name)
+	limit := cmd.Limit
+	if limit <= 0 || limit > s.plan.MaxLimit {
+		limit = s.plan.MaxLimit
+	}
+	var aList []sql.Article
+	for _, topic := range s.topicList() {
+		query := s.filter
+		query.Topic, query.AfterUid, query.Sort, query.Limit = topic, cmd.Uid, "uid_asc", limit
+		found, _, err := Articles.List(s.rctx, query)
+		if err != nil {
+			return s.send(liveMessage{Type: "error", Action: cmd.Action, Error: "Unable to backfill"})
+		}
+		aList = append(aList, found...)
+	}
+	sort.Slice(aList, func(i, j int) bool { return aList[i].Uid < aList[j].Uid })
+	if len(aList) > limit {
+		aList = aList[:limit]
+	}
+	for i := range aList {
+		if err := s.send(liveMessage{Type: "article", Action: cmd.Action, Article: &aList[i]}); err != nil {
+			return err
+		}
+	}
+	return s.send(liveMessage{Type: "ack", Action: cmd.Action, Topics: s.topicList(), Paused: s.paused, Count: len(aList)})
+}
+
+func (s *liveSession) send(message liveMessage) error {
+	data, err := json.Marshal(message)
+	if lib.CheckErr(err) {
+		return nil
+	}
+	return s.ws.WriteMessage(lib.WsText, data)
+}
+
+func (s *liveSession) topicList() (topics []string) {
+	for topic := range s.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return
+}
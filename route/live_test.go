@@ -0,0 +1,326 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/lib"
+	"all-news/sql"
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// The client end of a live socket.
+type liveClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// The upgrade request, with the headers given set over the usual ones, or left out when
+// empty. The socket is closed when the test ends.
+func dialLive(t *testing.T, query string, headers map[string]string) (*http.Response, *liveClient) {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	server := &fasthttp.Server{Handler: Handler()}
+	go func() { _ = server.Serve(ln) }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	sent := map[string]string{
+		"Upgrade":               "websocket",
+		"Connection":            "Upgrade",
+		"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+		"Sec-WebSocket-Version": "13",
+	}
+	for name, value := range headers {
+		sent[name] = value
+	}
+	request := "GET /api/V1/live?" + query + " HTTP/1.1\r\nHost: news.test\r\n"
+	for name, value := range sent {
+		if len(value) > 0 {
+			request += name + ": " + value + "\r\n"
+		}
+	}
+	if _, err = conn.Write([]byte(request + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	client := &liveClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	resp, err := http.ReadResponse(client.reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, client
+}
+
+// A socket opened on the topics, past its opening ack.
+func openLive(t *testing.T, topics string) *liveClient {
+	t.Helper()
+	resp, client := dialLive(t, "access_key="+testKey+"&topics="+topics, nil)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if m := client.message(); m.Type != "ack" || m.Action != "open" {
+		t.Fatalf("opened with %+v", m)
+	}
+	return client
+}
+
+// Sends a frame masked, as a client must.
+func (c *liveClient) send(opcode int, payload []byte) {
+	c.t.Helper()
+	frame := []byte{0x80 | byte(opcode)}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, 0x80|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, 0x80|126, byte(length>>8), byte(length))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	mask := []byte{0xA1, 0xB2, 0xC3, 0xD4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// The next frame from the server, which never masks them.
+func (c *liveClient) next(wait time.Duration) (opcode int, payload []byte, err error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(wait))
+	var head [2]byte
+	if _, err = io.ReadFull(c.reader, head[:]); err != nil {
+		return
+	}
+	if head[1]&0x80 != 0 {
+		c.t.Fatalf("masked frame from the server: % x", head)
+	}
+	length := uint64(head[1])
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	return int(head[0] & 0x0F), payload, err
+}
+
+func (c *liveClient) frame() (opcode int, payload []byte) {
+	c.t.Helper()
+	opcode, payload, err := c.next(5 * time.Second)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return
+}
+
+// The next message, past any heartbeat pings.
+func (c *liveClient) message() (m liveMessage) {
+	c.t.Helper()
+	for {
+		opcode, payload := c.frame()
+		if opcode == lib.WsPing {
+			continue
+		}
+		if opcode != lib.WsText {
+			c.t.Fatalf("frame %d %q, want a message", opcode, payload)
+		}
+		if err := json.Unmarshal(payload, &m); err != nil {
+			c.t.Fatalf("message %s: %v", payload, err)
+		}
+		return
+	}
+}
+
+// The live socket on memory stores, with the rate open and no heartbeats to get in the way.
+func useLive(t *testing.T) *sql.MemoryArticles {
+	t.Helper()
+	store := useMemoryStores(t)
+	rate, burst, heartBeat := conf.APIRATE, conf.APIBURST, conf.STREAMHEARTBEAT
+	t.Cleanup(func() { conf.APIRATE, conf.APIBURST, conf.STREAMHEARTBEAT = rate, burst, heartBeat })
+	conf.APIRATE, conf.APIBURST, conf.STREAMHEARTBEAT = 1000, 1000, 3600
+	return store
+}
+
+func openStreams() int {
+	streamLock.Lock()
+	defer streamLock.Unlock()
+	return streams[testKey]
+}
+
+func TestLiveHandshake(t *testing.T) {
+	useLive(t)
+	resp, client := dialLive(t, "access_key="+testKey+"&topics=general,sport", nil)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	// The example of RFC 6455 section 1.3.
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("accept %q", accept)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") || !strings.EqualFold(resp.Header.Get("Connection"), "Upgrade") {
+		t.Errorf("upgraded with %v", resp.Header)
+	}
+	if m := client.message(); m.Type != "ack" || m.Action != "open" || strings.Join(m.Topics, ",") != "general,sport" {
+		t.Errorf("opened with %+v", m)
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		headers map[string]string
+		status  int
+	}{
+		{"no upgrade", "access_key=" + testKey, map[string]string{"Upgrade": ""}, http.StatusUpgradeRequired},
+		{"other upgrade", "access_key=" + testKey, map[string]string{"Upgrade": "h2c"}, http.StatusUpgradeRequired},
+		{"no key", "access_key=" + testKey, map[string]string{"Sec-WebSocket-Key": ""}, http.StatusUpgradeRequired},
+		{"old version", "access_key=" + testKey, map[string]string{"Sec-WebSocket-Version": "8"}, http.StatusBadRequest},
+		{"bad access key", "access_key=guess", nil, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		resp, _ := dialLive(t, test.query, test.headers)
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, resp.StatusCode, test.status)
+		}
+		if test.status == http.StatusBadRequest && resp.Header.Get("Sec-WebSocket-Version") != "13" {
+			t.Errorf("%s: did not say which version it takes", test.name)
+		}
+	}
+	if open := openStreams(); open != 1 {
+		t.Errorf("%d streams held, want only the one upgraded", open)
+	}
+}
+
+func TestLiveControlFrames(t *testing.T) {
+	useLive(t)
+	client := openLive(t, "general")
+	client.send(lib.WsPing, []byte("are you there"))
+	if opcode, payload := client.frame(); opcode != lib.WsPong || string(payload) != "are you there" {
+		t.Errorf("ping answered with %d %q", opcode, payload)
+	}
+	client.send(lib.WsText, []byte(`{"action":"subscribe","topics":["sport"]}`))
+	if m := client.message(); m.Type != "ack" || m.Action != "subscribe" || strings.Join(m.Topics, ",") != "general,sport" {
+		t.Errorf("subscribe answered with %+v", m)
+	}
+	client.send(lib.WsBinary, []byte{1, 2})
+	if m := client.message(); m.Type != "error" {
+		t.Errorf("binary answered with %+v", m)
+	}
+	client.send(lib.WsClose, []byte{0x03, 0xE8})
+	if opcode, payload := client.frame(); opcode != lib.WsClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != lib.WsCloseNormal {
+		t.Errorf("close answered with %d % x", opcode, payload)
+	}
+	if _, _, err := client.next(5 * time.Second); err != io.EOF {
+		t.Errorf("socket left open after the close: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); openStreams() > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the stream was not given back")
+		}
+	}
+
+	// A message past the limit is refused with its own close code.
+	client = openLive(t, "general")
+	client.send(lib.WsText, make([]byte, 70000))
+	if opcode, payload := client.frame(); opcode != lib.WsClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != lib.WsCloseTooBig {
+		t.Errorf("too big answered with %d %q", opcode, payload)
+	}
+}
+
+func TestLiveSlowConsumer(t *testing.T) {
+	store := useLive(t)
+	client := openLive(t, "general")
+
+	// The client reads nothing while they come, the inserts go on regardless.
+	const count = 10 * liveBuffer
+	done := make(chan error)
+	go func() {
+		for i := 0; i < count; i++ {
+			if _, err := store.Insert(context.Background(), sql.Article{Topic: "general", Title: "Filler " + strconv.Itoa(i)}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("inserting was held up by a socket that is not read")
+	}
+
+	// Told of the ones missed once it reads again. When it has caught up one more has room,
+	// and every article before it was either sent or counted.
+	articles, dropped, last := 0, 0, int64(0)
+	tally := func(payload []byte) {
+		var m liveMessage
+		if err := json.Unmarshal(payload, &m); err != nil {
+			t.Fatalf("message %s: %v", payload, err)
+		}
+		switch m.Type {
+		case "dropped":
+			dropped += m.Count
+		case "article":
+			articles++
+			if m.Article.Uid == last {
+				last = 0
+			}
+		default:
+			t.Fatalf("message %+v", m)
+		}
+	}
+	for {
+		_, payload, err := client.next(200 * time.Millisecond)
+		if err != nil {
+			if timeout, ok := err.(interface{ Timeout() bool }); !ok || !timeout.Timeout() {
+				t.Fatal(err)
+			}
+			break // Caught up.
+		}
+		tally(payload)
+	}
+	if dropped == 0 {
+		t.Fatalf("%d sent, nothing dropped", articles)
+	}
+	last, err := store.Insert(context.Background(), sql.Article{Topic: "general", Title: "Last"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for last > 0 {
+		_, payload := client.frame()
+		tally(payload)
+	}
+	if articles+dropped != count+1 {
+		t.Errorf("%d sent and %d dropped, want %d between them", articles, dropped, count+1)
+	}
+}
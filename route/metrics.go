@@ -0,0 +1,132 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/lib"
+	"bytes"
+	"crypto/subtle"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	routeKey       = "route" // The user value a request's route label is kept under, if it is set by hand.
+	routeUnmatched = "unmatched"
+)
+
+var (
+	httpRequests = lib.NewCounter("news_http_requests_total", "Requests answered, by route, method and status.", "route", "method", "status")
+	httpDuration = lib.NewHistogram("news_http_request_duration_seconds", "Time to answer a request, by route and status. A stream is timed to its start.", nil, "route", "status")
+	rateLimited  = lib.NewCounter("news_rate_limited_total", "Requests refused with a 429, by the limiter that refused them.", "limiter")
+	planRequests = lib.NewCounter("news_plan_requests_total", "Api calls counted against the accounts, by plan.", "plan")
+)
+
+/*************************************************************************
+ *                     _        _          _____             _
+ *                    | |      (_)        |  __ \           | |
+ *      _ __ ___   ___| |_ _ __ _  ___ ___| |__) |___  _   _| |_ ___  ___
+ *     | '_ ` _ \ / _ \ __| '__| |/ __/ __|  _  // _ \| | | | __/ _ \/ __|
+ *     | | | | | |  __/ |_| |  | | (__\__ \ | \ \ (_) | |_| | ||  __/\__ \
+ *     |_| |_| |_|\___|\__|_|  |_|\___|___/_|  \_\___/ \__,_|\__\___||___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * GET /metrics, the Prometheus text format for a scraper. With
+ * METRICSTOKEN set it has to come as the bearer token, without it the
+ * metrics are open, so the port should not be.
+ * -------------------------------------------------------------------- */
+func metricsRoutes(router *fasthttprouter.Router) {
+	router.GET("/metrics", metricsHandler)
+	router.NotFound = unmatched(fasthttp.StatusNotFound)
+	router.MethodNotAllowed = unmatched(fasthttp.StatusMethodNotAllowed)
+}
+
+func metricsHandler(ctx *fasthttp.RequestCtx) {
+	if len(conf.METRICSTOKEN) > 0 {
+		auth := ctx.Request.Header.Peek("Authorization")
+		if subtle.ConstantTimeCompare(auth, []byte("Bearer "+conf.METRICSTOKEN)) != 1 {
+			ctx.Error("Metrics token is invalid", fasthttp.StatusUnauthorized)
+			return
+		}
+	}
+	var body bytes.Buffer
+	if lib.CheckErr(lib.WriteMetrics(&body)) {
+		ctx.Error("Unable to write the metrics", fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("text/plain; version=0.0.4; charset=utf-8")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(body.Bytes())
+}
+
+/*********************************************************************
+ *               _ _   _     __  __      _        _
+ *              (_) | | |   |  \/  |    | |      (_)
+ *     __      ___| |_| |__ | \  / | ___| |_ _ __ _  ___ ___
+ *     \ \ /\ / / | __| '_ \| |\/| |/ _ \ __| '__| |/ __/ __|
+ *      \ V  V /| | |_| | | | |  | |  __/ |_| |  | | (__\__ \
+ *       \_/\_/ |_|\__|_| |_|_|  |_|\___|\__|_|  |_|\___|___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Counts and times every request by the route it matched, the pattern
+ * and not the path, so the ids in paths do not make a series each.
+ * ---------------------------------------------------------------- */
+func withMetrics(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		next(ctx)
+		route, status := routeLabel(ctx), strconv.Itoa(ctx.Response.StatusCode())
+		httpRequests.Inc(route, methodLabel(ctx), status)
+		httpDuration.Since(start, route, status)
+	}
+}
+
+// What the router answers with when no route matches, marked so the path is not the label.
+func unmatched(status int) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetUserValue(routeKey, routeUnmatched)
+		ctx.Error(fasthttp.StatusMessage(status), status)
+	}
+}
+
+// The route pattern put back from the path, each parameter the router found in it
+// swapped for its :name, a catch all for its *name.
+func routeLabel(ctx *fasthttp.RequestCtx) string {
+	if label, ok := ctx.UserValue(routeKey).(string); ok {
+		return label
+	}
+	switch ctx.Response.StatusCode() {
+	case fasthttp.StatusMovedPermanently, fasthttp.StatusTemporaryRedirect, fasthttp.StatusPermanentRedirect:
+		return routeUnmatched // The router's trailing slash fixes, the handlers never redirect.
+	}
+	path := string(ctx.Path())
+	params := make(map[string]string)
+	ctx.VisitUserValues(func(key []byte, value interface{}) {
+		if v, ok := value.(string); ok && len(v) > 0 && string(key) != requestIdKey {
+			params[v] = string(key)
+		}
+	})
+	for value, name := range params {
+		if strings.HasPrefix(value, "/") && strings.HasSuffix(path, value) {
+			path = strings.TrimSuffix(path, value) + "/*" + name
+			delete(params, value)
+		}
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if name, ok := params[segment]; ok {
+			segments[i] = ":" + name
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// The method, anything the api does not answer to counted together.
+func methodLabel(ctx *fasthttp.RequestCtx) string {
+	switch method := string(ctx.Method()); method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS":
+		return method
+	}
+	return "other"
+}
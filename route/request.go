@@ -0,0 +1,66 @@
+package route
+
+import (
+	"all-news/lib"
+	"context"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	requestIdHeader = "X-Request-ID"
+	requestIdKey    = "requestId" // The user value the id is kept under.
+	maxRequestId    = 128
+)
+
+/****************************************************************************
+ *               _ _   _     _____                            _   _____    _
+ *              (_) | | |   |  __ \                          | | |_   _|  | |
+ *     __      ___| |_| |__ | |__) |___  __ _ _   _  ___  ___| |_  | |  __| |
+ *     \ \ /\ / / | __| '_ \|  _  // _ \/ _` | | | |/ _ \/ __| __| | | / _` |
+ *      \ V  V /| | |_| | | | | \ \  __/ (_| | |_| |  __/\__ \ |_ _| || (_| |
+ *       \_/\_/ |_|\__|_| |_|_|  \_\___|\__, |\__,_|\___||___/\__|_____\__,_|
+ *                                         | |
+ *                                         |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Gives every request an id, the X-Request-ID it came with if that is a
+ * sensible one, and sends it back in the reply. The handlers hand it down to
+ * the store in requestContext and log with requestLog, so the lines from one
+ * request can be tied together.
+ * ----------------------------------------------------------------------- */
+func withRequestId(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		id := string(ctx.Request.Header.Peek(requestIdHeader))
+		if !validRequestId(id) {
+			id = lib.NewRequestId()
+		}
+		ctx.SetUserValue(requestIdKey, id)
+		next(ctx)
+		ctx.Response.Header.Set(requestIdHeader, id) // After, ctx.Error resets the headers.
+	}
+}
+
+// Printable ascii without spaces, so it cannot break a log line or a header.
+func validRequestId(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestId {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// The context for the store calls of a request, carrying its id. It lives on past the
+// request, unlike the fasthttp ctx, so the stream writers can keep it.
+func requestContext(ctx *fasthttp.RequestCtx) context.Context {
+	id, _ := ctx.UserValue(requestIdKey).(string)
+	return lib.WithRequestId(context.Background(), id)
+}
+
+// The logger for a request, its id on every line.
+func requestLog(ctx *fasthttp.RequestCtx) lib.Logger {
+	return lib.Log(requestContext(ctx))
+}
@@ -0,0 +1,74 @@
+package route
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+var generatedId = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Runs one request through the handler with the X-Request-ID, if any, and gives the id replied with.
+func callWithId(handler fasthttp.RequestHandler, uri string, id string) (status int, replied string) {
+	var req fasthttp.Request
+	req.SetRequestURI(uri)
+	if len(id) > 0 {
+		req.Header.Set(requestIdHeader, id)
+	}
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, nil, nil)
+	handler(&ctx)
+	return ctx.Response.StatusCode(), string(ctx.Response.Header.Peek(requestIdHeader))
+}
+
+func TestRequestIdEchoed(t *testing.T) {
+	useMemoryStores(t)
+	capture := captureLogs(t)
+	handler := withRequestId(newsHandler)
+	status, replied := callWithId(handler, "/api/V1?access_key="+testKey+"&keywords=markets", "client-id-42")
+	if status != fasthttp.StatusOK || replied != "client-id-42" {
+		t.Fatalf("status %d, id %q, want the client's id back", status, replied)
+	}
+	capture.lock.Lock()
+	defer capture.lock.Unlock()
+	found := false
+	for _, line := range capture.lines {
+		if strings.Contains(line, "Account:") {
+			found = true
+			if !strings.Contains(line, "client-id-42") {
+				t.Errorf("logged without the request id: %s", line)
+			}
+		}
+	}
+	if !found {
+		t.Error("the request logged nothing")
+	}
+}
+
+func TestRequestIdReplaced(t *testing.T) {
+	useMemoryStores(t)
+	handler := withRequestId(newsHandler)
+	for name, id := range map[string]string{
+		"missing":     "",
+		"space":       "two words",
+		"newline":     "line\nbreak",
+		"not ascii":   "idé",
+		"oversized":   strings.Repeat("a", maxRequestId+1),
+		"header bait": "id\r\nSet-Cookie: x=1",
+	} {
+		_, replied := callWithId(handler, "/api/V1?access_key="+testKey, id)
+		if !generatedId.MatchString(replied) {
+			t.Errorf("%s: replied with %q, want a generated id", name, replied)
+		}
+	}
+	if _, replied := callWithId(handler, "/api/V1?access_key="+testKey, strings.Repeat("a", maxRequestId)); replied != strings.Repeat("a", maxRequestId) {
+		t.Errorf("an id of the most length allowed was replaced with %q", replied)
+	}
+	if _, first := callWithId(handler, "/api/V1", ""); first == "" {
+		t.Error("no id on a refused request")
+	} else if _, second := callWithId(handler, "/api/V1", ""); first == second {
+		t.Errorf("two requests were given the same id %q", first)
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"all-news/conf"
 	"all-news/lib"
 	"all-news/sql"
+	"context"
 	"fmt"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/buaazp/fasthttprouter"
@@ -13,8 +17,17 @@ import (
 	"golang.org/x/time/rate"
 )
 
+const (
+	dateFormat   = "2006-01-02"
+	defaultLimit = 25
+	limiterKey   = "limiter" // The user value the handler's limiter is kept under.
+)
+
 var (
-	limiter *rate.Limiter
+	planLimiterLock sync.Mutex
+	planLimiters                     = make(map[string]planLimiter)
+	Articles        sql.ArticleStore = sql.MysqlArticles{} // Swap for sql.NewMemoryArticles() to run without a database.
+	Accounts        sql.AccountStore = sql.MysqlAccounts{}
 )
 
 /***************************************
@@ -26,24 +39,51 @@ var (
  *     |_____|_| |_|_|\__| |  | |
  *                        \_\/_/   *  */
 func Init() {
-	limiter = rate.NewLimiter(rate.Limit(2), 5) //TODO add these values to the config
-	router := fasthttprouter.New()
 	server := &fasthttp.Server{
 		Name:               "MyStaticServer",
-		Handler:            router.Handler,
+		Handler:            Handler(),
 		ReadTimeout:        5 * time.Second,
 		WriteTimeout:       10 * time.Second,
 		IdleTimeout:        30 * time.Second,
 		MaxRequestBodySize: 1 * 1024 * 1024, // 1 MB
 	}
 
+	lib.Debug("Connection:", ":"+conf.PORT)
+	lib.CheckErr(server.ListenAndServe(":" + conf.PORT)) //This holds live.
+	panic("Abnormal exit:" + "Possible Port conflict?" + conf.PORT)
+}
+
+/****************************************************************************
+ *      _    _                 _ _
+ *     | |  | |               | | |
+ *     | |__| | __ _ _ __   __| | | ___ _ __
+ *     |  __  |/ _` | '_ \ / _` | |/ _ \ '__|
+ *     | |  | | (_| | | | | (_| | |  __/ |
+ *     |_|  |_|\__,_|_| |_|\__,_|_|\___|_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Builds the router, kept apart from Init so the handlers can be served from
+ * any listener, with the stores swapped for the memory versions if need be.
+ * Each handler built has a limiter of its own, APIRATE calls a second.
+ * ----------------------------------------------------------------------- */
+func Handler() fasthttp.RequestHandler {
+	limiter := rate.NewLimiter(rate.Limit(conf.APIRATE), conf.APIBURST)
+	router := fasthttprouter.New()
+
 	router.GET("/", webserver)                       // Gets the next Article, using IP as a control
 	router.ServeFiles("/static/*filepath", "static") // Gets the next Article, using IP as a control
 	router.GET("/api/V1", newsHandler)               // Gets the next Article, using IP as a control
+	accountRoutes(router)
+	signupRoutes(router)
+	adminRoutes(router)
+	feedRoutes(router)
+	discordRoutes(router)
+	webhookRoutes(router)
+	targetRoutes(router)
+	streamRoutes(router)
+	liveRoutes(router)
+	metricsRoutes(router)
 
-	lib.Debug("Connection:", ":"+conf.PORT)
-	lib.CheckErr(server.ListenAndServe(":" + conf.PORT)) //This holds live.
-	panic("Abnormal exit:" + "Possible Port conflict?" + conf.PORT)
+	return withRequestId(withLimiter(limiter, withMetrics(router.Handler)))
 }
 
 /**********************_*********************************************
@@ -53,7 +93,7 @@ func Init() {
  *      \ V  V /|  __/| |_) |\__ \|  __/| |    \ V /|  __/| |
  *       \_/\_/  \___||_.__/ |___/ \___||_|     \_/  \___||_|      */
 func webserver(ctx *fasthttp.RequestCtx) {
-	if !limiter.Allow() { // return a 429 Too Many Requests error if the limit is exceeded
+	if !limiterAllow(ctx) { // return a 429 Too Many Requests error if the limit is exceeded
 		ctx.Error("Too Many Requests", fasthttp.StatusTooManyRequests)
 		return
 	}
@@ -79,28 +119,16 @@ func newsHandler(ctx *fasthttp.RequestCtx) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Warn("newsHandler problem:", r)
+			requestLog(ctx).Warn("newsHandler problem:", r)
 		}
 	}()
-	if !limiter.Allow() {
+	if !limiterAllow(ctx) {
 		ctx.Error("Too many requests", fasthttp.StatusTooManyRequests)
 		return
 	}
-	//TO-DO add count for the key used, as this is 1 request.
 	accessKey := string(ctx.QueryArgs().Peek("access_key"))
-	if account, ok := sql.Accounts[accessKey]; ok {
-		if account.Used > account.Allocated {
-			ctx.Error("Usage Limit Reached", fasthttp.StatusUnauthorized)
-			return
-		} else if account.End.Before(time.Now()) {
-			ctx.Error("Account period expired", fasthttp.StatusUnauthorized)
-			return
-		} else { // All good, update values.
-			account.Used++
-			sql.Accounts[accessKey] = account
-		}
-	} else {
-		ctx.Error("Access key is invalid", fasthttp.StatusUnauthorized)
+	plan, ok := authorise(ctx, accessKey)
+	if !ok {
 		return
 	}
 
@@ -112,11 +140,176 @@ func newsHandler(ctx *fasthttp.RequestCtx) {
 	offset := string(ctx.QueryArgs().Peek("offset"))
 	sort := string(ctx.QueryArgs().Peek("sort"))
 
-	lib.Debug("Query:", accessKey, ":", keywords, ":", date, ":", categories, ":", sources, ":", limit, ":", offset, ":", sort, ";")
-	// TODO: Handle the actual news API request here
-	// using the accessKey, keywords, and countries values
+	requestLog(ctx).Debug("Query:", keywords, ":", date, ":", categories, ":", sources, ":", limit, ":", offset, ":", sort, ";")
 
-	ctx.SetStatusCode(fasthttp.StatusOK)
+	query := sql.NewsQuery{
+		Keywords:   splitList(keywords),
+		Categories: splitList(categories),
+		Sources:    splitList(sources),
+		Sort:       sort,
+		Limit:      defaultLimit,
+		Offset:     0,
+	}
+	if len(query.Sort) == 0 {
+		query.Sort = "published_desc"
+	}
+	if len(limit) > 0 {
+		value, err := setLimit(limit)
+		if err != nil {
+			response(ctx, conf.Reply{Code: fasthttp.StatusBadRequest, Msg: "Invalid limit: " + limit})
+			return
+		}
+		query.Limit = value
+	}
+	if query.Limit > plan.MaxLimit {
+		query.Limit = plan.MaxLimit
+	}
+	if len(offset) > 0 {
+		value, err := strconv.Atoi(offset)
+		if err != nil || value < 0 {
+			response(ctx, conf.Reply{Code: fasthttp.StatusBadRequest, Msg: "Invalid offset: " + offset})
+			return
+		}
+		query.Offset = value
+	}
+	if len(date) > 0 {
+		from, to, err := parseDateRange(date)
+		if err != nil {
+			response(ctx, conf.Reply{Code: fasthttp.StatusBadRequest, Msg: "Invalid date: " + date})
+			return
+		}
+		query.From, query.To = from, to
+	}
+
+	ctx.SetContentType("application/json; charset=utf-8")
+	if _, ok := sql.NewsSorts[query.Sort]; !ok {
+		response(ctx, conf.Reply{Code: fasthttp.StatusBadRequest, Msg: "Invalid sort: " + query.Sort})
+		return
+	}
+	aList, total, err := Articles.List(requestContext(ctx), query)
+	response(ctx, sql.NewsReply(query, aList, total, err))
+}
+
+/*******************************************************************************
+ *                  _   _                _
+ *                 | | | |              (_)
+ *       __ _ _   _| |_| |__   ___  _ __ _ ___  ___
+ *      / _` | | | | __| '_ \ / _ \| '__| / __|/ _ \
+ *     | (_| | |_| | |_| | | | (_) | |  | \__ \  __/
+ *      \__,_|\__,_|\__|_| |_|\___/|_|  |_|___/\___|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Checks the access key of an api call against its account and plan, and counts
+ * the call. Replies with the error and returns false if the call is refused.
+ * -------------------------------------------------------------------------- */
+func authorise(ctx *fasthttp.RequestCtx, accessKey string) (plan sql.Plan, ok bool) {
+	account, found := Accounts.Get(accessKey) // By the hash of the key, so only the whole key finds it.
+	if !found {
+		ctx.Error("Access key is invalid", fasthttp.StatusUnauthorized)
+		return
+	}
+	plan = sql.PlanFor(account.Plan)
+	if account.Used >= account.Allocated {
+		ctx.Error("Usage Limit Reached", fasthttp.StatusUnauthorized)
+		return
+	} else if account.End.Before(time.Now()) {
+		ctx.Error("Account period expired", fasthttp.StatusUnauthorized)
+		return
+	} else if !planAllow(accessKey, plan) {
+		ctx.Error("Too many requests for the "+plan.Name+" plan", fasthttp.StatusTooManyRequests)
+		return
+	}
+	Accounts.Use(accessKey, 1) // All good, count the request.
+	planRequests.Inc(plan.Name)
+	requestLog(ctx).Debug("Account:", account.Urn, plan.Name) // The urn, never the key, goes in the logs.
+	return plan, true
+}
+
+// Hands the handler's limiter to the routes through the request.
+func withLimiter(limiter *rate.Limiter, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetUserValue(limiterKey, limiter)
+		next(ctx)
+	}
+}
+
+// The limiter every api call goes through first, a refusal counted. A route called
+// without one, straight rather than through Handler, is not limited.
+func limiterAllow(ctx *fasthttp.RequestCtx) bool {
+	limiter, ok := ctx.UserValue(limiterKey).(*rate.Limiter)
+	if !ok || limiter.Allow() {
+		return true
+	}
+	rateLimited.Inc("global")
+	return false
+}
+
+// Per second limiter for each access key, rebuilt if the account changes plan.
+type planLimiter struct {
+	plan    string
+	limiter *rate.Limiter
+}
+
+func planAllow(accessKey string, plan sql.Plan) bool {
+	planLimiterLock.Lock()
+	pl, ok := planLimiters[accessKey]
+	if !ok || pl.plan != plan.Name {
+		pl = planLimiter{plan: plan.Name, limiter: rate.NewLimiter(rate.Limit(plan.Rate), plan.Burst)}
+		planLimiters[accessKey] = pl
+	}
+	planLimiterLock.Unlock()
+	if pl.limiter.Allow() {
+		return true
+	}
+	rateLimited.Inc("plan")
+	return false
+}
+
+/***********************************************************************************
+ *                                _____        _       _____
+ *                               |  __ \      | |     |  __ \
+ *      _ __   __ _ _ __ ___  ___| |  | | __ _| |_ ___| |__) |__ _ _ __   __ _  ___
+ *     | '_ \ / _` | '__/ __|/ _ \ |  | |/ _` | __/ _ \  _  // _` | '_ \ / _` |/ _ \
+ *     | |_) | (_| | |  \__ \  __/ |__| | (_| | ||  __/ | \ \ (_| | | | | (_| |  __/
+ *     | .__/ \__,_|_|  |___/\___|_____/ \__,_|\__\___|_|  \_\__,_|_| |_|\__, |\___|
+ *     | |                                                                __/ |
+ *     |_|                                                               |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Turns the date parameter into a from/to range, a single date covers that
+ * whole day and a comma pair covers both days inclusive.
+ * ------------------------------------------------------------------------------ */
+func parseDateRange(date string) (from time.Time, to time.Time, err error) {
+	parts := strings.Split(date, ",")
+	if len(parts) > 2 {
+		err = fmt.Errorf("too many dates in %q", date)
+		return
+	}
+	from, err = time.Parse(dateFormat, strings.TrimSpace(parts[0]))
+	if err != nil {
+		return
+	}
+	to = from
+	if len(parts) == 2 {
+		to, err = time.Parse(dateFormat, strings.TrimSpace(parts[1]))
+		if err != nil {
+			return
+		}
+	}
+	if to.Before(from) {
+		from, to = to, from
+	}
+	to = to.AddDate(0, 0, 1) // The range is inclusive of the last day.
+	return
+}
+
+// Split a comma list parameter, dropping empty entries.
+func splitList(value string) (list []string) {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) > 0 {
+			list = append(list, entry)
+		}
+	}
+	return
 }
 
 // -----------------------------------------------------------------------------
@@ -135,9 +328,17 @@ func requestDefault(ctx *fasthttp.RequestCtx, key string, sub string, kid string
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Error("Request Handler Failed:", r, key, sub, kid)
+			requestLog(ctx).Error("Request Handler Failed:", r, key, sub, kid)
 		}
 	}()
+	limitOf := func(value string) (limit int, ok bool) { // Replies with a 400 if the limit is not a number.
+		limit, err := setLimit(value)
+		if err != nil {
+			response(ctx, conf.Reply{Code: fasthttp.StatusBadRequest, Msg: "Invalid limit: " + value})
+			return 0, false
+		}
+		return limit, true
+	}
 	if lib.ThrottleAllow(ctx.RemoteIP().String(), conf.THROTTLE) {
 		switch key {
 		case "test", "help":
@@ -154,52 +355,86 @@ func requestDefault(ctx *fasthttp.RequestCtx, key string, sub string, kid string
 			_, _ = fmt.Fprintf(ctx, "Body is %q\n\n", ctx.PostBody())
 
 		case "last": // Just return the last article
-			lib.Info("Getting Last for :", sub)
-			response(ctx, sql.GetLastJsonByFilter(setLimit(sub), kid, "general"))
+			requestLog(ctx).Info("Getting Last for :", sub)
+			if limit, ok := limitOf(sub); ok {
+				response(ctx, sql.ArticlesReply(Articles.Last(requestContext(ctx), limit, splitList(kid), "general")))
+			}
 
 		case "fetch": // Get article based on its UID
-			lib.Info("Getting Article # :", sub)
-			_, err := strconv.Atoi(sub)
+			requestLog(ctx).Info("Getting Article # :", sub)
+			uid, err := strconv.ParseInt(sub, 10, 64)
 			if lib.CheckErr(err) { // No articles ID
 				_, _ = fmt.Fprintf(ctx, "Missing Article ID")
 			} else {
-				response(ctx, sql.GetJsonByArticleId(sub))
+				a, err := Articles.Get(requestContext(ctx), uid)
+				response(ctx, sql.ArticlesReply([]sql.Article{a}, err))
 			}
 		case "find", "tags": // Get limit number of items based on search
-			lib.Info("Find by string :", sub)
-			response(ctx, sql.GetJsonByTitle(sub, setLimit(kid), "general"))
+			requestLog(ctx).Info("Find by string :", sub)
+			if limit, ok := limitOf(kid); ok {
+				response(ctx, searchReply(requestContext(ctx), "title", sub, limit))
+			}
 
 		case "content": // Get limit number of items based on tags
-			lib.Info("Find by string :", sub)
-			response(ctx, sql.GetJsonByContent(sub, setLimit(kid), "general"))
+			requestLog(ctx).Info("Find by string :", sub)
+			if limit, ok := limitOf(kid); ok {
+				response(ctx, searchReply(requestContext(ctx), "content", sub, limit))
+			}
 
 		case "next": // Get limit number of items based on tags
-			lib.Info("Doing Next :", key, sub, kid, subkid)
-			response(ctx, sql.GetNextJsonByArticleId(sub, setLimit(kid), subkid, "general"))
+			requestLog(ctx).Info("Doing Next :", key, sub, kid, subkid)
+			if limit, ok := limitOf(kid); ok {
+				response(ctx, stepReply(requestContext(ctx), sub, limit, subkid, true))
+			}
 
 		case "prev": // Get limit number of items based on tags
-			lib.Info("Doing Previous with String :", sub, kid, subkid)
-			response(ctx, sql.GetPrevJsonByArticleId(sub, setLimit(kid), subkid, "general"))
+			requestLog(ctx).Info("Doing Previous with String :", sub, kid, subkid)
+			if limit, ok := limitOf(kid); ok {
+				response(ctx, stepReply(requestContext(ctx), sub, limit, subkid, false))
+			}
 
 		default: // If the key is not a function, then its a article number so get the next one.
-			lib.Info("Doing Defaults :", key, sub, kid)
-			response(ctx, sql.GetNextJsonByArticleId(key, setLimit(sub), kid, "general"))
+			requestLog(ctx).Info("Doing Defaults :", key, sub, kid)
+			if limit, ok := limitOf(sub); ok {
+				response(ctx, stepReply(requestContext(ctx), key, limit, kid, true))
+			}
 		}
 	} else {
 		response(ctx, conf.Reply{Code: 400, Msg: "Too Frequent"})
 	}
 }
 
+// Search the title or content of the general articles for any of the words.
+func searchReply(rctx context.Context, column string, search string, limit int) conf.Reply {
+	safeStr, err := url.QueryUnescape(search)
+	if lib.CheckErr(err) {
+		return conf.Reply{Code: 400, Msg: "Unable to decode the search string"}
+	}
+	return sql.ArticlesReply(Articles.Search(rctx, column, strings.Fields(safeStr), limit, "general"))
+}
+
+// Step forwards or backwards from an article uid, optionally filtering the content.
+func stepReply(rctx context.Context, articleId string, limit int, filter string, next bool) conf.Reply {
+	uid, err := strconv.ParseInt(articleId, 10, 64)
+	if lib.CheckErr(err) {
+		return conf.Reply{Code: 400, Msg: "Invalid Article ID"}
+	}
+	if next {
+		return sql.ArticlesReply(Articles.Next(rctx, uid, limit, splitList(filter), "general"))
+	}
+	return sql.ArticlesReply(Articles.Prev(rctx, uid, limit, splitList(filter), "general"))
+}
+
 // Use the caller IP as the Article control
 func requestFetchIp(ctx *fasthttp.RequestCtx) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Warn("No article count:", r)
+			requestLog(ctx).Warn("No article count:", r)
 		}
 	}()
 	idStr := ctx.RemoteIP()
-	response(ctx, sql.GetLatestJsonArticle(idStr.String(), 10, true, "API", "general"))
+	response(ctx, sql.GetLatestJsonArticle(requestContext(ctx), idStr.String(), 10, true, "API", "general"))
 }
 
 /****************************************************
@@ -210,24 +445,24 @@ func requestFetchIp(ctx *fasthttp.RequestCtx) {
  *     \__ \  __/ |_| |____| | | | | | | | |_
  *     |___/\___|\__|______|_|_| |_| |_|_|\__|
  * --------------------------------------------------
- * Sets the limit to that of the config if it is above
+ * Reads a limit, held to 1..NEWSLIMIT. An empty one is
+ * 1, anything else that is not a number is an error.
  * * * * * * * * * * * * * * * * * * * * * * * * * */
-func setLimit(value string) (limit int) {
-	defer func() {
-		r := recover()
-		if r != nil {
-			lib.Warn("Limit set error:", r)
-		}
-	}()
-	limit, err := strconv.Atoi(value)
-	if lib.CheckErr(err) {
+func setLimit(value string) (limit int, err error) {
+	if len(value) == 0 {
+		return 1, nil
+	}
+	limit, err = strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid limit: %s", value)
+	}
+	if limit < 1 {
 		limit = 1
-	} // If there is an error, then set the default to 1
+	}
 	if limit > conf.NEWSLIMIT {
 		limit = conf.NEWSLIMIT
 	}
-
-	return limit
+	return limit, nil
 }
 
 /*****************************************************
@@ -244,7 +479,7 @@ func response(ctx *fasthttp.RequestCtx, reply conf.Reply) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Error("Responding to request:", r)
+			requestLog(ctx).Error("Responding to request:", r)
 		}
 	}()
 
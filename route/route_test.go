@@ -0,0 +1,332 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/lib"
+	"all-news/sql"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+const testKey = "test-access-key"
+
+// Strings that would change the meaning of a query built by pasting them in.
+var injections = []string{
+	`' OR '1'='1`,
+	`'; DROP TABLE articles; --`,
+	`" OR ""="`,
+	`%' OR title LIKE '%`,
+	`1 UNION SELECT * FROM accounts`,
+	`\' OR 1=1 #`,
+	`%`,
+	`_`,
+	`.*`,
+	`(a|b)`,
+}
+
+var testArticles = []sql.Article{
+	{Topic: "general", Title: "Markets rally", Content: "Stocks rose across the board", Cat: "business", Author: "desk"},
+	{Topic: "general", Title: "Rates hold", Content: "The bank held rates", Cat: "economy", Author: "desk"},
+	{Topic: "general", Title: "Quoting ' OR '1'='1 in a headline", Content: "A story about ' OR '1'='1 itself", Cat: "tech", Author: "desk"},
+	{Topic: "sport", Title: "Cup final", Content: "A late goal", Cat: "sport", Author: "desk"},
+}
+
+// Memory stores for the handlers, put back as they were when the test ends.
+func useMemoryStores(t *testing.T) *sql.MemoryArticles {
+	t.Helper()
+	articles, accounts, throttle := Articles, Accounts, conf.THROTTLE
+	t.Cleanup(func() {
+		Articles, Accounts, conf.THROTTLE = articles, accounts, throttle
+	})
+	store := sql.NewMemoryArticles(testArticles...)
+	Articles = store
+	Accounts = sql.NewMemoryAccounts(map[string]sql.AccountsStruct{
+		testKey: {Plan: "PRO", Allocated: 1000000, End: time.Now().Add(time.Hour)},
+	})
+	conf.THROTTLE = 0
+	planLimiterLock.Lock()
+	planLimiters = make(map[string]planLimiter) // A fresh burst for every test.
+	planLimiterLock.Unlock()
+	return store
+}
+
+// Runs one request through the handler, without a listener.
+func call(handler fasthttp.RequestHandler, uri string) (status int, body []byte) {
+	var req fasthttp.Request
+	req.SetRequestURI(uri)
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, nil, nil)
+	handler(&ctx)
+	return ctx.Response.StatusCode(), ctx.Response.Body()
+}
+
+// The articles in a reply, either the news envelope or a plain list.
+func replyArticles(t *testing.T, body []byte) []sql.Article {
+	t.Helper()
+	var page sql.NewsPage
+	if err := json.Unmarshal(body, &page); err == nil && page.Data != nil {
+		return page.Data
+	}
+	var aList []sql.Article
+	if err := json.Unmarshal(body, &aList); err != nil {
+		t.Fatalf("reply is not articles: %v: %s", err, body)
+	}
+	return aList
+}
+
+func TestNewsHandlerInjection(t *testing.T) {
+	store := useMemoryStores(t)
+	for _, payload := range injections {
+		for _, param := range []string{"keywords", "categories", "sources"} {
+			uri := "/api/V1?access_key=" + testKey + "&" + param + "=" + url.QueryEscape(payload)
+			status, body := call(newsHandler, uri)
+			if status != fasthttp.StatusOK {
+				t.Fatalf("%s=%q: status %d: %s", param, payload, status, body)
+			}
+			for _, a := range replyArticles(t, body) {
+				field := a.Title + " " + a.Content
+				if param == "categories" {
+					field = a.Cat
+				} else if param == "sources" {
+					field = a.Author + " " + a.Link
+				}
+				if !strings.Contains(strings.ToLower(field), strings.ToLower(payload)) {
+					t.Errorf("%s=%q matched %q, which does not hold it", param, payload, a.Title)
+				}
+			}
+		}
+	}
+	_, total, _ := store.List(context.Background(), sql.NewsQuery{Sort: "uid_asc", Limit: 100})
+	if total != int64(len(testArticles)) {
+		t.Errorf("store holds %d articles, want %d", total, len(testArticles))
+	}
+}
+
+func TestNewsHandlerLiteralMatch(t *testing.T) {
+	useMemoryStores(t)
+	status, body := call(newsHandler, "/api/V1?access_key="+testKey+"&keywords="+url.QueryEscape(`' OR '1'='1`))
+	if status != fasthttp.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	aList := replyArticles(t, body)
+	if len(aList) != 1 || !strings.HasPrefix(aList[0].Title, "Quoting") {
+		t.Errorf("want only the article quoting the payload, got %+v", aList)
+	}
+}
+
+func TestNewsHandlerBadInput(t *testing.T) {
+	useMemoryStores(t)
+	for _, query := range []string{
+		"sort=" + url.QueryEscape("created; DROP TABLE articles"),
+		"offset=" + url.QueryEscape("0; DROP TABLE articles"),
+		"limit=" + url.QueryEscape("1 OR 1=1"),
+		"date=" + url.QueryEscape("2024-01-01' OR '1'='1"),
+	} {
+		status, body := call(newsHandler, "/api/V1?access_key="+testKey+"&"+query)
+		if status != fasthttp.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400: %s", query, status, body)
+		}
+	}
+}
+
+func TestVerbsInjection(t *testing.T) {
+	useMemoryStores(t)
+	verb := func(key, sub, kid, subkid string) (int, []byte) {
+		return call(func(ctx *fasthttp.RequestCtx) { requestDefault(ctx, key, sub, kid, subkid) }, "/")
+	}
+	for _, payload := range injections {
+		for _, key := range []string{"find", "content"} {
+			status, body := verb(key, url.QueryEscape(payload), "10", "")
+			if status != fasthttp.StatusOK && status != 206 {
+				t.Fatalf("%s %q: status %d: %s", key, payload, status, body)
+			}
+			if status == 206 {
+				continue
+			}
+			for _, a := range replyArticles(t, body) {
+				field := a.Title
+				if key == "content" {
+					field = a.Content
+				}
+				if !containsAnyField(field, strings.Fields(payload)) {
+					t.Errorf("%s %q matched %q", key, payload, a.Title)
+				}
+			}
+		}
+		for _, key := range []string{"next", "prev"} {
+			status, body := verb(key, "2", "10", payload)
+			if status == fasthttp.StatusOK {
+				for _, a := range replyArticles(t, body) {
+					if !strings.Contains(a.Content, payload) {
+						t.Errorf("%s filter %q matched %q", key, payload, a.Title)
+					}
+				}
+			} else if status != 206 {
+				t.Errorf("%s filter %q: status %d: %s", key, payload, status, body)
+			}
+		}
+		if status, body := verb(payload, "10", "", ""); status != fasthttp.StatusBadRequest {
+			t.Errorf("article id %q: status %d, want 400: %s", payload, status, body)
+		}
+		if _, body := verb("fetch", payload, "", ""); string(body) != "Missing Article ID" {
+			t.Errorf("fetch %q: %s", payload, body)
+		}
+	}
+}
+
+func containsAnyField(value string, terms []string) bool {
+	for _, term := range terms {
+		if strings.Contains(strings.ToLower(value), strings.ToLower(term)) {
+			return true
+		}
+	}
+	return len(terms) == 0
+}
+
+// Serves a Handler on an in-memory listener, a client that dials it, the server shut when the test ends.
+func serve(t *testing.T) *fasthttp.Client {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	server := &fasthttp.Server{Handler: Handler()}
+	go func() { _ = server.Serve(ln) }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return &fasthttp.Client{Dial: func(addr string) (net.Conn, error) { return ln.Dial() }}
+}
+
+func get(t *testing.T, client *fasthttp.Client, uri string) (status int, body []byte, requestId string) {
+	t.Helper()
+	req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://news.test" + uri)
+	if err := client.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode(), append([]byte(nil), resp.Body()...), string(resp.Header.Peek(requestIdHeader))
+}
+
+func TestNewsHandlerServed(t *testing.T) {
+	useMemoryStores(t)
+	rate, burst := conf.APIRATE, conf.APIBURST
+	t.Cleanup(func() { conf.APIRATE, conf.APIBURST = rate, burst })
+	conf.APIRATE, conf.APIBURST = 1000, 1000
+	client := serve(t)
+
+	status, body, requestId := get(t, client, "/api/V1?access_key="+testKey+"&keywords=markets")
+	if status != fasthttp.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	if aList := replyArticles(t, body); len(aList) != 1 || aList[0].Title != "Markets rally" {
+		t.Errorf("want the markets article, got %+v", aList)
+	}
+	if len(requestId) == 0 {
+		t.Error("no request id on the reply")
+	}
+	if status, _, _ := get(t, client, "/api/V1?access_key=wrong-key"); status != fasthttp.StatusUnauthorized {
+		t.Errorf("wrong key: status %d, want 401", status)
+	}
+	if status, _, _ := get(t, client, "/api/V1?access_key="+testKey+"&sort=nonsense"); status != fasthttp.StatusBadRequest {
+		t.Errorf("bad sort: status %d, want 400", status)
+	}
+	account, _ := Accounts.Get(testKey)
+	if account.Used != 2 {
+		t.Errorf("used %d calls, want the 2 that got past the key", account.Used)
+	}
+}
+
+func TestHandlerLimiterIsItsOwn(t *testing.T) {
+	useMemoryStores(t)
+	rate, burst := conf.APIRATE, conf.APIBURST
+	t.Cleanup(func() { conf.APIRATE, conf.APIBURST = rate, burst })
+	conf.APIRATE, conf.APIBURST = 0, 2
+	uri := "/api/V1?access_key=" + testKey
+	first, second := Handler(), Handler()
+	for i := 0; i < 2; i++ {
+		if status, body := call(first, uri); status != fasthttp.StatusOK {
+			t.Fatalf("call %d: status %d: %s", i, status, body)
+		}
+	}
+	if status, _ := call(first, uri); status != fasthttp.StatusTooManyRequests {
+		t.Errorf("over the burst: status %d, want 429", status)
+	}
+	if status, body := call(second, uri); status != fasthttp.StatusOK {
+		t.Errorf("a second handler was limited by the first: status %d: %s", status, body)
+	}
+}
+
+func TestAuthoriseStopsAtAllocation(t *testing.T) {
+	useMemoryStores(t)
+	Accounts = sql.NewMemoryAccounts(map[string]sql.AccountsStruct{
+		testKey: {Plan: "PRO", Allocated: 2, End: time.Now().Add(time.Hour)},
+	})
+	uri := "/api/V1?access_key=" + testKey
+	for i := 0; i < 2; i++ {
+		if status, body := call(newsHandler, uri); status != fasthttp.StatusOK {
+			t.Fatalf("call %d of 2: status %d: %s", i+1, status, body)
+		}
+	}
+	if status, body := call(newsHandler, uri); status != fasthttp.StatusUnauthorized || string(body) != "Usage Limit Reached" {
+		t.Errorf("call past the allocation: status %d: %s", status, body)
+	}
+	if account, _ := Accounts.Get(testKey); account.Used != 2 {
+		t.Errorf("used %d, want the 2 allocated", account.Used)
+	}
+}
+
+// Keeps the log lines written while it is added, as text.
+type logCapture struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+func (c *logCapture) Write(e lib.LogEntry) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lines = append(c.lines, e.Msg+" "+fmt.Sprint(e.Fields...))
+	return nil
+}
+
+func (c *logCapture) Close() error { return nil }
+
+// Captures every level of log line until the test ends.
+func captureLogs(t *testing.T) *logCapture {
+	t.Helper()
+	debug := lib.DebugLevel
+	capture := &logCapture{}
+	t.Cleanup(func() {
+		lib.RemoveLogSink(capture)
+		lib.DebugLevel = debug
+	})
+	lib.DebugLevel = "DEBUG INFO WARN ERROR CRIT"
+	lib.AddLogSink(capture)
+	return capture
+}
+
+func TestKeyNotLogged(t *testing.T) {
+	useMemoryStores(t)
+	capture := captureLogs(t)
+	call(newsHandler, "/api/V1?access_key="+testKey+"&keywords=markets")
+	call(newsHandler, "/api/V1?access_key="+testKey+"&sort=nonsense")
+	call(newsHandler, "/api/V1?access_key="+testKey+"x")
+
+	capture.lock.Lock()
+	defer capture.lock.Unlock()
+	if len(capture.lines) == 0 {
+		t.Fatal("nothing was logged")
+	}
+	for _, line := range capture.lines {
+		if strings.Contains(line, testKey) {
+			t.Errorf("the access key was logged: %s", line)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/lib"
+	"all-news/sql"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/time/rate"
+)
+
+var (
+	sendMail          = lib.SendMail // Swap to catch the mail without an smtp server.
+	signupAccount     = sql.SignupAccount
+	verifySignup      = sql.VerifySignup
+	signupLock        sync.Mutex
+	signupLimiters    = make(map[string]*signupLimiter)
+	signupLastSweep   time.Time
+	errSignupLink     = errors.New("verification link is invalid")
+	errSignupLinkTime = errors.New("verification link has expired, sign up again for a new one")
+)
+
+/********************************************************************************
+ *          _                         _____             _
+ *         (_)                       |  __ \           | |
+ *      ___ _  __ _ _ __  _   _ _ __ | |__) |___  _   _| |_ ___  ___
+ *     / __| |/ _` | '_ \| | | | '_ \|  _  // _ \| | | | __/ _ \/ __|
+ *     \__ \ | (_| | | | | |_| | |_) | | \ \ (_) | |_| | ||  __/\__ \
+ *     |___/_|\__, |_| |_|\__,_| .__/|_|  \_\___/ \__,_|\__\___||___/
+ *             __/ |           | |
+ *            |___/            |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Self service signup. An email gets a FREE account, pending, and a signed link
+ * that expires. Following the link shows a page to confirm on, and confirming
+ * makes the account live and shows the api key, the only time it is shown. The
+ * GET changes nothing, so a mail scanner opening the link does not use it up.
+ * Each address can only ask so often.
+ * --------------------------------------------------------------------------- */
+func signupRoutes(router *fasthttprouter.Router) {
+	router.POST("/api/V1/signup", signupHandler)
+	router.GET("/api/V1/signup/verify", signupConfirm)
+	router.POST("/api/V1/signup/verify", signupVerify)
+}
+
+func signupHandler(ctx *fasthttp.RequestCtx) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			requestLog(ctx).Error("Signup failed:", r)
+			ctx.Error("Signup failed", fasthttp.StatusInternalServerError)
+		}
+	}()
+	if len(conf.SIGNUPSECRET) == 0 {
+		ctx.Error("Signup is not available", fasthttp.StatusServiceUnavailable)
+		return
+	}
+	if !signupAllow(ctx.RemoteIP().String()) {
+		requestLog(ctx).Warn("Signup throttled:", ctx.RemoteIP())
+		ctx.Error("Too many signups from this address", fasthttp.StatusTooManyRequests)
+		return
+	}
+	address, err := mail.ParseAddress(strings.TrimSpace(formValue(ctx, "email")))
+	if err != nil {
+		ctx.Error("A valid email is required", fasthttp.StatusBadRequest)
+		return
+	}
+	urn, err := signupAccount(requestContext(ctx), address.Address)
+	switch {
+	case err == sql.ErrAccountExists:
+		// Same reply as a new signup, so nobody can find out who has an account.
+		requestLog(ctx).Info("Signup for an existing account:", ctx.RemoteIP())
+	case err != nil:
+		requestLog(ctx).Error("Signup:", err)
+		ctx.Error("Signup failed", fasthttp.StatusInternalServerError)
+		return
+	default:
+		if err = sendSignupMail(address.Address, urn); err != nil {
+			requestLog(ctx).Error("Signup email:", urn, err)
+			ctx.Error("Unable to send the verification email", fasthttp.StatusBadGateway)
+			return
+		}
+	}
+	jsonResponse(ctx, fasthttp.StatusAccepted, map[string]string{"status": "Check your email for the verification link"})
+}
+
+var signupConfirmPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Verify your {{.App}} account</title></head>
+<body>
+<p>Confirm your email to make your account live and get your api key.</p>
+<form method="POST" action="/api/V1/signup/verify">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Verify and show my api key</button>
+</form>
+</body></html>
+`))
+
+// The page the emailed link opens, it only asks for the POST that verifies.
+func signupConfirm(ctx *fasthttp.RequestCtx) {
+	token := string(ctx.QueryArgs().Peek("token"))
+	if _, err := checkSignupToken(token, time.Now()); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	ctx.SetContentType("text/html; charset=utf-8")
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	ctx.Response.Header.Set("Referrer-Policy", "no-referrer") // The token is in the url.
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	lib.CheckErr(signupConfirmPage.Execute(ctx, map[string]string{"App": conf.AppName, "Token": token}))
+}
+
+func signupVerify(ctx *fasthttp.RequestCtx) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			requestLog(ctx).Error("Signup verify failed:", r)
+			ctx.Error("Signup verify failed", fasthttp.StatusInternalServerError)
+		}
+	}()
+	urn, err := checkSignupToken(formValue(ctx, "token"), time.Now())
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	apikey, err := verifySignup(requestContext(ctx), urn)
+	switch err {
+	case nil:
+		ctx.Response.Header.Set("Cache-Control", "no-store")
+		jsonResponse(ctx, fasthttp.StatusOK, map[string]string{
+			"apikey": apikey,
+			"plan":   "FREE",
+			"note":   "Keep this key safe, it is not shown again",
+		})
+	case sql.ErrSignupUsed:
+		ctx.Error("This signup has already been verified", fasthttp.StatusGone)
+	default:
+		requestLog(ctx).Error("Signup verify:", urn, err)
+		ctx.Error("Signup verify failed", fasthttp.StatusInternalServerError)
+	}
+}
+
+func sendSignupMail(email string, urn int64) error {
+	expires := time.Now().Add(time.Duration(conf.SIGNUPEXPIRE) * time.Hour)
+	link := conf.PUBLICURL + "/api/V1/signup/verify?token=" + url.QueryEscape(signupToken(urn, expires))
+	return sendMail(lib.Mail{
+		HOST:    conf.MAILHOST,
+		PORT:    conf.MAILPORT,
+		FROM:    conf.MAILFROM,
+		PASS:    conf.MAILPASS,
+		TO:      email,
+		SUBJECT: "Verify your " + conf.AppName + " account",
+		BODY: fmt.Sprintf(`<p>Follow this link to verify your email and get your api key:</p>
+<p><a href="%[1]s">%[1]s</a></p>
+<p>The link works once, until %[2]s. If you did not sign up you can ignore this email.</p>`,
+			link, expires.UTC().Format("2006-01-02 15:04 MST")),
+	})
+}
+
+/*****************************************************************************
+ *          _                      _______    _
+ *         (_)                    |__   __|  | |
+ *      ___ _  __ _ _ __  _   _ _ __ | | ___ | | _____ _ __
+ *     / __| |/ _` | '_ \| | | | '_ \| |/ _ \| |/ / _ \ '_ \
+ *     \__ \ | (_| | | | | |_| | |_) | | (_) |   <  __/ | | |
+ *     |___/_|\__, |_| |_|\__,_| .__/|_|\___/|_|\_\___|_| |_|
+ *             __/ |           | |
+ *            |___/            |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * The verification link carries the urn and an expiry, signed with the signup
+ * secret, so nothing about the signup needs keeping anywhere but the account.
+ * ------------------------------------------------------------------------ */
+func signupToken(urn int64, expires time.Time) string {
+	payload := strconv.FormatInt(urn, 10) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + signupSign(payload)
+}
+
+func checkSignupToken(token string, now time.Time) (urn int64, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || !hmac.Equal([]byte(parts[2]), []byte(signupSign(parts[0]+"."+parts[1]))) {
+		return 0, errSignupLink
+	}
+	urn, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, errSignupLink
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, errSignupLink
+	}
+	if now.Unix() > expires {
+		return 0, errSignupLinkTime
+	}
+	return urn, nil
+}
+
+func signupSign(payload string) string {
+	mac := hmac.New(sha256.New, []byte(conf.SIGNUPSECRET))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signups allowed from each address, a few an hour, forgotten an hour after the last.
+type signupLimiter struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+func signupAllow(ip string) bool {
+	signupLock.Lock()
+	defer signupLock.Unlock()
+	now := time.Now()
+	if now.Sub(signupLastSweep) > 10*time.Minute {
+		for key, sl := range signupLimiters {
+			if now.Sub(sl.seen) > time.Hour {
+				delete(signupLimiters, key)
+			}
+		}
+		signupLastSweep = now
+	}
+	sl, ok := signupLimiters[ip]
+	if !ok {
+		perHour := conf.SIGNUPPERHOUR
+		if perHour < 1 {
+			perHour = 1
+		}
+		sl = &signupLimiter{limiter: rate.NewLimiter(rate.Every(time.Hour/time.Duration(perHour)), perHour)}
+		signupLimiters[ip] = sl
+	}
+	sl.seen = now
+	if sl.limiter.Allow() {
+		return true
+	}
+	rateLimited.Inc("signup")
+	return false
+}
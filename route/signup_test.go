@@ -0,0 +1,190 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/lib"
+	"all-news/sql"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Stands in for the smtp server and the accounts table, put back when the test ends.
+type signupStandIn struct {
+	lock     sync.Mutex
+	mails    []lib.Mail
+	mailErr  error
+	existing map[string]bool
+	pending  map[int64]bool
+	verified []int64
+}
+
+func useSignupStandIn(t *testing.T) *signupStandIn {
+	t.Helper()
+	oldSend, oldSignup, oldVerify := sendMail, signupAccount, verifySignup
+	secret, public := conf.SIGNUPSECRET, conf.PUBLICURL
+	t.Cleanup(func() {
+		sendMail, signupAccount, verifySignup = oldSend, oldSignup, oldVerify
+		conf.SIGNUPSECRET, conf.PUBLICURL = secret, public
+	})
+	conf.SIGNUPSECRET, conf.PUBLICURL = "signup-test-secret", "https://news.test"
+	signupLock.Lock()
+	signupLimiters = make(map[string]*signupLimiter)
+	signupLock.Unlock()
+
+	s := &signupStandIn{existing: map[string]bool{"taken@example.com": true}, pending: make(map[int64]bool)}
+	sendMail = func(mail lib.Mail) error {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.mailErr != nil {
+			return s.mailErr
+		}
+		s.mails = append(s.mails, mail)
+		return nil
+	}
+	signupAccount = func(ctx context.Context, email string) (int64, error) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.existing[email] {
+			return 0, sql.ErrAccountExists
+		}
+		urn := int64(100 + len(s.pending))
+		s.existing[email], s.pending[urn] = true, true
+		return urn, nil
+	}
+	verifySignup = func(ctx context.Context, urn int64) (string, error) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if !s.pending[urn] {
+			return "", sql.ErrSignupUsed
+		}
+		s.pending[urn] = false
+		s.verified = append(s.verified, urn)
+		return "news_signupkey", nil
+	}
+	return s
+}
+
+func post(handler fasthttp.RequestHandler, uri string, form url.Values) (status int, body []byte) {
+	var req fasthttp.Request
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/x-www-form-urlencoded")
+	req.SetRequestURI(uri)
+	req.SetBodyString(form.Encode())
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, nil, nil)
+	handler(&ctx)
+	return ctx.Response.StatusCode(), ctx.Response.Body()
+}
+
+var signupLink = regexp.MustCompile(`href="([^"]+)"`)
+
+func TestSignupVerify(t *testing.T) {
+	s := useSignupStandIn(t)
+	status, body := post(signupHandler, "/api/V1/signup", url.Values{"email": {"New Reader <reader@example.com>"}})
+	if status != fasthttp.StatusAccepted {
+		t.Fatalf("signup: status %d: %s", status, body)
+	}
+	if len(s.mails) != 1 || s.mails[0].TO != "reader@example.com" {
+		t.Fatalf("want one mail to reader@example.com, got %+v", s.mails)
+	}
+	match := signupLink.FindStringSubmatch(s.mails[0].BODY)
+	if match == nil || !strings.HasPrefix(match[1], "https://news.test/api/V1/signup/verify?token=") {
+		t.Fatalf("no verify link in the mail: %s", s.mails[0].BODY)
+	}
+	link, _ := url.Parse(match[1])
+	token := link.Query().Get("token")
+
+	status, body = call(signupConfirm, link.RequestURI())
+	if status != fasthttp.StatusOK {
+		t.Fatalf("confirm page: status %d: %s", status, body)
+	}
+	if !strings.Contains(string(body), `method="POST"`) || !strings.Contains(string(body), `value="`+token+`"`) {
+		t.Errorf("confirm page has no form posting the token: %s", body)
+	}
+	if strings.Contains(string(body), "news_signupkey") || len(s.verified) > 0 {
+		t.Fatal("following the link verified the account before it was confirmed")
+	}
+
+	status, body = post(signupVerify, "/api/V1/signup/verify", url.Values{"token": {token}})
+	if status != fasthttp.StatusOK {
+		t.Fatalf("verify: status %d: %s", status, body)
+	}
+	var reply map[string]string
+	if err := json.Unmarshal(body, &reply); err != nil || reply["apikey"] != "news_signupkey" {
+		t.Errorf("verify reply: %s", body)
+	}
+	if len(s.verified) != 1 || s.verified[0] != 100 {
+		t.Errorf("verified %v, want urn 100", s.verified)
+	}
+	if status, _ = post(signupVerify, "/api/V1/signup/verify", url.Values{"token": {token}}); status != fasthttp.StatusGone {
+		t.Errorf("second verify: status %d, want 410", status)
+	}
+}
+
+func TestSignupExistingSendsNothing(t *testing.T) {
+	s := useSignupStandIn(t)
+	status, body := post(signupHandler, "/api/V1/signup", url.Values{"email": {"taken@example.com"}})
+	if status != fasthttp.StatusAccepted {
+		t.Errorf("status %d, want the same 202 as a new signup: %s", status, body)
+	}
+	if len(s.mails) > 0 {
+		t.Errorf("mailed an existing account: %+v", s.mails)
+	}
+}
+
+func TestSignupRefusals(t *testing.T) {
+	s := useSignupStandIn(t)
+	if status, _ := post(signupHandler, "/api/V1/signup", url.Values{"email": {"not an address"}}); status != fasthttp.StatusBadRequest {
+		t.Errorf("bad email: status %d, want 400", status)
+	}
+	s.mailErr = errors.New("smtp down")
+	if status, _ := post(signupHandler, "/api/V1/signup", url.Values{"email": {"other@example.com"}}); status != fasthttp.StatusBadGateway {
+		t.Errorf("mail failed: status %d, want 502", status)
+	}
+	s.mailErr = nil
+
+	expired := signupToken(100, time.Now().Add(-time.Minute))
+	forged := signupToken(100, time.Now().Add(time.Hour))
+	if strings.HasSuffix(forged, "0") {
+		forged = strings.TrimSuffix(forged, "0") + "1"
+	} else {
+		forged = forged[:len(forged)-1] + "0"
+	}
+	for name, token := range map[string]string{"expired": expired, "forged": forged, "empty": ""} {
+		if status, _ := call(signupConfirm, "/api/V1/signup/verify?token="+url.QueryEscape(token)); status != fasthttp.StatusBadRequest {
+			t.Errorf("%s token, confirm page: status %d, want 400", name, status)
+		}
+		if status, _ := post(signupVerify, "/api/V1/signup/verify", url.Values{"token": {token}}); status != fasthttp.StatusBadRequest {
+			t.Errorf("%s token, verify: status %d, want 400", name, status)
+		}
+	}
+	if len(s.verified) > 0 {
+		t.Errorf("verified %v with bad tokens", s.verified)
+	}
+
+	conf.SIGNUPSECRET = ""
+	if status, _ := post(signupHandler, "/api/V1/signup", url.Values{"email": {"third@example.com"}}); status != fasthttp.StatusServiceUnavailable {
+		t.Errorf("no secret: status %d, want 503", status)
+	}
+}
+
+func TestSignupThrottled(t *testing.T) {
+	useSignupStandIn(t)
+	perHour := conf.SIGNUPPERHOUR
+	t.Cleanup(func() { conf.SIGNUPPERHOUR = perHour })
+	conf.SIGNUPPERHOUR = 2
+	for i, want := range []int{fasthttp.StatusAccepted, fasthttp.StatusAccepted, fasthttp.StatusTooManyRequests} {
+		if status, body := post(signupHandler, "/api/V1/signup", url.Values{"email": {"taken@example.com"}}); status != want {
+			t.Errorf("signup %d: status %d, want %d: %s", i+1, status, want, body)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/lib"
+	"all-news/sql"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	streamBuffer       = 256              // Articles a stream can fall behind by before it is dropped.
+	streamBackfill     = 500              // Articles read at a time to catch a stream up from its Last-Event-ID.
+	streamWriteTimeout = 10 * time.Second // For each write, the server's own timeout would end the stream.
+)
+
+var (
+	streamLock sync.Mutex
+	streams    = make(map[string]int) // Open streams by access key.
+)
+
+/*******************************************************************************
+ *          _                            _____             _
+ *         | |                          |  __ \           | |
+ *      ___| |_ _ __ ___  __ _ _ __ ___ | |__) |___  _   _| |_ ___  ___
+ *     / __| __| '__/ _ \/ _` | '_ ` _ \|  _  // _ \| | | | __/ _ \/ __|
+ *     \__ \ |_| | |  __/ (_| | | | | | | | \ \ (_) | |_| | ||  __/\__ \
+ *     |___/\__|_|  \___|\__,_|_| |_| |_|_|  \_\___/ \__,_|\__\___||___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * New articles as Server-Sent Events the moment they are inserted, so clients
+ * need not poll. The same access key and filters as the news api, each event
+ * id is the article uid for Last-Event-ID to pick up from.
+ * -------------------------------------------------------------------------- */
+func streamRoutes(router *fasthttprouter.Router) {
+	router.GET("/api/V1/stream", streamHandler)
+}
+
+/*****************************************************************************
+ *          _                            _    _                 _ _
+ *         | |                          | |  | |               | | |
+ *      ___| |_ _ __ ___  __ _ _ __ ___ | |__| | __ _ _ __   __| | | ___ _ __
+ *     / __| __| '__/ _ \/ _` | '_ ` _ \|  __  |/ _` | '_ \ / _` | |/ _ \ '__|
+ *     \__ \ |_| | |  __/ (_| | | | | | | |  | | (_| | | | | (_| | |  __/ |
+ *     |___/\__|_|  \___|\__,_|_| |_| |_|_|  |_|\__,_|_| |_|\__,_|_|\___|_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Opens a stream once the key is good and the plan has a stream to spare.
+ * With a Last-Event-ID, the header or last_event_id for clients that cannot
+ * set it, the articles since then are sent first. A comment goes every
+ * STREAMHEARTBEAT seconds to keep the connection open, and a stream that
+ * falls behind is closed for the client to come back from where it got to.
+ * ------------------------------------------------------------------------ */
+func streamHandler(ctx *fasthttp.RequestCtx) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			requestLog(ctx).Warn("streamHandler problem:", r)
+		}
+	}()
+	if !limiterAllow(ctx) {
+		ctx.Error("Too many requests", fasthttp.StatusTooManyRequests)
+		return
+	}
+	accessKey := string(ctx.QueryArgs().Peek("access_key"))
+	plan, ok := authorise(ctx, accessKey)
+	if !ok {
+		return
+	}
+	query := sql.NewsQuery{
+		Topic:      string(ctx.QueryArgs().Peek("topic")),
+		Keywords:   splitList(string(ctx.QueryArgs().Peek("keywords"))),
+		Categories: splitList(string(ctx.QueryArgs().Peek("categories"))),
+		Sources:    splitList(string(ctx.QueryArgs().Peek("sources"))),
+	}
+	lastId := string(ctx.Request.Header.Peek("Last-Event-ID"))
+	if len(lastId) == 0 {
+		lastId = string(ctx.QueryArgs().Peek("last_event_id"))
+	}
+	if len(lastId) > 0 {
+		uid, err := strconv.ParseInt(lastId, 10, 64)
+		if err != nil || uid < 0 {
+			response(ctx, conf.Reply{Code: fasthttp.StatusBadRequest, Msg: "Invalid Last-Event-ID: " + lastId})
+			return
+		}
+		query.AfterUid = uid
+	}
+	if !streamAcquire(accessKey, plan) {
+		ctx.Error("Too many streams for the "+plan.Name+" plan", fasthttp.StatusTooManyRequests)
+		return
+	}
+
+	sub := sql.SubscribeArticles(streamBuffer)          // Before the catch up, so nothing falls between.
+	conn, remote := ctx.Conn(), ctx.RemoteIP().String() // The ctx is not to be used once the writer runs,
+	rctx := requestContext(ctx)                         // so the writer keeps these.
+	log := lib.Log(rctx)
+	log.Info("Stream opened:", remote, plan.Name, "from:", query.AfterUid)
+	ctx.SetContentType("text/event-stream; charset=utf-8")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.Response.Header.Set("X-Accel-Buffering", "no") // Stop a proxy holding the events back.
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer streamRelease(accessKey)
+		defer sub.Close()
+		send := func(event string) error {
+			if conn != nil {
+				_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			}
+			if _, err := w.WriteString(event); err != nil {
+				return err
+			}
+			return w.Flush()
+		}
+		if send("retry: 5000\n\n") != nil {
+			return
+		}
+		for caughtUp := query.AfterUid == 0; !caughtUp; {
+			aList, err := streamCatchUp(rctx, query)
+			if lib.CheckErr(err) {
+				return
+			}
+			for _, a := range aList {
+				if send(articleEvent(a)) != nil {
+					return
+				}
+				query.AfterUid = a.Uid
+			}
+			caughtUp = len(aList) < streamBackfill
+		}
+		heartBeat := time.NewTicker(time.Duration(conf.STREAMHEARTBEAT) * time.Second)
+		defer heartBeat.Stop()
+		for {
+			select {
+			case a, open := <-sub.C:
+				if !open {
+					log.Info("Stream closed, it fell behind at:", query.AfterUid, remote)
+					return
+				}
+				if a.Uid <= query.AfterUid || !query.Matches(a) {
+					continue // Inserted while catching up, it has gone already.
+				}
+				if send(articleEvent(a)) != nil {
+					return
+				}
+				query.AfterUid = a.Uid
+			case <-heartBeat.C:
+				if send(": heartbeat\n\n") != nil {
+					log.Debug("Stream gone:", remote)
+					return
+				}
+			}
+		}
+	})
+}
+
+// The articles after the query's uid, in the order they came in, a page of streamBackfill.
+// The stream asks again from the last one sent until a page comes back short.
+func streamCatchUp(rctx context.Context, query sql.NewsQuery) (aList []sql.Article, err error) {
+	query.Sort = "uid_asc"
+	query.Limit = streamBackfill
+	aList, _, err = Articles.List(rctx, query)
+	return
+}
+
+// One article as an event, its uid the id.
+func articleEvent(a sql.Article) string {
+	data, err := json.Marshal(a)
+	if lib.CheckErr(err) {
+		return ""
+	}
+	return fmt.Sprintf("id: %d\nevent: article\ndata: %s\n\n", a.Uid, data)
+}
+
+// Takes one of the plan's streams for the key, false if they are all open.
+func streamAcquire(accessKey string, plan sql.Plan) bool {
+	streamLock.Lock()
+	defer streamLock.Unlock()
+	if streams[accessKey] >= plan.Streams {
+		rateLimited.Inc("streams")
+		return false
+	}
+	streams[accessKey]++
+	return true
+}
+
+func streamRelease(accessKey string) {
+	streamLock.Lock()
+	defer streamLock.Unlock()
+	if streams[accessKey]--; streams[accessKey] <= 0 {
+		delete(streams, accessKey)
+	}
+}
@@ -0,0 +1,149 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/sql"
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Opens a stream on the handler from the Last-Event-ID, the events read off it as they come.
+// The stream is closed when the test ends.
+func openStream(t *testing.T, lastId int64) <-chan int64 {
+	t.Helper()
+	heartBeat := conf.STREAMHEARTBEAT
+	conf.STREAMHEARTBEAT = 3600
+	var req fasthttp.Request
+	req.SetRequestURI("/api/V1/stream?access_key=" + testKey)
+	req.Header.Set("Last-Event-ID", strconv.FormatInt(lastId, 10))
+	conn, other := net.Pipe() // The writer sets deadlines on the conn, it needs a real one.
+	t.Cleanup(func() { _, _ = conn.Close(), other.Close() })
+	var ctx fasthttp.RequestCtx
+	ctx.Init2(conn, nil, false)
+	req.CopyTo(&ctx.Request)
+	streamHandler(&ctx)
+	if status := ctx.Response.StatusCode(); status != fasthttp.StatusOK {
+		t.Fatalf("status %d: %s", status, ctx.Response.Body())
+	}
+	body, ok := ctx.Response.BodyStream().(io.ReadCloser)
+	if !ok {
+		t.Fatal("the reply is not a stream")
+	}
+	ids := make(chan int64, 4096)
+	go func() {
+		defer close(ids)
+		lines := bufio.NewScanner(body)
+		for lines.Scan() {
+			if id, found := strings.CutPrefix(lines.Text(), "id: "); found {
+				uid, _ := strconv.ParseInt(id, 10, 64)
+				ids <- uid
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		_ = body.Close()
+		for range ids {
+		}
+		conf.STREAMHEARTBEAT = heartBeat
+	})
+	return ids
+}
+
+// The next count event ids, failing if they do not come in time.
+func nextEvents(t *testing.T, ids <-chan int64, count int) (got []int64) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for len(got) < count {
+		select {
+		case id, open := <-ids:
+			if !open {
+				t.Fatalf("stream ended after %d of %d events", len(got), count)
+			}
+			got = append(got, id)
+		case <-timeout:
+			t.Fatalf("%d of %d events came", len(got), count)
+		}
+	}
+	return
+}
+
+// Nothing more comes for a moment.
+func noEvents(t *testing.T, ids <-chan int64) {
+	t.Helper()
+	select {
+	case id := <-ids:
+		t.Errorf("unexpected event %d", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStreamCatchUpPages(t *testing.T) {
+	store := useMemoryStores(t)
+	total := int64(len(testArticles))
+	for ; total < 2*streamBackfill+10; total++ {
+		if _, err := store.Insert(context.Background(), sql.Article{Topic: "general", Title: "Filler " + strconv.FormatInt(total, 10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := openStream(t, 1)
+	got := nextEvents(t, ids, int(total-1))
+	for i, uid := range got {
+		if uid != int64(i)+2 {
+			t.Fatalf("event %d is article %d, want %d", i, uid, i+2)
+		}
+	}
+	uid, err := store.Insert(context.Background(), sql.Article{Topic: "general", Title: "Live one"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live := nextEvents(t, ids, 1); live[0] != uid {
+		t.Errorf("live event %d, want %d", live[0], uid)
+	}
+	noEvents(t, ids)
+}
+
+// A store that has an article come in while the stream is catching up, as the insert
+// would race with it.
+type insertDuringCatchUp struct {
+	*sql.MemoryArticles
+	inserted bool
+}
+
+func (s *insertDuringCatchUp) List(ctx context.Context, query sql.NewsQuery) ([]sql.Article, int64, error) {
+	if !s.inserted {
+		s.inserted = true
+		if _, err := s.MemoryArticles.Insert(ctx, sql.Article{Topic: "general", Title: "During the catch up"}); err != nil {
+			return nil, 0, err
+		}
+	}
+	return s.MemoryArticles.List(ctx, query)
+}
+
+func TestStreamSendsCatchUpOnce(t *testing.T) {
+	store := &insertDuringCatchUp{MemoryArticles: useMemoryStores(t)}
+	Articles = store
+	ids := openStream(t, 2)
+	want := []int64{3, 4, 5} // The two left in testArticles, then the one inserted while catching up.
+	got := nextEvents(t, ids, len(want))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events %v, want %v", got, want)
+		}
+	}
+	noEvents(t, ids)
+	uid, err := store.Insert(context.Background(), sql.Article{Topic: "general", Title: "After the catch up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live := nextEvents(t, ids, 1); live[0] != uid {
+		t.Errorf("live event %d, want %d", live[0], uid)
+	}
+}
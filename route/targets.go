@@ -0,0 +1,68 @@
+package route
+
+import (
+	"all-news/sql"
+	dbsql "database/sql"
+	"strings"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+/**********************************************************************
+ *      _                       _   _____             _
+ *     | |                     | | |  __ \           | |
+ *     | |_ __ _ _ __ __ _  ___| |_| |__) |___  _   _| |_ ___  ___
+ *     | __/ _` | '__/ _` |/ _ \ __|  _  // _ \| | | | __/ _ \/ __|
+ *     | || (_| | | | (_| |  __/ |_| | \ \ (_) | |_| | ||  __/\__ \
+ *      \__\__,_|_|  \__, |\___|\__|_|  \_\___/ \__,_|\__\___||___/
+ *                    __/ |
+ *                   |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * The publisher targets in the control table for the admin, with their
+ * detail and keyword settings. ?platform= lists just one platform's.
+ * ----------------------------------------------------------------- */
+func targetRoutes(router *fasthttprouter.Router) {
+	router.GET("/admin/targets", admin(adminTargets))
+	router.PUT("/admin/targets/:target", admin(adminTargetSettings))
+}
+
+func adminTargets(ctx *fasthttp.RequestCtx) {
+	tList, err := sql.ListTargets(requestContext(ctx), formValue(ctx, "platform"))
+	if err != nil {
+		adminError(ctx, err)
+		return
+	}
+	if tList == nil {
+		tList = []sql.Target{}
+	}
+	jsonResponse(ctx, fasthttp.StatusOK, tList)
+}
+
+// detail=on, off or default, which follows NEWSDETAIL, and keywords, empty for every article.
+func adminTargetSettings(ctx *fasthttp.RequestCtx) {
+	target := ctx.UserValue("target").(string)
+	var detail *bool
+	switch strings.ToLower(formValue(ctx, "detail")) {
+	case "on", "true", "1":
+		on := true
+		detail = &on
+	case "off", "false", "0":
+		off := false
+		detail = &off
+	case "", "default":
+	default:
+		ctx.Error("detail is on, off or default", fasthttp.StatusBadRequest)
+		return
+	}
+	err := sql.SetTargetSettings(requestContext(ctx), target, detail, strings.TrimSpace(formValue(ctx, "keywords")))
+	if err == dbsql.ErrNoRows {
+		ctx.Error("No such target", fasthttp.StatusNotFound)
+		return
+	} else if err != nil {
+		adminError(ctx, err)
+		return
+	}
+	t, err := sql.GetTarget(requestContext(ctx), target)
+	adminReply(ctx, 0, err, t)
+}
@@ -0,0 +1,138 @@
+package route
+
+import (
+	"all-news/sql"
+	dbsql "database/sql"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+/********************************************************************************
+ *                   _     _                 _    _____             _
+ *                  | |   | |               | |  |  __ \           | |
+ *     __      _____| |__ | |__   ___   ___ | | _| |__) |___  _   _| |_ ___  ___
+ *     \ \ /\ / / _ \ '_ \| '_ \ / _ \ / _ \| |/ /  _  // _ \| | | | __/ _ \/ __|
+ *      \ V  V /  __/ |_) | | | | (_) | (_) |   <| | \ \ (_) | |_| | ||  __/\__ \
+ *       \_/\_/ \___|_.__/|_| |_|\___/ \___/|_|\_\_|  \_\___/ \__,_|\__\___||___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Webhooks and their dead letters for the admin, behind the same admin key
+ * and code as the accounts. A new webhook's secret is in the reply that made it
+ * and not shown again.
+ * --------------------------------------------------------------------------- */
+func webhookRoutes(router *fasthttprouter.Router) {
+	router.GET("/admin/webhooks", admin(adminWebhooks))
+	router.POST("/admin/webhooks", admin(adminAddWebhook))
+	router.DELETE("/admin/webhooks/:uid", admin(adminRemoveWebhook))
+	router.GET("/admin/deadletters", admin(adminDeadLetters))
+	router.DELETE("/admin/deadletters/:uid", admin(adminDeleteDeadLetter))
+}
+
+// The webhooks tables as the routes use them, swapped out by the tests.
+var (
+	addWebhook       = sql.AddWebhook
+	getWebhook       = sql.GetWebhook
+	listWebhooks     = sql.ListWebhooks
+	removeWebhook    = sql.RemoveWebhook
+	listDeadLetters  = sql.ListDeadLetters
+	deleteDeadLetter = sql.DeleteDeadLetter
+)
+
+func adminWebhooks(ctx *fasthttp.RequestCtx) {
+	wList, err := listWebhooks(requestContext(ctx))
+	if err != nil {
+		adminError(ctx, err)
+		return
+	}
+	if wList == nil {
+		wList = []sql.Webhook{}
+	}
+	jsonResponse(ctx, fasthttp.StatusOK, wList)
+}
+
+func adminAddWebhook(ctx *fasthttp.RequestCtx) {
+	hookUrl := strings.TrimSpace(formValue(ctx, "url"))
+	if u, err := url.Parse(hookUrl); err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 {
+		ctx.Error("A valid http or https url is required", fasthttp.StatusBadRequest)
+		return
+	}
+	topic := formValue(ctx, "topic")
+	if len(topic) == 0 {
+		topic = "general"
+	}
+	hook, err := addWebhook(requestContext(ctx), hookUrl, formValue(ctx, "secret"), topic, formValue(ctx, "cats"), formValue(ctx, "keywords"))
+	if err != nil {
+		adminError(ctx, err)
+		return
+	}
+	jsonResponse(ctx, fasthttp.StatusCreated, hook)
+}
+
+func adminRemoveWebhook(ctx *fasthttp.RequestCtx) {
+	if uid, ok := uidParam(ctx); ok {
+		err := removeWebhook(requestContext(ctx), uid)
+		if err == dbsql.ErrNoRows {
+			ctx.Error("No such webhook", fasthttp.StatusNotFound)
+			return
+		} else if err != nil {
+			adminError(ctx, err)
+			return
+		}
+		hook, err := getWebhook(requestContext(ctx), uid)
+		adminReply(ctx, 0, err, hook)
+	}
+}
+
+// The dead letters, newest first, ?webhook= for just one webhook's.
+func adminDeadLetters(ctx *fasthttp.RequestCtx) {
+	webhook, _ := strconv.ParseInt(formValue(ctx, "webhook"), 10, 64)
+	offset := 0
+	if value := formValue(ctx, "offset"); len(value) > 0 {
+		var err error
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			ctx.Error("offset must be a whole number, zero or more", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultLimit
+	if value := formValue(ctx, "limit"); len(value) > 0 {
+		var err error
+		if limit, err = setLimit(value); err != nil {
+			ctx.Error("limit must be a whole number", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	dList, err := listDeadLetters(requestContext(ctx), webhook, limit, offset)
+	if err != nil {
+		adminError(ctx, err)
+		return
+	}
+	if dList == nil {
+		dList = []sql.DeadLetter{}
+	}
+	jsonResponse(ctx, fasthttp.StatusOK, dList)
+}
+
+func adminDeleteDeadLetter(ctx *fasthttp.RequestCtx) {
+	if uid, ok := uidParam(ctx); ok {
+		err := deleteDeadLetter(requestContext(ctx), uid)
+		if err == dbsql.ErrNoRows {
+			ctx.Error("No such dead letter", fasthttp.StatusNotFound)
+			return
+		}
+		adminReply(ctx, 0, err, map[string]int64{"deleted": uid})
+	}
+}
+
+// The :uid in the path, replies with a 400 if it is not a number.
+func uidParam(ctx *fasthttp.RequestCtx) (uid int64, ok bool) {
+	uid, err := strconv.ParseInt(ctx.UserValue("uid").(string), 10, 64)
+	if err != nil {
+		ctx.Error("Invalid uid", fasthttp.StatusBadRequest)
+		return
+	}
+	return uid, true
+}
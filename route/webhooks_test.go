@@ -0,0 +1,198 @@
+package route
+
+import (
+	"all-news/conf"
+	"all-news/lib"
+	"all-news/sql"
+	"context"
+	dbsql "database/sql"
+	"encoding/json"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Stands in for the webhooks table, put back when the test ends.
+type webhookStandIn struct {
+	lock  sync.Mutex
+	hooks []sql.Webhook
+}
+
+func useWebhookStandIn(t *testing.T) *webhookStandIn {
+	t.Helper()
+	oldAdd, oldGet, oldList, oldRemove := addWebhook, getWebhook, listWebhooks, removeWebhook
+	t.Cleanup(func() { addWebhook, getWebhook, listWebhooks, removeWebhook = oldAdd, oldGet, oldList, oldRemove })
+	s := &webhookStandIn{}
+	addWebhook = func(ctx context.Context, url string, secret string, topic string, cats string, keywords string) (sql.Webhook, error) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if len(secret) == 0 {
+			secret = "whsec_made"
+		}
+		hook := sql.Webhook{Uid: int64(len(s.hooks) + 1), Url: url, Secret: secret, Topic: topic, Cats: cats, Keywords: keywords, Live: true}
+		s.hooks = append(s.hooks, hook)
+		return hook, nil
+	}
+	getWebhook = func(ctx context.Context, uid int64) (sql.Webhook, error) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if uid < 1 || uid > int64(len(s.hooks)) {
+			return sql.Webhook{}, dbsql.ErrNoRows
+		}
+		hook := s.hooks[uid-1]
+		hook.Secret = ""
+		return hook, nil
+	}
+	listWebhooks = func(ctx context.Context) (wList []sql.Webhook, err error) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		for _, hook := range s.hooks {
+			hook.Secret = ""
+			wList = append(wList, hook)
+		}
+		return
+	}
+	removeWebhook = func(ctx context.Context, uid int64) error {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if uid < 1 || uid > int64(len(s.hooks)) {
+			return dbsql.ErrNoRows
+		}
+		s.hooks[uid-1].Live = false
+		return nil
+	}
+	return s
+}
+
+// Calls the admin api with the key, a code the first time and the session it got after.
+type adminClient struct {
+	t       *testing.T
+	client  *fasthttp.Client
+	key     string // The configured one if empty.
+	session string
+}
+
+func (a *adminClient) do(method string, uri string, form url.Values) (status int, body []byte) {
+	a.t.Helper()
+	req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.Header.SetMethod(method)
+	req.SetRequestURI("http://news.test" + uri)
+	key := a.key
+	if len(key) == 0 {
+		key = conf.ADMINKEY
+	}
+	req.Header.Set("X-Admin-Key", key)
+	if len(a.session) > 0 {
+		req.Header.Set("X-Admin-Session", a.session)
+	} else {
+		code, _ := lib.TotpCode(conf.ADMINOTPKEY, time.Now())
+		req.Header.Set("X-OTP", code)
+	}
+	if form != nil {
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		req.SetBodyString(form.Encode())
+	}
+	if err := a.client.Do(req, resp); err != nil {
+		a.t.Fatal(err)
+	}
+	if session := string(resp.Header.Peek("X-Admin-Session")); len(session) > 0 {
+		a.session = session
+	}
+	return resp.StatusCode(), append([]byte(nil), resp.Body()...)
+}
+
+func TestAdminWebhookRoutes(t *testing.T) {
+	useAdmin(t)
+	hooks := useWebhookStandIn(t)
+	client := serve(t)
+	admin := &adminClient{t: t, client: client}
+
+	status, body := admin.do(fasthttp.MethodPost, "/admin/webhooks", url.Values{"url": {"https://partner.test/hook"}, "cats": {"science"}})
+	if status != fasthttp.StatusCreated {
+		t.Fatalf("create: status %d: %s", status, body)
+	}
+	var made sql.Webhook
+	if err := json.Unmarshal(body, &made); err != nil || made.Uid != 1 || made.Secret != "whsec_made" || made.Topic != "general" || made.Cats != "science" {
+		t.Errorf("create replied %s, %v, want the secret and the general topic", body, err)
+	}
+	status, body = admin.do(fasthttp.MethodPost, "/admin/webhooks", url.Values{"url": {"http://other.test/in"}, "secret": {"mine"}, "topic": {"sport"}})
+	if status != fasthttp.StatusCreated {
+		t.Errorf("create with a secret: status %d: %s", status, body)
+	}
+	for _, bad := range []string{"", "partner.test/hook", "ftp://partner.test/hook", "https://", "javascript:alert(1)", "::"} {
+		if status, _ := admin.do(fasthttp.MethodPost, "/admin/webhooks", url.Values{"url": {bad}}); status != fasthttp.StatusBadRequest {
+			t.Errorf("create with url %q: status %d, want 400", bad, status)
+		}
+	}
+
+	status, body = admin.do(fasthttp.MethodGet, "/admin/webhooks", nil)
+	var listed []sql.Webhook
+	if err := json.Unmarshal(body, &listed); status != fasthttp.StatusOK || err != nil || len(listed) != 2 {
+		t.Fatalf("list: status %d, %v: %s", status, err, body)
+	}
+	for _, hook := range listed {
+		if len(hook.Secret) > 0 {
+			t.Errorf("listed the secret of %d", hook.Uid)
+		}
+	}
+
+	status, body = admin.do(fasthttp.MethodDelete, "/admin/webhooks/1", nil)
+	var removed sql.Webhook
+	if err := json.Unmarshal(body, &removed); status != fasthttp.StatusOK || err != nil || removed.Uid != 1 || removed.Live {
+		t.Errorf("delete: status %d, %v: %s", status, err, body)
+	}
+	if status, _ := admin.do(fasthttp.MethodDelete, "/admin/webhooks/9", nil); status != fasthttp.StatusNotFound {
+		t.Errorf("delete a missing webhook: status %d, want 404", status)
+	}
+	if status, _ := admin.do(fasthttp.MethodDelete, "/admin/webhooks/one", nil); status != fasthttp.StatusBadRequest {
+		t.Errorf("delete with a bad uid: status %d, want 400", status)
+	}
+	if !hooks.hooks[1].Live {
+		t.Error("the other webhook was stopped")
+	}
+
+	// None of it without the admin key, however good the session.
+	admin.key = "guess"
+	for _, method := range []string{fasthttp.MethodGet, fasthttp.MethodPost} {
+		if status, _ := admin.do(method, "/admin/webhooks", url.Values{"url": {"https://partner.test/hook"}}); status != fasthttp.StatusForbidden {
+			t.Errorf("%s with a wrong key: status %d, want 403", method, status)
+		}
+	}
+	if len(hooks.hooks) != 2 {
+		t.Errorf("%d webhooks made, want 2", len(hooks.hooks))
+	}
+}
+
+func TestAdminPagingRefused(t *testing.T) {
+	old := listDeadLetters
+	t.Cleanup(func() { listDeadLetters = old })
+	var asked []int
+	listDeadLetters = func(ctx context.Context, webhook int64, limit int, offset int) ([]sql.DeadLetter, error) {
+		asked = append(asked, limit, offset)
+		return nil, nil
+	}
+	for _, query := range []string{"offset=-1", "offset=ten", "offset=1.5", "limit=ten"} {
+		for name, handler := range map[string]fasthttp.RequestHandler{"accounts": adminList, "dead letters": adminDeadLetters} {
+			if status, body := call(handler, "/admin?"+query); status != fasthttp.StatusBadRequest {
+				t.Errorf("%s %s: status %d, want 400: %s", name, query, status, body)
+			}
+		}
+	}
+	if len(asked) > 0 {
+		t.Errorf("listed with %v for a bad page", asked)
+	}
+	if status, body := call(adminDeadLetters, "/admin/deadletters?offset=20&limit=5"); status != fasthttp.StatusOK || string(body) != "[]" {
+		t.Errorf("status %d: %s", status, body)
+	}
+	if status, _ := call(adminDeadLetters, "/admin/deadletters?offset="); status != fasthttp.StatusOK {
+		t.Errorf("an empty offset: status %d", status)
+	}
+	if len(asked) != 4 || asked[0] != 5 || asked[1] != 20 || asked[3] != 0 {
+		t.Errorf("listed with limits and offsets %v, want 5 from 20 then from 0", asked)
+	}
+}
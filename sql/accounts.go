@@ -0,0 +1,207 @@
+package sql
+
+import (
+	"[app name]/lib"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+/****************************************************************************
+ *                                        _    _____           _
+ *         /\                            | |  / ____|         | |
+ *        /  \   ___ ___ ___  _   _ _ __ | |_| |     __ _  ___| |__   ___
+ *       / /\ \ / __/ __/ _ \| | | | '_ \| __| |    / _` |/ __| '_ \ / _ \
+ *      / ____ \ (_| (_| (_) | |_| | | | | |_| |___| (_| | (__| | | |  __/
+ *     /_/    \_\___\___\___/ \__,_|_| |_|\__|\_____\__,_|\___|_| |_|\___|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * The api accounts held in memory for the request path. Every read and write
+ * goes through the lock, and the usage counted since the last flush is held
+ * alongside so a reload never drops an increment that is still in flight.
+ * ----------------------------------------------------------------------- */
+type AccountCache struct {
+	mu       sync.RWMutex
+	accounts map[int64]AccountsStruct // By urn.
+	keys     map[string]int64         // SHA-256 of each live key, to the urn it belongs to.
+	pending  map[int64]int64          // Usage counted since the last flush, by urn.
+}
+
+func NewAccountCache() *AccountCache {
+	return &AccountCache{
+		accounts: make(map[int64]AccountsStruct),
+		keys:     make(map[string]int64),
+		pending:  make(map[int64]int64),
+	}
+}
+
+// The account an api key belongs to, with KeyHash set to the hash of that key.
+func (c *AccountCache) Get(apikey string) (account AccountsStruct, ok bool) {
+	keyHash := HashKey(apikey)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	urn, ok := c.keys[keyHash]
+	if ok {
+		account, ok = c.accounts[urn]
+		account.KeyHash = keyHash
+	}
+	return
+}
+
+// Adds or updates an account under an api key, account.Urn ties the key to the account.
+func (c *AccountCache) Put(apikey string, account AccountsStruct) {
+	keyHash := HashKey(apikey)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	account.KeyHash = ""
+	c.keys[keyHash] = account.Urn
+	c.accounts[account.Urn] = account
+}
+
+// Count usage against the account the key belongs to, returns false if there is none.
+func (c *AccountCache) Use(apikey string, count int64) bool {
+	keyHash := HashKey(apikey)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	urn, ok := c.keys[keyHash]
+	if ok {
+		account := c.accounts[urn]
+		account.Used += count
+		c.accounts[urn] = account
+		c.pending[urn] += count
+	}
+	return ok
+}
+
+// Number of accounts held.
+func (c *AccountCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.accounts)
+}
+
+// A copy of the accounts by urn, safe to range over while the cache is in use.
+func (c *AccountCache) Snapshot() map[int64]AccountsStruct {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	snapshot := make(map[int64]AccountsStruct, len(c.accounts))
+	for urn, account := range c.accounts {
+		snapshot[urn] = account
+	}
+	return snapshot
+}
+
+/*********************************************************************************
+ *      _____            _
+ *     |  __ \          | |
+ *     | |__) |___ _ __ | | __ _  ___ ___
+ *     |  _  // _ \ '_ \| |/ _` |/ __/ _ \
+ *     | | \ \  __/ |_) | | (_| | (_|  __/
+ *     |_|  \_\___| .__/|_|\__,_|\___\___|
+ *                | |
+ *                |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Swap in a fresh load of the accounts, keyed by key hash. Usage counted here and
+ * not yet flushed is added back on, since the load can not have seen it.
+ * ---------------------------------------------------------------------------- */
+func (c *AccountCache) Replace(loaded map[string]AccountsStruct) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	accounts := make(map[int64]AccountsStruct, len(loaded))
+	keys := make(map[string]int64, len(loaded))
+	for keyHash, account := range loaded {
+		keys[keyHash] = account.Urn
+		if _, done := accounts[account.Urn]; !done {
+			account.KeyHash = ""
+			account.Used += c.pending[account.Urn]
+			accounts[account.Urn] = account
+		}
+	}
+	c.accounts = accounts
+	c.keys = keys
+}
+
+// Hand over the pending usage for flushing, the cache starts counting afresh.
+func (c *AccountCache) takePending() (pending map[int64]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending = c.pending
+	c.pending = make(map[int64]int64)
+	return
+}
+
+// Put back usage that failed to flush, so the next flush tries it again.
+func (c *AccountCache) restorePending(pending map[int64]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for urn, count := range pending {
+		c.pending[urn] += count
+	}
+}
+
+/*********************************************************************************************
+ *      _  __                                                _       ______             _
+ *     | |/ /                 /\                            | |     |  ____|           | |
+ *     | ' / ___  ___ _ __   /  \   ___ ___ ___  _   _ _ __ | |_ ___| |__ _ __ ___  ___| |__
+ *     |  < / _ \/ _ \ '_ \ / /\ \ / __/ __/ _ \| | | | '_ \| __/ __|  __| '__/ _ \/ __| '_ \
+ *     | . \  __/  __/ |_) / ____ \ (_| (_| (_) | |_| | | | | |_\__ \ |  | | |  __/\__ \ | | |
+ *     |_|\_\___|\___| .__/_/    \_\___\___\___/ \__,_|_| |_|\__|___/_|  |_|  \___||___/_| |_|
+ *                   | |
+ *                   |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Reloads the accounts every interval so new and changed rows reach the
+ * request path, runs for the life of the app.
+ * ---------------------------------------------------------------------------------------- */
+func KeepAccountsFresh(interval time.Duration) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Error("Account refresh loop:", r)
+		}
+	}()
+	for {
+		time.Sleep(interval)
+		LoadAccounts()
+		lib.Debug("Accounts refreshed:", Accounts.Len())
+	}
+}
+
+/*********************************************************************************
+ *      _   _               _  __
+ *     | \ | |             | |/ /
+ *     |  \| | _____      _| ' / ___ _   _
+ *     | . ` |/ _ \ \ /\ / /  < / _ \ | | |
+ *     | |\  |  __/\ V  V /| . \  __/ |_| |
+ *     |_| \_|\___| \_/\_/ |_|\_\___|\__, |
+ *                                    __/ |
+ *                                   |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * A new api key, a recognisable prefix and 160 random bits. Only its hash is ever
+ * stored, so the key can be shown once when it is made and never again. The old
+ * email derived keys were carried over by migration 0003 to expire after 30 days,
+ * their holders rotate to one of these before then.
+ * ---------------------------------------------------------------------------- */
+func NewKey() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); lib.CheckErr(err) {
+		panic(err)
+	}
+	return KeyPrefix + hex.EncodeToString(b)
+}
+
+const KeyPrefix = "news_"
+
+// The SHA-256 of an api key, hex encoded the same way as MySQL's SHA2(key, 256).
+func HashKey(apikey string) string {
+	sum := sha256.Sum256([]byte(apikey))
+	return hex.EncodeToString(sum[:])
+}
+
+// The last few characters of a key, stored so people can tell their keys apart.
+func keyHint(apikey string) string {
+	if len(apikey) < 4 {
+		return apikey
+	}
+	return apikey[len(apikey)-4:]
+}
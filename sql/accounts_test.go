@@ -0,0 +1,98 @@
+package sql
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Run with -race. Readers, counters, reloads and flushes all at once, and no usage lost.
+func TestAccountCacheConcurrent(t *testing.T) {
+	const (
+		keys   = 8
+		rounds = 2000
+	)
+	c := NewAccountCache()
+	loaded := make(map[string]AccountsStruct, keys)
+	for i := 0; i < keys; i++ {
+		key := "key-" + strconv.Itoa(i)
+		account := AccountsStruct{Urn: int64(i + 1), Plan: "BASIC", Allocated: 1000000}
+		c.Put(key, account)
+		loaded[HashKey(key)] = account
+	}
+
+	var wg sync.WaitGroup
+	var flushedLock sync.Mutex
+	flushed := make(map[int64]int64)
+	for i := 0; i < keys; i++ {
+		key := "key-" + strconv.Itoa(i)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < rounds; n++ {
+				if !c.Use(key, 1) {
+					t.Errorf("%s lost from the cache", key)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for n := 0; n < rounds; n++ {
+				if _, ok := c.Get(key); !ok {
+					t.Errorf("%s not found", key)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < rounds/10; n++ {
+			c.Replace(loaded)
+			_ = c.Snapshot()
+			_ = c.Len()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for n := 0; n < rounds/10; n++ {
+			pending := c.takePending()
+			if n%3 == 0 { // A failed flush now and then.
+				c.restorePending(pending)
+				continue
+			}
+			flushedLock.Lock()
+			for urn, count := range pending {
+				flushed[urn] += count
+			}
+			flushedLock.Unlock()
+		}
+	}()
+	wg.Wait()
+
+	for urn, count := range c.takePending() {
+		flushed[urn] += count
+	}
+	for i := 0; i < keys; i++ {
+		if flushed[int64(i+1)] != rounds {
+			t.Errorf("urn %d: %d uses flushed, want %d", i+1, flushed[int64(i+1)], rounds)
+		}
+	}
+}
+
+func TestAccountCacheReplaceKeepsPending(t *testing.T) {
+	c := NewAccountCache()
+	c.Put("key", AccountsStruct{Urn: 1, Used: 10})
+	c.Use("key", 4)
+	c.Replace(map[string]AccountsStruct{HashKey("key"): {Urn: 1, Used: 10}})
+	if account, _ := c.Get("key"); account.Used != 14 {
+		t.Errorf("used %d after a reload, want the 10 loaded and the 4 not yet flushed", account.Used)
+	}
+	c.takePending()
+	c.Replace(map[string]AccountsStruct{HashKey("key"): {Urn: 1, Used: 14}})
+	if account, _ := c.Get("key"); account.Used != 14 {
+		t.Errorf("used %d after a flush and reload, want 14", account.Used)
+	}
+}
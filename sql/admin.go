@@ -0,0 +1,280 @@
+package sql
+
+import (
+	"[app name]/lib"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+/****************************************************************************
+ *                                        _   _____                        _
+ *         /\                            | | |  __ \                      | |
+ *        /  \   ___ ___ ___  _   _ _ __ | |_| |__) |___  ___ ___  _ __ __| |
+ *       / /\ \ / __/ __/ _ \| | | | '_ \| __|  _  // _ \/ __/ _ \| '__/ _` |
+ *      / ____ \ (_| (_| (_) | |_| | | | | |_| | \ \  __/ (_| (_) | | | (_| |
+ *     /_/    \_\___\___\___/ \__,_|_| |_|\__|_|  \_\___|\___\___/|_|  \__,_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * An accounts row as the admin api sees it, revoked accounts included.
+ * ----------------------------------------------------------------------- */
+type AccountRecord struct {
+	Urn       int64     `json:"urn"`
+	Apikey    string    `json:"apikey,omitempty"` // Only on the reply that made the key.
+	Email     string    `json:"email"`
+	Plan      string    `json:"plan"`
+	Allocated int64     `json:"allocated"`
+	Used      int64     `json:"used"`
+	End       time.Time `json:"end"`
+	Live      bool      `json:"live"`
+	Pending   bool      `json:"pending"` // Signed up, email not verified yet.
+	Created   time.Time `json:"created"`
+}
+
+// A key as the admin api sees it, only the hint of the key itself is kept.
+type KeyRecord struct {
+	Uid     int64      `json:"uid"`
+	Hint    string     `json:"hint"`
+	Live    bool       `json:"live"`
+	Expires *time.Time `json:"expires"`
+	Created time.Time  `json:"created"`
+}
+
+const accountColumns = "urn, email, plan, allocated, used, end, live, pending, timestamp"
+
+func scanAccount(row interface{ Scan(...interface{}) error }) (a AccountRecord, err error) {
+	err = row.Scan(&a.Urn, &a.Email, &a.Plan, &a.Allocated, &a.Used, &a.End, &a.Live, &a.Pending, &a.Created)
+	return
+}
+
+/**************************************************************
+ *       _____      _                                     _
+ *      / ____|    | |     /\                            | |
+ *     | |  __  ___| |_   /  \   ___ ___ ___  _   _ _ __ | |_
+ *     | | |_ |/ _ \ __| / /\ \ / __/ __/ _ \| | | | '_ \| __|
+ *     | |__| |  __/ |_ / ____ \ (_| (_| (_) | |_| | | | | |_
+ *      \_____|\___|\__/_/    \_\___\___\___/ \__,_|_| |_|\__|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * A single account by its urn, sql.ErrNoRows if there is none.
+ * --------------------------------------------------------- */
+func GetAccount(ctx context.Context, urn int64) (a AccountRecord, err error) {
+	a, err = scanAccount(db.QueryRowContext(ctx, "SELECT "+accountColumns+" FROM accounts WHERE urn = ? ;", urn))
+	if err != nil && err != sql.ErrNoRows {
+		lib.CheckErr(err)
+	}
+	return
+}
+
+/*********************************************************************************
+ *      _      _     _                                     _
+ *     | |    (_)   | |     /\                            | |
+ *     | |     _ ___| |_   /  \   ___ ___ ___  _   _ _ __ | |_ ___
+ *     | |    | / __| __| / /\ \ / __/ __/ _ \| | | | '_ \| __/ __|
+ *     | |____| \__ \ |_ / ____ \ (_| (_| (_) | |_| | | | | |_\__ \
+ *     |______|_|___/\__/_/    \_\___\___\___/ \__,_|_| |_|\__|___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Accounts whose email contains the search, optionally on one plan, newest first.
+ * ---------------------------------------------------------------------------- */
+func ListAccounts(ctx context.Context, search string, plan string, limit int, offset int) (aList []AccountRecord, err error) {
+	sqlString := "SELECT " + accountColumns + " FROM accounts WHERE email LIKE ?"
+	args := []interface{}{likeTerm(search)}
+	if len(plan) > 0 {
+		sqlString += " AND plan = ?"
+		args = append(args, plan)
+	}
+	sqlString += " ORDER BY urn DESC LIMIT ? OFFSET ? ;"
+	rows, err := db.QueryContext(ctx, sqlString, append(args, limit, offset)...)
+	if lib.CheckErr(err) {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if lib.CheckErr(err) {
+			return aList, err
+		}
+		aList = append(aList, a)
+	}
+	err = rows.Err()
+	return
+}
+
+/****************************************************************************
+ *       _____                _                                         _
+ *      / ____|              | |         /\                            | |
+ *     | |     _ __ ___  __ _| |_ ___   /  \   ___ ___ ___  _   _ _ __ | |_
+ *     | |    | '__/ _ \/ _` | __/ _ \ / /\ \ / __/ __/ _ \| | | | '_ \| __|
+ *     | |____| | |  __/ (_| | ||  __// ____ \ (_| (_| (_) | |_| | | | | |_
+ *      \_____|_|  \___|\__,_|\__\___/_/    \_\___\___\___/ \__,_|_| |_|\__|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Creates an account on the plan with the plan allocation, and its first api
+ * key. The key is returned in the record, this is the only time it is seen.
+ * ----------------------------------------------------------------------- */
+func CreateAccount(ctx context.Context, email string, plan string) (a AccountRecord, err error) {
+	if _, ok := Plans[plan]; !ok {
+		return a, fmt.Errorf("unknown plan: %s", plan)
+	}
+	apikey := NewKey()
+	tx, err := db.BeginTx(ctx, nil)
+	if lib.CheckErr(err) {
+		return
+	}
+	res, err := tx.ExecContext(ctx, "INSERT INTO accounts (apikey, email, plan, allocated) VALUES (?,?,?,?) ;", HashKey(apikey), email, plan, Plans[plan].Allocated)
+	if err != nil {
+		lib.CheckErr(tx.Rollback())
+		return
+	}
+	urn, err := res.LastInsertId()
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "INSERT INTO apikeys (urn, keyhash, hint) VALUES (?,?,?) ;", urn, HashKey(apikey), keyHint(apikey))
+	}
+	if lib.CheckErr(err) {
+		lib.CheckErr(tx.Rollback())
+		return
+	}
+	if err = tx.Commit(); lib.CheckErr(err) {
+		return
+	}
+	lib.Log(ctx).Info("Account created:", urn, email, plan)
+	LoadAccounts()
+	a, err = GetAccount(ctx, urn)
+	a.Apikey = apikey
+	return
+}
+
+// Moves the account to another plan, along with that plan's allocation.
+func SetAccountPlan(ctx context.Context, urn int64, plan string) error {
+	if _, ok := Plans[plan]; !ok {
+		return fmt.Errorf("unknown plan: %s", plan)
+	}
+	return updateAccount(ctx, urn, "UPDATE accounts SET plan = ?, allocated = ? WHERE urn = ? ;", plan, Plans[plan].Allocated, urn)
+}
+
+// Sets the allocation for the current period, the next roll over resets it to the plan.
+func SetAccountAllocation(ctx context.Context, urn int64, allocated int64) error {
+	return updateAccount(ctx, urn, "UPDATE accounts SET allocated = ? WHERE urn = ? ;", allocated, urn)
+}
+
+// Moves the end of the current period, the periods after it end on the same day of the month.
+func SetAccountEnd(ctx context.Context, urn int64, end time.Time) error {
+	return updateAccount(ctx, urn, "UPDATE accounts SET end = ?, anchor = ? WHERE urn = ? ;", end, end.Day(), urn)
+}
+
+// Revoked accounts stay in the table but are no longer loaded, so their key stops working.
+func RevokeAccount(ctx context.Context, urn int64) error {
+	return updateAccount(ctx, urn, "UPDATE accounts SET live = 0, pending = 0 WHERE urn = ? ;", urn)
+}
+
+/*****************************************************************************************
+ *      _____       _        _                                         _   _  __
+ *     |  __ \     | |      | |         /\                            | | | |/ /
+ *     | |__) |___ | |_ __ _| |_ ___   /  \   ___ ___ ___  _   _ _ __ | |_| ' / ___ _   _
+ *     |  _  // _ \| __/ _` | __/ _ \ / /\ \ / __/ __/ _ \| | | | '_ \| __|  < / _ \ | | |
+ *     | | \ \ (_) | || (_| | ||  __// ____ \ (_| (_| (_) | |_| | | | | |_| . \  __/ |_| |
+ *     |_|  \_\___/ \__\__,_|\__\___/_/    \_\___\___\___/ \__,_|_| |_|\__|_|\_\___|\__, |
+ *                                                                                   __/ |
+ *                                                                                  |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Gives the account a new api key. The keys it already has keep working for
+ * the grace period, so callers can move over, and then expire.
+ * ------------------------------------------------------------------------------------ */
+func RotateAccountKey(ctx context.Context, urn int64, grace time.Duration) (apikey string, err error) {
+	if _, err = GetAccount(ctx, urn); err != nil {
+		return
+	}
+	apikey = NewKey()
+	expires := time.Now().Add(grace)
+	tx, err := db.BeginTx(ctx, nil)
+	if lib.CheckErr(err) {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE apikeys SET expires = ? WHERE urn = ? AND live = 1 AND (expires IS NULL OR expires > ?) ;", expires, urn, expires)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "INSERT INTO apikeys (urn, keyhash, hint) VALUES (?,?,?) ;", urn, HashKey(apikey), keyHint(apikey))
+	}
+	if lib.CheckErr(err) {
+		lib.CheckErr(tx.Rollback())
+		return "", err
+	}
+	if err = tx.Commit(); lib.CheckErr(err) {
+		return "", err
+	}
+	lib.Log(ctx).Info("Account key rotated:", urn, "old keys expire:", expires)
+	LoadAccounts()
+	return
+}
+
+/****************************************************************
+ *                                        _   _  __
+ *         /\                            | | | |/ /
+ *        /  \   ___ ___ ___  _   _ _ __ | |_| ' / ___ _   _ ___
+ *       / /\ \ / __/ __/ _ \| | | | '_ \| __|  < / _ \ | | / __|
+ *      / ____ \ (_| (_| (_) | |_| | | | | |_| . \  __/ |_| \__ \
+ *     /_/    \_\___\___\___/ \__,_|_| |_|\__|_|\_\___|\__, |___/
+ *                                                      __/ |
+ *                                                     |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * The keys of an account, newest first.
+ * ----------------------------------------------------------- */
+func AccountKeys(ctx context.Context, urn int64) (kList []KeyRecord, err error) {
+	rows, err := db.QueryContext(ctx, "SELECT uid, hint, live, expires, timestamp FROM apikeys WHERE urn = ? ORDER BY uid DESC ;", urn)
+	if lib.CheckErr(err) {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k KeyRecord
+		var expires sql.NullTime
+		if err = rows.Scan(&k.Uid, &k.Hint, &k.Live, &expires, &k.Created); lib.CheckErr(err) {
+			return
+		}
+		if expires.Valid {
+			k.Expires = &expires.Time
+		}
+		kList = append(kList, k)
+	}
+	err = rows.Err()
+	return
+}
+
+// Stops a single key working, the account and its other keys carry on. ErrNoRows if the
+// account has no live key by that uid, it never had one or it is already revoked.
+func RevokeAccountKey(ctx context.Context, urn int64, uid int64) (err error) {
+	if _, err = GetAccount(ctx, urn); err != nil {
+		return
+	}
+	switch runSQL(ctx, "UPDATE apikeys SET live = 0 WHERE urn = ? AND uid = ? AND live = 1 ;", urn, uid) {
+	case -1:
+		return fmt.Errorf("unable to revoke key %d of account %d", uid, urn)
+	case 0:
+		return sql.ErrNoRows
+	}
+	lib.Log(ctx).Info("Account key revoked:", urn, uid)
+	LoadAccounts()
+	return
+}
+
+/*****************************************************************************
+ *                      _       _                                         _
+ *                     | |     | |         /\                            | |
+ *      _   _ _ __   __| | __ _| |_ ___   /  \   ___ ___ ___  _   _ _ __ | |_
+ *     | | | | '_ \ / _` |/ _` | __/ _ \ / /\ \ / __/ __/ _ \| | | | '_ \| __|
+ *     | |_| | |_) | (_| | (_| | ||  __// ____ \ (_| (_| (_) | |_| | | | | |_
+ *      \__,_| .__/ \__,_|\__,_|\__\___/_/    \_\___\___\___/ \__,_|_| |_|\__|
+ *           | |
+ *           |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Runs an update against one account and reloads the cache, so the change is
+ * seen by the request path at once.
+ * ------------------------------------------------------------------------ */
+func updateAccount(ctx context.Context, urn int64, sqlStatement string, args ...interface{}) (err error) {
+	if _, err = GetAccount(ctx, urn); err != nil {
+		return
+	}
+	if runSQL(ctx, sqlStatement, args...) < 0 {
+		return fmt.Errorf("unable to update account %d", urn)
+	}
+	lib.Log(ctx).Info("Account updated:", urn, sqlStatement)
+	LoadAccounts()
+	return
+}
@@ -0,0 +1,78 @@
+package sql
+
+import (
+	"[app name]/lib"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRevokeAccountKey(t *testing.T) {
+	old := Accounts
+	t.Cleanup(func() { Accounts = old })
+	Accounts = NewAccountCache()
+	r := useRecorder(t)
+	ctx := context.Background()
+	now := time.Now()
+	r.answer(t, "FROM accounts WHERE urn = ?", []driver.Value{int64(7), "a@example.com", "FREE", int64(500), int64(0), now, true, false, now})
+
+	r.affect(1)
+	if err := RevokeAccountKey(ctx, 7, 3); err != nil {
+		t.Errorf("revoking a live key: %v", err)
+	}
+	r.affect(0)
+	if err := RevokeAccountKey(ctx, 7, 4); err != sql.ErrNoRows {
+		t.Errorf("revoking a key the account does not have: %v, want sql.ErrNoRows", err)
+	}
+	r.answer(t, "FROM accounts WHERE urn = ?")
+	if err := RevokeAccountKey(ctx, 8, 3); err != sql.ErrNoRows {
+		t.Errorf("revoking a key of no account: %v, want sql.ErrNoRows", err)
+	}
+}
+
+// Keeps the entries logged while it is added.
+type entrySink struct {
+	lock    sync.Mutex
+	entries []lib.LogEntry
+}
+
+func (s *entrySink) Write(e lib.LogEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+func (s *entrySink) Close() error { return nil }
+
+func TestAdminLogsRequestId(t *testing.T) {
+	old, debug := Accounts, lib.DebugLevel
+	sink := &entrySink{}
+	t.Cleanup(func() {
+		Accounts, lib.DebugLevel = old, debug
+		lib.RemoveLogSink(sink)
+	})
+	Accounts = NewAccountCache()
+	lib.DebugLevel = "DEBUG INFO WARN ERROR CRIT"
+	lib.AddLogSink(sink)
+	r := useRecorder(t)
+	now := time.Now()
+	r.answer(t, "FROM accounts WHERE urn = ?", []driver.Value{int64(7), "a@example.com", "FREE", int64(500), int64(0), now, true, false, now})
+
+	if err := RevokeAccountKey(lib.WithRequestId(context.Background(), "req-7"), 7, 3); err != nil {
+		t.Fatal(err)
+	}
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if len(sink.entries) == 0 {
+		t.Fatal("nothing was logged")
+	}
+	for _, e := range sink.entries {
+		if len(e.Fields) != 2 || e.Fields[1] != "req-7" {
+			t.Errorf("logged without the request id: %s %v", e.Msg, e.Fields)
+		}
+	}
+}
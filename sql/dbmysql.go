@@ -3,12 +3,14 @@ package sql
 import (
 	"[app name]/conf"
 	"[app name]/lib"
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -45,6 +47,12 @@ func (a *ArticleDetail) Scan(value interface{}) error {
 	return nil
 }
 
+// The image link held in the detail, if there is one.
+func (a ArticleDetail) img() string {
+	img, _ := a["img"].(string)
+	return img
+}
+
 type HomeMadeArticle struct {
 	Title   string `json:"title"`
 	Content string `json:"desc"`
@@ -56,6 +64,8 @@ type HomeMadeArticle struct {
 }
 
 type AccountsStruct struct {
+	Urn       int64
+	KeyHash   string // Hash of the key the account was looked up by.
 	Email     string
 	Otpkey    string
 	Plan      string
@@ -66,7 +76,7 @@ type AccountsStruct struct {
 
 var (
 	db       *sql.DB
-	Accounts map[string]AccountsStruct
+	Accounts = NewAccountCache()
 )
 
 func InitDB() {
@@ -79,11 +89,27 @@ func InitDB() {
 
 	db = openDB()
 	lib.Debug("Check DB connection:", db.Ping())
+	if conf.MIGRATE {
+		ran, err := MigrateUp(false)
+		lib.Info("Migrations applied at startup:", len(ran))
+		if err != nil {
+			lib.Error("Migrations stopped:", err)
+		}
+	}
 
 	LoadAccounts()
+	go KeepAccountsFresh(time.Duration(conf.ACCOUNTREFRESH) * time.Second)
+	go KeepUsageFlushed(time.Duration(conf.USAGEFLUSH) * time.Second)
+	go KeepPeriodsRolled(conf.HEARTBEAT)
+}
+
+// Opens the database without any of the background work InitDB starts, for the commands.
+func OpenDB() error {
+	db = openDB()
+	return db.Ping()
 }
 
-// TODO save accounts to Database
+// Loads the live keys of the live accounts, adding on any usage counted here but not yet flushed.
 func LoadAccounts() {
 	defer func() {
 		r := recover()
@@ -92,32 +118,31 @@ func LoadAccounts() {
 		}
 	}()
 
-	rows, err := db.Query("SELECT apikey, email, otpkey, plan, allocated, used, end FROM accounts")
+	flushLock.RLock()
+	defer flushLock.RUnlock()
+	sqlString := `SELECT k.keyhash, a.urn, a.email, a.otpkey, a.plan, a.allocated, a.used, a.end
+		FROM apikeys k JOIN accounts a ON a.urn = k.urn
+		WHERE a.live = 1 AND k.live = 1 AND (k.expires IS NULL OR k.expires > NOW()) ;`
+	rows, err := db.Query(sqlString)
 	if lib.CheckErr(err) {
 		panic(err)
 	}
 	defer rows.Close()
-	Accounts = make(map[string]AccountsStruct) // reset the map. fresh reload.
+	loaded := make(map[string]AccountsStruct) // fresh reload by key hash, swapped in whole.
 
 	for rows.Next() {
 		var d AccountsStruct
-		var apikey string
-		err = rows.Scan(&apikey, &d.Email, &d.Otpkey, &d.Plan, &d.Allocated, &d.Used, &d.End)
+		var keyHash string
+		err = rows.Scan(&keyHash, &d.Urn, &d.Email, &d.Otpkey, &d.Plan, &d.Allocated, &d.Used, &d.End)
 		if lib.CheckErr(err) {
 			panic(err)
 		}
-
-		Accounts[apikey] = AccountsStruct{
-			Email:     d.Email,
-			Otpkey:    d.Otpkey,
-			Plan:      d.Plan,
-			Allocated: d.Allocated,
-			Used:      d.Used,
-			End:       d.End,
-		}
+		loaded[keyHash] = d
 	}
-
-	return
+	if lib.CheckErr(rows.Err()) {
+		panic(rows.Err())
+	}
+	Accounts.Replace(loaded)
 }
 
 /*******************************************************************
@@ -128,31 +153,39 @@ func LoadAccounts() {
  *     | | | | \__ \  __/ |  | |_ / ____ \| |  | |_| | (__| |  __/
  *     |_|_| |_|___/\___|_|   \__/_/    \_\_|   \__|_|\___|_|\___|
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
- * Inserts new articles into the datase, Rejects duplicates
+ * Inserts new articles into the datase, Rejects duplicates. Returns the new uid
  * ---------------------------------------------------------------- */
-func InsertArticle(topic string, title string, content string, name string, email string, cat string, link string, image string) (id int64, returnErr error) {
+func InsertArticle(ctx context.Context, topic string, title string, content string, name string, email string, cat string, link string, image string) (id int64, returnErr error) {
+	return insertArticle(ctx, Article{Topic: topic, Title: title, Content: content, Author: name, Email: email, Cat: cat, Link: link, Detail: ArticleDetail{"img": image}})
+}
+
+// The insert itself, keeping the whole detail and the created time if the article has one.
+func insertArticle(ctx context.Context, a Article) (id int64, returnErr error) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Error("Error Inserting Articles into Database:", r)
+			lib.Log(ctx).Error("Error Inserting Articles into Database:", r)
 		}
 	}()
 
 	id = 0
-	now := time.Now().Format("2006-01-02 15:04:05.0000")
+	created := time.Now() // When it came in, the control cursors count on it only going up.
+	now := created.Format("2006-01-02 15:04:05.0000")
 	sqlString := `INSERT INTO articles (title,content,author,email,topic,cat,link,detail,created) values(?,?,?,?,?,?,?,?,?);` // ON DUPLICATE KEY UPDATE rating = rating + 1`
 	var detail string
-	jsonByte, _ := json.Marshal(map[string]interface{}{"img": image})
+	jsonByte, _ := json.Marshal(map[string]interface{}(a.Detail))
 	detail = string(jsonByte)
 
-	res, err := db.Exec(sqlString, lib.TrimLen(title, 128), lib.NilString(content), lib.NilString(name), lib.NilString(email), lib.NilString(topic), lib.NilString(lib.TrimLen(cat, 512)), lib.NilString(link), lib.NilString(detail), now)
+	res, err := db.ExecContext(ctx, sqlString, lib.TrimLen(a.Title, 128), lib.NilString(a.Content), lib.NilString(a.Author), lib.NilString(a.Email), lib.NilString(a.Topic), lib.NilString(lib.TrimLen(a.Cat, 512)), lib.NilString(a.Link), lib.NilString(detail), now)
 	if err == nil {
-		id, _ = res.RowsAffected()
-		lastId, _ := res.LastInsertId()
-		lib.Info("Insert general Completed...", "id:", lastId, " rows:", id)
+		rows, _ := res.RowsAffected()
+		id, _ = res.LastInsertId()
+		lib.Log(ctx).Info("Insert general Completed...", "id:", id, " rows:", rows)
+		a.Uid, a.Created = id, created
+		publishArticle(a)
 	} else {
 		if !strings.Contains(err.Error(), "Duplicate") {
-			lib.Info("Insert Statement result...", err, "\nDEBUG:", sqlString, res)
+			lib.Log(ctx).Info("Insert Statement result...", err, "\nDEBUG:", sqlString, res)
 		}
 		returnErr = err
 	}
@@ -169,22 +202,21 @@ func InsertArticle(topic string, title string, content string, name string, emai
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
  * get the last article in the database.
  * ----------------------------------------------------------------------------- */
-func CheckControl(target string, platform string) {
+func CheckControl(ctx context.Context, target string, platform string) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Error("check control error:", r)
+			lib.Log(ctx).Error("check control error:", r)
 		}
 	}()
-	sqlStmt := fmt.Sprintf("SELECT count(*) from control where target = '%s';", target)
-	if CountSQL(sqlStmt) < 1 {
-		sqlStmt := fmt.Sprintf("INSERT INTO control (target,timestamp,lastupdate, platform, note) VALUES ('%[1]s','%[2]v','%[3]v', '%[4]s', '%[5]s');", target, time.Now().AddDate(0, 0, -1).Format("2006-01-02 15:04:05.0000"), time.Now().Format("2006-01-02 15:04:05.0000"), platform, "Created on first Touch")
-		retval := RunSQL(sqlStmt)
+	if countSQL(ctx, "SELECT count(*) FROM control WHERE target = ?;", target) < 1 {
+		sqlStmt := "INSERT INTO control (target,timestamp,lastupdate, platform, note) VALUES (?,?,?,?,?);"
+		retval := runSQL(ctx, sqlStmt, target, time.Now().AddDate(0, 0, -1).Format("2006-01-02 15:04:05.0000"), time.Now().Format("2006-01-02 15:04:05.0000"), platform, "Created on first Touch")
 		if retval < 1 {
-			lib.Error("Control Table problem:", sqlStmt)
+			lib.Log(ctx).Error("Control Table problem:", sqlStmt, target)
 			panic(retval)
 		}
-		lib.Info("Control Record Inserted:", retval)
+		lib.Log(ctx).Info("Control Record Inserted:", retval)
 	}
 }
 
@@ -196,41 +228,22 @@ func CheckControl(target string, platform string) {
  *      \__, |\___|\__/_/    \_\_|   \__|_|\___|_|\___|
  *       __/ |
  *      |___/                                             */
-func getArticle(sqlString string) (reply conf.Reply) {
+func getArticle(ctx context.Context, sqlString string, args ...interface{}) (reply conf.Reply) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Error("Getting Articles:", r)
+			lib.Log(ctx).Error("Getting Articles:", r)
 		}
 	}()
 
-	var aList []Article
-	reply = conf.Reply{Code: 206, Msg: "[]"}
-	rowCount := 0
-
-	lib.Debug("Getting Data:", sqlString)
-	rows, err := db.Query(sqlString)
-	lib.CheckErr(err)
-	for rows.Next() {
-		var a Article
-		lib.Debug("Counting", rowCount)
-		switch err := rows.Scan(&a.Uid, &a.Title, &a.Content, &a.Author, &a.Email, &a.Topic, &a.Cat, &a.Link, &a.Detail, &a.Rating, &a.Created); err {
-		case sql.ErrNoRows:
-			lib.Error("No rows retrieved", err)
-			reply.Code = 204
-			reply.Msg = "[]"
-		case nil:
-			rowCount++
-			lib.Debug("Result:", a)
-			aList = append(aList, a)
-		default:
-			reply.Code = 400
-			reply.Msg = fmt.Sprintf(`Error: %v`, err.Error())
-		}
-	}
-	if rowCount > 0 {
+	reply = conf.Reply{Code: 400, Msg: "Error: Unable to retrieve Articles"}
+	aList := queryArticles(ctx, sqlString, args...)
+	if len(aList) < 1 {
+		reply.Code = 206
+		reply.Msg = "[]"
+	} else {
+		lib.Log(ctx).Debug("Rows:", len(aList), aList)
 		jsonReply, err := json.MarshalIndent(aList, "", "")
-		lib.Debug("Reply:", jsonReply)
 		if !lib.CheckErr(err) {
 			reply.Code = 200
 			reply.Msg = string(jsonReply)
@@ -239,6 +252,40 @@ func getArticle(sqlString string) (reply conf.Reply) {
 	return
 }
 
+/*****************************************************************************
+ *                                                _   _      _
+ *                                     /\        | | (_)    | |
+ *       __ _ _   _  ___ _ __ _   _   /  \   _ __| |_ _  ___| | ___  ___
+ *      / _` | | | |/ _ \ '__| | | | / /\ \ | '__| __| |/ __| |/ _ \/ __|
+ *     | (_| | |_| |  __/ |  | |_| |/ ____ \| |  | |_| | (__| |  __/\__ \
+ *      \__, |\__,_|\___|_|   \__, /_/    \_\_|   \__|_|\___|_|\___||___/
+ *         | |                 __/ |
+ *         |_|                |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Runs an article SELECT with its values bound to the placeholders, and scans
+ * the rows. Panics on a database error so callers can recover and reply.
+ * ------------------------------------------------------------------------ */
+func queryArticles(ctx context.Context, sqlString string, args ...interface{}) (aList []Article) {
+	lib.Log(ctx).Debug("Getting Data:", sqlString, args)
+	rows, err := db.QueryContext(ctx, sqlString, args...)
+	if lib.CheckErr(err) {
+		panic(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a Article
+		err := rows.Scan(&a.Uid, &a.Title, &a.Content, &a.Author, &a.Email, &a.Topic, &a.Cat, &a.Link, &a.Detail, &a.Rating, &a.Created)
+		if lib.CheckErr(err) {
+			panic(err)
+		}
+		aList = append(aList, a)
+	}
+	if lib.CheckErr(rows.Err()) {
+		panic(rows.Err())
+	}
+	return
+}
+
 /*********************************************************************************
  *                 _   _               _                 _   _      _
  *                | | | |             | |     /\        | | (_)    | |
@@ -251,16 +298,14 @@ func getArticle(sqlString string) (reply conf.Reply) {
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
  * get the last article in the database.
  * ----------------------------------------------------------------------------- */
-func GetLastSingleJsonArticle(topic string, limit int) (reply conf.Reply) {
+func GetLastSingleJsonArticle(ctx context.Context, topic string, limit int) (reply conf.Reply) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Error("Getting last Articles:", r)
+			lib.Log(ctx).Error("Getting last Articles:", r)
 		}
 	}()
-	sqlArticles := fmt.Sprintf(`SELECT * FROM articles WHERE topic='%[2]s' ORDER BY uid DESC LIMIT %[1]v;`, limit, topic)
-	lib.Debug("Getting Data:", sqlArticles)
-	reply = getArticle(sqlArticles)
+	reply = getArticle(ctx, `SELECT * FROM articles WHERE topic = ? ORDER BY uid DESC LIMIT ?;`, topic, limit)
 	return
 }
 
@@ -277,36 +322,48 @@ func GetLastSingleJsonArticle(topic string, limit int) (reply conf.Reply) {
  * Gets the next article depending on the last Article ID from the control
  * ---------------------------------------------------------------------------- */
 func GetNextArticle(callerId string, platform string, topic string) (message string, Behind int) {
+	a, Behind, found := NextArticle(callerId, platform, topic, "")
+	if found {
+		if conf.NEWSDETAIL {
+			message = fmt.Sprintf("*%[1]s*\n _%[2]s_ [%[3]s]", a.Title, a.Content, a.Link, a.Rating)
+		} else {
+			message = fmt.Sprintf("*%[1]s*\n [%[2]s]", a.Title, a.Link)
+		}
+		lib.Debug(message)
+	}
+	return
+}
+
+// The next article for the target itself, for the publishers that lay it out their own way.
+// Keywords, as for GetNextArticleByKeyWords, skip the articles whose titles have none of them.
+// The control moves past it, Behind counts it along with the ones still to come.
+func NextArticle(callerId string, platform string, topic string, keywords string) (a Article, Behind int, found bool) {
 	defer func() {
 		r := recover()
 		if r != nil {
 			lib.Error("Get next article:", r)
 		}
 	}()
-	var a Article
-	CheckControl(callerId, platform)
-	sqlCount := fmt.Sprintf(`SELECT count(*) FROM articles WHERE topic = '%[2]s' AND created > (SELECT timestamp FROM control WHERE target = '%[1]s') ;`, callerId, topic)
-	rowCount := db.QueryRow(sqlCount)
+	CheckControl(context.Background(), callerId, platform)
+	where := `topic = ? AND created > (SELECT timestamp FROM control WHERE target = ?)`
+	args := []interface{}{topic, callerId}
+	filter, filterArgs := keywordFilter(keywords)
+	where += filter
+	args = append(args, filterArgs...)
+	rowCount := db.QueryRow(`SELECT count(*) FROM articles WHERE `+where+` ;`, args...)
 	switch err := rowCount.Scan(&Behind); err {
 	case sql.ErrNoRows:
 		lib.Warn("No rows.", err)
 	case nil: // No errors, and has rows
 
-		sqlArticle := fmt.Sprintf(`SELECT * FROM articles WHERE topic = '%[2]s' AND created > (SELECT timestamp FROM control WHERE target = '%[1]s') ORDER BY created LIMIT 1 ;`, callerId, topic)
-		row := db.QueryRow(sqlArticle)
+		row := db.QueryRow(`SELECT * FROM articles WHERE `+where+` ORDER BY created LIMIT 1 ;`, args...)
 		switch err := row.Scan(&a.Uid, &a.Title, &a.Content, &a.Author, &a.Email, &a.Topic, &a.Cat, &a.Link, &a.Detail, &a.Rating, &a.Created); err {
 		case sql.ErrNoRows:
 			lib.Warn("No rows, adding First record.", err)
 		case nil:
-			sqlStatement := fmt.Sprintf("UPDATE control SET timestamp = '%[1]s' WHERE target = '%[2]s';", a.Created.Format("2006-01-02 15:04:05.0000"), callerId)
-			lib.Debug("Update Control:", sqlStatement)
-			RunSQL(sqlStatement)
-			if conf.NEWSDETAIL {
-				message = fmt.Sprintf("*%[1]s*\n _%[2]s_ [%[3]s]", a.Title, a.Content, a.Link, a.Rating)
-			} else {
-				message = fmt.Sprintf("*%[1]s*\n [%[2]s]", a.Title, a.Link)
-			}
-			lib.Debug(message)
+			lib.Debug("Update Control:", callerId, a.Created)
+			RunSQL("UPDATE control SET timestamp = ? WHERE target = ?;", a.Created.Format("2006-01-02 15:04:05.0000"), callerId)
+			found = true
 		default: //This should never happen, but left in just in case.
 			lib.Debug("Panic?:", a)
 			panic(err)
@@ -320,6 +377,17 @@ func GetNextArticle(callerId string, platform string, topic string) (message str
 	return
 }
 
+// Starts or stops posting to a target, making its control record if it has none. A target
+// started again after a stop picks up from now, not from all that came in while it was off.
+func SetTargetLive(ctx context.Context, target string, platform string, live bool) bool {
+	CheckControl(ctx, target, platform)
+	if live { // The IF sees live as it was, MySQL sets the columns in order.
+		return runSQL(ctx, "UPDATE control SET timestamp = IF(live = 0, ?, timestamp), live = 1 WHERE target = ? AND platform = ? ;",
+			time.Now().Format("2006-01-02 15:04:05.0000"), target, platform) >= 0
+	}
+	return runSQL(ctx, "UPDATE control SET live = 0 WHERE target = ? AND platform = ? ;", target, platform) >= 0
+}
+
 /*******************************************************************************
  *                 _   _   _           _                 _   _      _      ____        _  __
  *                | | | \ | |         | |     /\        | | (_)    | |    |  _ \      | |/ /
@@ -334,21 +402,18 @@ func GetNextArticle(callerId string, platform string, topic string) (message str
  * ---------------------------------------------------------------------------- */
 func GetNextArticleByKeyWords(callerId string, keyword string, platform string, topic string) (message string) {
 	var a Article
-	CheckControl(callerId, platform)
-	keyword = strings.ReplaceAll(keyword, " ", "|")
-	sqlArticle := fmt.Sprintf(`SELECT * FROM articles WHERE topic = '%[2]s' created > (SELECT timestamp FROM control WHERE target = '%[1]s') ORDER BY created LIMIT 1 ;`, callerId, topic)
-	if len(keyword) > 3 {
-		sqlArticle = fmt.Sprintf(`SELECT * FROM articles WHERE topic = '%[3]s' title rlike '%[2]s' AND created > (SELECT timestamp FROM control WHERE target = '%[1]s') ORDER BY created LIMIT 1 ;`, callerId, keyword, topic)
-	}
-	lib.Debug("Collecting:", sqlArticle, "Message:", message)
-	row := db.QueryRow(sqlArticle)
+	CheckControl(context.Background(), callerId, platform)
+	filter, filterArgs := keywordFilter(keyword)
+	sqlArticle := `SELECT * FROM articles WHERE topic = ? AND created > (SELECT timestamp FROM control WHERE target = ?)` + filter + ` ORDER BY created LIMIT 1 ;`
+	args := append([]interface{}{topic, callerId}, filterArgs...)
+	lib.Debug("Collecting:", sqlArticle, args)
+	row := db.QueryRow(sqlArticle, args...)
 	switch err := row.Scan(&a.Uid, &a.Title, &a.Content, &a.Author, &a.Email, &a.Topic, &a.Cat, &a.Link, &a.Detail, &a.Rating, &a.Created); err {
 	case sql.ErrNoRows:
 		lib.Warn("No rows, adding First record.", err)
 	case nil:
-		sqlStatement := fmt.Sprintf("UPDATE control SET timestamp = '%[1]s' WHERE target = '%[2]s';", a.Created.Format("2006-01-02 15:04:05.0000"), callerId)
-		lib.Debug("Update Control:", sqlStatement)
-		RunSQL(sqlStatement)
+		lib.Debug("Update Control:", callerId, a.Created)
+		RunSQL("UPDATE control SET timestamp = ? WHERE target = ?;", a.Created.Format("2006-01-02 15:04:05.0000"), callerId)
 		if conf.NEWSDETAIL {
 			message = fmt.Sprintf("*%[1]s*\n _%[2]s_ [%[3]s]", a.Title, a.Content, a.Link)
 		} else {
@@ -374,49 +439,27 @@ func GetNextArticleByKeyWords(callerId string, keyword string, platform string,
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
  * Gets the next article depending on the last Article ID and return as a json object
  * ------------------------------------------------------------------------------------------------ */
-func GetLatestJsonArticle(callerId string, limit int, next bool, platform string, topic string) (reply conf.Reply) {
+func GetLatestJsonArticle(ctx context.Context, callerId string, limit int, next bool, platform string, topic string) (reply conf.Reply) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Error("Can not get the next Article:", r)
+			lib.Log(ctx).Error("Can not get the next Article:", r)
 		}
 	}()
-	var aList []Article
-	CheckControl(callerId, platform)
-	rowCount := 0
-	sqlArticles := ""
+	CheckControl(ctx, callerId, platform)
+	reply = conf.Reply{Code: 400, Msg: "Error: Unable to retrieve Articles"}
+	sqlArticles := `SELECT * FROM articles WHERE topic = ? AND created < (SELECT timestamp FROM control WHERE target = ?) ORDER BY created DESC LIMIT ? ;`
 	if next {
-		sqlArticles = fmt.Sprintf(`SELECT * FROM articles WHERE created > (SELECT timestamp FROM control WHERE target = '%[1]s' and topic = '%[3]s') ORDER BY created LIMIT %[2]v ;`, callerId, limit, topic)
-	} else {
-		sqlArticles = fmt.Sprintf(`SELECT * FROM articles WHERE created < (SELECT timestamp FROM control WHERE target = '%[1]s' and topic = '%[3]s') ORDER BY created DESC LIMIT %[2]v ;`, callerId, limit, topic)
+		sqlArticles = `SELECT * FROM articles WHERE topic = ? AND created > (SELECT timestamp FROM control WHERE target = ?) ORDER BY created LIMIT ? ;`
 	}
-	rows, err := db.Query(sqlArticles)
-	lib.CheckErr(err)
-	for rows.Next() {
-		var a Article
-		lib.Debug("Counting", rowCount)
-		switch err := rows.Scan(&a.Uid, &a.Title, &a.Content, &a.Author, &a.Email, &a.Cat, &a.Link, &a.Detail, &a.Rating, &a.Created); err {
-		case sql.ErrNoRows:
-			lib.Error("No rows retrieved", err)
-			reply.Code = 204
-			reply.Msg = "[]"
-		case nil:
-			rowCount++
-			lib.Debug("Result:", a)
-			aList = append(aList, a)
-		default:
-			reply.Code = 400
-			reply.Msg = fmt.Sprintf(`Error: %v`, err.Error())
-		}
-	}
-	if rowCount < 1 {
+	aList := queryArticles(ctx, sqlArticles, topic, callerId, limit)
+	if len(aList) < 1 {
 		reply.Code = 206
 		reply.Msg = "[]"
 	} else {
-		lib.Debug("Rows:", rowCount, aList)
-		sqlStatement := fmt.Sprintf("UPDATE control SET timestamp = '%[1]s' WHERE target = '%[2]s';", aList[0].Created.Format("2006-01-02 15:04:05.0000"), callerId)
-		lib.Debug("Update Control:", sqlStatement)
-		RunSQL(sqlStatement)
+		lib.Log(ctx).Debug("Rows:", len(aList), aList)
+		lib.Log(ctx).Debug("Update Control:", callerId, aList[0].Created)
+		RunSQL("UPDATE control SET timestamp = ? WHERE target = ?;", aList[0].Created.Format("2006-01-02 15:04:05.0000"), callerId)
 		jsonReply, err := json.MarshalIndent(aList, "", "")
 		if !lib.CheckErr(err) {
 			reply.Code = 200
@@ -438,47 +481,20 @@ func GetLatestJsonArticle(callerId string, limit int, next bool, platform string
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
  * Gets the next article depending on the last Article ID and return as a json object
  * ------------------------------------------------------------------------------------------------ */
-func GetJsonByArticleId(articleId string) (reply conf.Reply) {
+func GetJsonByArticleId(ctx context.Context, articleId string) (reply conf.Reply) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Error("Unable to retrieve Article:", r, articleId)
+			lib.Log(ctx).Error("Unable to retrieve Article:", r, articleId)
 		}
 	}()
-	var aList []Article
-	rowCount := 0
-	sqlArticles := ""
-	sqlArticles = fmt.Sprintf(`SELECT * FROM articles WHERE uid = %[1]s ;`, articleId)
-	rows, err := db.Query(sqlArticles)
-	lib.CheckErr(err)
-	for rows.Next() {
-		var a Article
-		lib.Debug("Counting", rowCount)
-		switch err := rows.Scan(&a.Uid, &a.Title, &a.Content, &a.Author, &a.Email, &a.Topic, &a.Cat, &a.Link, &a.Detail, &a.Rating, &a.Created); err {
-		case sql.ErrNoRows:
-			lib.Error("No rows retrieved", err)
-			reply.Code = 204
-			reply.Msg = "[]"
-		case nil:
-			rowCount++
-			lib.Debug("Result:", a)
-			aList = append(aList, a)
-		default:
-			reply.Code = 400
-			reply.Msg = fmt.Sprintf(`Error: %v`, err.Error())
-		}
-	}
-	if rowCount < 1 {
-		reply.Code = 206
-		reply.Msg = "[]"
-	} else {
-		lib.Debug("Rows:", rowCount, aList)
-		jsonReply, err := json.MarshalIndent(aList, "", "")
-		if !lib.CheckErr(err) {
-			reply.Code = 200
-			reply.Msg = string(jsonReply)
-		}
+	reply = conf.Reply{Code: 400, Msg: "Invalid Article ID"}
+	articleUid, err := strconv.ParseInt(articleId, 10, 64)
+	if lib.CheckErr(err) {
+		panic(err)
 	}
+	a, err := MysqlArticles{}.Get(ctx, articleUid)
+	reply = ArticlesReply([]Article{a}, err)
 	return
 }
 
@@ -494,55 +510,19 @@ func GetJsonByArticleId(articleId string) (reply conf.Reply) {
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
  * Gets the next article depending on the last Article ID and return as a json object
  * ------------------------------------------------------------------------------------------------------------------- */
-func GetNextJsonByArticleId(articleId string, limit int, filter string, topic string) (reply conf.Reply) {
+func GetNextJsonByArticleId(ctx context.Context, articleId string, limit int, filter string, topic string) (reply conf.Reply) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Error("Unable to retrieve Article:", r, articleId)
+			lib.Log(ctx).Error("Unable to retrieve Article:", r, articleId)
 		}
 	}()
-	var a Article
-	var aList []Article
-	rowCount := 0
-	articleUid, err := strconv.Atoi(articleId)
+	reply = conf.Reply{Code: 400, Msg: "Invalid Article ID"}
+	articleUid, err := strconv.ParseInt(articleId, 10, 64)
 	if lib.CheckErr(err) {
 		panic(err)
-	} else {
-		sqlArticles := fmt.Sprintf(`SELECT * FROM articles WHERE uid > %[1]v and topic = $[3]s ORDER BY uid ASC LIMIT %[2]v ;`, articleUid, limit, topic)
-		if len(filter) > 0 {
-			filter = strings.ReplaceAll(filter, ",", "|")
-			sqlArticles = fmt.Sprintf(`SELECT * FROM articles WHERE uid > %[1]v AND topic = '%[4]s' AND content rlike '%[3]s' ORDER BY uid ASC LIMIT %[2]v ;`, articleUid, limit, filter, topic)
-		}
-		rows, err := db.Query(sqlArticles)
-		lib.CheckErr(err)
-		for rows.Next() {
-			lib.Debug("Counting", rowCount)
-			switch err := rows.Scan(&a.Uid, &a.Title, &a.Content, &a.Author, &a.Email, &a.Topic, &a.Cat, &a.Link, &a.Detail, &a.Rating, &a.Created); err {
-			case sql.ErrNoRows:
-				lib.Error("No rows retrieved", err)
-				reply.Code = 204
-				reply.Msg = "[]"
-			case nil:
-				rowCount++
-				lib.Debug("Result:", a)
-				aList = append(aList, a)
-			default:
-				reply.Code = 400
-				reply.Msg = fmt.Sprintf(`Error: %v`, err.Error())
-			}
-		}
-	}
-	if rowCount < 1 {
-		reply.Code = 206
-		reply.Msg = "[]"
-	} else {
-		lib.Debug("Rows:", rowCount, aList)
-		jsonReply, err := json.MarshalIndent(aList, "", "")
-		if !lib.CheckErr(err) {
-			reply.Code = 200
-			reply.Msg = string(jsonReply)
-		}
 	}
+	reply = ArticlesReply(MysqlArticles{}.Next(ctx, articleUid, limit, filterTerms(filter), topic))
 	return
 }
 
@@ -558,56 +538,19 @@ func GetNextJsonByArticleId(articleId string, limit int, filter string, topic st
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
  * Gets the next article depending on the last Article ID and return as a json object
  * ------------------------------------------------------------------------------------------------------------------- */
-func GetPrevJsonByArticleId(articleId string, limit int, filter string, topic string) (reply conf.Reply) {
+func GetPrevJsonByArticleId(ctx context.Context, articleId string, limit int, filter string, topic string) (reply conf.Reply) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Error("Unable to retrieve Article:", r, articleId)
+			lib.Log(ctx).Error("Unable to retrieve Article:", r, articleId)
 		}
 	}()
-	var a Article
-	var aList []Article
-	rowCount := 0
-	articleUid, err := strconv.Atoi(articleId)
+	reply = conf.Reply{Code: 400, Msg: "Invalid Article ID"}
+	articleUid, err := strconv.ParseInt(articleId, 10, 64)
 	if lib.CheckErr(err) {
 		panic(err)
-	} else {
-		sqlArticles := fmt.Sprintf(`SELECT * FROM articles WHERE uid < %[1]v AND topic = '%[3]s' ORDER BY uid DESC LIMIT %[2]v ;`, articleUid, limit, topic)
-		if len(filter) > 0 {
-			filter = strings.ReplaceAll(filter, ",", "|")
-			sqlArticles = fmt.Sprintf(`SELECT * FROM articles WHERE uid < %[1]v AND topic = '%[4]s' AND content rlike '%[3]s' ORDER BY uid DESC LIMIT %[2]v ;`, articleUid, limit, filter, topic)
-		}
-		rows, err := db.Query(sqlArticles)
-		lib.CheckErr(err)
-		for rows.Next() {
-			lib.Debug("Counting", rowCount)
-			switch err := rows.Scan(&a.Uid, &a.Title, &a.Content, &a.Author, &a.Email, &a.Topic, &a.Cat, &a.Link, &a.Detail, &a.Rating, &a.Created); err {
-			case sql.ErrNoRows:
-				lib.Error("No rows retrieved", err)
-				reply.Code = 204
-				reply.Msg = "[]"
-			case nil:
-				rowCount++
-				lib.Debug("Result:", a)
-				aList = append(aList, a)
-			default:
-				reply.Code = 400
-				reply.Msg = fmt.Sprintf(`Error: %v`, err.Error())
-			}
-		}
-	}
-
-	if rowCount < 1 {
-		reply.Code = 206
-		reply.Msg = "[]"
-	} else {
-		lib.Debug("Rows:", rowCount, aList)
-		jsonReply, err := json.MarshalIndent(aList, "", "")
-		if !lib.CheckErr(err) {
-			reply.Code = 200
-			reply.Msg = string(jsonReply)
-		}
 	}
+	reply = ArticlesReply(MysqlArticles{}.Prev(ctx, articleUid, limit, filterTerms(filter), topic))
 	return
 }
 
@@ -623,55 +566,14 @@ func GetPrevJsonByArticleId(articleId string, limit int, filter string, topic st
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
  * Gets the next article depending on the last Article ID and return as a json object
  * ------------------------------------------------------------------------------------------------ */
-func GetJsonByTitle(search string, limit int, topic string) (reply conf.Reply) {
+func GetJsonByTitle(ctx context.Context, search string, limit int, topic string) (reply conf.Reply) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Error("Unable to retrieve Article through search:", r, search)
+			lib.Log(ctx).Error("Unable to retrieve Article through search:", r, search)
 		}
 	}()
-	var aList []Article
-	safeStr, err := url.QueryUnescape(search)
-	if !lib.CheckErr(err) {
-		searchStr := strings.ReplaceAll(safeStr, " ", "|")
-		rowCount := 0
-		sqlArticles := fmt.Sprintf(`SELECT * FROM articles WHERE topic = '%[3]s' title rlike '%[1]s' ORDER BY created DESC LIMIT %[2]v ;`, searchStr, limit, topic)
-		lib.Debug("Search String:", sqlArticles)
-		rows, err := db.Query(sqlArticles)
-		lib.CheckErr(err)
-		for rows.Next() {
-			var a Article
-			lib.Debug("Counting", rowCount)
-			switch err := rows.Scan(&a.Uid, &a.Title, &a.Content, &a.Author, &a.Email, &a.Topic, &a.Cat, &a.Link, &a.Detail, &a.Rating, &a.Created); err {
-			case sql.ErrNoRows:
-				lib.Error("No rows retrieved", err)
-				reply.Code = 204
-				reply.Msg = `[]`
-			case nil:
-				rowCount++
-				lib.Debug("Result:", a)
-				aList = append(aList, a)
-			default:
-				reply.Code = 400
-				reply.Msg = fmt.Sprintf(`Error: %v`, err.Error())
-			}
-		}
-		if rowCount < 1 {
-			reply.Code = 206
-			reply.Msg = "[]"
-		} else {
-			lib.Debug("Rows:", rowCount, aList)
-			jsonReply, err := json.MarshalIndent(aList, "", "")
-			if !lib.CheckErr(err) {
-				reply.Code = 200
-				reply.Msg = string(jsonReply)
-			}
-		}
-	} else {
-		reply.Msg = "Unable to decode the search string"
-		reply.Code = 400
-	}
-
+	reply = searchJsonByColumn(ctx, "title", search, limit, topic)
 	return
 }
 
@@ -687,55 +589,39 @@ func GetJsonByTitle(search string, limit int, topic string) (reply conf.Reply) {
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
  * Gets the next article depending on the last Article ID and return as a json object
  * ------------------------------------------------------------------------------------------------ */
-func GetJsonByContent(searchStr string, limit int, topic string) (reply conf.Reply) {
+func GetJsonByContent(ctx context.Context, searchStr string, limit int, topic string) (reply conf.Reply) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Error("Unable to retrieve Article through Content search:", r, searchStr)
+			lib.Log(ctx).Error("Unable to retrieve Article through Content search:", r, searchStr)
 		}
 	}()
-	var aList []Article
-	safeStr, err := url.QueryUnescape(searchStr)
-	if !lib.CheckErr(err) {
-		searchStr := strings.ReplaceAll(safeStr, " ", "|")
-		rowCount := 0
-		sqlArticles := fmt.Sprintf(`SELECT * FROM topic WHERE topic = '%[3]s' cat rlike '%[1]s' ORDER BY created DESC LIMIT %[2]v ;`, searchStr, limit, topic)
-		lib.Debug("Search String:", sqlArticles)
-		rows, err := db.Query(sqlArticles)
-		lib.CheckErr(err)
-		for rows.Next() {
-			var a Article
-			lib.Debug("Counting", rowCount)
-			switch err := rows.Scan(&a.Uid, &a.Title, &a.Content, &a.Author, &a.Email, &a.Topic, &a.Cat, &a.Link, &a.Detail, &a.Rating, &a.Created); err {
-			case sql.ErrNoRows:
-				lib.Error("No rows retrieved", err)
-				reply.Code = 204
-				reply.Msg = "[]"
-			case nil:
-				rowCount++
-				lib.Debug("Result:", a)
-				aList = append(aList, a)
-			default:
-				reply.Code = 400
-				reply.Msg = fmt.Sprintf(`Error: %v`, err.Error())
-			}
-		}
-		if rowCount < 1 {
-			reply.Code = 206
-			reply.Msg = "[]"
-		} else {
-			lib.Debug("Rows:", rowCount, aList)
-			jsonReply, err := json.MarshalIndent(aList, "", "")
-			if !lib.CheckErr(err) {
-				reply.Code = 200
-				reply.Msg = string(jsonReply)
-			}
-		}
-	} else {
+	reply = searchJsonByColumn(ctx, "content", searchStr, limit, topic)
+	return
+}
+
+/************************************************************************************************************
+ *                              _          _                 ____         _____      _
+ *                             | |        | |               |  _ \       / ____|    | |
+ *      ___  ___  __ _ _ __ ___| |__      | |___  ___  _ __ | |_) |_   _| |     ___ | |_   _ _ __ ___  _ __
+ *     / __|/ _ \/ _` | '__/ __| '_ \ _   | / __|/ _ \| '_ \|  _ <| | | | |    / _ \| | | | | '_ ` _ \| '_ \
+ *     \__ \  __/ (_| | | | (__| | | | |__| \__ \ (_) | | | | |_) | |_| | |___| (_) | | |_| | | | | | | | | |
+ *     |___/\___|\__,_|_|  \___|_| |_|\____/|___/\___/|_| |_|____/ \__, |\_____\___/|_|\__,_|_| |_| |_|_| |_|
+ *                                                                  __/ |
+ *                                                                 |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Searches one whitelisted article column for any of the words in the url
+ * encoded search string, the words are bound as a literal RLIKE pattern.
+ * ------------------------------------------------------------------------------------------------------- */
+func searchJsonByColumn(ctx context.Context, column string, search string, limit int, topic string) (reply conf.Reply) {
+	safeStr, err := url.QueryUnescape(search)
+	if lib.CheckErr(err) {
 		reply.Msg = "Unable to decode the search string"
 		reply.Code = 400
+		return
 	}
-
+	lib.Log(ctx).Debug("Search String:", column, safeStr)
+	reply = ArticlesReply(MysqlArticles{}.Search(ctx, column, strings.Fields(safeStr), limit, topic))
 	return
 }
 
@@ -751,53 +637,138 @@ func GetJsonByContent(searchStr string, limit int, topic string) (reply conf.Rep
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
  * Gets the next article depending on the last Article ID and return as a json object
  * ------------------------------------------------------------------------------------------------ */
-func GetLastJsonByFilter(limit int, filter string, topic string) (reply conf.Reply) {
+func GetLastJsonByFilter(ctx context.Context, limit int, filter string, topic string) (reply conf.Reply) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Error("Unable to retrieve filtered Articles:", r, filter)
+			lib.Log(ctx).Error("Unable to retrieve filtered Articles:", r, filter)
 		}
 	}()
-	var aList []Article
-	rowCount := 0
-	sqlArticles := fmt.Sprintf(`SELECT * FROM %[2]s ORDER BY uid DESC LIMIT %[1]v ;`, limit, topic)
-	if len(filter) > 0 {
-		filter = strings.ReplaceAll(filter, ",", "|")
-		sqlArticles = fmt.Sprintf(`SELECT * FROM article WHERE topic = '%[3]s' content rlike '%[2]s' ORDER BY uid DESC LIMIT %[1]v ;`, limit, filter, topic)
-	}
-	rows, err := db.Query(sqlArticles)
-	lib.CheckErr(err)
-	for rows.Next() {
-		var a Article
-		lib.Debug("Counting", rowCount)
-		switch err := rows.Scan(&a.Uid, &a.Title, &a.Content, &a.Author, &a.Email, &a.Topic, &a.Cat, &a.Link, &a.Detail, &a.Rating, &a.Created); err {
-		case sql.ErrNoRows:
-			lib.Error("No rows retrieved", err)
-			reply.Code = 204
-			reply.Msg = "[]"
-		case nil:
-			rowCount++
-			lib.Debug("Result:", a)
-			aList = append(aList, a)
-		default:
-			reply.Code = 400
-			reply.Msg = fmt.Sprintf(`Error: %v`, err.Error())
+	reply = ArticlesReply(MysqlArticles{}.Last(ctx, limit, filterTerms(filter), topic))
+	if reply.Code == 206 { // An empty filter result is still a good reply.
+		reply.Code = 200
+	}
+	return
+}
+
+// Split a comma separated filter into its terms.
+func filterTerms(filter string) (terms []string) {
+	for _, term := range strings.Split(filter, ",") {
+		term = strings.TrimSpace(term)
+		if len(term) > 0 {
+			terms = append(terms, term)
 		}
 	}
-	if rowCount < 1 {
-		reply.Code = 200
-		reply.Msg = "[]"
-	} else {
-		lib.Debug("Rows:", rowCount, aList)
-		jsonReply, err := json.MarshalIndent(aList, "", "")
-		if !lib.CheckErr(err) {
-			reply.Code = 200
-			reply.Msg = string(jsonReply)
+	return
+}
+
+/************************************************************************
+ *      _   _                    ____
+ *     | \ | |                  / __ \
+ *     |  \| | _____      _____| |  | |_   _  ___ _ __ _   _
+ *     | . ` |/ _ \ \ /\ / / __| |  | | | | |/ _ \ '__| | | |
+ *     | |\  |  __/\ V  V /\__ \ |__| | |_| |  __/ |  | |_| |
+ *     |_| \_|\___| \_/\_/ |___/\___\_\\__,_|\___|_|   \__, |
+ *                                                      __/ |
+ *                                                     |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Search parameters for the news api, Lists are matched as any of, and a
+ * leading - on an entry excludes it instead.
+ * ------------------------------------------------------------------- */
+type NewsQuery struct {
+	Topic      string // Only this topic, all of them if empty.
+	Keywords   []string
+	Categories []string
+	Sources    []string
+	AfterUid   int64 // Only articles with a higher uid, for picking up where a stream left off.
+	From       time.Time
+	To         time.Time
+	Sort       string
+	Limit      int
+	Offset     int
+	Uncounted  bool // Leaves the total at 0 rather than counting, for paging that has it already.
+}
+
+type Pagination struct {
+	Limit  int   `json:"limit"`
+	Offset int   `json:"offset"`
+	Count  int   `json:"count"`
+	Total  int64 `json:"total"`
+}
+
+type NewsPage struct {
+	Pagination Pagination `json:"pagination"`
+	Data       []Article  `json:"data"`
+}
+
+// Sort names accepted by the api and the ORDER BY they map to.
+var NewsSorts = map[string]string{
+	"published_desc": "created DESC, uid DESC",
+	"published_asc":  "created ASC, uid ASC",
+	"popularity":     "rating DESC, created DESC",
+	"uid_asc":        "uid ASC", // The order they came in.
+}
+
+// Build the WHERE clause and its bound values from the query.
+func (q NewsQuery) where() (clause string, args []interface{}) {
+	var terms []string
+	addList := func(list []string, match func(value string) (string, []interface{})) {
+		var anyOf []string
+		for _, value := range list {
+			exclude := strings.HasPrefix(value, "-")
+			value = strings.TrimPrefix(value, "-")
+			if len(value) == 0 {
+				continue
+			}
+			sqlPart, values := match(value)
+			args = append(args, values...)
+			if exclude {
+				terms = append(terms, "NOT "+sqlPart)
+			} else {
+				anyOf = append(anyOf, sqlPart)
+			}
+		}
+		if len(anyOf) > 0 {
+			terms = append(terms, "("+strings.Join(anyOf, " OR ")+")")
 		}
 	}
+	if len(q.Topic) > 0 {
+		terms = append(terms, "topic = ?")
+		args = append(args, q.Topic)
+	}
+	addList(q.Keywords, func(value string) (string, []interface{}) {
+		return "(title LIKE ? OR content LIKE ?)", []interface{}{likeTerm(value), likeTerm(value)}
+	})
+	addList(q.Categories, func(value string) (string, []interface{}) {
+		return "cat LIKE ?", []interface{}{likeTerm(value)}
+	})
+	addList(q.Sources, func(value string) (string, []interface{}) {
+		return "(author = ? OR link LIKE ?)", []interface{}{value, likeTerm(value)}
+	})
+	if q.AfterUid > 0 {
+		terms = append(terms, "uid > ?")
+		args = append(args, q.AfterUid)
+	}
+	if !q.From.IsZero() {
+		terms = append(terms, "created >= ?")
+		args = append(args, q.From.Format("2006-01-02 15:04:05"))
+	}
+	if !q.To.IsZero() {
+		terms = append(terms, "created < ?")
+		args = append(args, q.To.Format("2006-01-02 15:04:05"))
+	}
+	if len(terms) > 0 {
+		clause = " WHERE " + strings.Join(terms, " AND ")
+	}
 	return
 }
 
+// Wrap a search value for LIKE, escaping the wildcards so they match literally.
+func likeTerm(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+	return "%" + value + "%"
+}
+
 /***************************************************************************************
  *                 _   _      _     _    ____   __ _______                   _
  *                | | | |    (_)   | |  / __ \ / _|__   __|                 | |
@@ -810,9 +781,6 @@ func GetLastJsonByFilter(limit int, filter string, topic string) (reply conf.Rep
  * -------------------------------------------------------------------------------------
  * List all targets that match the platform and return array
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
-var thisTarget string
-
-// TODO check this function
 func GetListOfTargets(platform string) (aList []string) {
 	defer func() {
 		r := recover()
@@ -820,11 +788,15 @@ func GetListOfTargets(platform string) (aList []string) {
 			lib.Error("Unable to retrieve List of Targets:", r, platform)
 		}
 	}()
-	sqlString := fmt.Sprintf(`SELECT target FROM control WHERE platform = '%[1]s' AND live = 1 ;`, platform)
-	rows, err := db.Query(sqlString)
-	lib.CheckErr(err)
-	lib.Debug("SQL String:", sqlString)
+	sqlString := `SELECT target FROM control WHERE platform = ? AND live = 1 ;`
+	lib.Debug("SQL String:", sqlString, platform)
+	rows, err := db.Query(sqlString, platform)
+	if lib.CheckErr(err) {
+		panic(err)
+	}
+	defer rows.Close()
 	for rows.Next() {
+		var thisTarget string
 		switch err := rows.Scan(&thisTarget); err {
 		case sql.ErrNoRows:
 			lib.Info("No rows retrieved", err)
@@ -850,25 +822,32 @@ func GetListOfTargets(platform string) (aList []string) {
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
  * Run an SQL statement such as an insert.
  * ------------------------------------------------------------------------ */
-func RunSQL(sqlStatement string) (id int64) {
+func RunSQL(sqlStatement string, args ...interface{}) (id int64) {
+	return runSQL(context.Background(), sqlStatement, args...)
+}
+
+// RunSQL for a request, logged under its id.
+func runSQL(ctx context.Context, sqlStatement string, args ...interface{}) (id int64) {
+	log := lib.Log(ctx)
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Warn("Creating Schema:", r)
+			log.Warn("Creating Schema:", r)
 		}
 	}()
 	id = -1
-	stmt, err := db.Prepare(sqlStatement)
+	stmt, err := db.PrepareContext(ctx, sqlStatement)
 	if lib.CheckErr(err) {
-		lib.Warn("Unable to Prepare:", err, sqlStatement)
+		log.Warn("Unable to Prepare:", err, sqlStatement)
 	} else {
-		res, err := stmt.Exec()
+		defer lib.DeferClose(stmt)
+		res, err := stmt.ExecContext(ctx, args...)
 		if lib.CheckErr(err) {
-			lib.Warn("Unable to Execute:", err, sqlStatement)
+			log.Warn("Unable to Execute:", err, sqlStatement, args)
 		} else {
 			id, err = res.RowsAffected()
 			lib.CheckErr(err)
-			lib.Info("Execution Complete:")
+			log.Info("Execution Complete:")
 		}
 	}
 	return
@@ -884,20 +863,80 @@ func RunSQL(sqlStatement string) (id int64) {
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
  * Count records based on the SQL request.
  * ------------------------------------------------------------------------ */
-func CountSQL(sqlStatement string) (id int64) {
+func CountSQL(sqlStatement string, args ...interface{}) (id int64) {
+	return countSQL(context.Background(), sqlStatement, args...)
+}
+
+func countSQL(ctx context.Context, sqlStatement string, args ...interface{}) (id int64) {
+	log := lib.Log(ctx)
 	defer func() {
 		r := recover()
 		if r != nil {
-			lib.Warn("Counting Rows:", r)
+			log.Warn("Counting Rows:", r)
 		}
 	}()
-	err := db.QueryRow(sqlStatement).Scan(&id)
+	err := db.QueryRowContext(ctx, sqlStatement, args...).Scan(&id)
 	switch {
 	case err != nil:
-		lib.Debug(err, sqlStatement)
+		log.Debug(err, sqlStatement, args)
 		panic(err)
 	default:
-		lib.Debug("Total rows:", id)
+		log.Debug("Total rows:", id)
+	}
+	return
+}
+
+/****************************************************************************
+ *                _                       _   _
+ *               | |                     | \ | |
+ *       ___ ___ | |_   _ _ __ ___  _ __ |  \| | __ _ _ __ ___   ___
+ *      / __/ _ \| | | | | '_ ` _ \| '_ \| . ` |/ _` | '_ ` _ \ / _ \
+ *     | (_| (_) | | |_| | | | | | | | | | |\  | (_| | | | | | |  __/
+ *      \___\___/|_|\__,_|_| |_| |_|_| |_|_| \_|\__,_|_| |_| |_|\___|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Article columns that may be named in generated SQL. Anything a caller asks
+ * for outside of this list is refused rather than pasted into the statement.
+ * ----------------------------------------------------------------------- */
+var articleColumns = map[string]bool{
+	"uid": true, "title": true, "content": true, "author": true, "email": true,
+	"topic": true, "cat": true, "link": true, "rating": true, "created": true,
+}
+
+func columnName(column string) string {
+	if !articleColumns[column] {
+		panic("Column not allowed: " + column)
+	}
+	return column
+}
+
+/*******************************************************************************
+ *           _ _ _     _______
+ *          | (_) |   |__   __|
+ *      _ __| |_| | _____| | ___ _ __ _ __ ___  ___
+ *     | '__| | | |/ / _ \ |/ _ \ '__| '_ ` _ \/ __|
+ *     | |  | | |   <  __/ |  __/ |  | | | | | \__ \
+ *     |_|  |_|_|_|\_\___|_|\___|_|  |_| |_| |_|___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Builds an RLIKE pattern that matches any of the terms, each term is quoted so
+ * regex and SQL characters within it are matched literally. With no terms left
+ * the pattern is empty, MySQL rejects RLIKE '', so leave the clause out then.
+ * -------------------------------------------------------------------------- */
+func rlikeTerms(terms []string) string {
+	var quoted []string
+	for _, term := range terms {
+		term = strings.TrimSpace(term)
+		if len(term) > 0 {
+			quoted = append(quoted, regexp.QuoteMeta(term))
+		}
+	}
+	return strings.Join(quoted, "|")
+}
+
+// The clause for a target's keywords, comma or space separated, any of them in the title.
+// Nothing when there are none, so every article gets through.
+func keywordFilter(keywords string) (where string, args []interface{}) {
+	if pattern := rlikeTerms(strings.Fields(strings.ReplaceAll(keywords, ",", " "))); len(pattern) > 0 {
+		return ` AND title RLIKE ?`, []interface{}{pattern}
 	}
 	return
 }
@@ -0,0 +1,338 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Strings that would change the meaning of a query built by pasting them in.
+var injections = []string{
+	`' OR '1'='1`,
+	`'; DROP TABLE articles; --`,
+	`" OR ""="`,
+	`%' OR title LIKE '%`,
+	`1 UNION SELECT * FROM accounts`,
+	`\' OR 1=1 #`,
+	`.*`,
+	`(a|b)`,
+}
+
+// A database/sql driver that keeps the statements it is handed. A query finds the rows
+// answered for the first part of it that matches, none if nothing does, and an exec
+// affects the rows set, one if none are, and gives the insert id set.
+type statement struct {
+	query string
+	args  []driver.Value
+}
+
+type recorder struct {
+	mu         sync.Mutex
+	statements []statement
+	answers    map[string][][]driver.Value
+	affected   *int64
+	insertId   int64
+}
+
+type recorderConn struct{ r *recorder }
+type recorderStmt struct {
+	r     *recorder
+	query string
+}
+type recorderRows struct{ rows [][]driver.Value }
+
+func (r *recorder) Open(name string) (driver.Conn, error) { return recorderConn{r}, nil }
+
+func (r *recorder) take() (statements []statement) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statements, r.statements = r.statements, nil
+	return
+}
+
+// Rows for the queries holding the part, from now until the test ends.
+func (r *recorder) answer(t *testing.T, part string, rows ...[]driver.Value) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.answers == nil {
+		r.answers = make(map[string][][]driver.Value)
+		t.Cleanup(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.answers, r.affected, r.insertId = nil, nil, 0
+		})
+	}
+	r.answers[part] = rows
+}
+
+func (r *recorder) affect(count int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.affected = &count
+}
+
+func (r *recorder) inserted(id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.insertId = id
+}
+
+type recorderResult struct{ id, affected int64 }
+
+func (res recorderResult) LastInsertId() (int64, error) { return res.id, nil }
+func (res recorderResult) RowsAffected() (int64, error) { return res.affected, nil }
+
+func (c recorderConn) Prepare(query string) (driver.Stmt, error) {
+	return recorderStmt{c.r, query}, nil
+}
+func (c recorderConn) Close() error              { return nil }
+func (c recorderConn) Begin() (driver.Tx, error) { return nil, errors.New("no transactions") }
+
+func (s recorderStmt) Close() error  { return nil }
+func (s recorderStmt) NumInput() int { return -1 }
+func (s recorderStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.record(args)
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	if s.r.affected != nil {
+		return recorderResult{s.r.insertId, *s.r.affected}, nil
+	}
+	return recorderResult{s.r.insertId, 1}, nil
+}
+func (s recorderStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.record(args)
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	for part, rows := range s.r.answers {
+		if strings.Contains(s.query, part) {
+			return &recorderRows{rows: rows}, nil
+		}
+	}
+	return &recorderRows{}, nil
+}
+func (s recorderStmt) record(args []driver.Value) {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.r.statements = append(s.r.statements, statement{s.query, args})
+}
+
+func (rows *recorderRows) Columns() []string {
+	if len(rows.rows) == 0 {
+		return []string{"uid"}
+	}
+	return make([]string, len(rows.rows[0]))
+}
+func (rows *recorderRows) Close() error { return nil }
+func (rows *recorderRows) Next(dest []driver.Value) error {
+	if len(rows.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, rows.rows[0])
+	rows.rows = rows.rows[1:]
+	return nil
+}
+
+var (
+	recorderOnce sync.Once
+	recorded     = &recorder{}
+)
+
+// Points the package at the recorder, put back as it was when the test ends.
+func useRecorder(t *testing.T) *recorder {
+	t.Helper()
+	recorderOnce.Do(func() { sql.Register("recorder", recorded) })
+	recorder, err := sql.Open("recorder", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := db
+	db = recorder
+	t.Cleanup(func() {
+		db = old
+		_ = recorder.Close()
+	})
+	recorded.take()
+	return recorded
+}
+
+// The payload is nowhere in the statements and is in the bound values, as it is or quoted.
+func checkBound(t *testing.T, call string, payload string, statements []statement) {
+	t.Helper()
+	if len(statements) == 0 {
+		t.Fatalf("%s %q: no statement was run", call, payload)
+	}
+	bound := false
+	for _, s := range statements {
+		if strings.Contains(s.query, payload) {
+			t.Errorf("%s %q: pasted into the statement: %s", call, payload, s.query)
+		}
+		for _, arg := range s.args {
+			if value, ok := arg.(string); ok && boundForm(value, payload) {
+				bound = true
+			}
+		}
+	}
+	if !bound {
+		t.Errorf("%s %q: not among the bound values: %+v", call, payload, statements)
+	}
+}
+
+func boundForm(value string, payload string) bool {
+	for _, field := range append([]string{payload}, strings.Fields(payload)...) {
+		if strings.Contains(value, field) || strings.Contains(value, regexp.QuoteMeta(field)) || strings.Contains(value, strings.Trim(likeTerm(field), "%")) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStoreBindsPayloads(t *testing.T) {
+	r := useRecorder(t)
+	ctx := context.Background()
+	store := MysqlArticles{}
+	for _, payload := range injections {
+		calls := map[string]func(){
+			"Next":   func() { _, _ = store.Next(ctx, 1, 10, []string{payload}, payload) },
+			"Prev":   func() { _, _ = store.Prev(ctx, 1, 10, []string{payload}, payload) },
+			"Last":   func() { _, _ = store.Last(ctx, 10, []string{payload}, payload) },
+			"Search": func() { _, _ = store.Search(ctx, "title", strings.Fields(payload), 10, payload) },
+			"List keywords": func() {
+				_, _, _ = store.List(ctx, NewsQuery{Keywords: []string{payload}, Sort: "published_desc", Limit: 10})
+			},
+			"List categories": func() {
+				_, _, _ = store.List(ctx, NewsQuery{Categories: []string{payload}, Sort: "published_desc", Limit: 10})
+			},
+			"List sources": func() {
+				_, _, _ = store.List(ctx, NewsQuery{Sources: []string{payload}, Sort: "published_desc", Limit: 10})
+			},
+			"List topic": func() {
+				_, _, _ = store.List(ctx, NewsQuery{Topic: payload, Sort: "published_desc", Limit: 10})
+			},
+			"GetJsonByTitle":      func() { GetJsonByTitle(ctx, url.QueryEscape(payload), 10, "general") },
+			"GetJsonByContent":    func() { GetJsonByContent(ctx, url.QueryEscape(payload), 10, "general") },
+			"GetLastJsonByFilter": func() { GetLastJsonByFilter(ctx, 10, payload, "general") },
+			"CheckControl":        func() { CheckControl(ctx, payload, payload) },
+			"GetListOfTargets":    func() { GetListOfTargets(payload) },
+		}
+		for name, call := range calls {
+			call()
+			checkBound(t, name, payload, r.take())
+		}
+	}
+}
+
+func TestStoreWhitelistsIdentifiers(t *testing.T) {
+	r := useRecorder(t)
+	ctx := context.Background()
+	for _, payload := range injections {
+		if _, err := (MysqlArticles{}).Search(ctx, payload, []string{"news"}, 10, "general"); err == nil {
+			t.Errorf("column %q was let through", payload)
+		}
+		if _, _, err := (MysqlArticles{}).List(ctx, NewsQuery{Sort: payload, Limit: 10}); err == nil {
+			t.Errorf("sort %q was let through", payload)
+		}
+		if statements := r.take(); len(statements) > 0 {
+			t.Errorf("%q: ran %+v", payload, statements)
+		}
+	}
+}
+
+func TestEmptyFilterLeavesOutRlike(t *testing.T) {
+	r := useRecorder(t)
+	ctx := context.Background()
+	store := MysqlArticles{}
+	_, _ = store.Next(ctx, 1, 10, []string{" ", ""}, "general")
+	_, _ = store.Prev(ctx, 1, 10, []string{" "}, "general")
+	_, _ = store.Last(ctx, 10, []string{""}, "general")
+	_, _ = store.Search(ctx, "title", nil, 10, "general")
+	GetLastJsonByFilter(ctx, 10, ",", "general")
+	for _, s := range r.take() {
+		if strings.Contains(s.query, "RLIKE") {
+			t.Errorf("RLIKE with nothing to match: %s %v", s.query, s.args)
+		}
+	}
+}
+
+func TestSetTargetLiveMovesPausedCursor(t *testing.T) {
+	r := useRecorder(t)
+	r.answer(t, "SELECT count(*) FROM control", []driver.Value{int64(1)})
+	before := time.Now().Add(-time.Second).Format("2006-01-02 15:04:05")
+	if !SetTargetLive(context.Background(), "channel-1", "discord", true) {
+		t.Fatal("subscribe failed")
+	}
+	statements := r.take()
+	last := statements[len(statements)-1]
+	if !strings.Contains(last.query, "timestamp = IF(live = 0, ?, timestamp)") || !strings.Contains(last.query, "live = 1") {
+		t.Errorf("subscribe does not move a paused cursor: %s", last.query)
+	}
+	if now, _ := last.args[0].(string); now < before {
+		t.Errorf("cursor moved to %v, want now", last.args[0])
+	}
+	if !SetTargetLive(context.Background(), "channel-1", "discord", false) {
+		t.Fatal("unsubscribe failed")
+	}
+	statements = r.take()
+	if last = statements[len(statements)-1]; strings.Contains(last.query, "timestamp") {
+		t.Errorf("unsubscribe moved the cursor: %s", last.query)
+	}
+}
+
+func TestShortKeywordsFilter(t *testing.T) {
+	r := useRecorder(t)
+	r.answer(t, "SELECT count(*) FROM", []driver.Value{int64(0)})
+	calls := map[string]func(keywords string){
+		"NextArticle":              func(keywords string) { NextArticle("chat-1", "telegram", "general", keywords) },
+		"PendingArticle":           func(keywords string) { PendingArticle(context.Background(), "chat-1", "general", keywords) },
+		"GetNextArticleByKeyWords": func(keywords string) { GetNextArticleByKeyWords("chat-1", keywords, "telegram", "general") },
+	}
+	for name, call := range calls {
+		call("AI")
+		filtered := false
+		for _, s := range r.take() {
+			if strings.Contains(s.query, "title RLIKE ?") && len(s.args) > 0 && s.args[len(s.args)-1] == "AI" {
+				filtered = true
+			}
+		}
+		if !filtered {
+			t.Errorf("%s: the keyword AI was not matched against the title", name)
+		}
+		call(" , ")
+		for _, s := range r.take() {
+			if strings.Contains(s.query, "RLIKE") {
+				t.Errorf("%s: RLIKE with no keywords: %s", name, s.query)
+			}
+		}
+	}
+}
+
+func TestListUncounted(t *testing.T) {
+	r := useRecorder(t)
+	ctx := context.Background()
+	r.answer(t, "count(*)", []driver.Value{int64(1200)})
+	query := NewsQuery{Sort: "uid_asc", Limit: 500, AfterUid: 1000}
+	for _, uncounted := range []bool{false, true} {
+		query.Uncounted = uncounted
+		if _, _, err := (MysqlArticles{}).List(ctx, query); err != nil {
+			t.Fatal(err)
+		}
+		counted := false
+		for _, s := range r.take() {
+			if strings.Contains(s.query, "count(*)") {
+				counted = true
+			} else if !strings.Contains(s.query, "WHERE uid > ? ORDER BY uid ASC LIMIT ?") {
+				t.Errorf("paged with %s", s.query)
+			}
+		}
+		if counted == uncounted {
+			t.Errorf("uncounted %v, counted %v", uncounted, counted)
+		}
+	}
+}
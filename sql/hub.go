@@ -0,0 +1,107 @@
+package sql
+
+import (
+	"[app name]/lib"
+	"sync"
+)
+
+/**************************************************************************************************
+ *                    _   _      _       _____       _                   _       _   _
+ *         /\        | | (_)    | |     / ____|     | |                 (_)     | | (_)
+ *        /  \   _ __| |_ _  ___| | ___| (___  _   _| |__  ___  ___ _ __ _ _ __ | |_ _  ___  _ __
+ *       / /\ \ | '__| __| |/ __| |/ _ \\___ \| | | | '_ \/ __|/ __| '__| | '_ \| __| |/ _ \| '_ \
+ *      / ____ \| |  | |_| | (__| |  __/____) | |_| | |_) \__ \ (__| |  | | |_) | |_| | (_) | | | |
+ *     /_/    \_\_|   \__|_|\___|_|\___|_____/ \__,_|_.__/|___/\___|_|  |_| .__/ \__|_|\___/|_| |_|
+ *                                                                        | |
+ *                                                                        |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * A listener on the articles as they are inserted. Publishing never waits
+ * on a listener, one whose buffer is full is dropped and its channel closed,
+ * Lagged then says so, and it picks up again from the last uid it had. One
+ * made with SubscribeArticlesDropping misses the article instead.
+ * --------------------------------------------------------------------------------------------- */
+type ArticleSubscription struct {
+	C <-chan Article
+
+	c       chan Article
+	drop    bool // Miss the articles that do not fit rather than be dropped.
+	lagged  bool
+	dropped int
+}
+
+var hub = struct {
+	lock sync.Mutex
+	subs map[*ArticleSubscription]bool
+}{subs: make(map[*ArticleSubscription]bool)}
+
+// Starts listening, holding up to buffer articles the listener has not got to yet.
+func SubscribeArticles(buffer int) *ArticleSubscription {
+	return subscribe(buffer, false)
+}
+
+// Starts listening, missing the articles that come while the buffer is full rather than
+// being dropped. Dropped says how many were missed.
+func SubscribeArticlesDropping(buffer int) *ArticleSubscription {
+	return subscribe(buffer, true)
+}
+
+func subscribe(buffer int, drop bool) *ArticleSubscription {
+	c := make(chan Article, buffer)
+	s := &ArticleSubscription{C: c, c: c, drop: drop}
+	hub.lock.Lock()
+	hub.subs[s] = true
+	hub.lock.Unlock()
+	return s
+}
+
+// Stops listening and closes the channel, closing again does nothing.
+func (s *ArticleSubscription) Close() {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	if hub.subs[s] {
+		delete(hub.subs, s)
+		close(s.c)
+	}
+}
+
+// Whether the listener was dropped for falling behind.
+func (s *ArticleSubscription) Lagged() bool {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	return s.lagged
+}
+
+// The articles missed since it was last asked.
+func (s *ArticleSubscription) Dropped() (count int) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	count, s.dropped = s.dropped, 0
+	return
+}
+
+// The number of listeners.
+func Subscribers() int {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	return len(hub.subs)
+}
+
+// Hands a newly inserted article to every listener.
+func publishArticle(a Article) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	for s := range hub.subs {
+		select {
+		case s.c <- a:
+		default:
+			if s.drop {
+				s.dropped++
+				continue
+			}
+			s.lagged = true
+			delete(hub.subs, s)
+			close(s.c)
+			lib.Warn("Article listener dropped for falling behind, at:", a.Uid)
+		}
+	}
+}
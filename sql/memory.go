@@ -0,0 +1,264 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/***********************************************************************************
+ *      __  __                                               _   _      _
+ *     |  \/  |                                   /\        | | (_)    | |
+ *     | \  / | ___ _ __ ___   ___  _ __ _   _   /  \   _ __| |_ _  ___| | ___  ___
+ *     | |\/| |/ _ \ '_ ` _ \ / _ \| '__| | | | / /\ \ | '__| __| |/ __| |/ _ \/ __|
+ *     | |  | |  __/ | | | | | (_) | |  | |_| |/ ____ \| |  | |_| | (__| |  __/\__ \
+ *     |_|  |_|\___|_| |_| |_|\___/|_|   \__, /_/    \_\_|   \__|_|\___|_|\___||___/
+ *                                        __/ |
+ *                                       |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Article store held in memory, it follows the MySQL store closely enough to
+ * run the http layer without a database. Title is unique, as in the table.
+ * ------------------------------------------------------------------------------ */
+type MemoryArticles struct {
+	mu       sync.RWMutex
+	articles []Article // Held in uid order.
+	lastUid  int64
+}
+
+func NewMemoryArticles(aList ...Article) *MemoryArticles {
+	m := &MemoryArticles{}
+	for _, a := range aList {
+		_, _ = m.Insert(context.Background(), a)
+	}
+	return m
+}
+
+func (m *MemoryArticles) Get(ctx context.Context, uid int64) (a Article, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, a := range m.articles {
+		if a.Uid == uid {
+			return a, nil
+		}
+	}
+	return a, sql.ErrNoRows
+}
+
+func (m *MemoryArticles) List(ctx context.Context, query NewsQuery) (aList []Article, total int64, err error) {
+	if _, ok := NewsSorts[query.Sort]; !ok {
+		return nil, 0, fmt.Errorf("Invalid sort: %s", query.Sort)
+	}
+	m.mu.RLock()
+	for _, a := range m.articles {
+		if query.matches(a) {
+			aList = append(aList, a)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.SliceStable(aList, func(i, j int) bool {
+		switch query.Sort {
+		case "published_asc":
+			return aList[i].Created.Before(aList[j].Created)
+		case "uid_asc":
+			return aList[i].Uid < aList[j].Uid
+		case "popularity":
+			if aList[i].Rating != aList[j].Rating {
+				return aList[i].Rating > aList[j].Rating
+			}
+		}
+		return aList[i].Created.After(aList[j].Created)
+	})
+	total = int64(len(aList))
+	if query.Offset >= len(aList) {
+		return nil, total, nil
+	}
+	aList = aList[query.Offset:]
+	if len(aList) > query.Limit {
+		aList = aList[:query.Limit]
+	}
+	return
+}
+
+func (m *MemoryArticles) Insert(ctx context.Context, a Article) (uid int64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, have := range m.articles {
+		if have.Title == a.Title {
+			return 0, fmt.Errorf("Error 1062: Duplicate entry '%s' for key 'title'", a.Title)
+		}
+	}
+	m.lastUid++
+	a.Uid = m.lastUid
+	a.Created = time.Now()
+	m.articles = append(m.articles, a)
+	publishArticle(a)
+	return a.Uid, nil
+}
+
+func (m *MemoryArticles) Next(ctx context.Context, uid int64, limit int, filter []string, topic string) (aList []Article, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, a := range m.articles {
+		if len(aList) >= limit {
+			break
+		}
+		if a.Uid > uid && a.Topic == topic && containsAny(a.Content, filter) {
+			aList = append(aList, a)
+		}
+	}
+	return
+}
+
+func (m *MemoryArticles) Prev(ctx context.Context, uid int64, limit int, filter []string, topic string) (aList []Article, err error) {
+	return m.backwards(limit, topic, func(a Article) bool {
+		return a.Uid < uid && containsAny(a.Content, filter)
+	}), nil
+}
+
+func (m *MemoryArticles) Last(ctx context.Context, limit int, filter []string, topic string) (aList []Article, err error) {
+	return m.backwards(limit, topic, func(a Article) bool {
+		return containsAny(a.Content, filter)
+	}), nil
+}
+
+func (m *MemoryArticles) Search(ctx context.Context, column string, terms []string, limit int, topic string) (aList []Article, err error) {
+	if !articleColumns[column] {
+		return nil, fmt.Errorf("Column not allowed: %s", column)
+	}
+	return m.backwards(limit, topic, func(a Article) bool {
+		var value string
+		switch column {
+		case "uid":
+			value = fmt.Sprint(a.Uid)
+		case "title":
+			value = a.Title
+		case "content":
+			value = a.Content
+		case "author":
+			value = a.Author
+		case "email":
+			value = a.Email
+		case "topic":
+			value = a.Topic
+		case "cat":
+			value = a.Cat
+		case "link":
+			value = a.Link
+		case "rating":
+			value = fmt.Sprint(a.Rating)
+		case "created":
+			value = a.Created.Format("2006-01-02 15:04:05")
+		}
+		return containsAny(value, terms)
+	}), nil
+}
+
+// Walk from the newest article back, collecting up to limit matches in the topic.
+func (m *MemoryArticles) backwards(limit int, topic string, match func(a Article) bool) (aList []Article) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := len(m.articles) - 1; i >= 0 && len(aList) < limit; i-- {
+		if m.articles[i].Topic == topic && match(m.articles[i]) {
+			aList = append(aList, m.articles[i])
+		}
+	}
+	return
+}
+
+// The in memory version of NewsQuery.where.
+func (q NewsQuery) matches(a Article) bool {
+	listMatch := func(list []string, match func(value string) bool) bool {
+		anyOf, matched := false, false
+		for _, value := range list {
+			exclude := strings.HasPrefix(value, "-")
+			value = strings.TrimPrefix(value, "-")
+			if len(value) == 0 {
+				continue
+			}
+			if exclude {
+				if match(value) {
+					return false
+				}
+			} else {
+				anyOf = true
+				matched = matched || match(value)
+			}
+		}
+		return matched || !anyOf
+	}
+	if len(q.Topic) > 0 && a.Topic != q.Topic {
+		return false
+	}
+	if q.AfterUid > 0 && a.Uid <= q.AfterUid {
+		return false
+	}
+	return listMatch(q.Keywords, func(value string) bool {
+		return containsAny(a.Title, []string{value}) || containsAny(a.Content, []string{value})
+	}) && listMatch(q.Categories, func(value string) bool {
+		return containsAny(a.Cat, []string{value})
+	}) && listMatch(q.Sources, func(value string) bool {
+		return a.Author == value || containsAny(a.Link, []string{value})
+	}) && (q.From.IsZero() || !a.Created.Before(q.From)) && (q.To.IsZero() || a.Created.Before(q.To))
+}
+
+// Whether an article the query was not run against, one just inserted say, would be in its results.
+func (q NewsQuery) Matches(a Article) bool {
+	return q.matches(a)
+}
+
+// Case insensitive match of any term, no terms matches everything.
+func containsAny(value string, terms []string) bool {
+	if len(terms) == 0 {
+		return true
+	}
+	value = strings.ToLower(value)
+	for _, term := range terms {
+		if strings.Contains(value, strings.ToLower(term)) {
+			return true
+		}
+	}
+	return false
+}
+
+/****************************************************************************************
+ *      __  __                                                                   _
+ *     |  \/  |                                   /\                            | |
+ *     | \  / | ___ _ __ ___   ___  _ __ _   _   /  \   ___ ___ ___  _   _ _ __ | |_ ___
+ *     | |\/| |/ _ \ '_ ` _ \ / _ \| '__| | | | / /\ \ / __/ __/ _ \| | | | '_ \| __/ __|
+ *     | |  | |  __/ | | | | | (_) | |  | |_| |/ ____ \ (_| (_| (_) | |_| | | | | |_\__ \
+ *     |_|  |_|\___|_| |_| |_|\___/|_|   \__, /_/    \_\___\___\___/ \__,_|_| |_|\__|___/
+ *                                        __/ |
+ *                                       |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Account store held in memory, for running without a database.
+ * ----------------------------------------------------------------------------------- */
+type MemoryAccounts struct {
+	*AccountCache
+}
+
+// Takes the accounts by their plain api key, any without a urn are given one.
+func NewMemoryAccounts(accounts map[string]AccountsStruct) *MemoryAccounts {
+	m := &MemoryAccounts{NewAccountCache()}
+	var urn int64
+	for apikey, account := range accounts {
+		if account.Urn == 0 {
+			urn--
+			account.Urn = urn
+		}
+		m.Put(apikey, account)
+	}
+	return m
+}
+
+func (m *MemoryAccounts) Load() error { return nil }
+
+// Nothing to write the usage to, so it is just dropped.
+func (m *MemoryAccounts) Flush() error {
+	m.takePending()
+	return nil
+}
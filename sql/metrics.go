@@ -0,0 +1,66 @@
+package sql
+
+import (
+	"[app name]/lib"
+)
+
+var (
+	storeDuration = lib.NewHistogram("news_store_query_duration_seconds", "Time taken by the article and account store calls, by function.", nil, "function")
+
+	dbOpen         = lib.NewGauge("news_db_open_connections", "Connections to the database, in use and idle.")
+	dbInUse        = lib.NewGauge("news_db_in_use_connections", "Connections to the database in use.")
+	dbIdle         = lib.NewGauge("news_db_idle_connections", "Connections to the database left idle.")
+	dbMaxOpen      = lib.NewGauge("news_db_max_open_connections", "Most connections the pool will open, 0 for no limit.")
+	dbWaits        = lib.NewCounter("news_db_wait_count_total", "Times a query waited for a free connection.")
+	dbWaitSeconds  = lib.NewCounter("news_db_wait_duration_seconds_total", "Time spent waiting for a free connection.")
+	dbClosedIdle   = lib.NewCounter("news_db_max_idle_closed_total", "Connections closed for going over the most kept idle.")
+	dbClosedLife   = lib.NewCounter("news_db_max_lifetime_closed_total", "Connections closed for going over their lifetime.")
+	planAccounts   = lib.NewGauge("news_plan_accounts", "Live accounts, by plan.", "plan")
+	planUsed       = lib.NewGauge("news_plan_used", "Calls used in the current period across the accounts, by plan.", "plan")
+	planAllocation = lib.NewGauge("news_plan_allocated", "Calls allocated in the current period across the accounts, by plan.", "plan")
+)
+
+func init() {
+	lib.OnMetrics(collectMetrics)
+}
+
+/************************************************************************
+ *                _ _           _   __  __      _        _
+ *               | | |         | | |  \/  |    | |      (_)
+ *       ___ ___ | | | ___  ___| |_| \  / | ___| |_ _ __ _  ___ ___
+ *      / __/ _ \| | |/ _ \/ __| __| |\/| |/ _ \ __| '__| |/ __/ __|
+ *     | (_| (_) | | |  __/ (__| |_| |  | |  __/ |_| |  | | (__\__ \
+ *      \___\___/|_|_|\___|\___|\__|_|  |_|\___|\__|_|  |_|\___|___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * The pool stats and the account usage by plan, read at each scrape
+ * rather than kept. Usage counted and not yet flushed is in the cache so
+ * it shows straight away.
+ * ------------------------------------------------------------------- */
+func collectMetrics() {
+	if db != nil {
+		stats := db.Stats()
+		dbOpen.Set(float64(stats.OpenConnections))
+		dbInUse.Set(float64(stats.InUse))
+		dbIdle.Set(float64(stats.Idle))
+		dbMaxOpen.Set(float64(stats.MaxOpenConnections))
+		dbWaits.Set(float64(stats.WaitCount))
+		dbWaitSeconds.Set(stats.WaitDuration.Seconds())
+		dbClosedIdle.Set(float64(stats.MaxIdleClosed))
+		dbClosedLife.Set(float64(stats.MaxLifetimeClosed))
+	}
+	count, used, allocated := make(map[string]float64), make(map[string]float64), make(map[string]float64)
+	for name := range Plans {
+		count[name], used[name], allocated[name] = 0, 0, 0 // A plan with no accounts left reads zero.
+	}
+	for _, account := range Accounts.Snapshot() {
+		name := PlanFor(account.Plan).Name
+		count[name]++
+		used[name] += float64(account.Used)
+		allocated[name] += float64(account.Allocated)
+	}
+	for name := range count {
+		planAccounts.Set(count[name], name)
+		planUsed.Set(used[name], name)
+		planAllocation.Set(allocated[name], name)
+	}
+}
@@ -0,0 +1,336 @@
+package sql
+
+import (
+	schema "[app name]/db"
+	"[app name]/lib"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One numbered script from db/sql, with the script that undoes it if there is one.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // SHA-256 of the up script.
+}
+
+// A migration as the status command shows it.
+type MigrationState struct {
+	Version int        `json:"version"`
+	Name    string     `json:"name"`
+	Applied *time.Time `json:"applied"`
+	Changed bool       `json:"changed"` // The script is not the one that was applied.
+}
+
+// The statements a migration runs, for dry runs to show.
+func (m Migration) Statements(down bool) []string {
+	if down {
+		return splitStatements(m.Down)
+	}
+	return splitStatements(m.Up)
+}
+
+const migrationTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INT NOT NULL PRIMARY KEY,
+    name       VARCHAR(128) NOT NULL,
+    checksum   CHAR(64) NOT NULL,
+    applied    TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=UTF8MB4`
+
+type appliedMigration struct {
+	checksum string
+	applied  time.Time
+}
+
+/******************************************************************************
+ *      __  __ _                 _       _    _
+ *     |  \/  (_)               | |     | |  | |
+ *     | \  / |_  __ _ _ __ __ _| |_ ___| |  | |_ __
+ *     | |\/| | |/ _` | '__/ _` | __/ _ \ |  | | '_ \
+ *     | |  | | | (_| | | | (_| | ||  __/ |__| | |_) |
+ *     |_|  |_|_|\__, |_|  \__,_|\__\___|\____/| .__/
+ *                __/ |                        | |
+ *               |___/                         |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Runs every migration not yet applied, in order, each recorded with the
+ * checksum of its script. A script changed since it was applied stops the run,
+ * as does a schema made by db/run.sh with no history, see MigrateBaseline.
+ * ------------------------------------------------------------------------- */
+func MigrateUp(dryRun bool) (ran []Migration, err error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return
+	}
+	if len(applied) == 0 && tableExists("accounts") {
+		return nil, fmt.Errorf("the schema has no migration history, baseline it at the version it is at first")
+	}
+	for _, m := range migrations {
+		if a, ok := applied[m.Version]; ok {
+			if a.checksum != m.Checksum {
+				return ran, fmt.Errorf("migration %04d_%s has changed since it was applied", m.Version, m.Name)
+			}
+			continue
+		}
+		if !dryRun {
+			if err = runMigration(m, false); err != nil {
+				return
+			}
+		}
+		ran = append(ran, m)
+	}
+	return
+}
+
+// Undoes the last steps migrations applied, newest first.
+func MigrateDown(steps int, dryRun bool) (ran []Migration, err error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return
+	}
+	for i := len(migrations) - 1; i >= 0 && len(ran) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if len(m.Down) == 0 {
+			return ran, fmt.Errorf("migration %04d_%s has no down script", m.Version, m.Name)
+		}
+		if !dryRun {
+			if err = runMigration(m, true); err != nil {
+				return
+			}
+		}
+		ran = append(ran, m)
+	}
+	return
+}
+
+// Records every migration up to the version as applied, without running them. For a
+// schema that db/run.sh built, so the runner carries on from where that left off.
+func MigrateBaseline(version int) (err error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return
+	}
+	if _, err = appliedMigrations(); err != nil {
+		return
+	}
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+		_, err = db.Exec("INSERT IGNORE INTO schema_migrations (version, name, checksum) VALUES (?,?,?) ;", m.Version, m.Name, m.Checksum)
+		if lib.CheckErr(err) {
+			return
+		}
+		lib.Info("Migration baselined:", m.Version, m.Name)
+	}
+	return
+}
+
+// Every migration, applied or not, oldest first.
+func MigrationStatus() (states []MigrationState, err error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return
+	}
+	for _, m := range migrations {
+		state := MigrationState{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			when := a.applied
+			state.Applied = &when
+			state.Changed = a.checksum != m.Checksum
+		}
+		states = append(states, state)
+	}
+	return
+}
+
+// Runs the statements on one connection, so a USE in the script holds for the rest of it.
+// MySQL commits each schema change as it goes, so a failure part way is left as it is.
+func runMigration(m Migration, down bool) (err error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if lib.CheckErr(err) {
+		return
+	}
+	defer lib.DeferClose(conn)
+	for _, statement := range m.Statements(down) {
+		if _, err = conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+	}
+	if down {
+		_, err = conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ? ;", m.Version)
+	} else {
+		_, err = conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, checksum) VALUES (?,?,?) ;", m.Version, m.Name, m.Checksum)
+	}
+	if err == nil && down {
+		lib.Info("Migration undone:", m.Version, m.Name)
+	} else if err == nil {
+		lib.Info("Migration applied:", m.Version, m.Name)
+	}
+	return
+}
+
+// The applied migrations by version, making the table the first time.
+func appliedMigrations() (applied map[int]appliedMigration, err error) {
+	if _, err = db.Exec(migrationTable); lib.CheckErr(err) {
+		return
+	}
+	rows, err := db.Query("SELECT version, checksum, applied FROM schema_migrations ;")
+	if lib.CheckErr(err) {
+		return
+	}
+	defer rows.Close()
+	applied = make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		if err = rows.Scan(&version, &a.checksum, &a.applied); lib.CheckErr(err) {
+			return
+		}
+		applied[version] = a
+	}
+	err = rows.Err()
+	return
+}
+
+func tableExists(table string) bool {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ? ;", table).Scan(&count)
+	if err != nil && err != sql.ErrNoRows {
+		lib.CheckErr(err)
+	}
+	return count > 0
+}
+
+// The scripts built into the binary, by version. 0000 makes the database itself, which
+// the admin does before there is anything to connect to, so it is left out.
+func loadMigrations() (migrations []Migration, err error) {
+	files, err := fs.Glob(schema.Migrations, "sql/*.sql")
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		base := strings.TrimSuffix(path.Base(file), ".sql")
+		number, name, found := strings.Cut(base, "_")
+		version, convErr := strconv.Atoi(number)
+		if !found || convErr != nil {
+			return nil, fmt.Errorf("migration %s is not named NNNN_name.sql", file)
+		}
+		if version == 0 {
+			continue
+		}
+		up, err := fs.ReadFile(schema.Migrations, file)
+		if err != nil {
+			return nil, err
+		}
+		down, _ := fs.ReadFile(schema.Migrations, "sql/down/"+path.Base(file)) // Not every script can be undone.
+		sum := sha256.Sum256(up)
+		migrations = append(migrations, Migration{
+			Version:  version,
+			Name:     name,
+			Up:       string(up),
+			Down:     string(down),
+			Checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return
+}
+
+/******************************************************************************
+ *                _ _ _    _____ _        _                            _
+ *               | (_) |  / ____| |      | |                          | |
+ *      ___ _ __ | |_| |_| (___ | |_ __ _| |_ ___ _ __ ___   ___ _ __ | |_ ___
+ *     / __| '_ \| | | __|\___ \| __/ _` | __/ _ \ '_ ` _ \ / _ \ '_ \| __/ __|
+ *     \__ \ |_) | | | |_ ____) | || (_| | ||  __/ | | | | |  __/ | | | |_\__ \
+ *     |___/ .__/|_|_|\__|_____/ \__\__,_|\__\___|_| |_| |_|\___|_| |_|\__|___/
+ *         | |
+ *         |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Splits a script into statements the way the mysql client does, on the
+ * delimiter outside of quotes and comments, and following DELIMITER lines so
+ * trigger bodies come through whole.
+ * ------------------------------------------------------------------------- */
+func splitStatements(script string) (statements []string) {
+	delimiter := ";"
+	var current strings.Builder
+	var quote byte // The quote we are inside of, if any.
+	inComment := false
+	flush := func() {
+		if statement := strings.TrimSpace(current.String()); len(statement) > 0 {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if quote == 0 && !inComment && strings.HasPrefix(strings.ToUpper(trimmed), "DELIMITER ") {
+			flush()
+			delimiter = strings.TrimSpace(trimmed[len("DELIMITER "):])
+			continue
+		}
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case inComment:
+				current.WriteByte(c)
+				if strings.HasPrefix(line[i:], "*/") {
+					current.WriteByte('/')
+					i++
+					inComment = false
+				}
+			case quote != 0:
+				current.WriteByte(c)
+				if c == '\\' && quote != '`' && i+1 < len(line) {
+					i++
+					current.WriteByte(line[i])
+				} else if c == quote {
+					quote = 0
+				}
+			case c == '\'' || c == '"' || c == '`':
+				quote = c
+				current.WriteByte(c)
+			case c == '#' || strings.HasPrefix(line[i:], "-- ") || line[i:] == "--":
+				i = len(line) // The rest of the line is a comment.
+			case strings.HasPrefix(line[i:], "/*"):
+				inComment = true
+				current.WriteString("/*")
+				i++
+			case strings.HasPrefix(line[i:], delimiter):
+				flush()
+				i += len(delimiter) - 1
+			default:
+				current.WriteByte(c)
+			}
+		}
+		current.WriteByte('\n')
+	}
+	flush()
+	return
+}
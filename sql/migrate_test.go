@@ -0,0 +1,335 @@
+package sql
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The trigger 0001 makes and down/0003 makes again, as one statement.
+const emailKeyTrigger = `CREATE TRIGGER trigger_name BEFORE INSERT ON accounts
+FOR EACH ROW
+BEGIN
+  SET NEW.apikey = LEFT(MD5(CONCAT('salt', NEW.email)), 24);
+END`
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"plain", "USE news;\nSELECT 1 ;\n\n  SELECT 2;", []string{"USE news", "SELECT 1", "SELECT 2"}},
+		{"no last delimiter", "SELECT 1;\nSELECT 2\n", []string{"SELECT 1", "SELECT 2"}},
+		{"empty", "\n ;\n;;\n", nil},
+		{"single quotes", "INSERT INTO t VALUES ('a;b');SELECT 1;", []string{"INSERT INTO t VALUES ('a;b')", "SELECT 1"}},
+		{"double quotes", `SELECT "x;y" ;`, []string{`SELECT "x;y"`}},
+		{"backticks", "SELECT `odd;name` FROM t;", []string{"SELECT `odd;name` FROM t"}},
+		{"escaped quote", `SELECT 'it\'s; here', "a\";b";`, []string{`SELECT 'it\'s; here', "a\";b"`}},
+		{"doubled quote", "SELECT 'it''s; here';", []string{"SELECT 'it''s; here'"}},
+		{"quote over lines", "INSERT INTO t VALUES ('one;\ntwo;');", []string{"INSERT INTO t VALUES ('one;\ntwo;')"}},
+		{"dash comment", "-- Makes t; then more.\nSELECT 1; -- the first;\nSELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"dash comment at the end of a line", "SELECT 1 --\n;", []string{"SELECT 1"}},
+		{"dashes that are not a comment", "SELECT 1--1;", []string{"SELECT 1--1"}},
+		{"hash comment", "# a; b\nSELECT 1; # c; d", []string{"SELECT 1"}},
+		{"block comment", "SELECT /* a; b */ 1;", []string{"SELECT /* a; b */ 1"}},
+		{"block comment over lines", "/* one;\ntwo; */ SELECT 1;", []string{"/* one;\ntwo; */ SELECT 1"}},
+		{"comment marks in quotes", "SELECT '-- a; # b; /* c';", []string{"SELECT '-- a; # b; /* c'"}},
+		{"delimiter", "SELECT 1;\nDELIMITER //\nCREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END//\nDELIMITER ;\nSELECT 3;",
+			[]string{"SELECT 1", "CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END", "SELECT 3"}},
+		{"delimiter in lower case", "delimiter $$\nSELECT 1; SELECT 2$$\ndelimiter ;\nSELECT 3;", []string{"SELECT 1; SELECT 2", "SELECT 3"}},
+		{"delimiter in quotes", "SELECT 'DELIMITER //\n';", []string{"SELECT 'DELIMITER //\n'"}},
+	}
+	for _, test := range tests {
+		if got := splitStatements(test.script); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// The shipped scripts with a trigger in them hand it over whole.
+func TestSplitShippedTriggers(t *testing.T) {
+	migrations := shippedMigrations(t)
+	tests := []struct {
+		name       string
+		statements []string
+	}{
+		{"0001 up", migrations[1].Statements(false)},
+		{"0003 down", migrations[3].Statements(true)},
+	}
+	for _, test := range tests {
+		found := false
+		for _, statement := range test.statements {
+			if strings.Contains(statement, "TRIGGER") {
+				if statement != emailKeyTrigger {
+					t.Errorf("%s: trigger split as %q", test.name, statement)
+				}
+				found = true
+			}
+			if strings.Contains(statement, "DELIMITER") || strings.HasSuffix(statement, "//") {
+				t.Errorf("%s: delimiter left in %q", test.name, statement)
+			}
+		}
+		if !found {
+			t.Errorf("%s: no trigger in %q", test.name, test.statements)
+		}
+	}
+}
+
+// The scripts built in, by version.
+func shippedMigrations(t *testing.T) map[int]Migration {
+	t.Helper()
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byVersion := make(map[int]Migration)
+	for i, m := range migrations {
+		if i > 0 && migrations[i-1].Version >= m.Version {
+			t.Errorf("migration %d comes after %d", m.Version, migrations[i-1].Version)
+		}
+		if len(m.Checksum) != 64 || len(m.Up) == 0 {
+			t.Errorf("migration %d loaded as %+v", m.Version, m)
+		}
+		byVersion[m.Version] = m
+	}
+	if _, ok := byVersion[0]; ok {
+		t.Error("loaded 0000, which makes the database and is left to the admin")
+	}
+	return byVersion
+}
+
+// The history the recorder answers with, the versions applied with the checksum given
+// or, when it is empty, the one of the script.
+func answerApplied(t *testing.T, r *recorder, migrations map[int]Migration, checksums map[int]string) {
+	t.Helper()
+	var rows [][]driver.Value
+	for version, checksum := range checksums {
+		if len(checksum) == 0 {
+			checksum = migrations[version].Checksum
+		}
+		rows = append(rows, []driver.Value{int64(version), checksum, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)})
+	}
+	r.answer(t, "FROM schema_migrations", rows...)
+}
+
+// The versions the statements recorded as applied and undone, in order.
+func historyChanges(statements []statement) (inserted []int64, deleted []int64) {
+	for _, s := range statements {
+		switch {
+		case strings.HasPrefix(s.query, "INSERT INTO schema_migrations"), strings.HasPrefix(s.query, "INSERT IGNORE INTO schema_migrations"):
+			inserted = append(inserted, s.args[0].(int64))
+		case strings.HasPrefix(s.query, "DELETE FROM schema_migrations"):
+			deleted = append(deleted, s.args[0].(int64))
+		}
+	}
+	return
+}
+
+func hasStatement(statements []statement, query string) bool {
+	for _, s := range statements {
+		if s.query == query {
+			return true
+		}
+	}
+	return false
+}
+
+func versions(ran []Migration) (list []int64) {
+	for _, m := range ran {
+		list = append(list, int64(m.Version))
+	}
+	return
+}
+
+func TestMigrateUp(t *testing.T) {
+	migrations := shippedMigrations(t)
+	last := int64(len(migrations))
+	all := make([]int64, 0, last)
+	for v := int64(1); v <= last; v++ {
+		all = append(all, v)
+	}
+	tests := []struct {
+		name     string
+		applied  map[int]string
+		accounts bool // A schema made by db/run.sh is there.
+		dryRun   bool
+		want     []int64
+		err      string
+	}{
+		{name: "fresh", want: all},
+		{name: "fresh dry run", dryRun: true, want: all},
+		{name: "part way", applied: map[int]string{1: "", 2: "", 3: ""}, accounts: true, want: all[3:]},
+		{name: "up to date", applied: appliedUpTo(int(last)), accounts: true},
+		{name: "changed script", applied: map[int]string{1: "", 2: strings.Repeat("0", 64)}, accounts: true,
+			err: "migration 0002_accounts_live has changed since it was applied"},
+		{name: "no history", accounts: true, err: "baseline"},
+	}
+	for _, test := range tests {
+		r := useRecorder(t)
+		answerApplied(t, r, migrations, test.applied)
+		if test.accounts {
+			r.answer(t, "information_schema.tables", []driver.Value{int64(1)})
+		} else {
+			r.answer(t, "information_schema.tables", []driver.Value{int64(0)})
+		}
+		ran, err := MigrateUp(test.dryRun)
+		statements := r.take()
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			if inserted, _ := historyChanges(statements); len(inserted) > 0 || len(ran) > 0 {
+				t.Errorf("%s: ran %v and recorded %v before stopping", test.name, versions(ran), inserted)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := versions(ran); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: ran %v, want %v", test.name, got, test.want)
+		}
+		inserted, _ := historyChanges(statements)
+		if test.dryRun {
+			if len(inserted) > 0 || hasStatement(statements, migrations[1].Statements(false)[0]) {
+				t.Errorf("%s: the dry run ran %v", test.name, inserted)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(inserted, test.want) {
+			t.Errorf("%s: recorded %v, want %v", test.name, inserted, test.want)
+		}
+		for _, v := range test.want {
+			for _, statement := range migrations[int(v)].Statements(false) {
+				if !hasStatement(statements, statement) {
+					t.Errorf("%s: %d did not run %q", test.name, v, statement)
+				}
+			}
+		}
+		for _, s := range statements {
+			if strings.HasPrefix(s.query, "INSERT INTO schema_migrations") && s.args[2] != migrations[int(s.args[0].(int64))].Checksum {
+				t.Errorf("%s: %d recorded with checksum %v", test.name, s.args[0], s.args[2])
+			}
+		}
+	}
+}
+
+func appliedUpTo(version int) map[int]string {
+	applied := make(map[int]string)
+	for v := 1; v <= version; v++ {
+		applied[v] = ""
+	}
+	return applied
+}
+
+func TestMigrateDown(t *testing.T) {
+	migrations := shippedMigrations(t)
+	last := len(migrations)
+	tests := []struct {
+		name    string
+		applied map[int]string
+		steps   int
+		dryRun  bool
+		want    []int64
+	}{
+		{name: "one", applied: appliedUpTo(last), steps: 1, want: []int64{int64(last)}},
+		{name: "two", applied: appliedUpTo(last), steps: 2, want: []int64{int64(last), int64(last - 1)}},
+		{name: "skips those not applied", applied: appliedUpTo(3), steps: 2, want: []int64{3, 2}},
+		{name: "more than there are", applied: appliedUpTo(2), steps: 5, want: []int64{2, 1}},
+		{name: "dry run", applied: appliedUpTo(last), steps: 1, dryRun: true, want: []int64{int64(last)}},
+		{name: "nothing applied", steps: 1},
+	}
+	for _, test := range tests {
+		r := useRecorder(t)
+		answerApplied(t, r, migrations, test.applied)
+		ran, err := MigrateDown(test.steps, test.dryRun)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := versions(ran); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: undid %v, want %v", test.name, got, test.want)
+		}
+		statements := r.take()
+		_, deleted := historyChanges(statements)
+		if test.dryRun {
+			if len(deleted) > 0 {
+				t.Errorf("%s: the dry run took %v out of the history", test.name, deleted)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(deleted, test.want) {
+			t.Errorf("%s: took %v out of the history, want %v", test.name, deleted, test.want)
+		}
+		for _, v := range test.want {
+			for _, statement := range migrations[int(v)].Statements(true) {
+				if !hasStatement(statements, statement) {
+					t.Errorf("%s: %d did not run %q", test.name, v, statement)
+				}
+			}
+		}
+	}
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	migrations := shippedMigrations(t)
+	r := useRecorder(t)
+	if err := MigrateBaseline(5); err != nil {
+		t.Fatal(err)
+	}
+	statements := r.take()
+	if !hasStatement(statements, migrationTable) {
+		t.Error("the history table was not made")
+	}
+	inserted, _ := historyChanges(statements)
+	if want := []int64{1, 2, 3, 4, 5}; !reflect.DeepEqual(inserted, want) {
+		t.Errorf("baselined %v, want %v", inserted, want)
+	}
+	for _, s := range statements {
+		if strings.HasPrefix(s.query, "INSERT") {
+			m := migrations[int(s.args[0].(int64))]
+			if !strings.HasPrefix(s.query, "INSERT IGNORE") || s.args[1] != m.Name || s.args[2] != m.Checksum {
+				t.Errorf("baselined as %s %v", s.query, s.args)
+			}
+		}
+		for _, m := range migrations {
+			if s.query == m.Statements(false)[0] {
+				t.Errorf("baselining ran %d", m.Version)
+			}
+		}
+	}
+
+	// Baselined, the runner carries on from the version after.
+	answerApplied(t, r, migrations, appliedUpTo(5))
+	r.answer(t, "information_schema.tables", []driver.Value{int64(1)})
+	ran, err := MigrateUp(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(ran); len(got) != len(migrations)-5 || got[0] != 6 {
+		t.Errorf("ran %v after the baseline", got)
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	migrations := shippedMigrations(t)
+	r := useRecorder(t)
+	answerApplied(t, r, migrations, map[int]string{1: "", 2: strings.Repeat("0", 64)})
+	states, err := MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != len(migrations) {
+		t.Fatalf("%d states for %d migrations", len(states), len(migrations))
+	}
+	for _, state := range states {
+		applied := state.Version == 1 || state.Version == 2
+		if (state.Applied != nil) != applied || state.Changed != (state.Version == 2) || state.Name != migrations[state.Version].Name {
+			t.Errorf("state %+v", state)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package sql
+
+import (
+	"[app name]/conf"
+	"[app name]/lib"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrOtpNotEnrolled = errors.New("no second factor enrolled")
+	ErrOtpInvalid     = errors.New("second factor code is invalid or already used")
+)
+
+/*******************************************************************************
+ *      ______                 _  ____  _
+ *     |  ____|               | |/ __ \| |
+ *     | |__   _ __  _ __ ___ | | |  | | |_ _ __
+ *     |  __| | '_ \| '__/ _ \| | |  | | __| '_ \
+ *     | |____| | | | | | (_) | | |__| | |_| |_) |
+ *     |______|_| |_|_|  \___/|_|\____/ \__| .__/
+ *                                         | |
+ *                                         |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Gives the account a new TOTP secret and the otpauth:// uri for it. The secret
+ * is not live until a code from it is confirmed, so a half done enrolment never
+ * locks anyone out. Any secret the account had before stops working here.
+ * -------------------------------------------------------------------------- */
+func EnrolOtp(ctx context.Context, urn int64) (secret string, uri string, err error) {
+	account, err := GetAccount(ctx, urn)
+	if err != nil {
+		return
+	}
+	secret = lib.NewTotpSecret()
+	// Not through runSQL, that logs its arguments when it fails.
+	if _, err = db.ExecContext(ctx, "UPDATE accounts SET otpkey = ?, otplive = 0, otpstep = 0 WHERE urn = ? ;", secret, urn); lib.CheckErr(err) {
+		return "", "", err
+	}
+	lib.Log(ctx).Info("Otp enrolment started:", urn)
+	return secret, lib.TotpURI(conf.AppName, account.Email, secret), nil
+}
+
+/*******************************************************************************
+ *       _____             __ _                 ____  _
+ *      / ____|           / _(_)               / __ \| |
+ *     | |     ___  _ __ | |_ _ _ __ _ __ ___ | |  | | |_ _ __
+ *     | |    / _ \| '_ \|  _| | '__| '_ ` _ \| |  | | __| '_ \
+ *     | |___| (_) | | | | | | | |  | | | | | | |__| | |_| |_) |
+ *      \_____\___/|_| |_|_| |_|_|  |_| |_| |_|\____/ \__| .__/
+ *                                                       | |
+ *                                                       |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Turns the enrolled secret on once the account shows a code made from it, and
+ * hands out a fresh set of recovery codes. They are shown here and never again.
+ * -------------------------------------------------------------------------- */
+func ConfirmOtp(ctx context.Context, urn int64, code string) (codes []string, err error) {
+	var secret string
+	var live bool
+	err = db.QueryRowContext(ctx, "SELECT otpkey, otplive FROM accounts WHERE urn = ? ;", urn).Scan(&secret, &live)
+	if err != nil {
+		return
+	}
+	if len(secret) == 0 {
+		return nil, ErrOtpNotEnrolled
+	}
+	step, ok := lib.TotpMatch(secret, code, time.Now(), conf.OTPWINDOW)
+	if !ok {
+		return nil, ErrOtpInvalid
+	}
+	if runSQL(ctx, "UPDATE accounts SET otplive = 1, otpstep = ? WHERE urn = ? AND otpstep < ? ;", step, urn, step) != 1 {
+		return nil, ErrOtpInvalid
+	}
+	lib.Log(ctx).Info("Otp enrolled:", urn)
+	return NewRecoveryCodes(ctx, urn)
+}
+
+/********************************************************************************
+ *       _____ _               _     ____  _
+ *      / ____| |             | |   / __ \| |
+ *     | |    | |__   ___  ___| | _| |  | | |_ _ __
+ *     | |    | '_ \ / _ \/ __| |/ / |  | | __| '_ \
+ *     | |____| | | |  __/ (__|   <| |__| | |_| |_) |
+ *      \_____|_| |_|\___|\___|_|\_\\____/ \__| .__/
+ *                                            | |
+ *                                            |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Checks a code for an account with a live second factor. Six digits are a TOTP
+ * code, which only passes for a step later than the last one used, anything else
+ * is tried as a recovery code, which works once.
+ * --------------------------------------------------------------------------- */
+func CheckOtp(ctx context.Context, urn int64, code string) error {
+	code = strings.TrimSpace(code)
+	if !OtpEnrolled(ctx, urn) {
+		return ErrOtpNotEnrolled
+	}
+	if len(code) == lib.TotpDigits {
+		var secret string
+		err := db.QueryRowContext(ctx, "SELECT otpkey FROM accounts WHERE urn = ? ;", urn).Scan(&secret)
+		if err != nil {
+			return err
+		}
+		step, ok := lib.TotpMatch(secret, code, time.Now(), conf.OTPWINDOW)
+		// Moving the step on only where it is behind makes a replayed code fail,
+		// even when two requests race with the same one.
+		if ok && runSQL(ctx, "UPDATE accounts SET otpstep = ? WHERE urn = ? AND otplive = 1 AND otpstep < ? ;", step, urn, step) == 1 {
+			return nil
+		}
+		return ErrOtpInvalid
+	}
+	if len(code) > 0 && runSQL(ctx, "UPDATE recoverycodes SET used = 1 WHERE urn = ? AND codehash = ? AND used = 0 ;", urn, HashKey(normalRecoveryCode(code))) == 1 {
+		lib.Log(ctx).Warn("Recovery code used:", urn)
+		return nil
+	}
+	return ErrOtpInvalid
+}
+
+// True when the account has confirmed a second factor.
+func OtpEnrolled(ctx context.Context, urn int64) bool {
+	var live bool
+	err := db.QueryRowContext(ctx, "SELECT otplive FROM accounts WHERE urn = ? ;", urn).Scan(&live)
+	if err != nil && err != sql.ErrNoRows {
+		lib.CheckErr(err)
+	}
+	return live
+}
+
+/************************************************************************************************
+ *      _   _               _____                                     _____          _
+ *     | \ | |             |  __ \                                   / ____|        | |
+ *     |  \| | _____      _| |__) |___  ___ _____   _____ _ __ _   _| |     ___   __| | ___  ___
+ *     | . ` |/ _ \ \ /\ / /  _  // _ \/ __/ _ \ \ / / _ \ '__| | | | |    / _ \ / _` |/ _ \/ __|
+ *     | |\  |  __/\ V  V /| | \ \  __/ (_| (_) \ V /  __/ |  | |_| | |___| (_) | (_| |  __/\__ \
+ *     |_| \_|\___| \_/\_/ |_|  \_\___|\___\___/ \_/ \___|_|   \__, |\_____\___/ \__,_|\___||___/
+ *                                                              __/ |
+ *                                                             |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Replaces the account's recovery codes with a new set, kept only as hashes.
+ * ------------------------------------------------------------------------------------------- */
+func NewRecoveryCodes(ctx context.Context, urn int64) (codes []string, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if lib.CheckErr(err) {
+		return
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM recoverycodes WHERE urn = ? ;", urn)
+	for i := 0; err == nil && i < recoveryCodeCount; i++ {
+		code := newRecoveryCode()
+		_, err = tx.ExecContext(ctx, "INSERT INTO recoverycodes (urn, codehash) VALUES (?,?) ;", urn, HashKey(code))
+		codes = append(codes, code)
+	}
+	if lib.CheckErr(err) {
+		lib.CheckErr(tx.Rollback())
+		return nil, err
+	}
+	err = tx.Commit()
+	return
+}
+
+// Takes the second factor off an account, for the admin when both the device and
+// the recovery codes are lost.
+func ResetOtp(ctx context.Context, urn int64) error {
+	runSQL(ctx, "DELETE FROM recoverycodes WHERE urn = ? ;", urn)
+	return updateAccount(ctx, urn, "UPDATE accounts SET otpkey = '', otplive = 0, otpstep = 0 WHERE urn = ? ;", urn)
+}
+
+// Ten hex characters in two groups, easy enough to type from paper.
+func newRecoveryCode() string {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); lib.CheckErr(err) {
+		panic(err)
+	}
+	code := hex.EncodeToString(b)
+	return code[:5] + "-" + code[5:]
+}
+
+// Recovery codes match without the dash or case, however they were typed in.
+func normalRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if len(code) == 10 {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
@@ -0,0 +1,140 @@
+package sql
+
+import (
+	"[app name]/lib"
+	"time"
+)
+
+/*******************************************************************************
+ *      _____  _
+ *     |  __ \| |
+ *     | |__) | | __ _ _ __
+ *     |  ___/| |/ _` | '_ \
+ *     | |    | | (_| | | | |
+ *     |_|    |_|\__,_|_| |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * What an account gets for its plan, the allocation is the number of calls in
+ * each monthly period, the rate and burst bound calls per second, and max limit
+ * is the largest page a single call may ask for. Streams is how many event
+ * streams it may hold open at once.
+ * -------------------------------------------------------------------------- */
+type Plan struct {
+	Name      string  `json:"name"`
+	Allocated int64   `json:"allocated"`
+	Rate      float64 `json:"rate"`
+	Burst     int     `json:"burst"`
+	MaxLimit  int     `json:"max_limit"`
+	Streams   int     `json:"streams"`
+}
+
+var Plans = map[string]Plan{
+	"FREE":  {Name: "FREE", Allocated: 500, Rate: 1, Burst: 2, MaxLimit: 25, Streams: 1},
+	"BASIC": {Name: "BASIC", Allocated: 10000, Rate: 5, Burst: 10, MaxLimit: 100, Streams: 3},
+	"PRO":   {Name: "PRO", Allocated: 100000, Rate: 20, Burst: 40, MaxLimit: 100, Streams: 10},
+}
+
+// The plan by name, anything unknown is treated as FREE.
+func PlanFor(name string) Plan {
+	if plan, ok := Plans[name]; ok {
+		return plan
+	}
+	return Plans["FREE"]
+}
+
+/*******************************************************************************
+ *      _____       _ _ _____          _           _
+ *     |  __ \     | | |  __ \        (_)         | |
+ *     | |__) |___ | | | |__) |__ _ __ _  ___   __| |___
+ *     |  _  // _ \| | |  ___/ _ \ '__| |/ _ \ / _` / __|
+ *     | | \ \ (_) | | | |  |  __/ |  | | (_) | (_| \__ \
+ *     |_|  \_\___/|_|_|_|   \___|_|  |_|\___/ \__,_|___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Starts a new period for every account whose period has ended. Usage goes back
+ * to zero, the allocation is reset to that of the plan, and the end moves on a
+ * month at a time until it is in the future, each time to the anchor day. The update only applies if the end
+ * is still the one read, so several instances can run this together safely.
+ * -------------------------------------------------------------------------- */
+func RollPeriods() (rolled int64) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Error("Rolling account periods:", r)
+		}
+	}()
+	type due struct {
+		urn    int64
+		plan   string
+		end    time.Time
+		anchor int
+	}
+	var dueList []due
+	now := time.Now()
+
+	rows, err := db.Query("SELECT urn, plan, end, anchor FROM accounts WHERE end <= ? AND live = 1 ;", now)
+	if lib.CheckErr(err) {
+		panic(err)
+	}
+	for rows.Next() {
+		var d due
+		if !lib.CheckErr(rows.Scan(&d.urn, &d.plan, &d.end, &d.anchor)) {
+			dueList = append(dueList, d)
+		}
+	}
+	lib.CheckErr(rows.Close())
+	if len(dueList) == 0 {
+		return
+	}
+
+	lib.CheckErr(FlushUsage()) // Usage from the old period belongs to the old period.
+	for _, d := range dueList {
+		end := d.end
+		for !end.After(now) {
+			end = nextPeriodEnd(end, d.anchor)
+		}
+		if count := RunSQL("UPDATE accounts SET used = 0, allocated = ?, end = ? WHERE urn = ? AND end = ? ;", PlanFor(d.plan).Allocated, end, d.urn, d.end); count > 0 {
+			rolled += count
+		}
+	}
+	lib.Info("Account periods rolled:", rolled)
+	LoadAccounts()
+	return
+}
+
+// A month on from the end, on the anchor day or the last day of a month too short for it.
+// Adding a month instead drifts, Jan 31 runs on to Mar 3 and stays on the 3rd after.
+func nextPeriodEnd(end time.Time, anchor int) time.Time {
+	if anchor < 1 {
+		anchor = end.Day()
+	}
+	year, month, _ := end.Date()
+	month++
+	if last := time.Date(year, month+1, 0, 0, 0, 0, 0, end.Location()).Day(); anchor > last {
+		anchor = last
+	}
+	return time.Date(year, month, anchor, end.Hour(), end.Minute(), end.Second(), end.Nanosecond(), end.Location())
+}
+
+/***************************************************************************************
+ *      _  __               _____          _           _     _____       _ _          _
+ *     | |/ /              |  __ \        (_)         | |   |  __ \     | | |        | |
+ *     | ' / ___  ___ _ __ | |__) |__ _ __ _  ___   __| |___| |__) |___ | | | ___  __| |
+ *     |  < / _ \/ _ \ '_ \|  ___/ _ \ '__| |/ _ \ / _` / __|  _  // _ \| | |/ _ \/ _` |
+ *     | . \  __/  __/ |_) | |  |  __/ |  | | (_) | (_| \__ \ | \ \ (_) | | |  __/ (_| |
+ *     |_|\_\___|\___| .__/|_|   \___|_|  |_|\___/ \__,_|___/_|  \_\___/|_|_|\___|\__,_|
+ *                   | |
+ *                   |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Checks for ended periods on a jittered heart beat, runs for the life of the app.
+ * ---------------------------------------------------------------------------------- */
+func KeepPeriodsRolled(heartBeat int) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Error("Period roll loop:", r)
+		}
+	}()
+	for {
+		RollPeriods()
+		time.Sleep(time.Duration(lib.NextHeartBeat(heartBeat)) * time.Second)
+	}
+}
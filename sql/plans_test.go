@@ -0,0 +1,38 @@
+package sql
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextPeriodEnd(t *testing.T) {
+	day := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+	}
+	for _, c := range []struct {
+		name   string
+		end    time.Time
+		anchor int
+		want   []time.Time
+	}{
+		{"31st through the short months", day(2025, time.January, 31), 31,
+			[]time.Time{day(2025, time.February, 28), day(2025, time.March, 31), day(2025, time.April, 30), day(2025, time.May, 31)}},
+		{"leap year", day(2024, time.January, 30), 30,
+			[]time.Time{day(2024, time.February, 29), day(2024, time.March, 30)}},
+		{"mid month", day(2025, time.November, 15), 15,
+			[]time.Time{day(2025, time.December, 15), day(2026, time.January, 15)}},
+		{"no anchor takes the end's day", day(2025, time.March, 31), 0,
+			[]time.Time{day(2025, time.April, 30)}},
+		{"back to the anchor after a short month", day(2025, time.February, 28), 31,
+			[]time.Time{day(2025, time.March, 31)}},
+	} {
+		end := c.end
+		for i, want := range c.want {
+			end = nextPeriodEnd(end, c.anchor)
+			if !end.Equal(want) {
+				t.Errorf("%s: period %d ends %s, want %s", c.name, i+1, end.Format(time.RFC3339), want.Format(time.RFC3339))
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package sql
+
+import (
+	"[app name]/lib"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrAccountExists = errors.New("an account with that email already exists")
+	ErrSignupUsed    = errors.New("signup already verified or not found")
+)
+
+/**********************************************************************************
+ *       _____ _                                                          _
+ *      / ____(_)                          /\                            | |
+ *     | (___  _  __ _ _ __  _   _ _ __   /  \   ___ ___ ___  _   _ _ __ | |_
+ *      \___ \| |/ _` | '_ \| | | | '_ \ / /\ \ / __/ __/ _ \| | | | '_ \| __|
+ *      ____) | | (_| | | | | |_| | |_) / ____ \ (_| (_| (_) | |_| | | | | |_
+ *     |_____/|_|\__, |_| |_|\__,_| .__/_/    \_\___\___\___/ \__,_|_| |_|\__|
+ *                __/ |           | |
+ *               |___/            |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Makes a FREE account for a self service signup, not live and pending until
+ * the email is verified. Signing up again before then gives back the same account,
+ * so a lost or expired link can be sent again.
+ * ----------------------------------------------------------------------------- */
+func SignupAccount(ctx context.Context, email string) (urn int64, err error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	plan := Plans["FREE"]
+	// The key column wants something unique, the hash of a key nobody ever sees.
+	res, err := db.ExecContext(ctx, "INSERT INTO accounts (apikey, email, plan, allocated, live, pending) VALUES (?,?,?,?,0,1) ;",
+		HashKey(NewKey()), email, plan.Name, plan.Allocated)
+	if err == nil {
+		urn, err = res.LastInsertId()
+		lib.Log(ctx).Info("Signup pending:", urn)
+		return
+	}
+	if !strings.Contains(err.Error(), "Duplicate") {
+		lib.CheckErr(err)
+		return
+	}
+	err = db.QueryRowContext(ctx, "SELECT urn FROM accounts WHERE email = ? AND pending = 1 ;", email).Scan(&urn)
+	if err == sql.ErrNoRows {
+		return 0, ErrAccountExists
+	}
+	return
+}
+
+/*******************************************************************************
+ *     __      __       _  __        _____ _
+ *     \ \    / /      (_)/ _|      / ____(_)
+ *      \ \  / /__ _ __ _| |_ _   _| (___  _  __ _ _ __  _   _ _ __
+ *       \ \/ / _ \ '__| |  _| | | |\___ \| |/ _` | '_ \| | | | '_ \
+ *        \  /  __/ |  | | | | |_| |____) | | (_| | | | | |_| | |_) |
+ *         \/ \___|_|  |_|_|  \__, |_____/|_|\__, |_| |_|\__,_| .__/
+ *                             __/ |          __/ |           | |
+ *                            |___/          |___/            |_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Makes a pending account live with its first api key, which is returned for
+ * showing once. A second go at the same signup finds nothing pending and fails.
+ * -------------------------------------------------------------------------- */
+func VerifySignup(ctx context.Context, urn int64) (apikey string, err error) {
+	apikey = NewKey()
+	tx, err := db.BeginTx(ctx, nil)
+	if lib.CheckErr(err) {
+		return "", err
+	}
+	res, err := tx.ExecContext(ctx, "UPDATE accounts SET apikey = ?, live = 1, pending = 0, end = CURDATE() + INTERVAL 1 MONTH, anchor = DAYOFMONTH(CURDATE()) WHERE urn = ? AND pending = 1 ;",
+		HashKey(apikey), urn)
+	if err == nil {
+		var count int64
+		if count, err = res.RowsAffected(); err == nil && count != 1 {
+			err = ErrSignupUsed
+		}
+	}
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "INSERT INTO apikeys (urn, keyhash, hint) VALUES (?,?,?) ;", urn, HashKey(apikey), keyHint(apikey))
+	}
+	if err != nil {
+		lib.CheckErr(tx.Rollback())
+		return "", err
+	}
+	if err = tx.Commit(); lib.CheckErr(err) {
+		return "", err
+	}
+	lib.Log(ctx).Info("Signup verified:", urn)
+	LoadAccounts()
+	return
+}
@@ -0,0 +1,146 @@
+package sql
+
+import (
+	"[app name]/lib"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+/********************************************************************************
+ *       _____
+ *      / ____|
+ *     | (___   ___  _   _ _ __ ___ ___
+ *      \___ \ / _ \| | | | '__/ __/ _ \
+ *      ____) | (_) | |_| | | | (_|  __/
+ *     |_____/ \___/ \__,_|_|  \___\___|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * A remote feed, polled on its own schedule. The etag and modified values are
+ * sent back on the next fetch so an unchanged feed costs a 304 and nothing more.
+ * --------------------------------------------------------------------------- */
+type Source struct {
+	Uid       int64      `json:"uid"`
+	Url       string     `json:"url"`
+	Topic     string     `json:"topic"`
+	Live      bool       `json:"live"`
+	Etag      string     `json:"etag"`
+	Modified  string     `json:"modified"`
+	Failures  int        `json:"failures"` // Failed fetches in a row, the backoff grows with them.
+	LastError string     `json:"lasterror"`
+	LastFetch *time.Time `json:"lastfetch"`
+	Next      time.Time  `json:"next"`
+	Created   time.Time  `json:"created"`
+}
+
+const sourceColumns = "uid, url, topic, live, etag, modified, failures, lasterror, lastfetch, next, timestamp"
+
+func scanSource(row interface{ Scan(...interface{}) error }) (s Source, err error) {
+	var lastFetch sql.NullTime
+	err = row.Scan(&s.Uid, &s.Url, &s.Topic, &s.Live, &s.Etag, &s.Modified, &s.Failures, &s.LastError, &lastFetch, &s.Next, &s.Created)
+	if lastFetch.Valid {
+		s.LastFetch = &lastFetch.Time
+	}
+	return
+}
+
+/*****************************************************************************
+ *                  _     _  _____
+ *         /\      | |   | |/ ____|
+ *        /  \   __| | __| | (___   ___  _   _ _ __ ___ ___
+ *       / /\ \ / _` |/ _` |\___ \ / _ \| | | | '__/ __/ _ \
+ *      / ____ \ (_| | (_| |____) | (_) | |_| | | | (_|  __/
+ *     /_/    \_\__,_|\__,_|_____/ \___/ \__,_|_|  \___\___|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Starts polling a feed url, filing what it brings under the topic. The first
+ * fetch is due straight away.
+ * ------------------------------------------------------------------------ */
+func AddSource(ctx context.Context, url string, topic string) (s Source, err error) {
+	res, err := db.ExecContext(ctx, "INSERT INTO sources (url, topic) VALUES (?,?) ;", url, topic)
+	if err != nil {
+		return
+	}
+	uid, err := res.LastInsertId()
+	if lib.CheckErr(err) {
+		return
+	}
+	lib.Log(ctx).Info("Source added:", uid, url, topic)
+	return GetSource(ctx, uid)
+}
+
+// A single source by its uid, sql.ErrNoRows if there is none.
+func GetSource(ctx context.Context, uid int64) (s Source, err error) {
+	s, err = scanSource(db.QueryRowContext(ctx, "SELECT "+sourceColumns+" FROM sources WHERE uid = ? ;", uid))
+	if err != nil && err != sql.ErrNoRows {
+		lib.CheckErr(err)
+	}
+	return
+}
+
+// Every source, stopped ones included, oldest first.
+func ListSources(ctx context.Context) ([]Source, error) {
+	return querySources(ctx, "SELECT "+sourceColumns+" FROM sources ORDER BY uid ;")
+}
+
+// The live sources whose next fetch has come round, the longest waiting first.
+func DueSources(ctx context.Context, now time.Time) ([]Source, error) {
+	return querySources(ctx, "SELECT "+sourceColumns+" FROM sources WHERE live = 1 AND next <= ? ORDER BY next ;", now)
+}
+
+// Stops polling a source. The row is kept, with the articles it brought.
+func RemoveSource(ctx context.Context, uid int64) error {
+	if _, err := GetSource(ctx, uid); err != nil {
+		return err
+	}
+	if runSQL(ctx, "UPDATE sources SET live = 0 WHERE uid = ? ;", uid) < 0 {
+		return fmt.Errorf("unable to remove source %d", uid)
+	}
+	lib.Log(ctx).Info("Source removed:", uid)
+	return nil
+}
+
+/****************************************************************************
+ *       _____                          ______   _       _              _
+ *      / ____|                        |  ____| | |     | |            | |
+ *     | (___   ___  _   _ _ __ ___ ___| |__ ___| |_ ___| |__   ___  __| |
+ *      \___ \ / _ \| | | | '__/ __/ _ \  __/ _ \ __/ __| '_ \ / _ \/ _` |
+ *      ____) | (_) | |_| | | | (_|  __/ | |  __/ || (__| | | |  __/ (_| |
+ *     |_____/ \___/ \__,_|_|  \___\___|_|  \___|\__\___|_| |_|\___|\__,_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Records a good fetch, a 304 included. The validators are kept for the next
+ * conditional fetch and the failures start again from none.
+ * ----------------------------------------------------------------------- */
+func SourceFetched(ctx context.Context, uid int64, etag string, modified string, next time.Time) error {
+	_, err := db.ExecContext(ctx, "UPDATE sources SET etag = ?, modified = ?, failures = 0, lasterror = '', lastfetch = NOW(), next = ? WHERE uid = ? ;",
+		etag, modified, next, uid)
+	lib.CheckErr(err)
+	return err
+}
+
+// Records a failed fetch and when to try again.
+func SourceFailed(ctx context.Context, uid int64, failures int, reason string, next time.Time) error {
+	if len(reason) > 512 {
+		reason = reason[:512]
+	}
+	_, err := db.ExecContext(ctx, "UPDATE sources SET failures = ?, lasterror = ?, lastfetch = NOW(), next = ? WHERE uid = ? ;",
+		failures, reason, next, uid)
+	lib.CheckErr(err)
+	return err
+}
+
+func querySources(ctx context.Context, sqlString string, args ...interface{}) (sList []Source, err error) {
+	rows, err := db.QueryContext(ctx, sqlString, args...)
+	if lib.CheckErr(err) {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s, err := scanSource(rows)
+		if lib.CheckErr(err) {
+			return sList, err
+		}
+		sList = append(sList, s)
+	}
+	err = rows.Err()
+	return
+}
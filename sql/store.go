@@ -0,0 +1,261 @@
+package sql
+
+import (
+	"[app name]/conf"
+	"[app name]/lib"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+/*******************************************************************************
+ *                    _   _      _       _____ _
+ *         /\        | | (_)    | |     / ____| |
+ *        /  \   _ __| |_ _  ___| | ___| (___ | |_ ___  _ __ ___
+ *       / /\ \ | '__| __| |/ __| |/ _ \\___ \| __/ _ \| '__/ _ \
+ *      / ____ \| |  | |_| | (__| |  __/____) | || (_) | | |  __/
+ *     /_/    \_\_|   \__|_|\___|_|\___|_____/ \__\___/|_|  \___|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Everything the http layer needs from the articles table. The MySQL version
+ * is what runs live, the memory version in memory.go runs without a database.
+ * The context carries the request id into the queries and their log lines.
+ * -------------------------------------------------------------------------- */
+type ArticleStore interface {
+	Get(ctx context.Context, uid int64) (a Article, err error)
+	List(ctx context.Context, query NewsQuery) (aList []Article, total int64, err error)
+	Insert(ctx context.Context, a Article) (uid int64, err error)
+	Next(ctx context.Context, uid int64, limit int, filter []string, topic string) (aList []Article, err error)
+	Prev(ctx context.Context, uid int64, limit int, filter []string, topic string) (aList []Article, err error)
+	Last(ctx context.Context, limit int, filter []string, topic string) (aList []Article, err error)
+	Search(ctx context.Context, column string, terms []string, limit int, topic string) (aList []Article, err error)
+}
+
+/**********************************************************************
+ *                                        _    _____ _
+ *         /\                            | |  / ____| |
+ *        /  \   ___ ___ ___  _   _ _ __ | |_| (___ | |_ ___  _ __ ___
+ *       / /\ \ / __/ __/ _ \| | | | '_ \| __|\___ \| __/ _ \| '__/ _ \
+ *      / ____ \ (_| (_| (_) | |_| | | | | |_ ____) | || (_) | | |  __/
+ *     /_/    \_\___\___\___/ \__,_|_| |_|\__|_____/ \__\___/|_|  \___|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Api accounts keyed by their access key.
+ * ----------------------------------------------------------------- */
+type AccountStore interface {
+	Get(apikey string) (account AccountsStruct, ok bool)
+	Put(apikey string, account AccountsStruct)
+	Use(apikey string, count int64) bool
+	Load() error
+	Flush() error
+}
+
+/* ------------------------------------- */
+
+// MySQL backed article store, the queries all go through queryArticles.
+type MysqlArticles struct{}
+
+func (MysqlArticles) Get(ctx context.Context, uid int64) (a Article, err error) {
+	defer storeDuration.Since(time.Now(), "articles.Get")
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Log(ctx).Error("Unable to retrieve Article:", r, uid)
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	aList := queryArticles(ctx, `SELECT * FROM articles WHERE uid = ? ;`, uid)
+	if len(aList) < 1 {
+		err = sql.ErrNoRows
+	} else {
+		a = aList[0]
+	}
+	return
+}
+
+func (MysqlArticles) List(ctx context.Context, query NewsQuery) (aList []Article, total int64, err error) {
+	defer storeDuration.Since(time.Now(), "articles.List")
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Log(ctx).Error("Unable to run news query:", r, query)
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	orderBy, ok := NewsSorts[query.Sort]
+	if !ok {
+		panic("Invalid sort: " + query.Sort)
+	}
+	where, args := query.where()
+
+	if !query.Uncounted {
+		sqlCount := "SELECT count(*) FROM articles" + where + ";"
+		lib.Log(ctx).Debug("News Count:", sqlCount, args)
+		err = db.QueryRowContext(ctx, sqlCount, args...).Scan(&total)
+		if lib.CheckErr(err) {
+			panic(err)
+		}
+	}
+	sqlArticles := "SELECT * FROM articles" + where + " ORDER BY " + orderBy + " LIMIT ? OFFSET ?;"
+	aList = queryArticles(ctx, sqlArticles, append(args, query.Limit, query.Offset)...)
+	return
+}
+
+func (MysqlArticles) Insert(ctx context.Context, a Article) (uid int64, err error) {
+	defer storeDuration.Since(time.Now(), "articles.Insert")
+	return insertArticle(ctx, a)
+}
+
+func (MysqlArticles) Next(ctx context.Context, uid int64, limit int, filter []string, topic string) (aList []Article, err error) {
+	defer storeDuration.Since(time.Now(), "articles.Next")
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Log(ctx).Error("Unable to retrieve next Articles:", r, uid)
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	if pattern := rlikeTerms(filter); len(pattern) > 0 {
+		aList = queryArticles(ctx, `SELECT * FROM articles WHERE uid > ? AND topic = ? AND content RLIKE ? ORDER BY uid ASC LIMIT ? ;`, uid, topic, pattern, limit)
+	} else {
+		aList = queryArticles(ctx, `SELECT * FROM articles WHERE uid > ? AND topic = ? ORDER BY uid ASC LIMIT ? ;`, uid, topic, limit)
+	}
+	return
+}
+
+func (MysqlArticles) Prev(ctx context.Context, uid int64, limit int, filter []string, topic string) (aList []Article, err error) {
+	defer storeDuration.Since(time.Now(), "articles.Prev")
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Log(ctx).Error("Unable to retrieve previous Articles:", r, uid)
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	if pattern := rlikeTerms(filter); len(pattern) > 0 {
+		aList = queryArticles(ctx, `SELECT * FROM articles WHERE uid < ? AND topic = ? AND content RLIKE ? ORDER BY uid DESC LIMIT ? ;`, uid, topic, pattern, limit)
+	} else {
+		aList = queryArticles(ctx, `SELECT * FROM articles WHERE uid < ? AND topic = ? ORDER BY uid DESC LIMIT ? ;`, uid, topic, limit)
+	}
+	return
+}
+
+func (MysqlArticles) Last(ctx context.Context, limit int, filter []string, topic string) (aList []Article, err error) {
+	defer storeDuration.Since(time.Now(), "articles.Last")
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Log(ctx).Error("Unable to retrieve filtered Articles:", r, filter)
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	if pattern := rlikeTerms(filter); len(pattern) > 0 {
+		aList = queryArticles(ctx, `SELECT * FROM articles WHERE topic = ? AND content RLIKE ? ORDER BY uid DESC LIMIT ? ;`, topic, pattern, limit)
+	} else {
+		aList = queryArticles(ctx, `SELECT * FROM articles WHERE topic = ? ORDER BY uid DESC LIMIT ? ;`, topic, limit)
+	}
+	return
+}
+
+func (MysqlArticles) Search(ctx context.Context, column string, terms []string, limit int, topic string) (aList []Article, err error) {
+	defer storeDuration.Since(time.Now(), "articles.Search")
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Log(ctx).Error("Unable to search Articles:", r, column, terms)
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	if pattern := rlikeTerms(terms); len(pattern) > 0 {
+		sqlArticles := `SELECT * FROM articles WHERE topic = ? AND ` + columnName(column) + ` RLIKE ? ORDER BY created DESC LIMIT ? ;`
+		aList = queryArticles(ctx, sqlArticles, topic, pattern, limit)
+	} else {
+		aList = queryArticles(ctx, `SELECT * FROM articles WHERE topic = ? ORDER BY created DESC LIMIT ? ;`, topic, limit)
+	}
+	return
+}
+
+// MySQL backed account store, accounts are served from the cache LoadAccounts fills.
+type MysqlAccounts struct{}
+
+func (MysqlAccounts) Get(apikey string) (account AccountsStruct, ok bool) {
+	return Accounts.Get(apikey)
+}
+
+func (MysqlAccounts) Put(apikey string, account AccountsStruct) {
+	Accounts.Put(apikey, account)
+}
+
+func (MysqlAccounts) Use(apikey string, count int64) bool {
+	return Accounts.Use(apikey, count)
+}
+
+func (MysqlAccounts) Load() error {
+	defer storeDuration.Since(time.Now(), "accounts.Load")
+	LoadAccounts()
+	return nil
+}
+
+func (MysqlAccounts) Flush() error {
+	defer storeDuration.Since(time.Now(), "accounts.Flush")
+	return FlushUsage()
+}
+
+/****************************************************************************
+ *                    _   _      _           _____            _
+ *         /\        | | (_)    | |         |  __ \          | |
+ *        /  \   _ __| |_ _  ___| | ___  ___| |__) |___ _ __ | |_   _
+ *       / /\ \ | '__| __| |/ __| |/ _ \/ __|  _  // _ \ '_ \| | | | |
+ *      / ____ \| |  | |_| | (__| |  __/\__ \ | \ \  __/ |_) | | |_| |
+ *     /_/    \_\_|   \__|_|\___|_|\___||___/_|  \_\___| .__/|_|\__, |
+ *                                                     | |       __/ |
+ *                                                     |_|      |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Package a list of articles the way the api verbs reply, an empty list or a
+ * missing row is a 206 with an empty array.
+ * ----------------------------------------------------------------------- */
+func ArticlesReply(aList []Article, err error) (reply conf.Reply) {
+	switch {
+	case err == sql.ErrNoRows || (err == nil && len(aList) < 1):
+		reply = conf.Reply{Code: 206, Msg: "[]"}
+	case err != nil:
+		reply = conf.Reply{Code: 400, Msg: fmt.Sprintf(`Error: %v`, err)}
+	default:
+		lib.Debug("Rows:", len(aList), aList)
+		reply = conf.Reply{Code: 400, Msg: "Error: Unable to encode Articles"}
+		jsonReply, err := json.MarshalIndent(aList, "", "")
+		if !lib.CheckErr(err) {
+			reply.Code = 200
+			reply.Msg = string(jsonReply)
+		}
+	}
+	return
+}
+
+/***********************************************************************
+ *      _   _                   _____            _
+ *     | \ | |                 |  __ \          | |
+ *     |  \| | _____      _____| |__) |___ _ __ | |_   _
+ *     | . ` |/ _ \ \ /\ / / __|  _  // _ \ '_ \| | | | |
+ *     | |\  |  __/\ V  V /\__ \ | \ \  __/ |_) | | |_| |
+ *     |_| \_|\___| \_/\_/ |___/_|  \_\___| .__/|_|\__, |
+ *                                        | |       __/ |
+ *                                        |_|      |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Package a page of news query results into the paginated api envelope.
+ * ------------------------------------------------------------------ */
+func NewsReply(query NewsQuery, aList []Article, total int64, err error) (reply conf.Reply) {
+	if lib.CheckErr(err) {
+		return conf.Reply{Code: 500, Msg: "Unable to run news query"}
+	}
+	page := NewsPage{Data: []Article{}}
+	page.Data = append(page.Data, aList...)
+	page.Pagination = Pagination{Limit: query.Limit, Offset: query.Offset, Count: len(page.Data), Total: total}
+	reply = conf.Reply{Code: 500, Msg: "Unable to encode news query"}
+	jsonReply, err := json.Marshal(page)
+	if !lib.CheckErr(err) {
+		reply.Code = 200
+		reply.Msg = string(jsonReply)
+	}
+	return
+}
@@ -0,0 +1,102 @@
+package sql
+
+import (
+	"[app name]/conf"
+	"[app name]/lib"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+/**************************************************************************
+ *      _______                   _
+ *     |__   __|                 | |
+ *        | | __ _ _ __ __ _  ___| |_
+ *        | |/ _` | '__/ _` |/ _ \ __|
+ *        | | (_| | | | (_| |  __/ |_
+ *        |_|\__,_|_|  \__, |\___|\__|
+ *                      __/ |
+ *                     |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * A publisher target's control record with its own settings. Detail is nil
+ * to follow NEWSDETAIL, Keywords are any of, matched against the title as
+ * GetNextArticleByKeyWords does, and empty lets every article through.
+ * --------------------------------------------------------------------- */
+type Target struct {
+	Target   string    `json:"target"`
+	Platform string    `json:"platform"`
+	Note     string    `json:"note"`
+	Live     bool      `json:"live"`
+	Detail   *bool     `json:"detail"`
+	Keywords string    `json:"keywords"`
+	Cursor   time.Time `json:"cursor"` // The created time of the last article it was given.
+}
+
+const targetColumns = "target, platform, note, live, detail, keywords, timestamp"
+
+func scanTarget(row interface{ Scan(...interface{}) error }) (t Target, err error) {
+	var detail sql.NullBool
+	err = row.Scan(&t.Target, &t.Platform, &t.Note, &t.Live, &detail, &t.Keywords, &t.Cursor)
+	if detail.Valid {
+		t.Detail = &detail.Bool
+	}
+	return
+}
+
+// Whether the target is sent the article content as well as the title and link.
+func (t Target) ShowDetail() bool {
+	if t.Detail == nil {
+		return conf.NEWSDETAIL
+	}
+	return *t.Detail
+}
+
+// A single target by its id. sql.ErrNoRows if it has no control record.
+func GetTarget(ctx context.Context, target string) (t Target, err error) {
+	t, err = scanTarget(db.QueryRowContext(ctx, "SELECT "+targetColumns+" FROM control WHERE target = ? ;", target))
+	if err != nil && err != sql.ErrNoRows {
+		lib.CheckErr(err)
+	}
+	return
+}
+
+// The targets on a platform, stopped ones included, or on every platform if none is given.
+func ListTargets(ctx context.Context, platform string) (tList []Target, err error) {
+	sqlString := "SELECT " + targetColumns + " FROM control"
+	var args []interface{}
+	if len(platform) > 0 {
+		sqlString += " WHERE platform = ?"
+		args = append(args, platform)
+	}
+	rows, err := db.QueryContext(ctx, sqlString+" ORDER BY platform, target ;", args...)
+	if lib.CheckErr(err) {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t, err := scanTarget(rows)
+		if lib.CheckErr(err) {
+			return tList, err
+		}
+		tList = append(tList, t)
+	}
+	err = rows.Err()
+	return
+}
+
+// Sets a target's detail and keywords, a nil detail going back to NEWSDETAIL.
+func SetTargetSettings(ctx context.Context, target string, detail *bool, keywords string) error {
+	if countSQL(ctx, "SELECT count(*) FROM control WHERE target = ? ;", target) < 1 {
+		return sql.ErrNoRows
+	}
+	var detailValue interface{}
+	if detail != nil {
+		detailValue = *detail
+	}
+	if runSQL(ctx, "UPDATE control SET detail = ?, keywords = ? WHERE target = ? ;", detailValue, keywords, target) < 0 {
+		return fmt.Errorf("unable to update target %s", target)
+	}
+	lib.Log(ctx).Info("Target settings:", target, "detail:", detailValue, "keywords:", keywords)
+	return nil
+}
@@ -0,0 +1,116 @@
+package sql
+
+import (
+	"[app name]/lib"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Held by a flush for its whole run, so a reload can not read the table between
+// the pending usage being taken and it being written.
+var flushLock sync.RWMutex
+
+/********************************************************************************
+ *      ______ _           _     _    _
+ *     |  ____| |         | |   | |  | |
+ *     | |__  | |_   _ ___| |__ | |  | |___  __ _  __ _  ___
+ *     |  __| | | | | / __| '_ \| |  | / __|/ _` |/ _` |/ _ \
+ *     | |    | | |_| \__ \ | | | |__| \__ \ (_| | (_| |  __/
+ *     |_|    |_|\__,_|___/_| |_|\____/|___/\__,_|\__, |\___|
+ *                                                 __/ |
+ *                                                |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Writes the counted usage to the accounts table as relative updates, so several
+ * instances sharing the database all add to the same count. Anything that fails
+ * to write is put back for the next flush.
+ * --------------------------------------------------------------------------- */
+func FlushUsage() (err error) {
+	var pending map[int64]int64
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Error("Flushing account usage:", r)
+			err = fmt.Errorf("flushing account usage: %v", r)
+		}
+		if err != nil && len(pending) > 0 {
+			Accounts.restorePending(pending) // Taken and not written, count it next time.
+		}
+	}()
+	flushLock.Lock()
+	defer flushLock.Unlock()
+	pending = Accounts.takePending()
+	if len(pending) == 0 {
+		return
+	}
+
+	tx, err := db.Begin()
+	if lib.CheckErr(err) {
+		return
+	}
+	stmt, err := tx.Prepare("UPDATE accounts SET used = used + ? WHERE urn = ?;")
+	if lib.CheckErr(err) {
+		lib.CheckErr(tx.Rollback())
+		return
+	}
+	defer lib.DeferClose(stmt)
+	for urn, count := range pending {
+		_, err = stmt.Exec(count, urn)
+		if lib.CheckErr(err) {
+			lib.CheckErr(tx.Rollback())
+			return
+		}
+	}
+	err = tx.Commit()
+	if !lib.CheckErr(err) {
+		lib.Debug("Usage flushed for accounts:", len(pending))
+	}
+	return
+}
+
+/*******************************************************************************************
+ *      _  __               _    _                      ______ _           _              _
+ *     | |/ /              | |  | |                    |  ____| |         | |            | |
+ *     | ' / ___  ___ _ __ | |  | |___  __ _  __ _  ___| |__  | |_   _ ___| |__   ___  __| |
+ *     |  < / _ \/ _ \ '_ \| |  | / __|/ _` |/ _` |/ _ \  __| | | | | / __| '_ \ / _ \/ _` |
+ *     | . \  __/  __/ |_) | |__| \__ \ (_| | (_| |  __/ |    | | |_| \__ \ | | |  __/ (_| |
+ *     |_|\_\___|\___| .__/ \____/|___/\__,_|\__, |\___|_|    |_|\__,_|___/_| |_|\___|\__,_|
+ *                   | |                      __/ |
+ *                   |_|                     |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Flushes the counted usage every interval, runs for the life of the app.
+ * -------------------------------------------------------------------------------------- */
+func KeepUsageFlushed(interval time.Duration) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Error("Usage flush loop:", r)
+		}
+	}()
+	for {
+		time.Sleep(interval)
+		FlushUsage()
+	}
+}
+
+/**************************************************************************
+ *       _____ _                _____  ____
+ *      / ____| |              |  __ \|  _ \
+ *     | |    | | ___  ___  ___| |  | | |_) |
+ *     | |    | |/ _ \/ __|/ _ \ |  | |  _ <
+ *     | |____| | (_) \__ \  __/ |__| | |_) |
+ *      \_____|_|\___/|___/\___|_____/|____/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Flush what is still held in memory and close the database, for shutdown.
+ * --------------------------------------------------------------------- */
+func CloseDB() {
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Error("Closing Database:", r)
+		}
+	}()
+	lib.Info("Flushing account usage before close")
+	lib.CheckErr(FlushUsage())
+	lib.DeferClose(db)
+}
@@ -0,0 +1,37 @@
+package sql
+
+import (
+	"testing"
+)
+
+// An account with usage counted against it, in a cache of its own for the test.
+func usageCounted(t *testing.T, count int64) {
+	t.Helper()
+	old, oldDb := Accounts, db
+	t.Cleanup(func() { Accounts, db = old, oldDb })
+	Accounts = NewAccountCache()
+	Accounts.Put("usage-key", AccountsStruct{Urn: 7})
+	Accounts.Use("usage-key", count)
+}
+
+func TestFlushUsageRestoresOnError(t *testing.T) {
+	usageCounted(t, 3)
+	useRecorder(t) // Begin fails, it has no transactions.
+	if err := FlushUsage(); err == nil {
+		t.Fatal("flush without a transaction reported no error")
+	}
+	if pending := Accounts.takePending(); pending[7] != 3 {
+		t.Errorf("pending after a failed flush: %v, want 3 for urn 7", pending)
+	}
+}
+
+func TestFlushUsageRestoresOnPanic(t *testing.T) {
+	usageCounted(t, 5)
+	db = nil // Begin on no database panics.
+	if err := FlushUsage(); err == nil {
+		t.Fatal("flush that panicked reported no error")
+	}
+	if pending := Accounts.takePending(); pending[7] != 5 {
+		t.Errorf("pending after a panicked flush: %v, want 5 for urn 7", pending)
+	}
+}
@@ -0,0 +1,253 @@
+package sql
+
+import (
+	"[app name]/lib"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const WebhookPlatform = "webhook"
+
+/*******************************************************************************
+ *     __          __  _     _                 _
+ *     \ \        / / | |   | |               | |
+ *      \ \  /\  / /__| |__ | |__   ___   ___ | | __
+ *       \ \/  \/ / _ \ '_ \| '_ \ / _ \ / _ \| |/ /
+ *        \  /\  /  __/ |_) | | | | (_) | (_) |   <
+ *         \/  \/ \___|_.__/|_| |_|\___/ \___/|_|\_\
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * A partner endpoint new articles are pushed to, those on its topic that match
+ * its category and keyword filters. The secret signs every delivery and is only
+ * shown when the webhook is made.
+ * -------------------------------------------------------------------------- */
+type Webhook struct {
+	Uid      int64     `json:"uid"`
+	Url      string    `json:"url"`
+	Secret   string    `json:"secret,omitempty"` // Only on the reply that made it.
+	Topic    string    `json:"topic"`
+	Cats     string    `json:"cats"`
+	Keywords string    `json:"keywords"`
+	Live     bool      `json:"live"`
+	Failures int       `json:"failures"` // Failed tries at the article it is on.
+	Next     time.Time `json:"next"`
+	Created  time.Time `json:"created"`
+}
+
+// A delivery that failed every retry. The webhook moved on past the article.
+type DeadLetter struct {
+	Uid       int64     `json:"uid"`
+	Webhook   int64     `json:"webhook"`
+	Article   int64     `json:"article"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lasterror"`
+	Created   time.Time `json:"created"`
+}
+
+const webhookColumns = "uid, url, secret, topic, cats, keywords, live, failures, next, timestamp"
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (w Webhook, err error) {
+	err = row.Scan(&w.Uid, &w.Url, &w.Secret, &w.Topic, &w.Cats, &w.Keywords, &w.Live, &w.Failures, &w.Next, &w.Created)
+	return
+}
+
+// The control target that holds where the webhook is up to.
+func (w Webhook) Target() string {
+	return WebhookPlatform + ":" + strconv.FormatInt(w.Uid, 10)
+}
+
+// Whether the article passes the filters, the same any of and leading - rules as the api.
+func (w Webhook) Matches(a Article) bool {
+	return NewsQuery{Topic: w.Topic, Categories: filterTerms(w.Cats), Keywords: filterTerms(w.Keywords)}.matches(a)
+}
+
+/*************************************************************************
+ *                  _     ___          __  _     _                 _
+ *         /\      | |   | \ \        / / | |   | |               | |
+ *        /  \   __| | __| |\ \  /\  / /__| |__ | |__   ___   ___ | | __
+ *       / /\ \ / _` |/ _` | \ \/  \/ / _ \ '_ \| '_ \ / _ \ / _ \| |/ /
+ *      / ____ \ (_| | (_| |  \  /\  /  __/ |_) | | | | (_) | (_) |   <
+ *     /_/    \_\__,_|\__,_|   \/  \/ \___|_.__/|_| |_|\___/ \___/|_|\_\
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Makes a webhook, with a new secret if none is given. It starts from the
+ * articles that come in after it is made, not the ones already held.
+ * -------------------------------------------------------------------- */
+func AddWebhook(ctx context.Context, url string, secret string, topic string, cats string, keywords string) (w Webhook, err error) {
+	if len(secret) == 0 {
+		b := make([]byte, 24)
+		if _, err = rand.Read(b); lib.CheckErr(err) {
+			return
+		}
+		secret = "whsec_" + hex.EncodeToString(b)
+	}
+	res, err := db.ExecContext(ctx, "INSERT INTO webhooks (url, secret, topic, cats, keywords) VALUES (?,?,?,?,?) ;", url, secret, topic, cats, keywords)
+	if lib.CheckErr(err) {
+		return
+	}
+	uid, err := res.LastInsertId()
+	if lib.CheckErr(err) {
+		return
+	}
+	w, err = GetWebhook(ctx, uid)
+	if err != nil {
+		return
+	}
+	if runSQL(ctx, "INSERT INTO control (target, timestamp, platform, note) VALUES (?, NOW(), ?, ?) ;", w.Target(), WebhookPlatform, "Webhook "+url) < 1 {
+		return w, fmt.Errorf("unable to start the cursor for webhook %d", uid)
+	}
+	lib.Log(ctx).Info("Webhook added:", uid, url, topic)
+	w.Secret = secret
+	return
+}
+
+// A single webhook by its uid, the secret left out. sql.ErrNoRows if there is none.
+func GetWebhook(ctx context.Context, uid int64) (w Webhook, err error) {
+	w, err = scanWebhook(db.QueryRowContext(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE uid = ? ;", uid))
+	if err != nil && err != sql.ErrNoRows {
+		lib.CheckErr(err)
+	}
+	w.Secret = ""
+	return
+}
+
+// Every webhook, stopped ones included, the secrets left out.
+func ListWebhooks(ctx context.Context) (wList []Webhook, err error) {
+	wList, err = queryWebhooks(ctx, "SELECT "+webhookColumns+" FROM webhooks ORDER BY uid ;")
+	for i := range wList {
+		wList[i].Secret = ""
+	}
+	return
+}
+
+// The live webhooks not waiting on a backoff, secrets and all, for the dispatcher.
+func DueWebhooks(ctx context.Context, now time.Time) ([]Webhook, error) {
+	return queryWebhooks(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE live = 1 AND next <= ? ORDER BY next ;", now)
+}
+
+// Stops a webhook and its cursor. The rows are kept, with its dead letters.
+func RemoveWebhook(ctx context.Context, uid int64) error {
+	w, err := GetWebhook(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if runSQL(ctx, "UPDATE webhooks SET live = 0 WHERE uid = ? ;", uid) < 0 || runSQL(ctx, "UPDATE control SET live = 0 WHERE target = ? ;", w.Target()) < 0 {
+		return fmt.Errorf("unable to remove webhook %d", uid)
+	}
+	lib.Log(ctx).Info("Webhook removed:", uid)
+	return nil
+}
+
+func queryWebhooks(ctx context.Context, sqlString string, args ...interface{}) (wList []Webhook, err error) {
+	rows, err := db.QueryContext(ctx, sqlString, args...)
+	if lib.CheckErr(err) {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if lib.CheckErr(err) {
+			return wList, err
+		}
+		wList = append(wList, w)
+	}
+	err = rows.Err()
+	return
+}
+
+/****************************************************************************
+ *      _____               _ _                           _   _      _
+ *     |  __ \             | (_)               /\        | | (_)    | |
+ *     | |__) |__ _ __   __| |_ _ __   __ _   /  \   _ __| |_ _  ___| | ___
+ *     |  ___/ _ \ '_ \ / _` | | '_ \ / _` | / /\ \ | '__| __| |/ __| |/ _ \
+ *     | |  |  __/ | | | (_| | | | | | (_| |/ ____ \| |  | |_| | (__| |  __/
+ *     |_|   \___|_| |_|\__,_|_|_| |_|\__, /_/    \_\_|   \__|_|\___|_|\___|
+ *                                     __/ |
+ *                                    |___/
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * The next article past the target's control, left where it is. The caller
+ * moves the control with AdvanceControl once it is done with it, so a failed
+ * delivery is tried again from the same place. Keywords skip the articles
+ * whose titles have none of them, as for NextArticle.
+ * ----------------------------------------------------------------------- */
+func PendingArticle(ctx context.Context, target string, topic string, keywords string) (a Article, found bool) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			lib.Log(ctx).Error("Unable to retrieve pending Article:", r, target)
+		}
+	}()
+	filter, args := keywordFilter(keywords)
+	aList := queryArticles(ctx, `SELECT * FROM articles WHERE topic = ? AND created > (SELECT timestamp FROM control WHERE target = ?)`+filter+` ORDER BY created LIMIT 1 ;`,
+		append([]interface{}{topic, target}, args...)...)
+	if len(aList) > 0 {
+		return aList[0], true
+	}
+	return
+}
+
+// Moves the target's control up to the article.
+func AdvanceControl(ctx context.Context, target string, a Article) bool {
+	return runSQL(ctx, "UPDATE control SET timestamp = ? WHERE target = ? ;", a.Created.Format("2006-01-02 15:04:05.0000"), target) >= 0
+}
+
+// Records a failed try at the current article and when to try again, none clears the count.
+func SetWebhookFailures(ctx context.Context, uid int64, failures int, next time.Time) bool {
+	return runSQL(ctx, "UPDATE webhooks SET failures = ?, next = ? WHERE uid = ? ;", failures, next, uid) >= 0
+}
+
+/*******************************************************************************
+ *                  _     _ _____                 _ _          _   _
+ *         /\      | |   | |  __ \               | | |        | | | |
+ *        /  \   __| | __| | |  | | ___  __ _  __| | |     ___| |_| |_ ___ _ __
+ *       / /\ \ / _` |/ _` | |  | |/ _ \/ _` |/ _` | |    / _ \ __| __/ _ \ '__|
+ *      / ____ \ (_| | (_| | |__| |  __/ (_| | (_| | |___|  __/ |_| ||  __/ |
+ *     /_/    \_\__,_|\__,_|_____/ \___|\__,_|\__,_|______\___|\__|\__\___|_|
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ * Parks a delivery that failed every retry, for the admin to see.
+ * -------------------------------------------------------------------------- */
+func AddDeadLetter(ctx context.Context, webhook int64, article int64, attempts int, reason string) bool {
+	if len(reason) > 512 {
+		reason = reason[:512]
+	}
+	lib.Log(ctx).Warn("Webhook delivery dead lettered:", webhook, article, reason)
+	return runSQL(ctx, "INSERT INTO deadletters (webhook, article, attempts, lasterror) VALUES (?,?,?,?) ;", webhook, article, attempts, reason) > 0
+}
+
+// The dead letters, newest first, only those of one webhook if it is given.
+func ListDeadLetters(ctx context.Context, webhook int64, limit int, offset int) (dList []DeadLetter, err error) {
+	sqlString := "SELECT uid, webhook, article, attempts, lasterror, timestamp FROM deadletters"
+	var args []interface{}
+	if webhook > 0 {
+		sqlString += " WHERE webhook = ?"
+		args = append(args, webhook)
+	}
+	rows, err := db.QueryContext(ctx, sqlString+" ORDER BY uid DESC LIMIT ? OFFSET ? ;", append(args, limit, offset)...)
+	if lib.CheckErr(err) {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d DeadLetter
+		if err = rows.Scan(&d.Uid, &d.Webhook, &d.Article, &d.Attempts, &d.LastError, &d.Created); lib.CheckErr(err) {
+			return
+		}
+		dList = append(dList, d)
+	}
+	err = rows.Err()
+	return
+}
+
+// Clears a dead letter once it has been dealt with.
+func DeleteDeadLetter(ctx context.Context, uid int64) error {
+	if countSQL(ctx, "SELECT count(*) FROM deadletters WHERE uid = ? ;", uid) < 1 {
+		return sql.ErrNoRows
+	}
+	if runSQL(ctx, "DELETE FROM deadletters WHERE uid = ? ;", uid) < 0 {
+		return fmt.Errorf("unable to delete dead letter %d", uid)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A webhooks row as the recorder answers it, in the order of webhookColumns.
+func webhookRow(uid int64, secret string) []driver.Value {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return []driver.Value{uid, "https://partner.test/hook", secret, "general", "science", "mars", int64(1), int64(0), created, created}
+}
+
+func TestAddWebhook(t *testing.T) {
+	r := useRecorder(t)
+	ctx := context.Background()
+	r.answer(t, "FROM webhooks WHERE uid = ?", webhookRow(7, "whsec_stored"))
+	r.inserted(7)
+
+	hook, err := AddWebhook(ctx, "https://partner.test/hook", "", "general", "science", "mars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^whsec_[0-9a-f]{48}$`).MatchString(hook.Secret) {
+		t.Errorf("made the secret %q", hook.Secret)
+	}
+	if hook.Uid != 7 || hook.Target() != "webhook:7" || hook.Cats != "science" || !hook.Live {
+		t.Errorf("added %+v", hook)
+	}
+	statements := r.take()
+	if len(statements) != 3 {
+		t.Fatalf("ran %+v, want the insert, the read back and the cursor", statements)
+	}
+	if !strings.HasPrefix(statements[0].query, "INSERT INTO webhooks") || statements[0].args[1] != hook.Secret {
+		t.Errorf("inserted with %s %v", statements[0].query, statements[0].args)
+	}
+	if cursor := statements[2]; !strings.HasPrefix(cursor.query, "INSERT INTO control") || cursor.args[0] != "webhook:7" || cursor.args[1] != WebhookPlatform {
+		t.Errorf("cursor started with %s %v", cursor.query, cursor.args)
+	}
+
+	if hook, err = AddWebhook(ctx, "https://partner.test/hook", "given-secret", "general", "", ""); err != nil || hook.Secret != "given-secret" {
+		t.Errorf("a given secret came back as %q, %v", hook.Secret, err)
+	}
+	r.take()
+	r.affect(0) // The cursor is not made.
+	if _, err = AddWebhook(ctx, "https://partner.test/hook", "", "general", "", ""); err == nil {
+		t.Error("added without a cursor")
+	}
+}
+
+func TestWebhookSecretsKept(t *testing.T) {
+	r := useRecorder(t)
+	ctx := context.Background()
+	r.answer(t, "FROM webhooks", webhookRow(7, "whsec_stored"), webhookRow(8, "whsec_other"))
+
+	hooks, err := ListWebhooks(ctx)
+	if err != nil || len(hooks) != 2 {
+		t.Fatalf("listed %+v, %v", hooks, err)
+	}
+	for _, hook := range hooks {
+		if len(hook.Secret) > 0 {
+			t.Errorf("listed the secret of %d", hook.Uid)
+		}
+	}
+	if hook, err := GetWebhook(ctx, 7); err != nil || len(hook.Secret) > 0 {
+		t.Errorf("got %+v, %v, want it without the secret", hook, err)
+	}
+	now := time.Now()
+	due, err := DueWebhooks(ctx, now)
+	if err != nil || len(due) != 2 || due[0].Secret != "whsec_stored" {
+		t.Errorf("due %+v, %v, want them with their secrets to sign with", due, err)
+	}
+	statements := r.take()
+	last := statements[len(statements)-1]
+	if !strings.Contains(last.query, "live = 1 AND next <= ?") || !last.args[0].(time.Time).Equal(now) {
+		t.Errorf("due asked with %s %v", last.query, last.args)
+	}
+}
+
+func TestRemoveWebhook(t *testing.T) {
+	r := useRecorder(t)
+	ctx := context.Background()
+	r.answer(t, "FROM webhooks WHERE uid = ?", webhookRow(7, "whsec_stored"))
+	if err := RemoveWebhook(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	var stopped []string
+	for _, s := range r.take() {
+		if strings.HasPrefix(s.query, "UPDATE") {
+			stopped = append(stopped, fmt.Sprintf("%s %v", s.query, s.args))
+		}
+	}
+	if want := []string{"UPDATE webhooks SET live = 0 WHERE uid = ? ; [7]", "UPDATE control SET live = 0 WHERE target = ? ; [webhook:7]"}; strings.Join(stopped, "\n") != strings.Join(want, "\n") {
+		t.Errorf("stopped with\n%s\nwant\n%s", strings.Join(stopped, "\n"), strings.Join(want, "\n"))
+	}
+
+	r.answer(t, "FROM webhooks WHERE uid = ?") // No such webhook.
+	if err := RemoveWebhook(ctx, 8); err != sql.ErrNoRows {
+		t.Errorf("removing a missing webhook: %v", err)
+	}
+	for _, s := range r.take() {
+		if strings.HasPrefix(s.query, "UPDATE") {
+			t.Errorf("ran %s for a missing webhook", s.query)
+		}
+	}
+}
+
+func TestWebhookCursor(t *testing.T) {
+	r := useRecorder(t)
+	ctx := context.Background()
+	created := time.Date(2024, 5, 1, 12, 30, 45, 123400000, time.UTC)
+	if !AdvanceControl(ctx, "webhook:7", Article{Uid: 3, Created: created}) {
+		t.Error("the cursor did not move")
+	}
+	next := time.Now().Add(time.Minute)
+	SetWebhookFailures(ctx, 7, 2, next)
+	_, _ = PendingArticle(ctx, "webhook:7", "general", "")
+	statements := r.take()
+	if len(statements) != 3 {
+		t.Fatalf("ran %+v", statements)
+	}
+	if s := statements[0]; s.args[0] != "2024-05-01 12:30:45.1234" || s.args[1] != "webhook:7" {
+		t.Errorf("moved the cursor with %v", s.args)
+	}
+	if s := statements[1]; s.args[0] != int64(2) || !s.args[1].(time.Time).Equal(next) || s.args[2] != int64(7) {
+		t.Errorf("set the failures with %v", s.args)
+	}
+	if s := statements[2]; !strings.Contains(s.query, "created > (SELECT timestamp FROM control WHERE target = ?)") || s.args[1] != "webhook:7" {
+		t.Errorf("pending asked with %s %v", s.query, s.args)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	r := useRecorder(t)
+	ctx := context.Background()
+	AddDeadLetter(ctx, 7, 3, 5, strings.Repeat("x", 600))
+	s := r.take()[0]
+	if s.args[0] != int64(7) || s.args[1] != int64(3) || s.args[2] != int64(5) || len(s.args[3].(string)) != 512 {
+		t.Errorf("dead lettered with %d character reason, %v", len(s.args[3].(string)), s.args[:3])
+	}
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	r.answer(t, "FROM deadletters WHERE webhook = ?", []driver.Value{int64(1), int64(7), int64(3), int64(5), "webhook replied 502", created})
+	dList, err := ListDeadLetters(ctx, 7, 10, 20)
+	if err != nil || len(dList) != 1 || dList[0].Article != 3 || dList[0].LastError != "webhook replied 502" {
+		t.Errorf("listed %+v, %v", dList, err)
+	}
+	if _, err = ListDeadLetters(ctx, 0, 10, 0); err != nil {
+		t.Error(err)
+	}
+	statements := r.take()
+	if one := statements[0]; !strings.Contains(one.query, "WHERE webhook = ?") || len(one.args) != 3 || one.args[0] != int64(7) || one.args[2] != int64(20) {
+		t.Errorf("one webhook's asked with %s %v", one.query, one.args)
+	}
+	if all := statements[1]; strings.Contains(all.query, "WHERE") || len(all.args) != 2 {
+		t.Errorf("all asked with %s %v", all.query, all.args)
+	}
+
+	r.answer(t, "count(*)", []driver.Value{int64(0)})
+	if err := DeleteDeadLetter(ctx, 9); err != sql.ErrNoRows {
+		t.Errorf("deleting a missing dead letter: %v", err)
+	}
+	r.answer(t, "count(*)", []driver.Value{int64(1)})
+	if err := DeleteDeadLetter(ctx, 1); err != nil {
+		t.Error(err)
+	}
+	statements = r.take()
+	if last := statements[len(statements)-1]; !strings.HasPrefix(last.query, "DELETE FROM deadletters") || last.args[0] != int64(1) {
+		t.Errorf("deleted with %s %v", last.query, last.args)
+	}
+}